@@ -0,0 +1,117 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package structured is an example of a fuzz target that accepts structured input.
+// go-fuzz mutates raw bytes, Decode converts them into a Record before Fuzz is called,
+// and Encode converts records back into canonical bytes that are stored in the corpus.
+package structured
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Record is the structured fuzz input.
+type Record struct {
+	Op     byte
+	Name   string
+	Values []int64
+}
+
+const (
+	OpSum = iota
+	OpMax
+)
+
+const maxValues = 1 << 10
+
+// Decode parses the wire format:
+// op byte, uvarint name length, name, uvarint number of values, varint values.
+// It ignores trailing bytes and accepts non-minimal varints,
+// so different inputs can encode the same record.
+func Decode(data []byte) (interface{}, bool) {
+	r := new(Record)
+	if len(data) == 0 {
+		return nil, false
+	}
+	r.Op = data[0]
+	data = data[1:]
+	n, data, ok := uvarint(data)
+	if !ok || n > uint64(len(data)) {
+		return nil, false
+	}
+	r.Name = string(data[:n])
+	data = data[n:]
+	n, data, ok = uvarint(data)
+	if !ok || n > maxValues {
+		return nil, false
+	}
+	for i := uint64(0); i < n; i++ {
+		v, sz := binary.Varint(data)
+		if sz <= 0 {
+			return nil, false
+		}
+		r.Values = append(r.Values, v)
+		data = data[sz:]
+	}
+	return r, true
+}
+
+func uvarint(data []byte) (uint64, []byte, bool) {
+	v, n := binary.Uvarint(data)
+	if n <= 0 {
+		return 0, nil, false
+	}
+	return v, data[n:], true
+}
+
+// Encode returns the canonical encoding of record v.
+func Encode(v interface{}) []byte {
+	r := v.(*Record)
+	var tmp [binary.MaxVarintLen64]byte
+	data := []byte{r.Op}
+	data = append(data, tmp[:binary.PutUvarint(tmp[:], uint64(len(r.Name)))]...)
+	data = append(data, r.Name...)
+	data = append(data, tmp[:binary.PutUvarint(tmp[:], uint64(len(r.Values)))]...)
+	for _, x := range r.Values {
+		data = append(data, tmp[:binary.PutVarint(tmp[:], x)]...)
+	}
+	return data
+}
+
+// Fuzz receives only records that Decode accepted.
+func Fuzz(v interface{}) int {
+	r := v.(*Record)
+	res, err := eval(r)
+	if err != nil {
+		return 0
+	}
+	if r.Name == "answer" && res == 42 {
+		return 1
+	}
+	return 0
+}
+
+func eval(r *Record) (int64, error) {
+	if len(r.Values) == 0 {
+		return 0, errors.New("no values")
+	}
+	switch r.Op {
+	case OpSum:
+		var sum int64
+		for _, v := range r.Values {
+			sum += v
+		}
+		return sum, nil
+	case OpMax:
+		max := r.Values[0]
+		for _, v := range r.Values[1:] {
+			if max < v {
+				max = v
+			}
+		}
+		return max, nil
+	default:
+		return 0, errors.New("unknown op")
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync/atomic"
+
+	"golang.org/x/tools/go/packages"
+
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+// buildCache is a content-addressed cache of instrumented packages and their literals.
+// Cover ids are stable and sonar ids are relative to the package sonarBase,
+// so cached packages can be freely mixed with freshly instrumented ones.
+// Instrumented sources of unchanged packages are byte-identical across builds,
+// so cmd/go build cache takes care of the compiled packages.
+// TODO: trim old entries.
+type buildCache struct {
+	dir    string // empty if the cache is disabled
+	hits   int32
+	misses int32
+}
+
+type cacheEntry struct {
+	Files    []cachedFile
+	Blocks   []CoverBlock
+	Literals []Literal
+}
+
+type cachedFile struct {
+	Name string
+	Data []byte
+}
+
+// cacheDir returns $GOFUZZCACHE, or go-fuzz-build dir in the user cache dir if it is not set.
+// GOFUZZCACHE=off disables the cache.
+func cacheDir() string {
+	dir := os.Getenv("GOFUZZCACHE")
+	if dir == "off" {
+		return ""
+	}
+	if dir == "" {
+		userDir, err := os.UserCacheDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(userDir, "go-fuzz-build")
+	}
+	return dir
+}
+
+func (bc *buildCache) file(key string) string {
+	return filepath.Join(bc.dir, key[:2], key)
+}
+
+// get returns cached entry for key, or nil if there is none.
+func (bc *buildCache) get(key string) *cacheEntry {
+	if bc.dir == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(bc.file(key))
+	e := new(cacheEntry)
+	if err != nil || gob.NewDecoder(bytes.NewReader(data)).Decode(e) != nil {
+		atomic.AddInt32(&bc.misses, 1)
+		return nil
+	}
+	atomic.AddInt32(&bc.hits, 1)
+	return e
+}
+
+// put stores e under key. Errors are ignored, the cache is best-effort.
+func (bc *buildCache) put(key string, e *cacheEntry) {
+	if bc.dir == "" {
+		return
+	}
+	// Literals can contain arbitrary bytes, so we can't use json here.
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(e); err != nil {
+		return
+	}
+	name := bc.file(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// initCache sets up the build cache and computes content hashes of all loaded packages.
+// Hash of a package covers its files, hashes of its imports,
+// build tags, target OS/arch, -skipfunc and the go-fuzz-build binary itself.
+func (c *Context) initCache() {
+	c.cache.dir = cacheDir()
+	h := sha256.New()
+	if exe, err := os.Executable(); err == nil {
+		if f, err := os.Open(exe); err == nil {
+			io.Copy(h, f)
+			f.Close()
+		}
+	}
+	fmt.Fprintf(h, "tags %v\n", makeTags())
+	fmt.Fprintf(h, "target %v/%v\n", c.GOOS, c.GOARCH)
+	fmt.Fprintf(h, "skipfunc %v\n", *flagSkipFunc)
+	base := h.Sum(nil)
+
+	c.pkgHash = make(map[*packages.Package]string)
+	packages.Visit(c.pkgs, nil, func(pkg *packages.Package) {
+		h := sha256.New()
+		h.Write(base)
+		fmt.Fprintf(h, "pkg %v\n", pkg.PkgPath)
+		for _, f := range pkg.CompiledGoFiles {
+			fmt.Fprintf(h, "file %v %x\n", f, sha256.Sum256(c.readFile(f)))
+		}
+		var imports []string
+		for path := range pkg.Imports {
+			imports = append(imports, path)
+		}
+		sort.Strings(imports)
+		for _, path := range imports {
+			fmt.Fprintf(h, "import %v %v\n", path, c.pkgHash[pkg.Imports[path]])
+		}
+		c.pkgHash[pkg] = hex.EncodeToString(h.Sum(nil))
+	})
+}
+
+// cacheKey returns cache key for the kind of instrumentation of pkg.
+func (c *Context) cacheKey(pkg *packages.Package, kind string) string {
+	h := sha256.Sum256([]byte(kind + " " + c.pkgHash[pkg]))
+	return hex.EncodeToString(h[:])
+}
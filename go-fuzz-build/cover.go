@@ -13,6 +13,7 @@ import (
 	"go/token"
 	"go/types"
 	"io"
+	"regexp"
 	"strconv"
 	"strings"
 
@@ -22,41 +23,157 @@ import (
 
 const fuzzdepPkg = "_go_fuzz_dep_"
 
+// coverBase is the name of a per-package constant added to dense cover ids of the package (see -coverpkg),
+// so that the instrumented code does not depend on cover ids of other packages.
+const coverBase = "_go_fuzz_cover_base_"
+
+// sonarBase is the name of a per-package constant added to all sonar ids of the package,
+// so that the instrumented code does not depend on sonar ids of other packages.
+const sonarBase = "_go_fuzz_sonar_base_"
+
+// noInstrumentDirective in doc comment of a function excludes it from instrumentation,
+// the same as matching -skipfunc.
+const noInstrumentDirective = "//go-fuzz:noinstrument"
+
+// skipFunc is the compiled -skipfunc regexp, nil if it is not set.
+var skipFunc *regexp.Regexp
+
+// noInstrument reports whether function fn of package pkg is built without
+// cover counters and sonar. The function and function literals in it
+// run as is and have no blocks, so they don't take space in cover and sonar ids.
+func noInstrument(pkg string, fn *ast.FuncDecl) bool {
+	if fn.Doc != nil {
+		for _, c := range fn.Doc.List {
+			if strings.TrimSpace(c.Text) == noInstrumentDirective {
+				return true
+			}
+		}
+	}
+	return skipFunc != nil && skipFunc.MatchString(funcName(pkg, fn))
+}
+
+// funcName returns name of fn qualified by import path of its package:
+// pkg.Func for functions and pkg.Type.Method for methods (for both value and pointer receivers).
+func funcName(pkg string, fn *ast.FuncDecl) string {
+	name := fn.Name.Name
+	if fn.Recv != nil && len(fn.Recv.List) == 1 {
+		for typ := fn.Recv.List[0].Type; typ != nil; {
+			switch t := typ.(type) {
+			case *ast.StarExpr:
+				typ = t.X
+			case *ast.ParenExpr:
+				typ = t.X
+			case *ast.IndexExpr: // generic type with a single type parameter
+				typ = t.X
+			case *ast.Ident:
+				name = t.Name + "." + name
+				typ = nil
+			default:
+				typ = nil
+			}
+		}
+	}
+	return pkg + "." + name
+}
+
 func instrument(pkg, fullName string, fset *token.FileSet, parsedFile *ast.File, info *types.Info, out io.Writer, blocks *[]CoverBlock, sonar *[]CoverBlock) {
+	f := instrumentFile(pkg, fullName, fset, parsedFile, info, sonar != nil)
+	if sonar != nil {
+		*sonar = append(*sonar, allocateIDs(pkg, []*instrumentedFile{f}, false)...)
+	} else {
+		*blocks = append(*blocks, allocateIDs(pkg, []*instrumentedFile{f}, false)...)
+	}
+	f.print(out)
+}
+
+// instrumentedFile is a file with cover counters or sonar sites inserted, but without ids assigned.
+type instrumentedFile struct {
+	file  *File
+	sonar *Sonar
+}
+
+// instrumentFile inserts cover counters (or sonar sites if sonar is set) into parsedFile.
+// Ids are assigned later with allocateIDs, so that files can be instrumented concurrently.
+// Sonar instrumentation updates info, so files of the same package must not be instrumented concurrently.
+func instrumentFile(pkg, fullName string, fset *token.FileSet, parsedFile *ast.File, info *types.Info, sonar bool) *instrumentedFile {
 	file := &File{
 		fset:     fset,
 		pkg:      pkg,
 		fullName: fullName,
 		astFile:  parsedFile,
-		blocks:   blocks,
 		info:     info,
 	}
-	if sonar == nil {
+	f := &instrumentedFile{file: file}
+	if !sonar {
 		file.addImport("go-fuzz-dep", fuzzdepPkg, "CoverTab")
 		ast.Walk(file, file.astFile)
 	} else {
-		s := &Sonar{
+		f.sonar = &Sonar{
 			fset:     fset,
 			fullName: fullName,
 			pkg:      pkg,
-			blocks:   sonar,
 			info:     info,
 		}
-		ast.Walk(s, file.astFile)
+		ast.Walk(f.sonar, file.astFile)
+	}
+	return f
+}
+
+// allocateIDs assigns ids to cover counters or sonar sites of files of package pkg and returns their blocks.
+// Cover ids depend only on pkg and position of the counter in the package, so they are stable across builds.
+// Dense cover ids (dense is set) and sonar ids are numbered from 0 within the package
+// and need to be offset by the package coverBase and sonarBase respectively.
+func allocateIDs(pkg string, files []*instrumentedFile, dense bool) []CoverBlock {
+	var blocks []CoverBlock
+	for _, f := range files {
+		if f.sonar == nil {
+			for i, counter := range f.file.counters {
+				cnt := genCounter(pkg, len(blocks))
+				idx := ast.Expr(&ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(cnt)})
+				if dense {
+					cnt = len(blocks)
+					idx = &ast.BinaryExpr{X: ast.NewIdent(coverBase), Op: token.ADD, Y: &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(cnt)}}
+				}
+				counter.Index = idx
+				f.file.blocks[i].ID = cnt
+				blocks = append(blocks, f.file.blocks[i])
+			}
+			continue
+		}
+		base := len(blocks)
+		for i, lit := range f.sonar.ids {
+			id, _ := strconv.Atoi(lit.Value)
+			lit.Value = strconv.Itoa(id + base<<8)
+			f.sonar.blocks[i].ID += base
+			blocks = append(blocks, f.sonar.blocks[i])
+		}
 	}
+	return blocks
+}
 
-	file.print(out)
+func (f *instrumentedFile) print(w io.Writer) {
+	f.file.print(w)
 }
 
 type Sonar struct {
 	fset     *token.FileSet
 	fullName string
 	pkg      string
-	blocks   *[]CoverBlock
+	blocks   []CoverBlock
+	ids      []*ast.BasicLit // id arguments of sonar calls, numbered from 0 within the file
 	info     *types.Info
 }
 
-var sonarSeq = 0
+// newSite registers a new sonar site for node n and returns its id expression.
+func (s *Sonar) newSite(n ast.Node, flags uint8, metaFlags int) ast.Expr {
+	seq := len(s.ids)
+	startPos := s.fset.Position(n.Pos())
+	endPos := s.fset.Position(n.End())
+	s.blocks = append(s.blocks, CoverBlock{seq, s.fullName, startPos.Line, startPos.Column, endPos.Line, endPos.Column, metaFlags})
+	id := &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(int(flags) | seq<<8)}
+	s.ids = append(s.ids, id)
+	return &ast.BinaryExpr{X: ast.NewIdent(sonarBase), Op: token.ADD, Y: id}
+}
 
 func (s *Sonar) Visit(n ast.Node) ast.Visitor {
 	// TODO: detect "x&mask==0", emit sonar(x, x&^mask)
@@ -64,6 +181,12 @@ func (s *Sonar) Visit(n ast.Node) ast.Visitor {
 	case *ast.BinaryExpr:
 		break
 
+	case *ast.FuncDecl:
+		if noInstrument(s.pkg, nn) {
+			return nil
+		}
+		return s
+
 	case *ast.GenDecl:
 		if nn.Tok != token.VAR {
 			return nil // constants and types are not interesting
@@ -73,6 +196,12 @@ func (s *Sonar) Visit(n ast.Node) ast.Visitor {
 	case *ast.SelectorExpr:
 		return nil
 
+	case *ast.CallExpr:
+		if s.instrumentCall(nn) {
+			return nil
+		}
+		return s // recurse
+
 	case *ast.SwitchStmt:
 		if nn.Tag == nil || nn.Body == nil {
 			return s // recurse
@@ -188,8 +317,6 @@ func (s *Sonar) Visit(n ast.Node) ast.Visitor {
 	// we would like to emit:
 	//	Sonar(x, 100*3, SonarEQL)
 
-	// TODO: intercept strings.Index/HasPrefix and similar functions.
-
 	nn := n.(*ast.BinaryExpr)
 	var flags uint8
 	switch nn.Op {
@@ -272,11 +399,16 @@ func (s *Sonar) Visit(n ast.Node) ast.Visitor {
 	if flags&SonarConst1 != 0 && flags&SonarConst2 != 0 {
 		return nil
 	}
-	id := int(flags) | sonarSeq<<8
-	startPos := s.fset.Position(nn.Pos())
-	endPos := s.fset.Position(nn.End())
-	*s.blocks = append(*s.blocks, CoverBlock{sonarSeq, s.fullName, startPos.Line, startPos.Column, endPos.Line, endPos.Column, int(flags)})
-	sonarSeq++
+	metaFlags := int(flags)
+	floatType, isFloat := tv.Type.Underlying().(*types.Basic)
+	if isFloat && floatType.Info()&types.IsFloat == 0 {
+		isFloat = false
+	}
+	if isFloat {
+		metaFlags |= SonarFloat
+	}
+	metaFlags |= intWidth(tv.Type) << SonarWidthShift
+	id := s.newSite(nn, flags, metaFlags)
 	block := &ast.BlockStmt{}
 
 	typstr := tv.Type.String()
@@ -322,11 +454,27 @@ func (s *Sonar) Visit(n ast.Node) ast.Visitor {
 	v1 = conv("__gofuzz_v1", v1)
 	v2 = conv("__gofuzz_v2", v2)
 
+	arg1, arg2 := v1, v2
+	if isFloat && tv.Type != floatType {
+		// Runtime recognizes only float32/float64, so convert user types
+		// with float underlying type to the basic type.
+		// The alias is used because the user's code can shadow the basic type name.
+		alias := "_go_fuzz_dep_.Float64"
+		if floatType.Kind() == types.Float32 {
+			alias = "_go_fuzz_dep_.Float32"
+		}
+		floatConv := func(v ast.Expr) ast.Expr {
+			c := &ast.CallExpr{Fun: ast.NewIdent(alias), Args: []ast.Expr{v}}
+			s.info.Types[c] = types.TypeAndValue{Type: floatType}
+			return c
+		}
+		arg1, arg2 = floatConv(v1), floatConv(v2)
+	}
 	block.List = append(block.List,
 		&ast.ExprStmt{
 			X: &ast.CallExpr{
 				Fun:  &ast.SelectorExpr{X: ast.NewIdent(fuzzdepPkg), Sel: ast.NewIdent("Sonar")},
-				Args: []ast.Expr{v1, v2, &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(id)}},
+				Args: []ast.Expr{arg1, arg2, id},
 			},
 		},
 		&ast.ReturnStmt{Results: []ast.Expr{&ast.BinaryExpr{Op: nn.Op, X: v1, Y: v2, OpPos: nn.Pos()}}},
@@ -339,9 +487,162 @@ func (s *Sonar) Visit(n ast.Node) ast.Visitor {
 	}
 	nn.Y = &ast.BasicLit{Kind: token.INT, Value: "true"}
 	nn.Op = token.EQL
+	setMissingPos(nn, nn.OpPos)
 	return nil
 }
 
+// setMissingPos sets position of generated identifiers, literals and statements in n to pos.
+// The printer estimates positions of nodes without them from the amount of output,
+// which quickly runs ahead of the source in large generated functions,
+// and then prints comments that follow n (e.g. a //go:nocheckptr directive
+// of the next function) inside the generated code.
+func setMissingPos(n ast.Node, pos token.Pos) {
+	ast.Inspect(n, func(n ast.Node) bool {
+		switch nn := n.(type) {
+		case *ast.Ident:
+			if !nn.NamePos.IsValid() {
+				nn.NamePos = pos
+			}
+		case *ast.BasicLit:
+			if !nn.ValuePos.IsValid() {
+				nn.ValuePos = pos
+			}
+		case *ast.AssignStmt:
+			if !nn.TokPos.IsValid() {
+				nn.TokPos = pos
+			}
+		case *ast.ReturnStmt:
+			if !nn.Return.IsValid() {
+				nn.Return = pos
+			}
+		}
+		return true
+	})
+}
+
+// sonarCompare says how a function in sonarFuncs compares its arguments.
+type sonarCompare int
+
+const (
+	compareFull     sonarCompare = iota // the arguments are compared as a whole
+	comparePrefix                       // only a prefix of the first argument is compared with the second one
+	compareContains                     // the second argument is searched anywhere in the first one
+	compareFold                         // the arguments are compared as a whole under case folding
+)
+
+// sonarFuncs are library functions that compare their arguments.
+// Prefix and contains comparisons need the constant as the second argument.
+// Sonar compares only a prefix of the first argument for contains comparisons too,
+// a deliberate truncation heuristic: the hint makes the input start with
+// the searched constant, and such input satisfies Contains as well.
+var sonarFuncs = map[string]sonarCompare{
+	"bytes.Equal":       compareFull,
+	"bytes.Compare":     compareFull,
+	"bytes.HasPrefix":   comparePrefix,
+	"strings.HasPrefix": comparePrefix,
+	"strings.Contains":  compareContains,
+	"strings.EqualFold": compareFold,
+}
+
+// instrumentCall instruments calls of sonarFuncs with a constant argument.
+// It returns false if the call is not instrumented.
+func (s *Sonar) instrumentCall(nn *ast.CallExpr) bool {
+	sel, ok := nn.Fun.(*ast.SelectorExpr)
+	if !ok || len(nn.Args) != 2 || nn.Ellipsis.IsValid() {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	if !ok {
+		return false
+	}
+	pkg, ok := s.info.Uses[x].(*types.PkgName)
+	if !ok {
+		return false
+	}
+	fn := pkg.Imported().Path() + "." + sel.Sel.Name
+	cmp, ok := sonarFuncs[fn]
+	if !ok {
+		return false
+	}
+	prefix := cmp == comparePrefix || cmp == compareContains
+	const1 := s.isConstBytes(nn.Args[0])
+	const2 := s.isConstBytes(nn.Args[1])
+	if const1 == const2 || prefix && const1 {
+		// We need exactly one constant operand to provide a hint,
+		// and for prefix and contains functions it must be the second one.
+		return false
+	}
+	flags := uint8(SonarEQL | SonarString)
+	if const1 {
+		flags |= SonarConst1
+	} else {
+		flags |= SonarConst2
+	}
+	ast.Walk(s, nn.Args[0])
+	ast.Walk(s, nn.Args[1])
+	res := "_go_fuzz_dep_.Bool"
+	if s.info.Types[nn].Type.String() == "int" {
+		res = "_go_fuzz_dep_.Int"
+	}
+	sonarFn := "Sonar"
+	switch {
+	case prefix:
+		sonarFn = "SonarPrefix"
+	case cmp == compareFold:
+		sonarFn = "SonarFold"
+	}
+
+	// Replace:
+	//	bytes.Equal(x, y)
+	// with:
+	//	func() _go_fuzz_dep_.Bool { v1 := []_go_fuzz_dep_.Byte(x); v2 := []_go_fuzz_dep_.Byte(y); go-fuzz-dep.Sonar(v1, v2, flags); return bytes.Equal(v1, v2) }()
+	// Conversion strips user types with []byte underlying type, runtime recognizes only []byte and string.
+	// Aliases from go-fuzz-dep are used because the user's code can shadow string and byte.
+	id := s.newSite(nn, flags, int(flags))
+	var typ ast.Expr = ast.NewIdent("_go_fuzz_dep_.String")
+	if pkg.Imported().Path() == "bytes" {
+		typ = &ast.ArrayType{Elt: ast.NewIdent("_go_fuzz_dep_.Byte")}
+	}
+	block := &ast.BlockStmt{}
+	var args []ast.Expr
+	for i, arg := range nn.Args {
+		tmp := ast.NewIdent(fmt.Sprintf("__gofuzz_v%v", i+1))
+		block.List = append(block.List, &ast.AssignStmt{
+			Tok: token.DEFINE,
+			Lhs: []ast.Expr{tmp},
+			Rhs: []ast.Expr{&ast.CallExpr{Fun: typ, Args: []ast.Expr{arg}}},
+		})
+		args = append(args, tmp)
+	}
+	block.List = append(block.List,
+		&ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun:  &ast.SelectorExpr{X: ast.NewIdent(fuzzdepPkg), Sel: ast.NewIdent(sonarFn)},
+				Args: []ast.Expr{args[0], args[1], id},
+			},
+		},
+		&ast.ReturnStmt{Results: []ast.Expr{&ast.CallExpr{Fun: nn.Fun, Args: args}}},
+	)
+	nn.Fun = &ast.FuncLit{
+		Type: &ast.FuncType{Results: &ast.FieldList{List: []*ast.Field{{Type: ast.NewIdent(res)}}}},
+		Body: block,
+	}
+	nn.Args = nil
+	setMissingPos(nn, nn.Lparen)
+	return true
+}
+
+// isConstBytes reports whether n is a constant or a conversion of a constant, e.g. []byte("foo").
+func (s *Sonar) isConstBytes(n ast.Expr) bool {
+	if isConstExpr(s.info, n) {
+		return true
+	}
+	if call, ok := n.(*ast.CallExpr); ok && len(call.Args) == 1 && s.info.Types[call.Fun].IsType() {
+		return isConstExpr(s.info, call.Args[0])
+	}
+	return false
+}
+
 func isWeirdShift(info *types.Info, n ast.Expr) bool {
 	w := &WeirdShiftWalker{info: info}
 	ast.Walk(w, n)
@@ -386,17 +687,50 @@ func isLen(n ast.Expr) bool {
 	return false
 }
 
+// intWidth returns size in bytes of integer type typ,
+// or 0 if typ is not an integer or its size depends on the target.
+func intWidth(typ types.Type) int {
+	basic, ok := typ.Underlying().(*types.Basic)
+	if !ok {
+		return 0
+	}
+	switch basic.Kind() {
+	case types.Int8, types.Uint8:
+		return 1
+	case types.Int16, types.Uint16:
+		return 2
+	case types.Int32, types.Uint32:
+		return 4
+	case types.Int64, types.Uint64:
+		return 8
+	}
+	return 0
+}
+
 type LiteralCollector struct {
-	ctxt *Context
-	lits map[Literal]struct{}
+	ctxt   *Context
+	info   *types.Info
+	lits   map[Literal]struct{}
+	consts int // number of literals collected from const declarations
 }
 
+// maxConstLiterals limits number of literals collected from const declarations
+// of a package (a collector walks all files of one package), so that large
+// generated enums don't crowd out other literals of the package.
+// The dictionary as a whole is bounded by -maxliterals.
+const maxConstLiterals = 256
+
 func (lc *LiteralCollector) Visit(n ast.Node) (w ast.Visitor) {
 	switch nn := n.(type) {
 	default:
 		return lc // recurse
 	case *ast.ImportSpec:
 		return nil
+	case *ast.GenDecl:
+		if nn.Tok == token.CONST && lc.info != nil {
+			lc.collectConsts(nn)
+		}
+		return lc
 	case *ast.Field:
 		return nil // ignore field tags
 	case *ast.CallExpr:
@@ -430,22 +764,62 @@ func (lc *LiteralCollector) Visit(n ast.Node) (w ast.Visitor) {
 				}
 				v = int64(u)
 			}
-			var val []byte
-			if v >= -(1<<7) && v < 1<<8 {
-				val = append(val, byte(v))
-			} else if v >= -(1<<15) && v < 1<<16 {
-				val = append(val, byte(v), byte(v>>8))
-			} else if v >= -(1<<31) && v < 1<<32 {
-				val = append(val, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
-			} else {
-				val = append(val, byte(v), byte(v>>8), byte(v>>16), byte(v>>24), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
-			}
-			lc.lits[Literal{string(val), false}] = struct{}{}
+			lc.lits[intLiteral(v)] = struct{}{}
 		}
 		return nil
 	}
 }
 
+// collectConsts collects values of constants declared in decl.
+// Values are taken from type info, so that iota-generated sequences
+// are collected as well (they don't have literals in the source).
+func (lc *LiteralCollector) collectConsts(decl *ast.GenDecl) {
+	for _, spec := range decl.Specs {
+		for _, name := range spec.(*ast.ValueSpec).Names {
+			obj, ok := lc.info.Defs[name].(*types.Const)
+			if !ok || name.Name == "_" {
+				continue
+			}
+			if lc.consts >= maxConstLiterals {
+				return
+			}
+			val := obj.Val()
+			switch val.Kind() {
+			case constant.String:
+				lc.lits[Literal{constant.StringVal(val), true}] = struct{}{}
+			case constant.Int:
+				v, ok := constant.Int64Val(val)
+				if !ok {
+					u, ok := constant.Uint64Val(val)
+					if !ok {
+						continue // does not fit into 64 bits
+					}
+					v = int64(u)
+				}
+				lc.lits[intLiteral(v)] = struct{}{}
+			default:
+				continue
+			}
+			lc.consts++
+		}
+	}
+}
+
+// intLiteral returns little-endian encoding of v with the minimal size that holds it.
+func intLiteral(v int64) Literal {
+	var val []byte
+	if v >= -(1<<7) && v < 1<<8 {
+		val = append(val, byte(v))
+	} else if v >= -(1<<15) && v < 1<<16 {
+		val = append(val, byte(v), byte(v>>8))
+	} else if v >= -(1<<31) && v < 1<<32 {
+		val = append(val, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	} else {
+		val = append(val, byte(v), byte(v>>8), byte(v>>16), byte(v>>24), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+	}
+	return Literal{string(val), false}
+}
+
 func trimComments(file *ast.File, fset *token.FileSet) []*ast.CommentGroup {
 	var comments []*ast.CommentGroup
 	for _, group := range file.Comments {
@@ -462,6 +836,9 @@ func trimComments(file *ast.File, fset *token.FileSet) []*ast.CommentGroup {
 	return comments
 }
 
+// initialComments returns the comment lines that precede the package clause,
+// except //go:build lines: trimComments keeps them in the AST, so the printer
+// writes them along with the file, and the go command rejects files with several.
 func initialComments(content []byte) []byte {
 	// Derived from go/build.Context.shouldBuild.
 	end := 0
@@ -482,7 +859,13 @@ func initialComments(content []byte) []byte {
 			break
 		}
 	}
-	return content[:end]
+	var res []byte
+	for _, line := range bytes.SplitAfter(content[:end], []byte("\n")) {
+		if !bytes.HasPrefix(line, goBuild) {
+			res = append(res, line...)
+		}
+	}
+	return res
 }
 
 type File struct {
@@ -490,11 +873,15 @@ type File struct {
 	pkg      string
 	fullName string
 	astFile  *ast.File
-	blocks   *[]CoverBlock
+	blocks   []CoverBlock
+	counters []*ast.IndexExpr // counters, in the same order as blocks
 	info     *types.Info
 }
 
-var slashslash = []byte("//")
+var (
+	slashslash = []byte("//")
+	goBuild    = []byte("//go:build")
+)
 
 func (f *File) Visit(node ast.Node) ast.Visitor {
 	switch n := node.(type) {
@@ -504,6 +891,9 @@ func (f *File) Visit(node ast.Node) ast.Visitor {
 			// They run regardless of what we do, so it is just noise.
 			return nil
 		}
+		if noInstrument(f.pkg, n) {
+			return nil
+		}
 	case *ast.GenDecl:
 		if n.Tok != token.VAR {
 			return nil // constants and types are not interesting
@@ -827,36 +1217,25 @@ func (f *File) statementBoundary(s ast.Stmt) token.Pos {
 	return s.End()
 }
 
-var counterGen uint32
-
-func genCounter() int {
-	counterGen++
-	id := counterGen
-	buf := []byte{byte(id), byte(id >> 8), byte(id >> 16), byte(id >> 24)}
+// genCounter returns coverage table index for seq-th counter in package pkg.
+func genCounter(pkg string, seq int) int {
+	buf := append([]byte(pkg), byte(seq), byte(seq>>8), byte(seq>>16), byte(seq>>24))
 	hash := sha1.Sum(buf)
 	return int(uint16(hash[0]) | uint16(hash[1])<<8)
 }
 
 func (f *File) newCounter(start, end token.Pos, numStmt int) ast.Stmt {
-	cnt := genCounter()
-
-	if f.blocks != nil {
-		s := f.fset.Position(start)
-		e := f.fset.Position(end)
-		*f.blocks = append(*f.blocks, CoverBlock{cnt, f.fullName, s.Line, s.Column, e.Line, e.Column, numStmt})
-	}
-
-	idx := &ast.BasicLit{
-		Kind:  token.INT,
-		Value: strconv.Itoa(cnt),
-	}
+	s := f.fset.Position(start)
+	e := f.fset.Position(end)
+	f.blocks = append(f.blocks, CoverBlock{0, f.fullName, s.Line, s.Column, e.Line, e.Column, numStmt})
 	counter := &ast.IndexExpr{
 		X: &ast.SelectorExpr{
 			X:   ast.NewIdent(fuzzdepPkg),
 			Sel: ast.NewIdent("CoverTab"),
 		},
-		Index: idx,
+		Index: &ast.BasicLit{Kind: token.INT}, // replaced by allocateIDs
 	}
+	f.counters = append(f.counters, counter)
 	return &ast.IncDecStmt{
 		X:   counter,
 		Tok: token.INC,
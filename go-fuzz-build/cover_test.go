@@ -0,0 +1,539 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+// typecheck parses and type-checks src as package foo.
+func typecheck(t *testing.T, src string) (*token.FileSet, *ast.File, *types.Info) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "foo.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	conf := types.Config{Importer: importer.Default()}
+	if _, err := conf.Check("foo", fset, []*ast.File{f}, info); err != nil {
+		t.Fatal(err)
+	}
+	return fset, f, info
+}
+
+// instrumentSonar instruments src with sonar and returns the resulting source and sonar blocks.
+func instrumentSonar(t *testing.T, src string) (string, []CoverBlock) {
+	fset, f, info := typecheck(t, src)
+	var buf bytes.Buffer
+	var blocks, sonar []CoverBlock
+	instrument("foo", "foo.go", fset, f, info, &buf, &blocks, &sonar)
+	if _, err := parser.ParseFile(token.NewFileSet(), "foo.go", buf.Bytes(), 0); err != nil {
+		t.Fatalf("instrumented source does not parse: %v\n%s", err, buf.Bytes())
+	}
+	return buf.String(), sonar
+}
+
+func TestSonarFloat(t *testing.T) {
+	src := `package foo
+
+type Celsius float64
+
+type Ratio float32
+
+func Parse(x float64, y float32, c Celsius, r Ratio, n int) int {
+	if x > 3.14159 {
+		return 1
+	}
+	if y == 2.5 {
+		return 2
+	}
+	if c < -273.15 {
+		return 3
+	}
+	if r >= 0.5 {
+		return 5
+	}
+	if n != 5 {
+		return 4
+	}
+	return 0
+}
+`
+	out, sonar := instrumentSonar(t, src)
+	if len(sonar) != 5 {
+		t.Fatalf("got %v sonar sites, want 5:\n%s", len(sonar), out)
+	}
+	for i, b := range sonar {
+		isFloat := b.NumStmt&SonarFloat != 0
+		if want := i < 4; isFloat != want {
+			t.Errorf("site %v at line %v: float flag is %v, want %v", i, b.StartLine, isFloat, want)
+		}
+		if b.NumStmt&SonarConst2 == 0 {
+			t.Errorf("site %v at line %v: const operand is not detected", i, b.StartLine)
+		}
+	}
+	if n := strings.Count(out, fuzzdepPkg+".Sonar("); n != 5 {
+		t.Errorf("got %v sonar calls, want 5:\n%s", n, out)
+	}
+	// Untyped float constants must be converted to the operand type,
+	// and user types must be converted to the basic float type for the runtime
+	// through aliases that can't be shadowed.
+	for _, want := range []string{"float64(3.14159)", "float32(2.5)", "Celsius(-273.15)", "Ratio(0.5)",
+		fuzzdepPkg + ".Float64(__gofuzz_v1)", fuzzdepPkg + ".Float32(__gofuzz_v1)"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("instrumented source does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSonarWidth(t *testing.T) {
+	src := `package foo
+
+type Kind uint16
+
+type Header struct {
+	typ  uint16
+	kind Kind
+	len  int8
+	crc  uint32
+	off  int
+}
+
+func Parse(h *Header, x int64) int {
+	if h.typ == 0x12 {
+		return 1
+	}
+	if h.kind != 7 {
+		return 2
+	}
+	if h.len < -1 {
+		return 3
+	}
+	if h.crc == uint32(x) {
+		return 4
+	}
+	if x > 1<<40 {
+		return 5
+	}
+	if h.off == 3 {
+		return 6
+	}
+	return 0
+}
+`
+	out, sonar := instrumentSonar(t, src)
+	want := []int{2, 2, 1, 4, 8, 0}
+	if len(sonar) != len(want) {
+		t.Fatalf("got %v sonar sites, want %v:\n%s", len(sonar), len(want), out)
+	}
+	for i, b := range sonar {
+		if w := b.NumStmt >> SonarWidthShift; w != want[i] {
+			t.Errorf("site %v at line %v: got width %v, want %v", i, b.StartLine, w, want[i])
+		}
+	}
+}
+
+func TestSonarBytesCalls(t *testing.T) {
+	src := `package foo
+
+import (
+	"bytes"
+	"strings"
+)
+
+type Header []byte
+
+func Parse(data []byte, hdr Header, s, t string) int {
+	if len(data) >= 4 && bytes.Equal(data[:4], []byte("PK\x03\x04")) {
+		return 1
+	}
+	if bytes.HasPrefix(hdr, []byte("\x89PNG")) {
+		return 2
+	}
+	if bytes.Compare([]byte("GIF8"), data) == 0 {
+		return 3
+	}
+	if strings.HasPrefix(s, "%PDF") || strings.Contains(s, "<?xml") || strings.EqualFold(s, "MThd") {
+		return 4
+	}
+	// Not instrumented: no constant operands, constant is not the prefix or the searched string, other functions.
+	if bytes.Equal(data, hdr) || strings.HasPrefix("foo", s) || strings.Contains(s, t) || strings.Contains("<html>", s) || strings.Index(s, "bar") == 0 {
+		return 5
+	}
+	return 0
+}
+`
+	out, sonar := instrumentSonar(t, src)
+	// Calls are marked with SonarString, other sites are integer comparisons.
+	var calls []CoverBlock
+	for _, b := range sonar {
+		if b.NumStmt&SonarString != 0 {
+			calls = append(calls, b)
+		}
+	}
+	if len(calls) != 6 {
+		t.Fatalf("got %v instrumented calls, want 6:\n%s", len(calls), out)
+	}
+	for i, b := range calls {
+		const1 := b.NumStmt&SonarConst1 != 0
+		const2 := b.NumStmt&SonarConst2 != 0
+		if want := i == 2; const1 != want || const2 == want {
+			t.Errorf("call %v at line %v: bad flags %x", i, b.StartLine, b.NumStmt)
+		}
+	}
+	for _, want := range []string{
+		fuzzdepPkg + `.Sonar(__gofuzz_v1, __gofuzz_v2, `,
+		`__gofuzz_v2 := []_go_fuzz_dep_.Byte([]byte("PK\x03\x04"))`,
+		`__gofuzz_v1 := []_go_fuzz_dep_.Byte(hdr)`,
+		fuzzdepPkg + `.SonarPrefix(__gofuzz_v1, __gofuzz_v2, `,
+		`__gofuzz_v2 := _go_fuzz_dep_.String("<?xml")`,
+		fuzzdepPkg + `.SonarFold(__gofuzz_v1, __gofuzz_v2, `,
+		`return bytes.Compare(__gofuzz_v1, __gofuzz_v2)`,
+		`func() _go_fuzz_dep_.Int {`,
+		`bytes.Equal(data, hdr)`,
+		`strings.HasPrefix("foo", s)`,
+		`strings.Contains(s, t)`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("instrumented source does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestSonarDirectives(t *testing.T) {
+	src := `package foo
+
+func F(a, b []byte, s string, x, y int) bool {
+	return x == 1 || y == 2 || x+y == 3 || string(a) == "foo" || bytes.Equal(a, []byte("bar")) || strings.HasPrefix(s, "baz")
+}
+
+//go:noinline
+// The directive is separated from the doc comment.
+
+// G is not inlined.
+func G() {
+}
+`
+	src = strings.Replace(src, "package foo\n", "package foo\n\nimport (\n\t\"bytes\"\n\t\"strings\"\n)\n", 1)
+	out, _ := instrumentSonar(t, src)
+	// The directive must stay outside of the code generated for comparisons.
+	if !regexp.MustCompile(`(?m)^//go:noinline$`).MatchString(out) {
+		t.Fatalf("directive is moved:\n%s", out)
+	}
+}
+
+func TestInitialComments(t *testing.T) {
+	src := "// Copyright\n\n//go:build linux && !race\n// +build linux,!race\n\n// Package foo does things.\npackage foo\n\n//go:build ignored\n"
+	// The printer writes //go:build lines itself, because they are kept in the AST.
+	want := "// Copyright\n\n// +build linux,!race\n\n"
+	if got := string(initialComments([]byte(src))); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestConstLiterals(t *testing.T) {
+	src := `package foo
+
+type Opcode uint16
+
+const (
+	OpNop Opcode = iota
+	OpPush
+	OpPop
+	_
+	OpJump = 0x1234
+	OpCall = OpJump + 1
+	OpHalt = 1 << 20
+)
+
+const (
+	Magic      = "\x7fELF"
+	Version    = "v" + "1"
+	Pi         = 3.14
+	Big        = 1 << 70
+	Max uint32 = 1<<32 - 1
+)
+
+func Generated() {
+	const (
+		E0 = iota * 3
+		E1
+		E2
+		E3
+		E4
+		E5
+		E6
+		E7
+		E8
+		E9
+	)
+}
+`
+	_, f, info := typecheck(t, src)
+	lits := make(map[Literal]struct{})
+	ast.Walk(&LiteralCollector{lits: lits, info: info}, f)
+	for _, want := range []Literal{
+		{"\x00", false},             // OpNop
+		{"\x01", false},             // OpPush
+		{"\x02", false},             // OpPop
+		{"\x34\x12", false},         // OpJump
+		{"\x35\x12", false},         // OpCall
+		{"\x00\x00\x10\x00", false}, // OpHalt
+		{"\x7fELF", true},
+		{"v1", true},
+		{strings.Repeat("\xff", 4), false}, // Max
+		{"\x1b", false},                    // E9
+	} {
+		if _, ok := lits[want]; !ok {
+			t.Errorf("literal %q (str=%v) is not collected", want.Val, want.IsStr)
+		}
+	}
+}
+
+func TestConstLiteralsLimit(t *testing.T) {
+	// The limit is per package, so many small declarations can't bypass it.
+	const decls, consts = 10, 40
+	src := "package foo\n"
+	for i := 0; i < decls; i++ {
+		src += fmt.Sprintf("\nconst (\n\tE%v_0 = iota + %v\n", i, 1000+100*i)
+		for j := 1; j < consts; j++ {
+			src += fmt.Sprintf("\tE%v_%v\n", i, j)
+		}
+		src += ")\n"
+	}
+	_, f, info := typecheck(t, src)
+	lits := make(map[Literal]struct{})
+	ast.Walk(&LiteralCollector{lits: lits, info: info}, f)
+	// Literals like 1000 are collected regardless of the limit, but they are the same
+	// as the first const of their declaration, unless the declaration is over the limit.
+	if want := maxConstLiterals + (decls*consts-maxConstLiterals)/consts; len(lits) != want {
+		t.Fatalf("got %v literals, want %v", len(lits), want)
+	}
+}
+
+func TestSelectLiterals(t *testing.T) {
+	var src strings.Builder
+	src.WriteString("package foo\n\nfunc F(b []byte, s string, r rune) int {\n\tn := 0\n")
+	for i := 0; i < 3000; i++ {
+		// Trivial literals: small integers, characters and short strings.
+		fmt.Fprintf(&src, "\tif len(b) > %v || r == %q || s == %q {\n\t\tn++\n\t}\n", i%300, rune('a'+i%26), string(rune('a'+i%26)))
+		if i%30 == 0 {
+			fmt.Fprintf(&src, "\tif s == \"magic-token-%v\" || len(b) == %v {\n\t\tn++\n\t}\n", i, 1<<20+i)
+		}
+	}
+	src.WriteString("\treturn n\n}\n")
+	collect := func() map[Literal]struct{} {
+		_, f, info := typecheck(t, src.String())
+		lits := make(map[Literal]struct{})
+		ast.Walk(&LiteralCollector{lits: lits, info: info}, f)
+		return lits
+	}
+	lits := collect()
+	if len(lits) < 300 {
+		t.Fatalf("collected only %v literals", len(lits))
+	}
+	const max = 150
+	sel := selectLiterals(lits, max)
+	if len(sel) != max {
+		t.Fatalf("got %v literals, want %v", len(sel), max)
+	}
+	for i, lit := range sel {
+		if i != 0 && !lessLiteral(sel[i-1], lit) {
+			t.Errorf("literals are not sorted: %q, %q", sel[i-1].Val, lit.Val)
+		}
+	}
+	// The longest literals are kept.
+	for i := 0; i < 3000; i += 30 {
+		tok := Literal{fmt.Sprintf("magic-token-%v", i), true}
+		found := false
+		for _, lit := range sel {
+			found = found || lit == tok
+		}
+		if !found {
+			t.Errorf("literal %q is not selected", tok.Val)
+		}
+	}
+	// The result does not depend on map order.
+	for i := 0; i < 3; i++ {
+		if sel1 := selectLiterals(collect(), max); !reflect.DeepEqual(sel, sel1) {
+			t.Fatalf("selected literals differ between runs")
+		}
+	}
+	// Single-byte ints are kept unless a string literal has the same bytes.
+	all := selectLiterals(lits, 0)
+	if len(all) <= max {
+		t.Fatalf("got %v literals without limit", len(all))
+	}
+	ints := make(map[string]bool)
+	for _, lit := range all {
+		if _, dup := lits[Literal{lit.Val, true}]; !lit.IsStr && dup {
+			t.Errorf("literal %q duplicates a string literal", lit.Val)
+		}
+		if !lit.IsStr && len(lit.Val) == 1 {
+			ints[lit.Val] = true
+		}
+	}
+	if ints["a"] || !ints["\x00"] || !ints["\xff"] {
+		t.Errorf("bad single-byte literals: %v", ints)
+	}
+}
+
+func TestSonarIDs(t *testing.T) {
+	srcs := []string{`package foo
+
+func F(x int, s string) bool {
+	return x == 1 || x < 10 || s != "foo"
+}
+`, `package foo
+
+import "strings"
+
+func G(s string) bool {
+	return len(s) > 3 && strings.HasPrefix(s, "bar")
+}
+`}
+	var files []*instrumentedFile
+	for _, src := range srcs {
+		fset, f, info := typecheck(t, src)
+		files = append(files, instrumentFile("foo", "foo.go", fset, f, info, true))
+	}
+	blocks := allocateIDs("foo", files, false)
+	var buf bytes.Buffer
+	for _, f := range files {
+		f.print(&buf)
+	}
+	re := regexp.MustCompile(sonarBase + ` ?\+ ?([0-9]+)`)
+	matches := re.FindAllStringSubmatch(buf.String(), -1)
+	if len(matches) != 5 || len(blocks) != 5 {
+		t.Fatalf("got %v sonar calls and %v blocks, want 5:\n%s", len(matches), len(blocks), buf.Bytes())
+	}
+	for i, m := range matches {
+		id, _ := strconv.Atoi(m[1])
+		if id>>8 != i || blocks[i].ID != i || id&0xff != blocks[i].NumStmt&0xff {
+			t.Errorf("sonar call %v has id %v, block %+v", i, id, blocks[i])
+		}
+	}
+}
+
+func TestNoInstrument(t *testing.T) {
+	src := `package foo
+
+import "fmt"
+
+func Parse(s string) int {
+	if s == "foo" {
+		return 1
+	}
+	return 0
+}
+
+// Logf is called too often to be interesting.
+//go-fuzz:noinstrument
+func Logf(format string, args ...interface{}) {
+	if len(args) != 0 && format != "" {
+		fmt.Printf(format, args...)
+	}
+	func() {
+		if format == "\n" {
+			fmt.Println()
+		}
+	}()
+}
+
+type Metrics struct{ n int }
+
+func (m *Metrics) Inc(v int) {
+	if v > 0 {
+		m.n += v
+	}
+}
+
+func Check(x int) bool {
+	if x > 10 {
+		return true
+	}
+	return false
+}
+`
+	defer func(re *regexp.Regexp) { skipFunc = re }(skipFunc)
+	skipFunc = regexp.MustCompile(`^foo\.Metrics\.`)
+	fset, f, info := typecheck(t, src)
+	cover := instrumentFile("foo", "foo.go", fset, f, info, false)
+	blocks := allocateIDs("foo", []*instrumentedFile{cover}, true)
+	fset, f, info = typecheck(t, src)
+	sonar := instrumentFile("foo", "foo.go", fset, f, info, true)
+	sonarBlocks := allocateIDs("foo", []*instrumentedFile{sonar}, false)
+
+	// Logf is lines 13-23 and Inc is 27-31.
+	skipped := func(b CoverBlock) bool {
+		return b.EndLine >= 13 && b.StartLine <= 23 || b.EndLine >= 27 && b.StartLine <= 31
+	}
+	for _, bb := range [][]CoverBlock{blocks, sonarBlocks} {
+		var parse, check bool
+		for i, b := range bb {
+			if skipped(b) {
+				t.Errorf("block %+v is in a function that is not instrumented", b)
+			}
+			if b.ID != i {
+				t.Errorf("block %+v has id %v, want dense ids", b, b.ID)
+			}
+			parse = parse || b.StartLine >= 5 && b.EndLine <= 10
+			check = check || b.StartLine >= 33 && b.EndLine <= 38
+		}
+		if !parse || !check {
+			t.Errorf("functions around not instrumented ones have no blocks: %+v", bb)
+		}
+	}
+	for _, f := range []*instrumentedFile{cover, sonar} {
+		var buf bytes.Buffer
+		f.print(&buf)
+		if _, err := parser.ParseFile(token.NewFileSet(), "foo.go", buf.Bytes(), 0); err != nil {
+			t.Fatalf("instrumented source does not parse: %v\n%s", err, buf.Bytes())
+		}
+	}
+}
+
+func TestFuncName(t *testing.T) {
+	src := `package foo
+
+type T struct{}
+
+func F()      {}
+func (T) V()  {}
+func (*T) P() {}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "foo.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, decl := range f.Decls {
+		if fn, ok := decl.(*ast.FuncDecl); ok {
+			names = append(names, funcName("example.com/foo", fn))
+		}
+	}
+	want := "example.com/foo.F example.com/foo.T.V example.com/foo.T.P"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("got names %v, want %v", got, want)
+	}
+}
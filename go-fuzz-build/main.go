@@ -17,21 +17,28 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"runtime/pprof"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"text/template"
 	"unicode"
 	"unicode/utf8"
 
 	"golang.org/x/tools/go/packages"
 
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
 	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
 )
 
 var (
-	flagTag       = flag.String("tags", "", "a space-separated list of build tags to consider satisfied during the build")
+	flagTag       = flag.String("tags", "", "a comma- or space-separated list of build tags to consider satisfied during the build")
 	flagOut       = flag.String("o", "", "output file")
 	flagFunc      = flag.String("func", "", "preferred entry function")
 	flagWork      = flag.Bool("work", false, "don't remove working directory")
@@ -40,6 +47,10 @@ var (
 	flagLibFuzzer = flag.Bool("libfuzzer", false, "output static archive for use with libFuzzer")
 	flagBuildX    = flag.Bool("x", false, "print the commands if build fails")
 	flagPreserve  = flag.String("preserve", "", "a comma-separated list of import paths not to instrument")
+	flagCoverPkg  = flag.String("coverpkg", "", "a comma-separated list of import path patterns (e.g. example.com/lib/...) of packages to instrument, other packages are built as is")
+	flagTests     = flag.Bool("includetests", false, "also collect literals from _test.go files of the fuzzed package (they are not instrumented)")
+	flagMaxLits   = flag.Int("maxliterals", 10000, "max number of literals put into the dictionary, the longest ones are kept (0 means no limit)")
+	flagSkipFunc  = flag.String("skipfunc", "", "a regexp of functions not to instrument, matched against import path qualified names (e.g. example.com/log.Debugf, example.com/log.Logger.Printf)")
 )
 
 func makeTags() string {
@@ -50,8 +61,9 @@ func makeTags() string {
 	if *flagRace {
 		tags += " race"
 	}
-	if len(*flagTag) > 0 {
-		tags += " " + *flagTag
+	// cmd/go splits the list on commas if there are any, so we can't just append user tags.
+	for _, tag := range strings.FieldsFunc(*flagTag, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		tags += " " + tag
 	}
 	return tags
 }
@@ -75,13 +87,35 @@ func basePackagesConfig() *packages.Config {
 	return cfg
 }
 
-// checkModVendor reports if the GOFLAGS env variable
-// contains -mod=vendor, which enables vendoring for modules.
-func checkModVendor() bool {
-	val := os.Getenv("GOFLAGS")
-	for _, s := range strings.Split(val, " ") {
-		if s == "-mod=vendor" {
-			return true
+// modVendor reports whether cmd/go loads dependencies of the main module from its vendor dir.
+// It mirrors the cmd/go rules: -mod in GOFLAGS wins, otherwise vendor dir is used
+// if it contains modules.txt and go.mod declares go 1.14 or higher.
+func (c *Context) modVendor() bool {
+	out, err := exec.Command("go", "env", "GOMOD", "GOFLAGS").Output()
+	if err != nil {
+		c.failf("go env GOMOD GOFLAGS failed: %v", err)
+	}
+	lines := strings.Split(string(out), "\n")
+	gomod, goflags := strings.TrimSpace(lines[0]), ""
+	if len(lines) > 1 {
+		goflags = lines[1]
+	}
+	if gomod == "" || gomod == os.DevNull {
+		return false // GOPATH mode or no main module
+	}
+	for _, f := range strings.Fields(goflags) {
+		if strings.HasPrefix(f, "-mod=") {
+			return f == "-mod=vendor"
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(gomod), "vendor", "modules.txt")); err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(c.readFile(gomod)), "\n") {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "go" {
+			var major, minor int
+			fmt.Sscanf(f[1], "%d.%d", &major, &minor)
+			return major > 1 || major == 1 && minor >= 14
 		}
 	}
 	return false
@@ -107,18 +141,22 @@ func main() {
 	if *flagLibFuzzer && *flagRace {
 		c.failf("-race and -libfuzzer are incompatible")
 	}
-	if checkModVendor() {
-		// We don't support -mod=vendor with modules.
-		// Part of the issue is go-fuzz-dep and go-fuzz-defs
-		// won't be in the user's vendor directory.
-		c.failf("GOFLAGS with -mod=vendor is not supported")
+	if *flagMaxLits < 0 {
+		c.failf("bad -maxliterals value %v, want a non-negative number", *flagMaxLits)
+	}
+	if *flagSkipFunc != "" {
+		re, err := regexp.Compile(*flagSkipFunc)
+		if err != nil {
+			c.failf("bad -skipfunc regexp: %v", err)
+		}
+		skipFunc = re
 	}
-
 	c.startProfiling()  // start pprof as requested
 	c.loadPkg(pkg)      // load and typecheck pkg
-	c.getEnv()          // discover GOROOT, GOPATH
+	c.getEnv()          // discover GOROOT, GOPATH, GOOS, GOARCH
 	c.loadStd()         // load standard library
 	c.calcIgnore()      // calculate set of packages to ignore
+	c.initCache()       // set up build cache
 	c.makeWorkdir()     // create workdir
 	defer c.cleanup()   // delete workdir as needed, etc.
 	c.populateWorkdir() // copy tools and packages to workdir as needed
@@ -201,26 +239,40 @@ func main() {
 type Context struct {
 	fuzzpkg *packages.Package   // package containing Fuzz function
 	pkgs    []*packages.Package // typechecked root packages
+	fuzzDep []*packages.Package // go-fuzz-dep, loaded without syntax and types
 
 	std    map[string]bool // set of packages in the standard library
 	ignore map[string]bool // set of packages to ignore during instrumentation
 
 	allFuncs []string // all fuzz functions found in package
 
+	hasDecode    bool            // fuzzpkg has func Decode([]byte) (interface{}, bool)
+	hasEncode    bool            // fuzzpkg has func Encode(interface{}) []byte
+	decodedFuncs map[string]bool // fuzz functions that accept decoded input
+	outputFuncs  map[string]bool // differential fuzz functions that return output signature
+	maxInputSize int             // input size hint from maxInputSizeDirective, 0 if there is none
+
 	workdir string
 	GOROOT  string
 	GOPATH  string
+	GOOS    string // target OS and architecture, the build is cross-compiled if they differ from the host
+	GOARCH  string
 
 	cpuprofile *os.File
 
 	cmdGoHasTrimPath bool // does the active version of cmd/go have the -trimpath flag?
+
+	cache   buildCache
+	pkgHash map[*packages.Package]string // content hashes of packages for cache keys
 }
 
-// getEnv determines GOROOT and GOPATH and updates c accordingly.
+// getEnv determines GOROOT, GOPATH and target GOOS/GOARCH and updates c accordingly.
 func (c *Context) getEnv() {
 	env := map[string]string{
 		"GOROOT": "",
 		"GOPATH": "",
+		"GOOS":   "",
+		"GOARCH": "",
 	}
 	for k := range env {
 		v := os.Getenv(k)
@@ -237,6 +289,8 @@ func (c *Context) getEnv() {
 	}
 	c.GOROOT = env["GOROOT"]
 	c.GOPATH = env["GOPATH"]
+	c.GOOS = env["GOOS"]
+	c.GOARCH = env["GOARCH"]
 
 	out, err := exec.Command("go", "list", "-f", "'{{context.ReleaseTags}}'", "runtime").CombinedOutput()
 	if err != nil || len(out) == 0 {
@@ -258,8 +312,8 @@ func (c *Context) startProfiling() {
 	pprof.StartCPUProfile(c.cpuprofile)
 }
 
-// loadPkg loads, parses, and typechecks pkg (the package containing the Fuzz function),
-// go-fuzz-dep, and their dependencies.
+// loadPkg loads, parses, and typechecks pkg (the package containing the Fuzz function)
+// and its dependencies, and loads go-fuzz-dep.
 func (c *Context) loadPkg(pkg string) {
 	// Resolve pkg.
 	// See https://golang.org/issue/30826 and https://golang.org/issue/30828.
@@ -295,9 +349,9 @@ func (c *Context) loadPkg(pkg string) {
 	}
 	// We need to load:
 	// * the target package, obviously
-	// * go-fuzz-dep, since we use it for instrumentation
 	// * reflect, if we are using libfuzzer, since its generated main function requires it
-	loadpkgs := []string{pkg, "github.com/dvyukov/go-fuzz/go-fuzz-dep"}
+	// go-fuzz-dep is loaded separately by loadFuzzDep.
+	loadpkgs := []string{pkg}
 	if *flagLibFuzzer {
 		loadpkgs = append(loadpkgs, "reflect")
 	}
@@ -312,6 +366,7 @@ func (c *Context) loadPkg(pkg string) {
 	}
 
 	c.pkgs = initial
+	c.loadFuzzDep()
 
 	// Find the fuzz package among c.pkgs.
 	for _, p := range initial {
@@ -324,9 +379,22 @@ func (c *Context) loadPkg(pkg string) {
 		c.failf("internal error: failed to find fuzz package; please file an issue")
 	}
 
+	// Find the optional input decoder and encoder.
+	// Functions with these names but other signatures are not hooks,
+	// the package may well be a decoder itself.
+	s := c.fuzzpkg.Types.Scope()
+	if sig, ok := funcSig(s, "Decode"); ok && isDecodeSig(sig) {
+		c.hasDecode = true
+	}
+	if sig, ok := funcSig(s, "Encode"); ok && isEncodeSig(sig) && c.hasDecode {
+		c.hasEncode = true
+	}
+	c.findMaxInputSize()
+
 	// Find all fuzz functions in fuzzpkg.
 	foundFlagFunc := false
-	s := c.fuzzpkg.Types.Scope()
+	c.decodedFuncs = make(map[string]bool)
+	c.outputFuncs = make(map[string]bool)
 	for _, n := range s.Names() {
 		if !isFuzzFuncName(n) {
 			continue
@@ -334,7 +402,9 @@ func (c *Context) loadPkg(pkg string) {
 		// Check that n is a function with an appropriate signature.
 		typ := s.Lookup(n).Type()
 		sig, ok := typ.(*types.Signature)
-		if !ok || sig.Variadic() || !isFuzzSig(sig) {
+		decoded := ok && c.hasDecode && isDecodedFuzzSig(sig)
+		output := ok && isOutputFuzzSig(sig)
+		if !ok || sig.Variadic() || !isFuzzSig(sig) && !decoded && !output {
 			if n == *flagFunc {
 				c.failf("provided -func=%v, but %v is not a fuzz function", *flagFunc, *flagFunc)
 			}
@@ -342,14 +412,16 @@ func (c *Context) loadPkg(pkg string) {
 		}
 		// n is a fuzz function.
 		c.allFuncs = append(c.allFuncs, n)
+		c.decodedFuncs[n] = decoded
+		c.outputFuncs[n] = output
 		foundFlagFunc = foundFlagFunc || n == *flagFunc
 	}
 
 	if len(c.allFuncs) == 0 {
 		c.failf("could not find any fuzz functions in %v", c.fuzzpkg.PkgPath)
 	}
-	if len(c.allFuncs) > 255 {
-		c.failf("go-fuzz-build supports a maximum of 255 fuzz functions, found %v; please file an issue", len(c.allFuncs))
+	if len(c.allFuncs) > OutputFunc {
+		c.failf("go-fuzz-build supports a maximum of %v fuzz functions, found %v; please file an issue", OutputFunc, len(c.allFuncs))
 	}
 
 	if *flagFunc != "" {
@@ -372,12 +444,104 @@ func (c *Context) loadPkg(pkg string) {
 	}
 }
 
+// loadFuzzDep loads go-fuzz-dep, which instrumented code uses, with its dependencies.
+// It is not imported by the target, so with vendoring in modules mode it is not
+// in the vendor dir, and it is loaded from the module cache instead
+// (go.mod must require github.com/dvyukov/go-fuzz).
+// Replace directives of the main module apply to go-fuzz-dep as well.
+func (c *Context) loadFuzzDep() {
+	cfg := basePackagesConfig()
+	cfg.Mode = packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps
+	cfg.BuildFlags = []string{"-tags", makeTags()}
+	if c.modVendor() {
+		cfg.BuildFlags = append(cfg.BuildFlags, "-mod=mod")
+	}
+	pkgs, err := packages.Load(cfg, "github.com/dvyukov/go-fuzz/go-fuzz-dep")
+	if err != nil {
+		c.failf("could not load go-fuzz-dep: %v", err)
+	}
+	if packages.PrintErrors(pkgs) > 0 {
+		c.failf("could not load go-fuzz-dep, go get github.com/dvyukov/go-fuzz/go-fuzz-dep")
+	}
+	c.fuzzDep = pkgs
+}
+
+// maxInputSizeDirective advertises max meaningful input size of the fuzz package,
+// e.g. "//go-fuzz:maxinputsize 256". It is recorded in metadata as a hint for go-fuzz.
+const maxInputSizeDirective = "//go-fuzz:maxinputsize"
+
+// findMaxInputSize looks for maxInputSizeDirective in comments of fuzzpkg.
+func (c *Context) findMaxInputSize() {
+	for _, f := range c.fuzzpkg.Syntax {
+		for _, cg := range f.Comments {
+			for _, cm := range cg.List {
+				if !strings.HasPrefix(cm.Text, maxInputSizeDirective+" ") {
+					continue
+				}
+				pos := c.fuzzpkg.Fset.Position(cm.Pos())
+				n, err := strconv.Atoi(strings.TrimSpace(cm.Text[len(maxInputSizeDirective):]))
+				if err != nil || n <= 0 || n > MaxInputSize {
+					c.failf("%v: bad %v directive, want size in bytes in [1, %v]", pos, maxInputSizeDirective, MaxInputSize)
+				}
+				if c.maxInputSize != 0 && c.maxInputSize != n {
+					c.failf("%v: conflicting %v directives: %v and %v", pos, maxInputSizeDirective, c.maxInputSize, n)
+				}
+				c.maxInputSize = n
+			}
+		}
+	}
+}
+
 // isFuzzSig reports whether sig is of the form
 //   func FuzzFunc(data []byte) int
 func isFuzzSig(sig *types.Signature) bool {
 	return tupleHasTypes(sig.Params(), "[]byte") && tupleHasTypes(sig.Results(), "int")
 }
 
+// isOutputFuzzSig reports whether sig is of the form
+//   func FuzzFunc(data []byte) []byte
+// Such functions return output signature for differential fuzzing,
+// inputs that produce new signatures are interesting.
+func isOutputFuzzSig(sig *types.Signature) bool {
+	return tupleHasTypes(sig.Params(), "[]byte") && tupleHasTypes(sig.Results(), "[]byte")
+}
+
+// isDecodedFuzzSig reports whether sig is of the form
+//   func FuzzFunc(v interface{}) int
+// Such functions receive inputs converted by Decode.
+func isDecodedFuzzSig(sig *types.Signature) bool {
+	return sig.Params().Len() == 1 && isEmptyInterface(sig.Params().At(0).Type()) && tupleHasTypes(sig.Results(), "int")
+}
+
+// isDecodeSig reports whether sig is of the form
+//   func Decode(data []byte) (interface{}, bool)
+func isDecodeSig(sig *types.Signature) bool {
+	res := sig.Results()
+	return !sig.Variadic() && tupleHasTypes(sig.Params(), "[]byte") &&
+		res.Len() == 2 && isEmptyInterface(res.At(0).Type()) && res.At(1).Type().String() == "bool"
+}
+
+// isEncodeSig reports whether sig is of the form
+//   func Encode(v interface{}) []byte
+func isEncodeSig(sig *types.Signature) bool {
+	params := sig.Params()
+	return !sig.Variadic() && params.Len() == 1 && isEmptyInterface(params.At(0).Type()) && tupleHasTypes(sig.Results(), "[]byte")
+}
+
+func isEmptyInterface(typ types.Type) bool {
+	iface, ok := typ.Underlying().(*types.Interface)
+	return ok && iface.Empty()
+}
+
+// funcSig returns signature of function name declared in scope s.
+func funcSig(s *types.Scope, name string) (*types.Signature, bool) {
+	fn, ok := s.Lookup(name).(*types.Func)
+	if !ok {
+		return nil, false
+	}
+	return fn.Type().(*types.Signature), true
+}
+
 // tupleHasTypes reports whether tuple is composed of
 // elements with exactly the types in types.
 func tupleHasTypes(tuple *types.Tuple, types ...string) bool {
@@ -474,8 +638,7 @@ func (c *Context) populateWorkdir() {
 	if _, err := os.Stat(filepath.Join(c.GOROOT, "pkg", "include")); err == nil {
 		c.copyDir(filepath.Join(c.GOROOT, "pkg", "include"), filepath.Join(c.workdir, "goroot", "pkg", "include"))
 	} else {
-		// Cross-compilation is not implemented.
-		c.copyDir(filepath.Join(c.GOROOT, "pkg", runtime.GOOS+"_"+runtime.GOARCH), filepath.Join(c.workdir, "goroot", "pkg", runtime.GOOS+"_"+runtime.GOARCH))
+		c.copyDir(filepath.Join(c.GOROOT, "pkg", c.GOOS+"_"+c.GOARCH), filepath.Join(c.workdir, "goroot", "pkg", c.GOOS+"_"+c.GOARCH))
 	}
 
 	// Clone our package, go-fuzz-deps, and all dependencies.
@@ -484,17 +647,25 @@ func (c *Context) populateWorkdir() {
 	// we could instead just os.MkdirAll and copy non-Go files here.
 	// We'd still need to do a full package clone for packages that
 	// we aren't instrumenting (c.ignore).
-	packages.Visit(c.pkgs, nil, func(p *packages.Package) {
-		c.clonePackage(p)
+	// Packages of go-fuzz-dep are loaded separately, so std packages can appear in both graphs.
+	cloned := make(map[string]bool)
+	packages.Visit(append(c.pkgs[:len(c.pkgs):len(c.pkgs)], c.fuzzDep...), nil, func(p *packages.Package) {
+		if !cloned[p.PkgPath] {
+			cloned[p.PkgPath] = true
+			c.clonePackage(p)
+		}
 	})
 	c.copyFuzzDep()
 }
 
+// bigEndianArchs are GOARCH values of big-endian architectures.
+var bigEndianArchs = map[string]bool{
+	"armbe": true, "arm64be": true, "mips": true, "mips64": true, "mips64p32": true,
+	"ppc": true, "ppc64": true, "s390": true, "s390x": true, "sparc": true, "sparc64": true,
+}
+
 func (c *Context) createMeta(lits map[Literal]struct{}, blocks []CoverBlock, sonar []CoverBlock) string {
-	meta := MetaData{Blocks: blocks, Sonar: sonar, Funcs: c.allFuncs, DefaultFunc: *flagFunc}
-	for k := range lits {
-		meta.Literals = append(meta.Literals, k)
-	}
+	meta := MetaData{Version: MetaDataVersion, Literals: selectLiterals(lits, *flagMaxLits), Blocks: blocks, Sonar: sonar, Funcs: c.allFuncs, DefaultFunc: *flagFunc, Canonicalize: c.hasEncode, MaxInputSize: c.maxInputSize, BigEndian: bigEndianArchs[c.GOARCH], OutputFuncs: c.outputList()}
 	data, err := json.Marshal(meta)
 	if err != nil {
 		c.failf("failed to serialize meta information: %v", err)
@@ -504,6 +675,17 @@ func (c *Context) createMeta(lits map[Literal]struct{}, blocks []CoverBlock, son
 	return f
 }
 
+// outputList returns names of differential fuzz functions, in order of c.allFuncs.
+func (c *Context) outputList() []string {
+	var res []string
+	for _, fn := range c.allFuncs {
+		if c.outputFuncs[fn] {
+			res = append(res, fn)
+		}
+	}
+	return res
+}
+
 func (c *Context) buildInstrumentedBinary(blocks *[]CoverBlock, sonar *[]CoverBlock) string {
 	c.instrumentPackages(blocks, sonar)
 	mainPkg := c.createFuzzMain()
@@ -533,6 +715,8 @@ func (c *Context) buildInstrumentedBinary(blocks *[]CoverBlock, sonar *[]CoverBl
 	cmd.Env = append(os.Environ(),
 		"GOROOT="+filepath.Join(c.workdir, "goroot"),
 		"GOPATH="+filepath.Join(c.workdir, "gopath"),
+		"GOOS="+c.GOOS,
+		"GOARCH="+c.GOARCH,
 		"GO111MODULE=off",
 	)
 	if out, err := cmd.CombinedOutput(); err != nil {
@@ -554,7 +738,7 @@ func (c *Context) calcIgnore() {
 	// noisy (because they are low level), and/or not interesting.
 	// We could manually maintain this list, but that makes go-fuzz-build
 	// fragile in the face of internal standard library package changes.
-	roots := c.packagesNamed("runtime", "github.com/dvyukov/go-fuzz/go-fuzz-dep")
+	roots := append(c.packagesNamed("runtime"), c.fuzzDep...)
 	packages.Visit(roots, func(p *packages.Package) bool {
 		c.ignore[p.PkgPath] = true
 		return true
@@ -565,6 +749,75 @@ func (c *Context) calcIgnore() {
 	for _, path := range paths {
 		c.ignore[path] = true
 	}
+	if *flagCoverPkg != "" {
+		patterns := strings.Split(*flagCoverPkg, ",")
+		matched := false
+		packages.Visit(c.pkgs, nil, func(p *packages.Package) {
+			if !matchCoverPkg(patterns, p.PkgPath) {
+				c.ignore[p.PkgPath] = true
+			} else if !c.ignore[p.PkgPath] {
+				matched = true
+			}
+		})
+		if !matched {
+			c.failf("-coverpkg=%v does not match any package that can be instrumented", *flagCoverPkg)
+		}
+	}
+
+	// Instrumentation of cgo and assembly packages does not work,
+	// link them as is, coverage just won't include them.
+	var skipped []string
+	packages.Visit(c.pkgs, nil, func(p *packages.Package) {
+		if !c.ignore[p.PkgPath] && !c.std[p.PkgPath] && hasCgoOrAsm(p) {
+			c.ignore[p.PkgPath] = true
+			skipped = append(skipped, p.PkgPath)
+		}
+	})
+	if len(skipped) != 0 {
+		fmt.Fprintf(os.Stderr, "go-fuzz-build: not instrumenting cgo/assembly packages: %v\n", strings.Join(skipped, ", "))
+	}
+}
+
+// matchCoverPkg reports whether import path matches one of -coverpkg patterns.
+// As in go command patterns, "..." matches any string, and "x/..." also matches x.
+func matchCoverPkg(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		re := regexp.QuoteMeta(pattern)
+		re = strings.Replace(re, `\.\.\.`, `.*`, -1)
+		if strings.HasSuffix(re, `/.*`) {
+			re = strings.TrimSuffix(re, `/.*`) + `(/.*)?`
+		}
+		if regexp.MustCompile(`^` + re + `$`).MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCgoOrAsm reports whether package p imports "C" or contains assembly files.
+func hasCgoOrAsm(p *packages.Package) bool {
+	// Syntax is parsed from cgo output, so look at the original files.
+	for _, name := range p.GoFiles {
+		f, err := parser.ParseFile(token.NewFileSet(), name, nil, parser.ImportsOnly)
+		if err != nil {
+			continue // type checking would fail in this case anyway
+		}
+		for _, imp := range f.Imports {
+			if imp.Path.Value == `"C"` {
+				return true
+			}
+		}
+	}
+	for _, f := range p.OtherFiles {
+		if strings.HasSuffix(f, ".s") || strings.HasSuffix(f, ".S") {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *Context) gatherLiterals() map[Literal]struct{} {
@@ -574,20 +827,108 @@ func (c *Context) gatherLiterals() map[Literal]struct{} {
 		"unicode": true,
 	}
 
-	lits := make(map[Literal]struct{})
-	visit := func(pkg *packages.Package) {
-		if c.ignore[pkg.PkgPath] || nolits[pkg.PkgPath] {
+	var pkgs []*packages.Package
+	packages.Visit(c.pkgs, nil, func(pkg *packages.Package) {
+		if !c.ignore[pkg.PkgPath] && !nolits[pkg.PkgPath] {
+			pkgs = append(pkgs, pkg)
+		}
+	})
+	pkgLits := make([][]Literal, len(pkgs))
+	parallel(len(pkgs), func(i int) {
+		key := c.cacheKey(pkgs[i], "literals")
+		if e := c.cache.get(key); e != nil {
+			pkgLits[i] = e.Literals
 			return
 		}
-		for _, f := range pkg.Syntax {
-			ast.Walk(&LiteralCollector{lits: lits, ctxt: c}, f)
+		lits := make(map[Literal]struct{})
+		lc := &LiteralCollector{lits: lits, ctxt: c, info: pkgs[i].TypesInfo}
+		for _, f := range pkgs[i].Syntax {
+			ast.Walk(lc, f)
+		}
+		pkgLits[i] = sortedLiterals(lits)
+		c.cache.put(key, &cacheEntry{Literals: pkgLits[i]})
+	})
+	lits := make(map[Literal]struct{})
+	for _, l := range pkgLits {
+		for _, lit := range l {
+			lits[lit] = struct{}{}
 		}
 	}
-
-	packages.Visit(c.pkgs, nil, visit)
+	if *flagTests {
+		c.gatherTestLiterals(lits)
+	}
 	return lits
 }
 
+// gatherTestLiterals adds literals from _test.go files of the fuzzed package to lits.
+// Tests and examples often contain magic values and valid inputs.
+// Test files are only parsed (they are not part of the loaded package),
+// so constants are collected only if they are spelled as literals.
+func (c *Context) gatherTestLiterals(lits map[Literal]struct{}) {
+	if len(c.fuzzpkg.GoFiles) == 0 {
+		return
+	}
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(c.fuzzpkg.GoFiles[0]), "*_test.go"))
+	if err != nil {
+		c.failf("failed to list test files: %v", err)
+	}
+	fset := token.NewFileSet()
+	for _, fn := range files {
+		f, err := parser.ParseFile(fset, fn, nil, 0)
+		if err != nil {
+			c.failf("failed to parse test file: %v", err)
+		}
+		ast.Walk(&LiteralCollector{lits: lits, ctxt: c}, f)
+	}
+}
+
+// sortedLiterals returns lits sorted by value.
+func sortedLiterals(lits map[Literal]struct{}) []Literal {
+	res := make([]Literal, 0, len(lits))
+	for lit := range lits {
+		res = append(res, lit)
+	}
+	sortLiterals(res)
+	return res
+}
+
+func sortLiterals(lits []Literal) {
+	sort.Slice(lits, func(i, j int) bool {
+		return lessLiteral(lits[i], lits[j])
+	})
+}
+
+func lessLiteral(a, b Literal) bool {
+	if a.Val != b.Val {
+		return a.Val < b.Val
+	}
+	return !a.IsStr && b.IsStr
+}
+
+// selectLiterals returns dictionary literals: lits without non-string ones
+// that duplicate bytes of a string literal (e.g. 'a' and "a" give the same token),
+// and, if there are more than max (0 means no limit), only the max longest ones.
+// The result is sorted by value, so it does not depend on the order of collection.
+func selectLiterals(lits map[Literal]struct{}, max int) []Literal {
+	res := make([]Literal, 0, len(lits))
+	for lit := range lits {
+		if _, dup := lits[Literal{lit.Val, true}]; lit.IsStr || !dup {
+			res = append(res, lit)
+		}
+	}
+	if max != 0 && len(res) > max {
+		sort.Slice(res, func(i, j int) bool {
+			if len(res[i].Val) != len(res[j].Val) {
+				return len(res[i].Val) > len(res[j].Val)
+			}
+			return lessLiteral(res[i], res[j])
+		})
+		res = res[:max]
+	}
+	sortLiterals(res)
+	return res
+}
+
 func (c *Context) copyFuzzDep() {
 	// Standard library packages can't depend on non-standard ones.
 	// So we pretend that go-fuzz-dep is a standard one.
@@ -598,7 +939,18 @@ func (c *Context) copyFuzzDep() {
 	// directly into the go-fuzz-dep package.
 	newDir := filepath.Join(c.workdir, "goroot", "src", "go-fuzz-dep")
 	c.mkdirAll(newDir)
-	dep := c.packageNamed("github.com/dvyukov/go-fuzz/go-fuzz-dep")
+	var dep, defs *packages.Package
+	packages.Visit(c.fuzzDep, nil, func(p *packages.Package) {
+		switch p.PkgPath {
+		case "github.com/dvyukov/go-fuzz/go-fuzz-dep":
+			dep = p
+		case "github.com/dvyukov/go-fuzz/go-fuzz-defs":
+			defs = p
+		}
+	})
+	if dep == nil || defs == nil {
+		c.failf("internal error: go-fuzz-dep is not loaded; please file an issue")
+	}
 	for _, f := range dep.GoFiles {
 		data := c.readFile(f)
 		// Eliminate the dot import.
@@ -606,7 +958,6 @@ func (c *Context) copyFuzzDep() {
 		c.writeFile(filepath.Join(newDir, filepath.Base(f)), data)
 	}
 
-	defs := c.packageNamed("github.com/dvyukov/go-fuzz/go-fuzz-defs")
 	for _, f := range defs.GoFiles {
 		data := c.readFile(f)
 		// Adjust package name to match go-fuzz-deps.
@@ -620,7 +971,15 @@ func (c *Context) funcMain() []byte {
 	if *flagLibFuzzer {
 		t = mainSrcLibFuzzer
 	}
-	dot := map[string]interface{}{"Pkg": c.fuzzpkg.PkgPath, "AllFuncs": c.allFuncs, "DefaultFunc": *flagFunc}
+	dot := map[string]interface{}{
+		"Pkg":         c.fuzzpkg.PkgPath,
+		"AllFuncs":    c.allFuncs,
+		"DefaultFunc": *flagFunc,
+		"Decoded":     c.decodedFuncs,
+		"Output":      c.outputFuncs,
+		"HasDecode":   c.hasDecode,
+		"HasEncode":   c.hasEncode,
+	}
 	buf := new(bytes.Buffer)
 	if err := t.Execute(buf, dot); err != nil {
 		c.failf("could not execute template: %v", err)
@@ -629,10 +988,11 @@ func (c *Context) funcMain() []byte {
 }
 
 func (c *Context) createFuzzMain() string {
-	mainPkg := filepath.Join(c.fuzzpkg.PkgPath, "go.fuzz.main")
-	path := filepath.Join(c.workdir, "gopath", "src", mainPkg)
-	c.mkdirAll(path)
-	c.writeFile(filepath.Join(path, "main.go"), c.funcMain())
+	// mainPkg is an import path, it must use forward slashes on all OSes.
+	mainPkg := path.Join(c.fuzzpkg.PkgPath, "go.fuzz.main")
+	dir := filepath.Join(c.workdir, "gopath", "src", filepath.FromSlash(mainPkg))
+	c.mkdirAll(dir)
+	c.writeFile(filepath.Join(dir, "main.go"), c.funcMain())
 	return mainPkg
 }
 
@@ -641,7 +1001,7 @@ func (c *Context) clonePackage(p *packages.Package) {
 	if !c.std[p.PkgPath] {
 		root = "gopath"
 	}
-	newDir := filepath.Join(c.workdir, root, "src", p.PkgPath)
+	newDir := filepath.Join(c.workdir, root, "src", filepath.FromSlash(p.PkgPath))
 	c.mkdirAll(newDir)
 
 	if p.PkgPath == "unsafe" {
@@ -668,15 +1028,6 @@ func (c *Context) clonePackage(p *packages.Package) {
 	// TODO: do we need to look for and copy go.mod?
 }
 
-// packageNamed extracts the package listed in path.
-func (c *Context) packageNamed(path string) (pkgs *packages.Package) {
-	all := c.packagesNamed(path)
-	if len(all) != 1 {
-		c.failf("got multiple packages, requested only %v", path)
-	}
-	return all[0]
-}
-
 // packagesNamed extracts the packages listed in paths.
 func (c *Context) packagesNamed(paths ...string) (pkgs []*packages.Package) {
 	pre := func(p *packages.Package) bool {
@@ -692,48 +1043,166 @@ func (c *Context) packagesNamed(paths ...string) (pkgs []*packages.Package) {
 	return pkgs
 }
 
+// instrumentPackages instruments all packages that are not ignored and writes them to workdir.
+// Packages are instrumented concurrently (cmd/go then compiles them in parallel in dependency order),
+// but sonar ids are allocated in the package visit order, so the result does not depend on scheduling.
 func (c *Context) instrumentPackages(blocks *[]CoverBlock, sonar *[]CoverBlock) {
-	visit := func(pkg *packages.Package) {
-		if c.ignore[pkg.PkgPath] {
-			return
+	pkgs := c.instrumentFiles(sonar != nil)
+	mergeBlocks(pkgs, blocks, sonar)
+	if *flagCoverPkg != "" && blocks != nil && len(*blocks) > CoverSize {
+		c.failf("-coverpkg packages have %v coverage blocks, at most %v are supported", len(*blocks), CoverSize)
+	}
+	parallel(len(pkgs), func(i int) {
+		c.writePackage(pkgs[i])
+	})
+}
+
+// instrumentedPackage is an instrumented package ready to be written to workdir.
+type instrumentedPackage struct {
+	pkg       *packages.Package
+	files     []cachedFile
+	blocks    []CoverBlock
+	sonar     bool
+	coverBase int
+	sonarBase int
+}
+
+// instrumentFiles instruments Go files of all packages that are not ignored,
+// or takes them from the build cache if the packages did not change.
+func (c *Context) instrumentFiles(sonar bool) []*instrumentedPackage {
+	var pkgs []*packages.Package
+	packages.Visit(c.pkgs, nil, func(pkg *packages.Package) {
+		if !c.ignore[pkg.PkgPath] {
+			pkgs = append(pkgs, pkg)
 		}
+	})
+	res := make([]*instrumentedPackage, len(pkgs))
+	parallel(len(pkgs), func(i int) {
+		res[i] = c.instrumentPackage(pkgs[i], sonar)
+	})
+	return res
+}
 
-		root := "goroot"
-		if !c.std[pkg.PkgPath] {
-			root = "gopath"
+func (c *Context) instrumentPackage(pkg *packages.Package, sonar bool) *instrumentedPackage {
+	p := &instrumentedPackage{pkg: pkg, sonar: sonar}
+	// With -coverpkg cover ids are dense, files refer to the package coverBase.
+	dense := *flagCoverPkg != "" && !sonar
+	kind := "cover"
+	if sonar {
+		kind = "sonar"
+	} else if dense {
+		kind = "cover-dense"
+	}
+	key := c.cacheKey(pkg, kind)
+	if e := c.cache.get(key); e != nil {
+		p.files, p.blocks = e.Files, e.Blocks
+		return p
+	}
+
+	var files []*instrumentedFile
+	for i, fullName := range pkg.CompiledGoFiles {
+		if !strings.HasSuffix(fullName, ".go") {
+			// This is a cgo-generated file.
+			// Instrumenting it currently does not work.
+			// We copied the original Go file as part of copyPackageRewrite,
+			// so we can just skip this one.
+			// See https://golang.org/issue/30479.
+			continue
 		}
-		path := filepath.Join(c.workdir, root, "src", pkg.PkgPath) // TODO: need filepath.FromSlash for pkg.PkgPath?
+		f := pkg.Syntax[i]
+
+		// TODO: rename trimComments?
+		f.Comments = trimComments(f, pkg.Fset)
+
+		files = append(files, instrumentFile(pkg.PkgPath, fullName, pkg.Fset, f, pkg.TypesInfo, sonar))
+	}
+	p.blocks = allocateIDs(pkg.PkgPath, files, dense)
+	for _, f := range files {
+		buf := new(bytes.Buffer)
+		content := c.readFile(f.file.fullName)
+		buf.Write(initialComments(content)) // Retain '// +build' directives.
+		f.print(buf)
+		p.files = append(p.files, cachedFile{filepath.Base(f.file.fullName), buf.Bytes()})
+	}
+	c.cache.put(key, &cacheEntry{Files: p.files, Blocks: p.blocks})
+	return p
+}
 
-		for i, fullName := range pkg.CompiledGoFiles {
-			fname := filepath.Base(fullName)
-			if !strings.HasSuffix(fname, ".go") {
-				// This is a cgo-generated file.
-				// Instrumenting it currently does not work.
-				// We copied the original Go file as part of copyPackageRewrite,
-				// so we can just skip this one.
-				// See https://golang.org/issue/30479.
+// mergeBlocks appends blocks of all packages to blocks or sonar, and assigns cover bases (with -coverpkg)
+// and sonar bases to packages.
+func mergeBlocks(pkgs []*instrumentedPackage, blocks *[]CoverBlock, sonar *[]CoverBlock) {
+	for _, p := range pkgs {
+		if !p.sonar {
+			if *flagCoverPkg != "" {
+				p.coverBase = len(*blocks)
+				for _, b := range p.blocks {
+					b.ID += p.coverBase
+					*blocks = append(*blocks, b)
+				}
 				continue
 			}
-			f := pkg.Syntax[i]
-
-			// TODO: rename trimComments?
-			f.Comments = trimComments(f, pkg.Fset)
-
-			buf := new(bytes.Buffer)
-			content := c.readFile(fullName)
-			buf.Write(initialComments(content)) // Retain '// +build' directives.
-			instrument(pkg.PkgPath, fullName, pkg.Fset, f, pkg.TypesInfo, buf, blocks, sonar)
-			tmp := c.tempFile()
-			c.writeFile(tmp, buf.Bytes())
-			outpath := filepath.Join(path, fname)
-			if runtime.GOOS == "windows" {
-				os.Remove(outpath)
+			if blocks != nil {
+				*blocks = append(*blocks, p.blocks...)
 			}
-			c.moveFile(tmp, outpath)
+			continue
+		}
+		p.sonarBase = len(*sonar)
+		for _, b := range p.blocks {
+			b.ID += p.sonarBase
+			*sonar = append(*sonar, b)
+		}
+	}
+}
+
+func (c *Context) writePackage(p *instrumentedPackage) {
+	root := "goroot"
+	if !c.std[p.pkg.PkgPath] {
+		root = "gopath"
+	}
+	path := filepath.Join(c.workdir, root, "src", filepath.FromSlash(p.pkg.PkgPath))
+
+	files := p.files
+	if p.sonar && len(p.blocks) != 0 {
+		data := fmt.Sprintf("package %v\n\nconst %v = %v\n", p.pkg.Name, sonarBase, p.sonarBase<<8)
+		files = append(files[:len(files):len(files)], cachedFile{"go.fuzz.sonar.go", []byte(data)})
+	}
+	if !p.sonar && *flagCoverPkg != "" && len(p.blocks) != 0 {
+		data := fmt.Sprintf("package %v\n\nconst %v = %v\n", p.pkg.Name, coverBase, p.coverBase)
+		files = append(files[:len(files):len(files)], cachedFile{"go.fuzz.cover.go", []byte(data)})
+	}
+	for _, f := range files {
+		tmp := c.tempFile()
+		c.writeFile(tmp, f.Data)
+		outpath := filepath.Join(path, f.Name)
+		if runtime.GOOS == "windows" {
+			os.Remove(outpath)
 		}
+		c.moveFile(tmp, outpath)
 	}
+}
 
-	packages.Visit(c.pkgs, nil, visit)
+// parallel calls f(0), ..., f(n-1) on up to GOMAXPROCS goroutines.
+func parallel(n int, f func(i int)) {
+	procs := runtime.GOMAXPROCS(0)
+	if procs > n {
+		procs = n
+	}
+	var next int32 = -1
+	var wg sync.WaitGroup
+	wg.Add(procs)
+	for p := 0; p < procs; p++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt32(&next, 1))
+				if i >= n {
+					return
+				}
+				f(i)
+			}
+		}()
+	}
+	wg.Wait()
 }
 
 func (c *Context) copyDir(dir, newDir string) {
@@ -828,12 +1297,35 @@ import (
 	dep "go-fuzz-dep"
 )
 
+{{if .HasDecode}}
+// decoded adapts fn to raw fuzzer input.
+// Inputs that fail to decode are rejected without calling fn.
+func decoded(fn func(interface{}) int) func([]byte) int {
+	return func(data []byte) int {
+		v, ok := target.Decode(data)
+		if !ok {
+			return -1
+		}
+		return fn(v)
+	}
+}
+{{end}}
+
 func main() {
 	fns := []func([]byte)int {
 		{{range .AllFuncs}}
-			target.{{.}},
+			{{if index $.Decoded .}}decoded(target.{{.}}){{else if index $.Output .}}dep.Output(target.{{.}}){{else}}target.{{.}}{{end}},
 		{{end}}
 	}
+	{{if .HasEncode}}
+	dep.Canonicalize = func(data []byte) []byte {
+		v, ok := target.Decode(data)
+		if !ok {
+			return nil
+		}
+		return target.Encode(v)
+	}
+	{{end}}
 	dep.Main(fns)
 }
 `))
@@ -872,7 +1364,13 @@ func LLVMFuzzerTestOneInput(data uintptr, size uint64) int {
 	}
 
 	input := *(*[]byte)(unsafe.Pointer(sh))
+	{{if index .Decoded .DefaultFunc}}
+	if v, ok := target.Decode(input); ok {
+		target.{{.DefaultFunc}}(v)
+	}
+	{{else}}
 	target.{{.DefaultFunc}}(input)
+	{{end}}
 
 	return 0
 }
@@ -0,0 +1,735 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/packages"
+
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+type buildMeta struct {
+	lits   []Literal
+	blocks []CoverBlock
+	sonar  []CoverBlock
+	src    string
+}
+
+// instrumentTarget loads pkg and runs both instrumentation passes on it the same way main does.
+func instrumentTarget(pkg string) (buildMeta, *Context) {
+	c := new(Context)
+	c.loadPkg(pkg)
+	c.getEnv()
+	c.loadStd()
+	c.calcIgnore()
+	c.initCache()
+	var meta buildMeta
+	meta.lits = sortedLiterals(c.gatherLiterals())
+	mergeBlocks(c.instrumentFiles(false), &meta.blocks, nil)
+	pkgs := c.instrumentFiles(true)
+	mergeBlocks(pkgs, nil, &meta.sonar)
+	for _, p := range pkgs {
+		for _, f := range p.files {
+			meta.src += string(f.Data)
+		}
+	}
+	return meta, c
+}
+
+func TestParallelInstrumentation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	_, cleanup := writeTestTarget(t, 8)
+	defer cleanup()
+	defer setenv("GOFUZZCACHE", "off")()
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(0))
+
+	runtime.GOMAXPROCS(1)
+	serial, _ := instrumentTarget("target")
+	if len(serial.blocks) == 0 || len(serial.sonar) == 0 || len(serial.lits) == 0 {
+		t.Fatalf("got %v blocks, %v sonar sites and %v literals", len(serial.blocks), len(serial.sonar), len(serial.lits))
+	}
+	runtime.GOMAXPROCS(8)
+	for i := 0; i < 3; i++ {
+		par, _ := instrumentTarget("target")
+		if !reflect.DeepEqual(par.lits, serial.lits) {
+			t.Fatalf("parallel literals differ from serial:\n%+v\n%+v", par.lits, serial.lits)
+		}
+		if !reflect.DeepEqual(par.blocks, serial.blocks) {
+			t.Fatalf("parallel blocks differ from serial")
+		}
+		if !reflect.DeepEqual(par.sonar, serial.sonar) {
+			t.Fatalf("parallel sonar blocks differ from serial")
+		}
+		if par.src != serial.src {
+			t.Fatalf("parallel instrumented source differs from serial")
+		}
+	}
+}
+
+func TestBuildCache(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	dir, cleanup := writeTestTarget(t, 4)
+	defer cleanup()
+	defer setenv("GOFUZZCACHE", filepath.Join(dir, "cache"))()
+
+	cold, c := instrumentTarget("target")
+	if c.cache.hits != 0 || c.cache.misses == 0 {
+		t.Fatalf("cold build: got %v cache hits and %v misses", c.cache.hits, c.cache.misses)
+	}
+	npkgs := c.cache.misses
+
+	warm, c := instrumentTarget("target")
+	if c.cache.hits != npkgs || c.cache.misses != 0 {
+		t.Fatalf("warm build: got %v cache hits and %v misses, want %v hits", c.cache.hits, c.cache.misses, npkgs)
+	}
+	if !reflect.DeepEqual(warm, cold) {
+		t.Fatalf("cached build differs from the original one")
+	}
+
+	// Change the target package, but not the number of sonar sites: all deps must come from the cache.
+	fuzz := filepath.Join(dir, "src", "target", "fuzz.go")
+	data, err := ioutil.ReadFile(fuzz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, fuzz, strings.Replace(string(data), "return res", "return res + 1", 1))
+	changed, c := instrumentTarget("target")
+	if c.cache.hits != npkgs-3 || c.cache.misses != 3 {
+		t.Fatalf("rebuild: got %v cache hits and %v misses, want %v hits and 3 misses (literals, cover and sonar of target)",
+			c.cache.hits, c.cache.misses, npkgs-3)
+	}
+	if !reflect.DeepEqual(changed.sonar, cold.sonar) {
+		t.Fatalf("sonar blocks changed after rebuild")
+	}
+	if len(changed.blocks) != len(cold.blocks) {
+		t.Fatalf("got %v blocks after rebuild, want %v", len(changed.blocks), len(cold.blocks))
+	}
+	for i, b := range changed.blocks {
+		if b.File != fuzz && b.ID != cold.blocks[i].ID {
+			t.Fatalf("block %+v changed id after rebuild, was %v", b, cold.blocks[i].ID)
+		}
+	}
+}
+
+func TestBuildConstraints(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	dir, cleanup := writeTestTarget(t, 1)
+	defer cleanup()
+	defer setenv("GOFUZZCACHE", "off")()
+	oldTag := *flagTag
+	defer func() { *flagTag = oldTag }()
+
+	integration := filepath.Join(dir, "src", "target", "integration.go")
+	writeTestFile(t, integration, "//go:build integration\n// +build integration\n\npackage target\n\nfunc integration(x int) int {\n\tif x > 0 {\n\t\treturn 1\n\t}\n\treturn 0\n}\n")
+	windows := filepath.Join(dir, "src", "target", "target_windows.go")
+	writeTestFile(t, windows, "package target\n\nfunc windows(x int) int {\n\tif x > 0 {\n\t\treturn 1\n\t}\n\treturn 0\n}\n")
+	hasFile := func(blocks []CoverBlock, file string) bool {
+		for _, b := range blocks {
+			if b.File == file {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, test := range []struct {
+		tags, goos           string
+		integration, windows bool
+	}{
+		{"", "linux", false, false},
+		{"integration", "linux", true, false},
+		{"foo,integration", "windows", true, true},
+		{"", "windows", false, true},
+	} {
+		*flagTag = test.tags
+		restoreGOOS := setenv("GOOS", test.goos)
+		meta, c := instrumentTarget("target")
+		restoreGOOS()
+		if c.GOOS != test.goos {
+			t.Errorf("tags=%q GOOS=%v: target GOOS is %v", test.tags, test.goos, c.GOOS)
+		}
+		if got := hasFile(meta.blocks, integration); got != test.integration {
+			t.Errorf("tags=%q GOOS=%v: integration.go in blocks: %v, want %v", test.tags, test.goos, got, test.integration)
+		}
+		if got := hasFile(meta.blocks, windows); got != test.windows {
+			t.Errorf("tags=%q GOOS=%v: target_windows.go in blocks: %v, want %v", test.tags, test.goos, got, test.windows)
+		}
+	}
+}
+
+func TestBigEndianTarget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	_, cleanup := writeTestTarget(t, 0)
+	defer cleanup()
+	defer setenv("GOFUZZCACHE", "off")()
+
+	for _, test := range []struct {
+		goarch    string
+		bigEndian bool
+	}{
+		{"amd64", false},
+		{"s390x", true},
+	} {
+		restoreGOARCH := setenv("GOARCH", test.goarch)
+		meta, c := instrumentTarget("target")
+		restoreGOARCH()
+		f := c.createMeta(nil, meta.blocks, meta.sonar)
+		var metadata MetaData
+		if err := json.Unmarshal(c.readFile(f), &metadata); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(f)
+		if metadata.BigEndian != test.bigEndian {
+			t.Errorf("GOARCH=%v: BigEndian is %v, want %v", test.goarch, metadata.BigEndian, test.bigEndian)
+		}
+	}
+}
+
+func TestCoverPkg(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	dir, cleanup := writeTestTarget(t, 2)
+	defer cleanup()
+	defer setenv("GOFUZZCACHE", "off")()
+	oldCoverPkg := *flagCoverPkg
+	defer func() { *flagCoverPkg = oldCoverPkg }()
+
+	*flagCoverPkg = "target/dep1"
+	c := new(Context)
+	c.loadPkg("target")
+	c.getEnv()
+	c.loadStd()
+	c.calcIgnore()
+	c.initCache()
+	c.makeWorkdir()
+	defer c.cleanup()
+	c.populateWorkdir()
+	var blocks []CoverBlock
+	bin := c.buildInstrumentedBinary(&blocks, nil)
+	defer os.Remove(bin)
+	if len(blocks) == 0 {
+		t.Fatalf("no blocks")
+	}
+	dep := filepath.Join(dir, "src", "target", "dep1", "dep.go")
+	for i, b := range blocks {
+		if b.File != dep {
+			t.Fatalf("block %+v is not in %v", b, dep)
+		}
+		if b.ID != i {
+			t.Fatalf("block %+v has id %v, want dense ids", b, b.ID)
+		}
+	}
+}
+
+func TestMatchCoverPkg(t *testing.T) {
+	for _, test := range []struct {
+		patterns string
+		path     string
+		match    bool
+	}{
+		{"example.com/lib", "example.com/lib", true},
+		{"example.com/lib", "example.com/lib/sub", false},
+		{"example.com/lib/...", "example.com/lib", true},
+		{"example.com/lib/...", "example.com/lib/sub/sub", true},
+		{"example.com/lib/...", "example.com/library", false},
+		{"example.com/.../internal", "example.com/a/b/internal", true},
+		{"foo, example.com/lib", "example.com/lib", true},
+		{"encoding/...", "example.com/lib", false},
+	} {
+		if got := matchCoverPkg(strings.Split(test.patterns, ","), test.path); got != test.match {
+			t.Errorf("matchCoverPkg(%q, %q) = %v, want %v", test.patterns, test.path, got, test.match)
+		}
+	}
+}
+
+func TestCgoDependency(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	if out, err := exec.Command("go", "env", "CGO_ENABLED").Output(); err != nil || strings.TrimSpace(string(out)) != "1" {
+		t.Skip("cgo is not enabled")
+	}
+	dir, cleanup := writeTestTarget(t, 1)
+	defer cleanup()
+	defer setenv("GOFUZZCACHE", "off")()
+
+	cdep := filepath.Join(dir, "src", "target", "cdep", "cdep.go")
+	writeTestFile(t, cdep, `package cdep
+
+// static int twice(int x) { return 2 * x; }
+import "C"
+
+//go:noinline
+func Twice(x int) int {
+	if x > 10 {
+		return 0
+	}
+	return int(C.twice(C.int(x)))
+}
+`)
+	cfg := basePackagesConfig()
+	cfg.Mode = packages.LoadAllSyntax
+	pkgs, err := packages.Load(cfg, "target/cdep")
+	if err == nil && len(pkgs) == 1 && len(pkgs[0].Errors) != 0 {
+		err = pkgs[0].Errors[0]
+	}
+	if err != nil {
+		t.Skipf("failed to load cgo package: %v", err)
+	}
+	asm := filepath.Join(dir, "src", "target", "asmdep", "asm.go")
+	writeTestFile(t, asm, "package asmdep\n\n// Nop is implemented in assembly.\nfunc Nop()\n\n//go:noinline\nfunc F(x int) int {\n\tif x > 0 {\n\t\tNop()\n\t}\n\treturn x\n}\n")
+	writeTestFile(t, filepath.Join(dir, "src", "target", "asmdep", "asm_"+runtime.GOARCH+".s"), "#include \"textflag.h\"\n\nTEXT ·Nop(SB),NOSPLIT,$0-0\n\tRET\n")
+	writeTestFile(t, filepath.Join(dir, "src", "target", "asmdep", "asm_other.go"), "// +build !"+runtime.GOARCH+"\n\npackage asmdep\n\nfunc Nop() {}\n")
+	writeTestFile(t, filepath.Join(dir, "src", "target", "cgo.go"),
+		"package target\n\nimport (\n\t\"target/asmdep\"\n\t\"target/cdep\"\n)\n\nfunc Fuzz2(data []byte) int {\n\treturn cdep.Twice(len(data)) + asmdep.F(len(data))\n}\n")
+
+	c := new(Context)
+	c.loadPkg("target")
+	c.getEnv()
+	c.loadStd()
+	c.calcIgnore()
+	c.initCache()
+	c.makeWorkdir()
+	defer c.cleanup()
+	c.populateWorkdir()
+	var blocks []CoverBlock
+	bin := c.buildInstrumentedBinary(&blocks, nil)
+	defer os.Remove(bin)
+
+	files := make(map[string]bool)
+	for _, b := range blocks {
+		files[filepath.Base(filepath.Dir(b.File))+"/"+filepath.Base(b.File)] = true
+	}
+	if !files["target/cgo.go"] || !files["dep0/dep.go"] {
+		t.Errorf("instrumented packages are missing in blocks: %v", files)
+	}
+	if files["cdep/cdep.go"] || files["asmdep/asm.go"] {
+		t.Errorf("cgo/assembly packages are instrumented: %v", files)
+	}
+	out, err := exec.Command("go", "tool", "nm", bin).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go tool nm failed: %v\n%s", err, out)
+	}
+	for _, sym := range []string{"target/cdep.Twice", "target/asmdep.Nop"} {
+		if !strings.Contains(string(out), sym) {
+			t.Errorf("binary does not contain %v", sym)
+		}
+	}
+}
+
+func TestModules(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	dir, err := ioutil.TempDir("", "go-fuzz-build-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Block file names are compared with paths under dir, and cmd/go resolves symlinks.
+	if dir, err = filepath.EvalSymlinks(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer setenv("GOFUZZCACHE", "off")()
+	defer setenv("GO111MODULE", "on")()
+	defer setenv("GOPROXY", "off")()
+	defer setenv("GOFLAGS", "-mod=mod")()
+
+	// go-fuzz is replaced with a local copy of go-fuzz-dep and go-fuzz-defs,
+	// and the dependency with a local package outside of the module.
+	writeTestFile(t, filepath.Join(dir, "gofuzz", "go.mod"), "module github.com/dvyukov/go-fuzz\n\ngo 1.14\n")
+	for _, pkg := range []string{"go-fuzz-dep", "go-fuzz-defs"} {
+		files, err := filepath.Glob(filepath.Join("..", pkg, "*.go"))
+		if err != nil || len(files) == 0 {
+			t.Fatalf("no sources of %v: %v", pkg, err)
+		}
+		for _, f := range files {
+			data, err := ioutil.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, filepath.Join(dir, "gofuzz", pkg, filepath.Base(f)), string(data))
+		}
+	}
+	writeTestFile(t, filepath.Join(dir, "dep", "go.mod"), "module example.com/dep\n\ngo 1.14\n")
+	writeTestFile(t, filepath.Join(dir, "dep", "dep.go"), `package dep
+
+func Check(data []byte) bool {
+	if len(data) > 3 && data[0] == 'x' {
+		return true
+	}
+	return false
+}
+`)
+	target := filepath.Join(dir, "target")
+	writeTestFile(t, filepath.Join(target, "go.mod"), `module example.com/target
+
+go 1.14
+
+require (
+	example.com/dep v0.0.0
+	github.com/dvyukov/go-fuzz v0.0.0
+)
+
+replace example.com/dep => ../dep
+
+replace github.com/dvyukov/go-fuzz => ../gofuzz
+`)
+	writeTestFile(t, filepath.Join(target, "target.go"), `package target
+
+import "example.com/dep"
+
+func Fuzz(data []byte) int {
+	if dep.Check(data) {
+		return 1
+	}
+	return 0
+}
+`)
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(target); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	depBlocks := func(blocks []CoverBlock, file string) []int {
+		var ids []int
+		for _, b := range blocks {
+			if b.File == file {
+				ids = append(ids, b.ID)
+			}
+		}
+		return ids
+	}
+	meta, _ := instrumentTarget(".")
+	replaced := depBlocks(meta.blocks, filepath.Join(dir, "dep", "dep.go"))
+	if len(replaced) == 0 {
+		t.Fatalf("no blocks of the replaced package in %+v", meta.blocks)
+	}
+
+	// With vendoring the package comes from vendor dir, but go-fuzz-dep is not vendored.
+	if out, err := exec.Command("go", "mod", "vendor").CombinedOutput(); err != nil {
+		t.Fatalf("go mod vendor failed: %v\n%s", err, out)
+	}
+	defer setenv("GOFLAGS", "-mod=vendor")()
+	c := new(Context)
+	c.loadPkg(".")
+	c.getEnv()
+	c.loadStd()
+	c.calcIgnore()
+	c.initCache()
+	c.makeWorkdir()
+	defer c.cleanup()
+	c.populateWorkdir()
+	var blocks []CoverBlock
+	bin := c.buildInstrumentedBinary(&blocks, nil)
+	defer os.Remove(bin)
+	vendored := depBlocks(blocks, filepath.Join(target, "vendor", "example.com", "dep", "dep.go"))
+	if !reflect.DeepEqual(vendored, replaced) {
+		t.Fatalf("vendored package has blocks %v, replaced package has %v", vendored, replaced)
+	}
+}
+
+func TestDecodeHook(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	dir, cleanup := writeTestTarget(t, 0)
+	defer cleanup()
+	oldFunc := *flagFunc
+	defer func() { *flagFunc = oldFunc }()
+
+	writeTestFile(t, filepath.Join(dir, "src", "target", "decode.go"), `package target
+
+func Decode(data []byte) (interface{}, bool) {
+	if len(data) == 0 {
+		return nil, false
+	}
+	return string(data), true
+}
+
+func Encode(v interface{}) []byte {
+	return []byte(v.(string))
+}
+
+func FuzzDecoded(v interface{}) int {
+	if v.(string) == "foo" {
+		return 1
+	}
+	return 0
+}
+
+// Not a fuzz function.
+func FuzzPtr(v *int) int {
+	return 0
+}
+`)
+	c := new(Context)
+	c.loadPkg("target")
+	if !reflect.DeepEqual(c.allFuncs, []string{"Fuzz", "FuzzDecoded"}) {
+		t.Fatalf("got fuzz functions %v", c.allFuncs)
+	}
+	if !c.hasDecode || !c.hasEncode || c.decodedFuncs["Fuzz"] || !c.decodedFuncs["FuzzDecoded"] {
+		t.Fatalf("hasDecode=%v hasEncode=%v decodedFuncs=%v", c.hasDecode, c.hasEncode, c.decodedFuncs)
+	}
+	src := string(c.funcMain())
+	if _, err := parser.ParseFile(token.NewFileSet(), "main.go", src, 0); err != nil {
+		t.Fatalf("bad main source: %v\n%s", err, src)
+	}
+	for _, want := range []string{"target.Fuzz,", "decoded(target.FuzzDecoded),", "dep.Canonicalize = "} {
+		if !strings.Contains(src, want) {
+			t.Errorf("main source does not contain %q:\n%s", want, src)
+		}
+	}
+}
+
+// TestMultipleFuncs checks that all functions are built into the binary.
+// That they are fuzzed independently is checked by TestMultipleFuncs in go-fuzz/fuzz.
+func TestMultipleFuncs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	gopath, err := exec.Command("go", "env", "GOPATH").Output()
+	if err != nil {
+		t.Skipf("go env GOPATH failed: %v", err)
+	}
+	testdata, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer setenv("GOPATH", testdata+string(filepath.ListSeparator)+strings.TrimSpace(string(gopath)))()
+	defer setenv("GO111MODULE", "off")()
+	defer setenv("GOFUZZCACHE", "off")()
+	oldFunc := *flagFunc
+	defer func() { *flagFunc = oldFunc }()
+
+	for _, fn := range []string{"", "FuzzBody"} {
+		*flagFunc = fn
+		c := new(Context)
+		c.loadPkg("multifunc")
+		c.getEnv()
+		c.loadStd()
+		c.calcIgnore()
+		c.initCache()
+		c.makeWorkdir()
+		c.populateWorkdir()
+		var blocks []CoverBlock
+		bin := c.buildInstrumentedBinary(&blocks, nil)
+		os.Remove(bin)
+		f := c.createMeta(nil, blocks, nil)
+		var metadata MetaData
+		if err := json.Unmarshal(c.readFile(f), &metadata); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(f)
+		src := string(c.funcMain())
+		c.cleanup()
+		// Both functions are in the dispatch table of the binary, -func only selects the default one.
+		if want := []string{"FuzzBody", "FuzzHeader"}; !reflect.DeepEqual(metadata.Funcs, want) {
+			t.Errorf("-func=%q: got Funcs %v, want %v", fn, metadata.Funcs, want)
+		}
+		if metadata.DefaultFunc != fn {
+			t.Errorf("-func=%q: got DefaultFunc %q", fn, metadata.DefaultFunc)
+		}
+		for _, want := range []string{"target.FuzzHeader,", "target.FuzzBody,"} {
+			if !strings.Contains(src, want) {
+				t.Errorf("-func=%q: main source does not contain %q:\n%s", fn, want, src)
+			}
+		}
+	}
+}
+
+func TestShadowedTypes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	dir, cleanup := writeTestTarget(t, 0)
+	defer cleanup()
+	defer setenv("GOFUZZCACHE", "off")()
+
+	// Instrumentation of these comparisons converts operands to basic types,
+	// it must not refer to them by names that the function shadows.
+	writeTestFile(t, filepath.Join(dir, "src", "target", "fuzz.go"), `package target
+
+import (
+	"bytes"
+	"strings"
+)
+
+type Ratio float64
+
+type Level float32
+
+func Fuzz(data []byte) int {
+	string, byte := "MThd", len(data)
+	float64, float32 := Ratio(byte), Level(byte)
+	if bytes.Equal(data, []uint8("\x89PNG")) || strings.EqualFold(string, "mthd") {
+		return 1
+	}
+	if float64 > 0.5 || float32 < 2.5 {
+		return 2
+	}
+	return 0
+}
+`)
+	c := new(Context)
+	c.loadPkg("target")
+	c.getEnv()
+	c.loadStd()
+	c.calcIgnore()
+	c.initCache()
+	c.makeWorkdir()
+	defer c.cleanup()
+	c.populateWorkdir()
+	// Sonar is added on top of coverage, like in main.
+	var blocks, sonar []CoverBlock
+	os.Remove(c.buildInstrumentedBinary(&blocks, nil))
+	os.Remove(c.buildInstrumentedBinary(nil, &sonar))
+	if len(sonar) < 4 {
+		t.Fatalf("got %v sonar sites, want at least 4", len(sonar))
+	}
+}
+
+func TestIncludeTests(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	dir, cleanup := writeTestTarget(t, 0)
+	defer cleanup()
+	oldTests := *flagTests
+	defer func() { *flagTests = oldTests }()
+
+	writeTestFile(t, filepath.Join(dir, "src", "target", "fuzz_test.go"), `package target
+
+import "testing"
+
+const testMagic = "includetests-magic"
+
+func TestFuzz(t *testing.T) {
+	Fuzz([]byte(testMagic))
+}
+`)
+	writeTestFile(t, filepath.Join(dir, "src", "target", "example_test.go"), `package target_test
+
+import "target"
+
+func ExampleFuzz() {
+	target.Fuzz([]byte("includetests-example"))
+}
+`)
+	for _, include := range []bool{false, true} {
+		*flagTests = include
+		c := new(Context)
+		c.loadPkg("target")
+		c.getEnv()
+		c.loadStd()
+		c.calcIgnore()
+		c.initCache()
+		lits := c.gatherLiterals()
+		for _, want := range []string{"includetests-magic", "includetests-example"} {
+			if _, ok := lits[Literal{want, true}]; ok != include {
+				t.Errorf("-includetests=%v: literal %q collected: %v", include, want, ok)
+			}
+		}
+		for _, p := range c.pkgs {
+			for _, f := range p.GoFiles {
+				if strings.HasSuffix(f, "_test.go") {
+					t.Errorf("-includetests=%v: test file %v is instrumented", include, f)
+				}
+			}
+		}
+	}
+}
+
+// writeTestTarget creates GOPATH with package target that imports ndeps independent packages.
+func writeTestTarget(t *testing.T, ndeps int) (string, func()) {
+	gopath, err := exec.Command("go", "env", "GOPATH").Output()
+	if err != nil {
+		t.Skipf("go env GOPATH failed: %v", err)
+	}
+	dir, err := ioutil.TempDir("", "go-fuzz-build-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var imports, calls string
+	for i := 0; i < ndeps; i++ {
+		src := fmt.Sprintf(`package dep%[1]v
+
+import "bytes"
+
+func F(data []byte, n int) int {
+	if bytes.HasPrefix(data, []byte("dep%[1]v")) {
+		return 1
+	}
+	switch {
+	case n == %[1]v:
+		return 2
+	case n > %[1]v00:
+		return 3
+	}
+	return 0
+}
+`, i)
+		writeTestFile(t, filepath.Join(dir, "src", "target", fmt.Sprintf("dep%v", i), "dep.go"), src)
+		imports += fmt.Sprintf("\t\"target/dep%v\"\n", i)
+		calls += fmt.Sprintf("\tres += dep%v.F(data, len(data))\n", i)
+	}
+	writeTestFile(t, filepath.Join(dir, "src", "target", "fuzz.go"),
+		"package target\n\nimport (\n"+imports+")\n\nfunc Fuzz(data []byte) int {\n\tres := 0\n"+calls+"\treturn res\n}\n")
+
+	restoreGopath := setenv("GOPATH", dir+string(filepath.ListSeparator)+strings.TrimSpace(string(gopath)))
+	restoreModule := setenv("GO111MODULE", "off")
+	return dir, func() {
+		restoreModule()
+		restoreGopath()
+		os.RemoveAll(dir)
+	}
+}
+
+func writeTestFile(t *testing.T, name, data string) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setenv sets env var key to val and returns a func that restores the old value.
+func setenv(key, val string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, val)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
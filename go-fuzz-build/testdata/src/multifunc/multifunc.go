@@ -0,0 +1,19 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package multifunc is a fuzz target with two fuzz functions for go-fuzz-build tests.
+package multifunc
+
+func FuzzHeader(data []byte) int {
+	if len(data) >= 4 && string(data[:4]) == "HDR:" {
+		return 1
+	}
+	return 0
+}
+
+func FuzzBody(data []byte) int {
+	if len(data) != 0 && data[len(data)-1] == ';' {
+		return 1
+	}
+	return 0
+}
@@ -16,6 +16,40 @@ const (
 	SonarRegionSize = 1 << 20
 )
 
+// The comm file shared with the test binary contains coverage, input and sonar
+// regions followed by the exec state: the test binary stores ExecRunning into
+// the uint32 at ExecStateOffset when it receives a request and ExecIdle before
+// it replies, so go-fuzz can tell whether the test binary died while executing
+// the last input or after it. Older test binaries leave the exec state 0.
+const (
+	ExecStateOffset = CoverSize + MaxInputSize + SonarRegionSize
+	CommSize        = ExecStateOffset + 8
+
+	ExecIdle    = 1
+	ExecRunning = 2
+)
+
+// CanonicalizeFunc is the function index that asks the test binary to replace
+// the input with Encode(Decode(input)) instead of running a fuzz function.
+// Fuzz function indices are always smaller.
+const CanonicalizeFunc = 255
+
+// LeakCheckFunc is the function index that asks the test binary to reply
+// with the number of goroutines and open file descriptors (see -leakcheck).
+const LeakCheckFunc = 254
+
+// OutputFunc is the function index that asks the test binary to reply
+// with the hash of the result of the last run of a differential fuzz function
+// (of the form func([]byte) []byte).
+const OutputFunc = 253
+
+const (
+	// MemLimitEnv is the environment variable that passes -memlimit to the test binary.
+	MemLimitEnv = "GOFUZZ_MEMLIMIT"
+	// MemLimitMsg starts the crash message of inputs that exceeded -memlimit.
+	MemLimitMsg = "fatal error: out of memory: "
+)
+
 const (
 	SonarEQL = iota
 	SonarNEQ
@@ -31,6 +65,13 @@ const (
 	SonarConst1 = 1 << 6
 	SonarConst2 = 1 << 7
 
+	// Flags that don't fit into the low 8 bits of runtime sonar id.
+	// They are passed to go-fuzz only in sonar metadata (CoverBlock.NumStmt).
+	SonarFloat = 1 << 8
+	// Static width of integer operands in bytes (1, 2, 4 or 8) is stored
+	// in sonar metadata as NumStmt>>SonarWidthShift, 0 means unknown.
+	SonarWidthShift = 9
+
 	SonarHdrLen = 6
 	SonarMaxLen = 20
 )
@@ -14,6 +14,21 @@ import (
 // to avoid compilation errors when a user's code shadows the built-in bool.
 type Bool = bool
 
+// Int is just an int, see Bool.
+type Int = int
+
+// String is just a string, see Bool.
+type String = string
+
+// Byte is just a byte, see Bool.
+type Byte = byte
+
+// Float32 is just a float32, see Bool.
+type Float32 = float32
+
+// Float64 is just a float64, see Bool.
+type Float64 = float64
+
 // CoverTab holds code coverage.
 // It is initialized to a new array so that instrumentation
 // executed during process initialization has somewhere to write to.
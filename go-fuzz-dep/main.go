@@ -16,29 +16,74 @@ import (
 	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
 )
 
+// Canonicalize, if set, converts input into its canonical encoding,
+// it returns nil if input can't be decoded.
+var Canonicalize func([]byte) []byte
+
 func Main(fns []func([]byte) int) {
 	mem, inFD, outFD := setupCommFile()
 	CoverTab = (*[CoverSize]byte)(unsafe.Pointer(&mem[0]))
 	input := mem[CoverSize : CoverSize+MaxInputSize]
-	sonarRegion = mem[CoverSize+MaxInputSize:]
+	sonarRegion = mem[CoverSize+MaxInputSize : CoverSize+MaxInputSize+SonarRegionSize]
+	if len(mem) >= CommSize {
+		execState = (*uint32)(unsafe.Pointer(&mem[ExecStateOffset]))
+	}
+	atomic.StoreUint32(execState, ExecIdle)
 	runtime.GOMAXPROCS(1) // makes coverage more deterministic, we parallelize on higher level
+	startMemWatcher()
 	for {
 		fnidx, n := read(inFD)
+		// The store goes directly into the comm file mapping,
+		// so go-fuzz sees it even if the input crashes the process.
+		atomic.StoreUint32(execState, ExecRunning)
 		if n > uint64(len(input)) {
 			println("invalid input length")
 			syscall.Exit(1)
 		}
+		if fnidx == CanonicalizeFunc {
+			canonicalize(outFD, input, n)
+			continue
+		}
+		if fnidx == LeakCheckFunc {
+			write(outFD, uint64(runtime.NumGoroutine()), uint64(openFDs()), 0)
+			continue
+		}
+		if fnidx == OutputFunc {
+			write(outFD, uint64(lastOutputValid), lastOutput, 0)
+			continue
+		}
 		for i := range CoverTab {
 			CoverTab[i] = 0
 		}
 		atomic.StoreUint32(&sonarPos, 0)
 		t0 := time.Now()
+		atomic.StoreUint32(&inFuzz, 1)
 		res := fns[fnidx](input[:n:n])
+		atomic.StoreUint32(&inFuzz, 0)
 		ns := time.Since(t0)
 		write(outFD, uint64(res), uint64(ns), uint64(atomic.LoadUint32(&sonarPos)))
 	}
 }
 
+// execState is the exec state in the comm file, see ExecStateOffset.
+// It points to a dummy variable if go-fuzz does not map the exec state.
+var execState = new(uint32)
+
+// canonicalize replaces input[:n] with its canonical encoding
+// and replies with the new length, or with ^0 if that is not possible.
+func canonicalize(outFD FD, input []byte, n uint64) {
+	var data []byte
+	if Canonicalize != nil {
+		data = Canonicalize(input[:n:n])
+	}
+	if data == nil || len(data) > len(input) {
+		write(outFD, ^uint64(0), 0, 0)
+		return
+	}
+	copy(input, data)
+	write(outFD, uint64(len(data)), 0, 0)
+}
+
 // read reads little-endian-encoded uint8+uint64 from fd.
 func read(fd FD) (uint8, uint64) {
 	rd := 0
@@ -61,7 +106,9 @@ func read(fd FD) (uint8, uint64) {
 }
 
 // write writes little-endian-encoded vals... to fd.
+// It is used only for replies, so it also marks the end of the execution.
 func write(fd FD, vals ...uint64) {
+	atomic.StoreUint32(execState, ExecIdle)
 	var tmp [3 * 8]byte
 	buf := tmp[:len(vals)*8]
 	for i, v := range vals {
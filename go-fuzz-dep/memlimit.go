@@ -0,0 +1,114 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// +build gofuzz
+// +build !gofuzz_libfuzzer
+
+package gofuzzdep
+
+import (
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+)
+
+// inFuzz is set while the fuzz function is running.
+var inFuzz uint32
+
+// The address space cap is memCapFactor*limit+memCapSlack bytes above the size
+// of the process after setup. It is much higher than the limit, because the heap
+// holds garbage until the next GC and address space is not returned to the OS.
+const (
+	memCapFactor = 4
+	memCapSlack  = 256 << 20
+)
+
+// startMemWatcher starts a goroutine that kills the process if heap grows
+// by more than MemLimitEnv bytes while the fuzz function is running.
+// Memory allocated by setup (coverage tables, input buffer) is not accounted.
+//
+// The goroutine polls memory usage, so an input that allocates a lot at once
+// can exhaust memory between polls. To catch such inputs, address space of the
+// process is capped as well where the OS supports it (see setMemCap): then the
+// allocation itself fails and the runtime crashes with "fatal error: out of memory",
+// which go-fuzz treats the same way.
+func startMemWatcher() {
+	v, _ := syscall.Getenv(MemLimitEnv)
+	limit := parseUint(v)
+	if limit == 0 {
+		return
+	}
+	var ms runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&ms)
+	base := ms.HeapAlloc
+	if limit <= (^uint64(0)-memCapSlack)/memCapFactor {
+		setMemCap(memCapFactor*limit + memCapSlack)
+	}
+	// memUsage is cheap but includes garbage, so it is only used to decide
+	// when to check the heap. The heap is also checked once in a while regardless,
+	// because memUsage does not grow when the heap reuses memory of garbage.
+	usageBase := memUsage()
+	trigger := usageBase + limit
+	go func() {
+		for poll := 1; ; poll++ {
+			time.Sleep(10 * time.Millisecond)
+			if atomic.LoadUint32(&inFuzz) == 0 {
+				continue
+			}
+			usage := memUsage()
+			if usage < trigger {
+				if t := usage + limit/8; t < trigger && t > usageBase+limit {
+					// Garbage was returned to the OS.
+					trigger = t
+				}
+				if poll%100 != 0 {
+					continue
+				}
+			}
+			runtime.ReadMemStats(&ms)
+			if ms.HeapAlloc >= base+limit {
+				// Garbage left by previous inputs does not count, only live memory does.
+				runtime.GC()
+				runtime.ReadMemStats(&ms)
+			}
+			if ms.HeapAlloc < base+limit || atomic.LoadUint32(&inFuzz) == 0 {
+				if usage >= trigger {
+					// Usage includes garbage that is not necessarily
+					// returned to the OS, check again when it grows further.
+					trigger = usage + limit/8
+				}
+				continue
+			}
+			// The first line is used for deduplication, so it must not depend on the input.
+			println(MemLimitMsg+"heap limit exceeded (-memlimit", limit, "bytes)")
+			println("heap grew by", ms.HeapAlloc-base, "bytes\n")
+			// Print stacks of all goroutines except this one,
+			// so that the fuzz function stack comes first.
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			for i := 0; i+1 < n; i++ {
+				if buf[i] == '\n' && buf[i+1] == '\n' {
+					print(string(buf[i+2 : n]))
+					break
+				}
+			}
+			syscall.Exit(2)
+		}
+	}()
+}
+
+// parseUint parses decimal s, it returns 0 if s is not a valid number.
+func parseUint(s string) uint64 {
+	var v uint64
+	for _, c := range []byte(s) {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		v = v*10 + uint64(c-'0')
+	}
+	return v
+}
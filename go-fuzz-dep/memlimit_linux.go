@@ -0,0 +1,57 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// +build gofuzz
+// +build !gofuzz_libfuzzer
+
+package gofuzzdep
+
+import (
+	"syscall"
+)
+
+// memUsage returns size of the process address space in bytes, or 0 on error.
+// Unlike runtime.ReadMemStats it does not stop the world, and unlike
+// resident set size it grows with allocations that are not touched yet.
+func memUsage() uint64 {
+	fd, err := syscall.Open("/proc/self/statm", syscall.O_RDONLY, 0)
+	if err != nil {
+		return 0
+	}
+	var buf [128]byte
+	n, err := syscall.Read(fd, buf[:])
+	syscall.Close(fd)
+	if err != nil || n <= 0 {
+		return 0
+	}
+	// The first field is the size in pages.
+	var size uint64
+	for _, c := range buf[:n] {
+		if c < '0' || c > '9' {
+			break
+		}
+		size = size*10 + uint64(c-'0')
+	}
+	return size * uint64(syscall.Getpagesize())
+}
+
+// setMemCap limits address space of the process to its current size plus extra bytes.
+func setMemCap(extra uint64) {
+	size := memUsage()
+	if size == 0 {
+		return
+	}
+	var lim syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_AS, &lim); err != nil {
+		return
+	}
+	cur := size + extra
+	if cur < size || cur > lim.Max {
+		cur = lim.Max
+	}
+	if cur >= lim.Cur {
+		return
+	}
+	lim.Cur = cur
+	syscall.Setrlimit(syscall.RLIMIT_AS, &lim)
+}
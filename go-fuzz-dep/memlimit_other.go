@@ -0,0 +1,24 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// +build !linux
+// +build gofuzz
+// +build !gofuzz_libfuzzer
+
+package gofuzzdep
+
+import (
+	"runtime"
+)
+
+// memUsage returns the number of allocated heap bytes, including garbage.
+// It stops the world, but there is no cheaper way to measure it on this OS.
+func memUsage() uint64 {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	return ms.HeapAlloc
+}
+
+// setMemCap is not implemented on this OS, the limit is enforced only by polling.
+func setMemCap(extra uint64) {
+}
@@ -0,0 +1,31 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// +build gofuzz
+// +build !gofuzz_libfuzzer
+
+package gofuzzdep
+
+// Hash of the result of the last run of a differential fuzz function, see OutputFunc.
+var (
+	lastOutput      uint64
+	lastOutputValid uint8
+)
+
+// Output adapts differential fuzz function fn that returns an output
+// signature (e.g. a digest of results of two implementations) to Main.
+// go-fuzz keeps inputs that produce novel output signatures.
+func Output(fn func([]byte) []byte) func([]byte) int {
+	return func(data []byte) int {
+		lastOutputValid = 0
+		out := fn(data)
+		// FNV-1a, go-fuzz-dep does not import hash/fnv to keep it instrumentable (see doc.go).
+		h := uint64(14695981039346656037)
+		for _, b := range out {
+			h ^= uint64(b)
+			h *= 1099511628211
+		}
+		lastOutput, lastOutputValid = h, 1
+		return 0
+	}
+}
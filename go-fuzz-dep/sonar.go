@@ -19,6 +19,12 @@ var (
 
 const failure = ^uint8(0)
 
+// bigEndian is set if the target stores numbers most significant byte first.
+var bigEndian = func() bool {
+	x := uint16(1)
+	return *(*byte)(unsafe.Pointer(&x)) == 0
+}()
+
 type iface struct {
 	typ unsafe.Pointer
 	val unsafe.Pointer
@@ -36,6 +42,16 @@ func Sonar(v1, v2 interface{}, id uint32) {
 	if n2 == failure {
 		return
 	}
+	if bigEndian {
+		// Numbers are sent in the byte order of the target,
+		// because this is how they are usually stored in inputs.
+		if f1&SonarString == 0 {
+			reverseBytes(buf[SonarHdrLen : SonarHdrLen+n1])
+		}
+		if f2&SonarString == 0 {
+			reverseBytes(buf[SonarHdrLen+n1 : SonarHdrLen+n1+n2])
+		}
+	}
 	// Ideal const operands are converted to signed int,
 	// but it does not mean that the comparison is signed
 	// unless the other operand is signed.
@@ -63,6 +79,45 @@ func Sonar(v1, v2 interface{}, id uint32) {
 	copy(sonarRegion[pos:pos+n], buf[:])
 }
 
+// SonarPrefix is like Sonar, but only the first len(v2) bytes of v1 are compared.
+// It is used for calls like bytes.HasPrefix(v1, v2), where v1 is usually much longer than v2.
+func SonarPrefix(v1, v2 interface{}, id uint32) {
+	switch vv1 := v1.(type) {
+	case string:
+		if vv2, ok := v2.(string); ok && len(vv1) > len(vv2) {
+			v1 = vv1[:len(vv2)]
+		}
+	case []byte:
+		if vv2, ok := v2.([]byte); ok && len(vv1) > len(vv2) {
+			v1 = vv1[:len(vv2)]
+		}
+	}
+	Sonar(v1, v2, id)
+}
+
+// SonarFold is like Sonar, but for calls like strings.EqualFold(v1, v2):
+// if v1 and v2 are equal under ASCII case folding, they are reported as equal.
+// Unicode folding is not handled, because the strings package can't be used here.
+func SonarFold(v1, v2 string, id uint32) {
+	if len(v1) == len(v2) {
+		equal := true
+		for i := 0; i < len(v1) && equal; i++ {
+			equal = lowerASCII(v1[i]) == lowerASCII(v2[i])
+		}
+		if equal {
+			v1 = v2
+		}
+	}
+	Sonar(v1, v2, id)
+}
+
+func lowerASCII(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
+
 func serialize(v, v2 interface{}, buf []byte) (n, flags uint8) {
 	switch vv := v.(type) {
 	case int8:
@@ -95,11 +150,26 @@ func serialize(v, v2 interface{}, buf []byte) (n, flags uint8) {
 		} else {
 			return serialize64(buf, uint64(vv)), 0
 		}
+	case float32:
+		if vv != vv {
+			return failure, 0 // NaN compares false with everything, no useful hints
+		}
+		return serialize32(buf, *(*uint32)(unsafe.Pointer(&vv))), 0
+	case float64:
+		if vv != vv {
+			return failure, 0
+		}
+		return serialize64(buf, *(*uint64)(unsafe.Pointer(&vv))), 0
 	case string:
 		if len(vv) > SonarMaxLen {
 			return failure, 0
 		}
 		return uint8(copy(buf, vv)), SonarString
+	case []byte:
+		if len(vv) > SonarMaxLen {
+			return failure, 0
+		}
+		return uint8(copy(buf, vv)), SonarString
 	case [1]byte:
 		return uint8(copy(buf, vv[:])), SonarString
 	case [2]byte:
@@ -188,6 +258,12 @@ func serialize64(buf []byte, v uint64) uint8 {
 	return 8
 }
 
+func reverseBytes(buf []byte) {
+	for i, j := 0, len(buf)-1; i < j; i, j = i+1, j-1 {
+		buf[i], buf[j] = buf[j], buf[i]
+	}
+}
+
 func deserialize64(buf []byte) uint64 {
 	_ = buf[7]
 	return uint64(buf[0])<<0 |
@@ -7,6 +7,7 @@
 package gofuzzdep
 
 import (
+	"runtime"
 	"syscall"
 
 	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
@@ -15,7 +16,14 @@ import (
 type FD int
 
 func setupCommFile() ([]byte, FD, FD) {
-	mem, err := syscall.Mmap(3, 0, CoverSize+MaxInputSize+SonarRegionSize, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	size := CoverSize + MaxInputSize + SonarRegionSize
+	var st syscall.Stat_t
+	if err := syscall.Fstat(3, &st); err == nil && st.Size >= CommSize {
+		// The exec state is mapped only if go-fuzz knows about it,
+		// accesses beyond the end of the comm file would crash.
+		size = CommSize
+	}
+	mem, err := syscall.Mmap(3, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
 	if err != nil {
 		println("failed to mmap fd = 3 errno =", err.(syscall.Errno))
 		syscall.Exit(1)
@@ -23,6 +31,33 @@ func setupCommFile() ([]byte, FD, FD) {
 	return mem, 4, 5
 }
 
+// openFDs returns the number of open file descriptors, or 0 if it is unknown.
+func openFDs() int {
+	dir := "/dev/fd"
+	if runtime.GOOS == "linux" {
+		dir = "/proc/self/fd"
+	}
+	fd, err := syscall.Open(dir, syscall.O_RDONLY, 0)
+	if err != nil {
+		return 0
+	}
+	n := -1 // fd of dir itself
+	var buf [4 << 10]byte
+	for {
+		m, err := syscall.ReadDirent(fd, buf[:])
+		if err != nil || m <= 0 {
+			break
+		}
+		_, cnt, _ := syscall.ParseDirent(buf[:m], -1, nil)
+		n += cnt
+	}
+	syscall.Close(fd)
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
 func (fd FD) read(buf []byte) (int, error) {
 	return syscall.Read(int(fd), buf)
 }
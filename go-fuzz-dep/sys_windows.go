@@ -20,12 +20,31 @@ type sliceHeader struct {
 
 type FD syscall.Handle
 
+// setupCommFile maps the comm file named by GO_FUZZ_COMM_FILE and connects
+// to go-fuzz over loopback TCP on GO_FUZZ_COMM_PORT, the same socket is used
+// for both requests and replies.
 func setupCommFile() ([]byte, FD, FD) {
 	const (
-		size                = CoverSize + MaxInputSize + SonarRegionSize
+		size                = CommSize
 		FILE_MAP_ALL_ACCESS = 0xF001F
 	)
-	mapping := readEnvParam("GO_FUZZ_COMM_FD")
+	name, _ := syscall.Getenv("GO_FUZZ_COMM_FILE")
+	name16, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		println("bad comm file name:", name)
+		syscall.Exit(1)
+	}
+	f, err := syscall.CreateFile(name16, syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE, nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		println("failed to open comm file:", err.Error())
+		syscall.Exit(1)
+	}
+	mapping, err := syscall.CreateFileMapping(f, nil, syscall.PAGE_READWRITE, 0, size, nil)
+	if err != nil {
+		println("failed to create file mapping:", err.Error())
+		syscall.Exit(1)
+	}
 	addr, err := syscall.MapViewOfFile(mapping, FILE_MAP_ALL_ACCESS, 0, 0, size)
 	if err != nil {
 		println("failed to mmap comm file:", err.Error())
@@ -33,24 +52,61 @@ func setupCommFile() ([]byte, FD, FD) {
 	}
 	hdr := sliceHeader{addr, size, size}
 	mem := *(*[]byte)(unsafe.Pointer(&hdr))
-	in := FD(readEnvParam("GO_FUZZ_IN_FD"))
-	out := FD(readEnvParam("GO_FUZZ_OUT_FD"))
-	return mem, in, out
+
+	var wsa syscall.WSAData
+	if err := syscall.WSAStartup(0x202, &wsa); err != nil {
+		println("failed to init winsock:", err.Error())
+		syscall.Exit(1)
+	}
+	s, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_STREAM, syscall.IPPROTO_TCP)
+	if err != nil {
+		println("failed to create socket:", err.Error())
+		syscall.Exit(1)
+	}
+	addr4 := &syscall.SockaddrInet4{Port: int(readEnvParam("GO_FUZZ_COMM_PORT")), Addr: [4]byte{127, 0, 0, 1}}
+	if err := syscall.Connect(s, addr4); err != nil {
+		println("failed to connect to go-fuzz:", err.Error())
+		syscall.Exit(1)
+	}
+	fd := FD(s)
+	write(fd, readEnvParam("GO_FUZZ_COMM_TOKEN"))
+	return mem, fd, fd
+}
+
+var procGetProcessHandleCount = syscall.NewLazyDLL("kernel32.dll").NewProc("GetProcessHandleCount")
+
+// openFDs returns the number of open handles, or 0 if it is unknown.
+func openFDs() int {
+	h, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return 0
+	}
+	var n uint32
+	if r, _, _ := procGetProcessHandleCount.Call(uintptr(h), uintptr(unsafe.Pointer(&n))); r == 0 {
+		return 0
+	}
+	return int(n)
 }
 
-func readEnvParam(name string) syscall.Handle {
+func readEnvParam(name string) uint64 {
 	v, _ := syscall.Getenv(name)
-	var x uintptr
+	var x uint64
 	for i := 0; i < len(v); i++ {
-		x = x*10 + uintptr(v[i]-'0')
+		x = x*10 + uint64(v[i]-'0')
 	}
-	return syscall.Handle(x)
+	return x
 }
 
 func (fd FD) read(buf []byte) (int, error) {
-	return syscall.Read(syscall.Handle(fd), buf)
+	var n, flags uint32
+	b := syscall.WSABuf{Len: uint32(len(buf)), Buf: &buf[0]}
+	err := syscall.WSARecv(syscall.Handle(fd), &b, 1, &n, &flags, nil, nil)
+	return int(n), err
 }
 
 func (fd FD) write(buf []byte) (int, error) {
-	return syscall.Write(syscall.Handle(fd), buf)
+	var n uint32
+	b := syscall.WSABuf{Len: uint32(len(buf)), Buf: &buf[0]}
+	err := syscall.WSASend(syscall.Handle(fd), &b, 1, &n, 0, nil, nil)
+	return int(n), err
 }
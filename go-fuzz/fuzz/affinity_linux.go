@@ -0,0 +1,72 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"log"
+	"math/bits"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+)
+
+const affinitySupported = true
+
+// cpuSet is CPU affinity mask of a thread (cpu_set_t).
+type cpuSet [1024 / 64]uint64
+
+// schedAffinity gets or sets (depending on trap) CPU affinity of the current thread.
+func schedAffinity(trap uintptr, set *cpuSet) error {
+	_, _, errno := syscall.RawSyscall(trap, 0, unsafe.Sizeof(*set), uintptr(unsafe.Pointer(set)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// startPinned starts cmd, if cpu is not negative the process is pinned to
+// the cpu-th (modulo their number) CPU that go-fuzz is allowed to run on.
+// A new process inherits affinity of the thread that forks it, so the thread
+// is pinned while the process is started. Threads of the process inherit it too.
+func startPinned(cmd *exec.Cmd, cpu int) error {
+	if cpu < 0 {
+		return cmd.Start()
+	}
+	runtime.LockOSThread()
+	var old cpuSet
+	if err := schedAffinity(syscall.SYS_SCHED_GETAFFINITY, &old); err != nil {
+		runtime.UnlockOSThread()
+		log.Printf("failed to get CPU affinity: %v", err)
+		return cmd.Start()
+	}
+	count := 0
+	for _, m := range old {
+		count += bits.OnesCount64(m)
+	}
+	var set cpuSet
+	for i, n := 0, cpu%count; i < len(old)*64; i++ {
+		if old[i/64]&(1<<uint(i%64)) == 0 {
+			continue
+		}
+		if n == 0 {
+			set[i/64] |= 1 << uint(i%64)
+			break
+		}
+		n--
+	}
+	if err := schedAffinity(syscall.SYS_SCHED_SETAFFINITY, &set); err != nil {
+		runtime.UnlockOSThread()
+		log.Printf("failed to set CPU affinity: %v", err)
+		return cmd.Start()
+	}
+	startErr := cmd.Start()
+	if err := schedAffinity(syscall.SYS_SCHED_SETAFFINITY, &old); err != nil {
+		// Leave the thread locked, so that other goroutines don't run on the pinned thread.
+		log.Printf("failed to restore CPU affinity: %v", err)
+		return startErr
+	}
+	runtime.UnlockOSThread()
+	return startErr
+}
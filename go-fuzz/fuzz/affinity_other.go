@@ -0,0 +1,15 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// +build !linux
+
+package fuzz
+
+import "os/exec"
+
+const affinitySupported = false
+
+// startPinned starts cmd, -affinity is supported only on Linux.
+func startPinned(cmd *exec.Cmd, cpu int) error {
+	return cmd.Start()
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"log"
+	"path/filepath"
+)
+
+// loadBaseline adds inputs from -baseline dir to corpus, but not to corpus dir.
+// Baseline inputs are triaged as is (without minimization and smashing),
+// so they seed the coverage before fuzzing starts and only inputs
+// that give coverage beyond the baseline reach NewInput.
+func (c *Coordinator) loadBaseline(dir string) {
+	baseline := &PersistentSet{dir: dir, m: make(map[Sig]Artifact)}
+	baseline.readInDir(dir)
+	for sig, a := range baseline.m {
+		if _, ok := c.corpus.m[sig]; !ok {
+			c.corpus.m[sig] = Artifact{a.data, 0, false}
+		}
+	}
+	c.newCover = newPersistentSet(filepath.Join(c.workdir, "newcover"))
+	log.Printf("baseline: loaded %v inputs from %v", len(baseline.m), dir)
+}
+
+// noteNewCover saves a new corpus input with coverage beyond the baseline.
+// Minimized user seeds (from corpus dir or native corpus) are part of the known
+// coverage, only inputs discovered by fuzzing are reported.
+func (c *Coordinator) noteNewCover(a *NewInputArgs) {
+	if c.newCover == nil || a.Prov == nil || a.Prov.Origin == originSeed {
+		return
+	}
+	c.newCover.add(Artifact{a.Data, 0, false})
+	c.newCoverInputs = append(c.newCoverInputs, a.Data)
+	log.Printf("baseline: input %x gives coverage beyond baseline", hash(a.Data))
+}
+
+// baselineSummary logs inputs with coverage beyond the baseline found in this session,
+// go-fuzz exits with status 1 if there are any.
+func (c *Coordinator) baselineSummary() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.newCover == nil {
+		return
+	}
+	if len(c.newCoverInputs) == 0 {
+		log.Printf("baseline: no coverage beyond baseline")
+		return
+	}
+	log.Printf("baseline: %v inputs give coverage beyond baseline, saved into %v", len(c.newCoverInputs), c.newCover.dir)
+	exitStatus = 1
+}
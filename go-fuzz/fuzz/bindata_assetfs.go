@@ -6,7 +6,7 @@
 // assets/jquery.min.js (95.992kB)
 // assets/stats.html (3.868kB)
 
-package main
+package fuzz
 
 import (
 	"bytes"
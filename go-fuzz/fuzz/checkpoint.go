@@ -0,0 +1,99 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+)
+
+// checkpointPeriod is how often coordinator state is saved into workdir.
+const checkpointPeriod = time.Minute
+
+// checkpoint is coordinator state saved into <workdir>/checkpoint,
+// -resume restores it, so that a restarted run continues where it stopped.
+type checkpoint struct {
+	CoverTotal int           // number of distinct coverage counters in the test binary
+	Cover      []byte        // max coverage of all workers
+	Tokens     [][]byte      // dynamic dictionary, the most recently used token first
+	Execs      uint64        // total number of executions
+	Restarts   uint64        // total number of test binary restarts
+	Uptime     time.Duration // total fuzzing time
+}
+
+func checkpointFile(workdir string) string {
+	return filepath.Join(workdir, "checkpoint")
+}
+
+// writeCheckpoint saves coordinator state, it is a no-op until the first worker connects.
+// The file is replaced atomically, so that a crash during writing does not lose the previous checkpoint.
+func (c *Coordinator) writeCheckpoint() {
+	c.mu.Lock()
+	if c.corpus == nil {
+		c.mu.Unlock()
+		return
+	}
+	data, err := json.Marshal(&checkpoint{
+		CoverTotal: c.coverTotal,
+		Cover:      c.cover,
+		Tokens:     c.dict.tokens(),
+		Execs:      c.statExecs,
+		Restarts:   c.statRestarts,
+		Uptime:     time.Since(c.startTime),
+	})
+	fname := checkpointFile(c.workdir)
+	c.mu.Unlock()
+	if err != nil {
+		log.Printf("failed to serialize checkpoint: %v", err)
+		return
+	}
+	if err := ioutil.WriteFile(fname+".tmp", data, 0660); err != nil {
+		log.Printf("failed to write checkpoint: %v", err)
+		return
+	}
+	if err := os.Rename(fname+".tmp", fname); err != nil {
+		log.Printf("failed to write checkpoint: %v", err)
+	}
+}
+
+// resume restores coordinator state from checkpoint in workdir.
+// Checkpoint of a different test binary (with a different number of coverage counters)
+// is ignored, because its coverage does not match coverage of the current binary.
+func (c *Coordinator) resume(coverTotal int) {
+	data, err := ioutil.ReadFile(checkpointFile(c.workdir))
+	if err != nil {
+		log.Printf("not resuming: %v", err)
+		return
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		log.Printf("not resuming: failed to parse checkpoint: %v", err)
+		return
+	}
+	if cp.CoverTotal != coverTotal || len(cp.Cover) != CoverSize {
+		log.Printf("not resuming: checkpoint was made for a different test binary (%v coverage counters, now %v)",
+			cp.CoverTotal, coverTotal)
+		return
+	}
+	c.cover = cp.Cover
+	c.coverFullness = 0
+	for _, v := range cp.Cover {
+		if v != 0 {
+			c.coverFullness++
+		}
+	}
+	for i := len(cp.Tokens) - 1; i >= 0; i-- {
+		c.dict.add(cp.Tokens[i])
+	}
+	c.statExecs = cp.Execs
+	c.statRestarts = cp.Restarts
+	c.startTime = time.Now().Add(-cp.Uptime)
+	log.Printf("resuming: cover %v, execs %v, uptime %v", c.coverFullness, c.statExecs, fmtDuration(cp.Uptime))
+}
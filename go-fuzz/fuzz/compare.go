@@ -3,7 +3,7 @@
 
 // +build !amd64
 
-package main
+package fuzz
 
 func compareCoverBody(base, cur []byte) bool {
 	return compareCoverDump(base, cur)
@@ -1,7 +1,7 @@
 // Copyright 2015 go-fuzz project authors. All rights reserved.
 // Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
 
-package main
+package fuzz
 
 func compareCoverBody(base, cur []byte) bool {
 	if hasAVX2 {
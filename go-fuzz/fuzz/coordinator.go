@@ -0,0 +1,719 @@
+// Copyright 2015 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	_ "net/http/pprof"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/stephens2424/writerset"
+)
+
+// Coordinator manages persistent fuzzer state like input corpus and crashers.
+type Coordinator struct {
+	mu           sync.Mutex
+	epoch        uint64 // random ID of this coordinator instance, worker IDs are unique only within an epoch
+	idSeq        int
+	workers      map[int]*CoordinatorWorker
+	fn           string // function being fuzzed, set by the first worker
+	workdir      string // dir with persistent data for fn
+	corpus       *PersistentSet
+	suppressions *PersistentSet
+	crashers     *PersistentSet
+	hangs        *PersistentSet
+	leaks        *PersistentSet
+	newCover     *PersistentSet // inputs with coverage beyond -baseline, nil without -baseline
+	coverLog     *coverLog      // -coverlog, nil if not enabled
+	sonarTrace   *sonarTrace    // -sonartrace, nil if not enabled
+	token        string         // auth token that workers must present, if set
+	procs        int            // number of workers per worker process requested with /procs, 0 if not set
+
+	shard     int             // -shard index
+	shards    int             // -shard number of shards, 0 without -shard
+	shardSeen map[string]bool // corpus dir files that were already considered by rescanCorpus
+
+	startTime     time.Time
+	lastInput     time.Time
+	statExecs     uint64
+	statRestarts  uint64
+	coverFullness int
+	coverTotal    int    // number of distinct coverage counters in the test binary
+	cover         []byte // max coverage of all workers, saved in checkpoint
+	coverVersion  uint64 // incremented when coverage of other shards is merged into cover
+	dict          *dynamicDict
+
+	newCoverInputs [][]byte // inputs with coverage beyond -baseline found in this session
+
+	statsWriters *writerset.WriterSet
+}
+
+// CoordinatorWorker represents coordinator's view of a worker.
+type CoordinatorWorker struct {
+	id       int
+	procs    int
+	pending  []CoordinatorInput
+	lastSync time.Time
+
+	dictVersion  uint64 // version of the dynamic dictionary sent to the worker
+	coverVersion uint64 // version of cover sent to the worker
+}
+
+// startCoordinator starts coordinator that serves workers on ln.
+// The protocol is net/rpc with gob encoding (the methods of Coordinator),
+// workers in the same process use it over loopback as well.
+func startCoordinator(ln net.Listener) *Coordinator {
+	m := newCoordinator()
+	if *flagCoverLog != "" {
+		m.coverLog = openCoverLog(*flagCoverLog, *flagBin)
+	}
+	if *flagSonarTrace != "" {
+		m.sonarTrace = openSonarTrace(*flagSonarTrace)
+	}
+	go coordinatorLoop(m, shutdownC)
+	shutdownCleanup = append(shutdownCleanup, m.baselineSummary, m.closeCoverLog, m.closeSonarTrace)
+
+	s := rpc.NewServer()
+	s.Register(m)
+	go s.Accept(ln)
+	return m
+}
+
+func newCoordinator() *Coordinator {
+	c := &Coordinator{}
+	c.statsWriters = writerset.New()
+	c.startTime = time.Now()
+	c.lastInput = time.Now()
+	c.workers = make(map[int]*CoordinatorWorker)
+	c.dict = newDynamicDict()
+	var epoch [8]byte
+	if _, err := rand.Read(epoch[:]); err != nil {
+		log.Fatalf("failed to generate coordinator epoch: %v", err)
+	}
+	c.epoch = binary.LittleEndian.Uint64(epoch[:])
+	c.token = *flagAuthToken
+	c.shard, c.shards, _ = parseShard(*flagShard)
+	return c
+}
+
+var errBadToken = errors.New("bad auth token")
+
+// checkToken verifies auth token presented by a worker.
+func (c *Coordinator) checkToken(token string) error {
+	if c.token == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) != 1 {
+		return errBadToken
+	}
+	return nil
+}
+
+// loadWorkdir reads persistent data for fuzz function fn.
+// Workdir is not known until the first worker connects,
+// because only workers know what functions the test binary contains.
+func (c *Coordinator) loadWorkdir(fn string, funcs int) {
+	c.fn = fn
+	c.workdir = funcWorkdir(fn, funcs)
+	if funcs > 1 && c.workdir == *flagWorkdir {
+		log.Printf("workdir %v has data at the top level, fuzzing %v there; move corpus, crashers and suppressions "+
+			"into %v to keep data of each fuzz function separate", *flagWorkdir, fn, filepath.Join(*flagWorkdir, fn))
+	}
+	c.suppressions = newPersistentSet(filepath.Join(c.workdir, "suppressions"))
+	c.crashers = newPersistentSet(filepath.Join(c.workdir, "crashers"))
+	c.hangs = newPersistentSet(filepath.Join(c.workdir, "hangs"))
+	c.leaks = newPersistentSet(filepath.Join(c.workdir, "leaks"))
+	c.corpus = newFilteredSet(corpusDir(c.workdir), c.ownsInput)
+	if c.corpus.dups != 0 {
+		log.Printf("corpus: collapsed %v duplicate inputs", c.corpus.dups)
+	}
+	if c.shards != 0 {
+		c.listCorpusDir()
+		log.Printf("shard %v/%v: loaded %v corpus inputs", c.shard, c.shards, len(c.corpus.m))
+	}
+	if *flagNativeCorpus != "" {
+		// Seeds of Go native fuzzing are used as is, but are not copied into workdir.
+		for _, data := range readNativeCorpus(filepath.Join(*flagNativeCorpus, fn)) {
+			if _, ok := c.corpus.m[hash(data)]; !ok && c.ownsInput(hash(data)) {
+				c.corpus.m[hash(data)] = Artifact{data, 0, true}
+			}
+		}
+	}
+	if *flagBaseline != "" {
+		c.loadBaseline(*flagBaseline)
+	}
+	if len(c.corpus.m) == 0 {
+		c.corpus.add(Artifact{[]byte{}, 0, false})
+		if data, err := json.Marshal(&Provenance{Origin: originSeed}); err == nil {
+			c.corpus.addDescription([]byte{}, data, "meta")
+		}
+	}
+}
+
+// truncateCorpus truncates corpus inputs that are larger than max (if it is not 0).
+// Only the in-memory copies are truncated, files in corpus dir are left intact.
+func (c *Coordinator) truncateCorpus(max int) {
+	if max == 0 {
+		return
+	}
+	for sig, a := range c.corpus.m {
+		if len(a.data) <= max {
+			continue
+		}
+		log.Printf("corpus input %x is %v bytes, truncating to max input size %v", sig, len(a.data), max)
+		delete(c.corpus.m, sig)
+		a.data = a.data[:max]
+		if _, ok := c.corpus.m[hash(a.data)]; !ok {
+			c.corpus.m[hash(a.data)] = a
+		}
+	}
+}
+
+// funcWorkdir returns dir with persistent data for fuzz function fn
+// of a test binary with funcs fuzz functions.
+func funcWorkdir(fn string, funcs int) string {
+	if funcs > 1 {
+		// Each function of a multi-function binary gets own corpus and crashers,
+		// otherwise inputs and coverage of different functions would mix.
+		// Workdirs created before that keep being used as is, so that they don't lose corpus.
+		dir := filepath.Join(*flagWorkdir, fn)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if _, err := os.Stat(filepath.Join(*flagWorkdir, "corpus")); err == nil {
+				return *flagWorkdir
+			}
+		}
+		return dir
+	}
+	return *flagWorkdir
+}
+
+// corpusDir returns corpus dir for function workdir, unless overridden with -corpus.
+func corpusDir(workdir string) string {
+	if *flagCorpus != "" {
+		return *flagCorpus
+	}
+	return filepath.Join(workdir, "corpus")
+}
+
+func coordinatorListen(c *Coordinator) {
+	if *flagHTTP != "" {
+		http.HandleFunc("/eventsource", c.eventSource)
+		http.HandleFunc("/", c.index)
+
+		go func() {
+			fmt.Printf("Serving statistics on http://%s/\n", *flagHTTP)
+			panic(http.ListenAndServe(*flagHTTP, nil))
+		}()
+	} else {
+		runtime.MemProfileRate = 0
+	}
+	if *flagMetrics != "" {
+		ln, err := net.Listen("tcp", *flagMetrics)
+		if err != nil {
+			log.Fatalf("failed to listen on -metrics address: %v", err)
+		}
+		go func() {
+			fmt.Printf("Serving metrics on http://%s/ and http://%s/metrics\n", ln.Addr(), ln.Addr())
+			panic(http.Serve(ln, c.metricsHandler()))
+		}()
+	}
+}
+
+func coordinatorLoop(c *Coordinator, done chan struct{}) {
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+	lastCheckpoint := time.Now()
+	lastShardSync := time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-done:
+			c.writeCheckpoint()
+			return
+		}
+		c.mu.Lock()
+		if c.coverLog != nil {
+			c.coverLog.flush()
+		}
+		if c.sonarTrace != nil {
+			c.sonarTrace.flush()
+		}
+		// Nuke dead workers.
+		for id, s := range c.workers {
+			if time.Since(s.lastSync) < syncDeadline {
+				continue
+			}
+			log.Printf("worker %v died", s.id)
+			delete(c.workers, id)
+		}
+		c.mu.Unlock()
+
+		c.broadcastStats()
+
+		if time.Since(lastShardSync) > shardPeriod {
+			c.syncShards()
+			lastShardSync = time.Now()
+		}
+
+		if time.Since(lastCheckpoint) > checkpointPeriod {
+			c.writeCheckpoint()
+			lastCheckpoint = time.Now()
+		}
+	}
+}
+
+func (c *Coordinator) broadcastStats() {
+	stats := c.coordinatorStats()
+
+	// log to stdout
+	logStats(stats)
+
+	// write to any http clients
+	b, err := json.Marshal(stats)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Fprintf(c.statsWriters, "event: ping\ndata: %s\n\n", string(b))
+	c.statsWriters.Flush()
+}
+
+func (c *Coordinator) eventSource(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	<-c.statsWriters.Add(w)
+}
+
+func (c *Coordinator) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		r.URL.Path = "/stats.html"
+	}
+	http.FileServer(assetFS()).ServeHTTP(w, r)
+}
+
+func (c *Coordinator) coordinatorStats() coordinatorStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := coordinatorStats{
+		Uptime:           fmtDuration(time.Since(c.startTime)),
+		StartTime:        c.startTime,
+		LastNewInputTime: c.lastInput,
+		Execs:            c.statExecs,
+		Cover:            uint64(c.coverFullness),
+		CoverTotal:       uint64(c.coverTotal),
+	}
+	if c.corpus != nil {
+		stats.Corpus = uint64(len(c.corpus.m))
+		stats.Crashers = uint64(len(c.crashers.m))
+	}
+
+	// Print stats line.
+	if c.statExecs != 0 && c.statRestarts != 0 {
+		stats.RestartsDenom = c.statExecs / c.statRestarts
+	}
+
+	for _, w := range c.workers {
+		stats.Workers += uint64(w.procs)
+	}
+
+	return stats
+}
+
+type coordinatorStats struct {
+	Workers, Corpus, Crashers, Execs, Cover, CoverTotal, RestartsDenom uint64
+	LastNewInputTime, StartTime                                        time.Time
+	Uptime                                                             string
+}
+
+func (s coordinatorStats) String() string {
+	return fmt.Sprintf("workers: %v, corpus: %v (%v ago), crashers: %v,"+
+		" restarts: 1/%v, execs: %v (%.0f/sec), cover: %v, uptime: %v",
+		s.Workers, s.Corpus, fmtDuration(time.Since(s.LastNewInputTime)),
+		s.Crashers, s.RestartsDenom, s.Execs, s.ExecsPerSec(), s.Cover,
+		s.Uptime,
+	)
+}
+
+func (s coordinatorStats) ExecsPerSec() float64 {
+	return float64(s.Execs) * 1e9 / float64(time.Since(s.StartTime))
+}
+
+func fmtDuration(d time.Duration) string {
+	if d.Hours() >= 1 {
+		return fmt.Sprintf("%vh%vm", int(d.Hours()), int(d.Minutes())%60)
+	} else if d.Minutes() >= 1 {
+		return fmt.Sprintf("%vm%vs", int(d.Minutes()), int(d.Seconds())%60)
+	} else {
+		return fmt.Sprintf("%vs", int(d.Seconds()))
+	}
+}
+
+type ConnectArgs struct {
+	Procs        int
+	Func         string // fuzz function name
+	Funcs        int    // total number of fuzz functions in the test binary
+	CoverTotal   int    // number of distinct coverage counters in the test binary
+	PrevID       int    // ID of the previous connection of a reconnecting worker, or 0
+	PrevEpoch    uint64 // epoch of the coordinator that assigned PrevID
+	MaxInputSize int    // max input size of the worker, larger corpus inputs are truncated, 0 means no limit
+	Token        string // auth token
+}
+
+type ConnectRes struct {
+	ID     int
+	Epoch  uint64 // epoch of the coordinator, sent back as ConnectArgs.PrevEpoch on reconnect
+	Corpus []CoordinatorInput
+	Cover  []byte // max coverage restored from checkpoint or reported by workers, can be nil
+	Shard  int    // -shard of the coordinator, the worker keeps only inputs of the shard in corpus
+	Shards int    // 0 without -shard
+
+	SonarTraceRate int // trace every N-th execution with sonar for -sonartrace, 0 if disabled
+}
+
+// CoordinatorInput is description of input that is passed between coordinator and worker.
+type CoordinatorInput struct {
+	Data      []byte
+	Prio      uint64
+	Type      execType
+	Minimized bool
+	Smashed   bool
+	Prov      *Provenance // provenance of new inputs sent to the hub for triage, nil otherwise
+}
+
+// Connect attaches new worker to coordinator.
+func (c *Coordinator) Connect(a *ConnectArgs, r *ConnectRes) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkToken(a.Token); err != nil {
+		log.Printf("rejected worker connection: %v", err)
+		return err
+	}
+	if c.corpus == nil {
+		c.loadWorkdir(a.Func, a.Funcs)
+		c.truncateCorpus(a.MaxInputSize)
+		if *flagResume {
+			c.resume(a.CoverTotal)
+		}
+	} else if a.Func != c.fn {
+		return fmt.Errorf("coordinator fuzzes function %v, but worker fuzzes %v", c.fn, a.Func)
+	}
+	c.coverTotal = a.CoverTotal
+	if prev := c.workers[a.PrevID]; prev != nil && a.PrevEpoch == c.epoch {
+		// The worker lost connection and reconnected, free its old slot
+		// instead of waiting for it to die. IDs restart after coordinator
+		// restart, so an ID of a previous coordinator instance belongs to
+		// some other worker.
+		log.Printf("worker %v reconnected as worker %v", prev.id, c.idSeq+1)
+		delete(c.workers, prev.id)
+	}
+	c.idSeq++
+	w := &CoordinatorWorker{
+		id:       c.idSeq,
+		procs:    a.Procs,
+		lastSync: time.Now(),
+
+		coverVersion: c.coverVersion,
+	}
+	c.workers[w.id] = w
+	r.ID = w.id
+	r.Epoch = c.epoch
+	// Give the worker initial corpus.
+	for _, a := range c.corpus.m {
+		r.Corpus = append(r.Corpus, CoordinatorInput{a.data, a.meta, execCorpus, !a.user, true, nil})
+	}
+	if c.cover != nil {
+		r.Cover = makeCopy(c.cover)
+	}
+	if c.sonarTrace != nil {
+		r.SonarTraceRate = *flagSonarTraceRate
+	}
+	r.Shard, r.Shards = c.shard, c.shards
+	return nil
+}
+
+type NewInputArgs struct {
+	ID    int
+	Data  []byte
+	Prio  uint64
+	Prov  *Provenance // nil for inputs resent after reconnect by older workers
+	Token string      // auth token
+}
+
+// NewInput saves new interesting input on coordinator.
+func (c *Coordinator) NewInput(a *NewInputArgs, r *int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkToken(a.Token); err != nil {
+		return err
+	}
+	w := c.workers[a.ID]
+	if w == nil {
+		return errors.New("unknown worker")
+	}
+
+	if !c.ownsInput(hash(a.Data)) {
+		// The input is routed to its shard through corpus dir,
+		// but its coverage still reaches other workers with Sync.
+		c.routeInput(a)
+		c.noteNewCover(a)
+		c.lastInput = time.Now()
+		return nil
+	}
+	art := Artifact{a.Data, a.Prio, false}
+	if !c.corpus.add(art) {
+		return nil
+	}
+	if a.Prov != nil {
+		if data, err := json.Marshal(a.Prov); err == nil {
+			c.corpus.addDescription(a.Data, data, "meta")
+		}
+	}
+	c.noteNewCover(a)
+	c.lastInput = time.Now()
+	// Queue the input for sending to every worker.
+	for _, w1 := range c.workers {
+		w1.pending = append(w1.pending, CoordinatorInput{a.Data, a.Prio, execCorpus, true, w1 != w, nil})
+	}
+
+	return nil
+}
+
+type NewCrasherArgs struct {
+	Data        []byte
+	Minimized   []byte // minimized Data that crashes the same way, nil if minimization did not shrink it
+	Error       []byte
+	Suppression []byte
+	Class       string // termination class of the test binary (panic, fatal, exit or signal), empty for leaks
+	Stray       bool   // the test binary crashed after Data was executed, e.g. in a goroutine started by it
+	Cover       []byte // with -crashcover, coverage blocks executed by Data up to the crash (see crashCoverage)
+	Hanging     bool
+	HangTimeout time.Duration // non-zero if the input exceeded -hangtimeout
+	MemLimit    uint64        // non-zero if the input exceeded -memlimit
+	Leak        string        // leaked resource if the input leaks with -leakcheck, Error describes the leak
+	Token       string        // auth token
+}
+
+// NewCrasher saves new crasher input on coordinator.
+func (c *Coordinator) NewCrasher(a *NewCrasherArgs, r *int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkToken(a.Token); err != nil {
+		return err
+	}
+	// Inputs that exceeded -hangtimeout and leaking inputs are kept separately from real crashers.
+	set := c.crashers
+	if a.HangTimeout != 0 {
+		set = c.hangs
+	}
+	if a.Leak != "" {
+		set = c.leaks
+	}
+	if *flagMaxCrashers != 0 && len(set.m) >= *flagMaxCrashers {
+		c.countCrasher(set, a)
+		return nil
+	}
+	if !*flagDup && !c.suppressions.add(Artifact{a.Suppression, 0, false}) {
+		return nil // Already have this.
+	}
+	art := Artifact{a.Data, 0, false}
+	if !set.add(art) {
+		return nil // Already have this.
+	}
+	dedupKey := hash(a.Suppression)
+	logEvent("crasher", map[string]interface{}{
+		"file":      persistentFilename(set.dir, art, hash(a.Data)),
+		"dedup_key": hex.EncodeToString(dedupKey[:]),
+		"hang":      a.HangTimeout != 0,
+		"oom":       a.MemLimit != 0,
+		"leak":      a.Leak != "",
+		"class":     a.Class,
+	})
+
+	// Prepare quoted version of input to simplify creation of standalone reproducers.
+	set.addDescription(a.Data, quoteInput(a.Data), "quoted")
+	set.addDescription(a.Data, a.Error, "output")
+	if a.Minimized != nil {
+		set.addDescription(a.Data, a.Minimized, "min")
+		set.addDescription(a.Data, quoteInput(a.Minimized), "min.quoted")
+	}
+	if *flagDedup == "stack" {
+		set.addDescription(a.Data, extractStack(a.Error), "stack")
+	}
+	if a.Class != "" {
+		class := a.Class + "\n"
+		if a.Stray {
+			class += "the test binary crashed after the input was executed (e.g. in a goroutine started by it)\n"
+		}
+		set.addDescription(a.Data, []byte(class), "class")
+	}
+	if len(a.Cover) != 0 {
+		set.addDescription(a.Data, a.Cover, "cover")
+	}
+	if a.HangTimeout != 0 {
+		set.addDescription(a.Data, []byte(fmt.Sprintf("execution exceeded hang timeout %v\n", a.HangTimeout)), "hang")
+	}
+	if a.MemLimit != 0 {
+		set.addDescription(a.Data, []byte(fmt.Sprintf("out of memory: heap grew over memory limit %v bytes\n", a.MemLimit)), "oom")
+	}
+	if a.Leak != "" {
+		set.addDescription(a.Data, []byte(fmt.Sprintf("input leaks %v\n", a.Leak)), "leak")
+	}
+	if *flagExitOnCrash && set == c.crashers {
+		log.Printf("found crasher %v, exiting (-exitoncrash)", persistentFilename(set.dir, art, hash(a.Data)))
+		requestStop(1)
+	}
+
+	return nil
+}
+
+// countCrasher records crasher a only in memory, because set has reached -maxcrashers.
+// It is still deduplicated and counted in stats, but no files are written.
+func (c *Coordinator) countCrasher(set *PersistentSet, a *NewCrasherArgs) {
+	if !*flagDup {
+		supp := hash(a.Suppression)
+		if _, ok := c.suppressions.m[supp]; ok {
+			return
+		}
+		c.suppressions.m[supp] = Artifact{a.Suppression, 0, false}
+	}
+	sig := hash(a.Data)
+	if _, ok := set.m[sig]; ok {
+		return
+	}
+	if len(set.m) == *flagMaxCrashers {
+		log.Printf("reached -maxcrashers=%v in %v, new inputs are counted, but not saved", *flagMaxCrashers, set.dir)
+	}
+	set.m[sig] = Artifact{a.Data, 0, false}
+}
+
+// quoteInput formats data as a Go string literal split into lines.
+func quoteInput(data []byte) []byte {
+	var buf bytes.Buffer
+	for i := 0; i < len(data); i += 20 {
+		e := i + 20
+		if e > len(data) {
+			e = len(data)
+		}
+		fmt.Fprintf(&buf, "\t%q", data[i:e])
+		if e != len(data) {
+			fmt.Fprintf(&buf, " +")
+		}
+		fmt.Fprintf(&buf, "\n")
+	}
+	return buf.Bytes()
+}
+
+type SyncArgs struct {
+	ID            int
+	Execs         uint64
+	Restarts      uint64
+	CoverFullness int
+	Cover         []byte        // corpus coverage, nil if it did not change since the last sync
+	Tokens        [][]byte      // new dynamic dictionary tokens observed by sonar
+	UsedTokens    [][]byte      // dynamic dictionary tokens that gave new coverage
+	SonarTrace    []SonarRecord // sampled sonar comparisons for -sonartrace
+	Procs         int           // number of workers in the worker process, 0 for older workers
+	Token         string        // auth token
+}
+
+type SyncRes struct {
+	Inputs []CoordinatorInput // new interesting inputs
+	Tokens [][]byte           // dynamic dictionary, nil if it did not change since the last sync
+	Cover  []byte             // max coverage including coverage of other shards, nil if it did not change
+	Procs  int                // requested number of workers, 0 means no change
+}
+
+var errUnkownWorker = errors.New("unknown worker")
+
+// Sync is a periodic sync with a worker.
+// Worker sends statistics. Coordinator returns new inputs.
+func (c *Coordinator) Sync(a *SyncArgs, r *SyncRes) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkToken(a.Token); err != nil {
+		return err
+	}
+	w := c.workers[a.ID]
+	if w == nil {
+		return errUnkownWorker
+	}
+	c.statExecs += a.Execs
+	c.statRestarts += a.Restarts
+	if a.Restarts != 0 {
+		logEvent("restart", map[string]interface{}{
+			"worker":   w.id,
+			"restarts": a.Restarts,
+		})
+	}
+	if c.coverFullness < a.CoverFullness {
+		c.coverFullness = a.CoverFullness
+		logEvent("cover", map[string]interface{}{
+			"worker":      w.id,
+			"cover":       c.coverFullness,
+			"cover_total": c.coverTotal,
+		})
+	}
+	if a.Cover != nil {
+		if c.coverLog != nil {
+			c.coverLog.logCover(c.cover, a.Cover, time.Since(c.startTime))
+		}
+		if c.shards != 0 && (c.cover == nil || compareCover(c.cover, a.Cover)) {
+			// Other workers don't receive inputs of other shards found by this worker,
+			// so they need the coverage.
+			c.coverVersion++
+		}
+		if c.cover == nil {
+			c.cover = makeCopy(a.Cover)
+		} else {
+			updateMaxCover(c.cover, a.Cover)
+		}
+	}
+	for _, tok := range a.Tokens {
+		c.dict.add(tok)
+	}
+	for _, tok := range a.UsedTokens {
+		c.dict.use(tok)
+	}
+	if c.sonarTrace != nil {
+		c.sonarTrace.write(a.SonarTrace)
+	}
+	if a.Procs != 0 {
+		w.procs = a.Procs
+	}
+	if c.procs != 0 && a.Procs != c.procs {
+		r.Procs = c.procs
+	}
+	w.lastSync = time.Now()
+	r.Inputs = w.pending
+	w.pending = nil
+	if w.dictVersion != c.dict.version {
+		w.dictVersion = c.dict.version
+		r.Tokens = c.dict.tokens()
+	}
+	if w.coverVersion != c.coverVersion {
+		w.coverVersion = c.coverVersion
+		r.Cover = makeCopy(c.cover)
+	}
+	return nil
+}
@@ -0,0 +1,556 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+)
+
+// testWorkdir points -workdir to a fresh temp dir.
+// The returned function restores -workdir and removes the dir.
+func testWorkdir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "go-fuzz-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := *flagWorkdir
+	*flagWorkdir = dir
+	return dir, func() {
+		*flagWorkdir = old
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCoordinatorMultiFunc(t *testing.T) {
+	workdir, cleanup := testWorkdir(t)
+	defer cleanup()
+
+	// Fuzz FuzzA and FuzzB of the same binary, one after another.
+	var resA, resB ConnectRes
+	ca := newCoordinator()
+	if err := ca.Connect(&ConnectArgs{Procs: 1, Func: "FuzzA", Funcs: 2}, &resA); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.NewInput(&NewInputArgs{ID: resA.ID, Data: []byte("input A")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.Connect(&ConnectArgs{Procs: 1, Func: "FuzzB", Funcs: 2}, &resB); err == nil {
+		t.Fatalf("coordinator accepted worker for another function")
+	}
+	if err := ca.Sync(&SyncArgs{ID: resA.ID, CoverFullness: 10}, &SyncRes{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cb := newCoordinator()
+	if err := cb.Connect(&ConnectArgs{Procs: 1, Func: "FuzzB", Funcs: 2}, &resB); err != nil {
+		t.Fatal(err)
+	}
+	for _, inp := range resB.Corpus {
+		if string(inp.Data) == "input A" {
+			t.Fatalf("FuzzB received corpus of FuzzA")
+		}
+	}
+	if stats := cb.coordinatorStats(); stats.Cover != 0 || stats.Corpus != 1 {
+		t.Fatalf("FuzzB inherited state of FuzzA: %+v", stats)
+	}
+	for _, fn := range []string{"FuzzA", "FuzzB"} {
+		for _, dir := range []string{"corpus", "crashers", "suppressions"} {
+			if _, err := os.Stat(filepath.Join(workdir, fn, dir)); err != nil {
+				t.Errorf("missing per-function dir: %v", err)
+			}
+		}
+	}
+	if _, err := os.Stat(filepath.Join(workdir, "corpus")); err == nil {
+		t.Errorf("multi-function binary uses shared corpus dir")
+	}
+}
+
+func TestCoordinatorMultiFuncOldWorkdir(t *testing.T) {
+	workdir, cleanup := testWorkdir(t)
+	defer cleanup()
+
+	// Workdir created before functions got own dirs keeps its corpus.
+	corpus := filepath.Join(workdir, "corpus")
+	if err := os.MkdirAll(corpus, 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(corpus, "old"), []byte("old input"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	var res ConnectRes
+	c := newCoordinator()
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "FuzzA", Funcs: 2}, &res); err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, inp := range res.Corpus {
+		found = found || string(inp.Data) == "old input"
+	}
+	if !found || c.workdir != workdir {
+		t.Fatalf("corpus of the old workdir layout is not used: workdir %v, %v inputs", c.workdir, len(res.Corpus))
+	}
+	if _, err := os.Stat(filepath.Join(workdir, "FuzzA")); err == nil {
+		t.Errorf("per-function dir created for the old workdir layout")
+	}
+}
+
+func TestCoordinatorSingleFunc(t *testing.T) {
+	workdir, cleanup := testWorkdir(t)
+	defer cleanup()
+
+	// Binaries with a single function keep using the workdir directly.
+	c := newCoordinator()
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1}, &ConnectRes{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(workdir, "corpus")); err != nil {
+		t.Fatalf("missing corpus dir: %v", err)
+	}
+}
+
+func TestCoordinatorReconnectEpoch(t *testing.T) {
+	_, cleanup := testWorkdir(t)
+	defer cleanup()
+
+	// Worker 1 of the previous coordinator instance reconnects to a restarted
+	// coordinator, where ID 1 belongs to another live worker.
+	old := newCoordinator()
+	var resOld ConnectRes
+	if err := old.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1}, &resOld); err != nil {
+		t.Fatal(err)
+	}
+	c := newCoordinator()
+	if c.epoch == old.epoch {
+		t.Fatalf("coordinators have the same epoch %v", c.epoch)
+	}
+	var res1 ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1}, &res1); err != nil {
+		t.Fatal(err)
+	}
+	var res2 ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1, PrevID: resOld.ID, PrevEpoch: resOld.Epoch}, &res2); err != nil {
+		t.Fatal(err)
+	}
+	if res1.ID != resOld.ID || c.workers[res1.ID] == nil {
+		t.Fatalf("worker %v was evicted by reconnect of worker %v of another coordinator", res1.ID, resOld.ID)
+	}
+
+	// Reconnect to the same coordinator frees the old slot.
+	var res3 ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1, PrevID: res2.ID, PrevEpoch: res2.Epoch}, &res3); err != nil {
+		t.Fatal(err)
+	}
+	if c.workers[res2.ID] != nil || c.workers[res3.ID] == nil || len(c.workers) != 2 {
+		t.Fatalf("reconnected worker %v did not replace worker %v", res3.ID, res2.ID)
+	}
+}
+
+func TestCoordinatorHang(t *testing.T) {
+	workdir, cleanup := testWorkdir(t)
+	defer cleanup()
+
+	c := newCoordinator()
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1}, &ConnectRes{}); err != nil {
+		t.Fatal(err)
+	}
+	hang := &NewCrasherArgs{
+		Data:        []byte("slow input"),
+		Error:       []byte("SIGABRT: abort\n"),
+		Suppression: []byte("SIGABRT: abort\n"),
+		Hanging:     true,
+		HangTimeout: time.Second,
+	}
+	if err := c.NewCrasher(hang, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Same suppression, so this one must be deduplicated.
+	dup := *hang
+	dup.Data = []byte("another slow input")
+	if err := c.NewCrasher(&dup, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.hangs.m) != 1 || len(c.crashers.m) != 0 {
+		t.Fatalf("got %v hangs and %v crashers, want 1 and 0", len(c.hangs.m), len(c.crashers.m))
+	}
+	sig := hash(hang.Data)
+	markers, err := filepath.Glob(filepath.Join(workdir, "hangs", hex.EncodeToString(sig[:])+".hang"))
+	if err != nil || len(markers) != 1 {
+		t.Fatalf("missing hang marker file: %v", err)
+	}
+
+	// Hangs detected by plain -timeout still go to crashers.
+	crash := &NewCrasherArgs{
+		Data:        []byte("crash"),
+		Error:       []byte("signal: killed\n"),
+		Suppression: []byte("signal: killed\n"),
+		Hanging:     true,
+	}
+	if err := c.NewCrasher(crash, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.hangs.m) != 1 || len(c.crashers.m) != 1 {
+		t.Fatalf("got %v hangs and %v crashers, want 1 and 1", len(c.hangs.m), len(c.crashers.m))
+	}
+}
+
+func TestCoordinatorNativeCorpus(t *testing.T) {
+	workdir, cleanup := testWorkdir(t)
+	defer cleanup()
+
+	native := filepath.Join(workdir, "testdata", "fuzz")
+	if err := os.MkdirAll(filepath.Join(native, "FuzzFoo"), 0770); err != nil {
+		t.Fatal(err)
+	}
+	seed := []byte("go test fuzz v1\n[]byte(\"native seed\")\n")
+	if err := ioutil.WriteFile(filepath.Join(native, "FuzzFoo", "seed"), seed, 0660); err != nil {
+		t.Fatal(err)
+	}
+	old := *flagNativeCorpus
+	*flagNativeCorpus = native
+	defer func() { *flagNativeCorpus = old }()
+
+	c := newCoordinator()
+	var res ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "FuzzFoo", Funcs: 1}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Corpus) != 1 || string(res.Corpus[0].Data) != "native seed" {
+		t.Fatalf("native seed is not in corpus: %+v", res.Corpus)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(workdir, "corpus"))
+	if err != nil || len(files) != 0 {
+		t.Fatalf("native seed is copied into workdir: %v", err)
+	}
+}
+
+func TestCoordinatorDedupStack(t *testing.T) {
+	workdir, cleanup := testWorkdir(t)
+	defer cleanup()
+	old := *flagDedup
+	*flagDedup = "stack"
+	defer func() { *flagDedup = old }()
+
+	c := newCoordinator()
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1}, &ConnectRes{}); err != nil {
+		t.Fatal(err)
+	}
+	for i, input := range []string{"abc", "abcdefghijklmnopq"} {
+		output := []byte(fmt.Sprintf("panic: runtime error: index out of range [%v] with length %v\n\n"+
+			"goroutine 1 [running]:\n"+
+			"foo.parse(0xc%05x, 0x%x)\n\t/tmp/go-fuzz-build%v/src/foo/foo.go:12 +0x%x\n"+
+			"foo.Fuzz(0xc%05x)\n\t/tmp/go-fuzz-build%v/src/foo/fuzz.go:7 +0x65\n",
+			len(input)+2, len(input), i, len(input), i, i+1, i, i))
+		crash := &NewCrasherArgs{
+			Data:        []byte(input),
+			Error:       output,
+			Suppression: extractSuppression(output),
+		}
+		if err := c.NewCrasher(crash, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(c.crashers.m) != 1 {
+		t.Fatalf("got %v crashers, want 1", len(c.crashers.m))
+	}
+	sig := hash([]byte("abc"))
+	stack, err := ioutil.ReadFile(filepath.Join(workdir, "crashers", hex.EncodeToString(sig[:])+".stack"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "foo.parse\n\tfoo.go:12\nfoo.Fuzz\n\tfuzz.go:7\n"; string(stack) != want {
+		t.Fatalf("got stack file:\n%s\nwant:\n%s", stack, want)
+	}
+}
+
+func TestCoordinatorResume(t *testing.T) {
+	_, cleanup := testWorkdir(t)
+	defer cleanup()
+	old := *flagResume
+	*flagResume = true
+	defer func() { *flagResume = old }()
+
+	cover := make([]byte, CoverSize)
+	cover[1], cover[7] = 1, 3
+	c := newCoordinator()
+	var res ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1, CoverTotal: 10}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Cover != nil {
+		t.Fatalf("got cover without checkpoint")
+	}
+	sync := &SyncArgs{ID: res.ID, Execs: 1000, CoverFullness: 2, Cover: cover, Tokens: [][]byte{[]byte("token")}}
+	if err := c.Sync(sync, &SyncRes{}); err != nil {
+		t.Fatal(err)
+	}
+	c.writeCheckpoint()
+
+	// The run is restarted with -resume.
+	c2 := newCoordinator()
+	var res2 ConnectRes
+	if err := c2.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1, CoverTotal: 10}, &res2); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(res2.Cover, cover) {
+		t.Fatalf("coverage is not restored")
+	}
+	if stats := c2.coordinatorStats(); stats.Cover != 2 || stats.Execs != 1000 {
+		t.Fatalf("got cover %v and execs %v after resume, want 2 and 1000", stats.Cover, stats.Execs)
+	}
+	if toks := c2.dict.tokens(); len(toks) != 1 || string(toks[0]) != "token" {
+		t.Fatalf("dynamic dictionary is not restored: %q", toks)
+	}
+
+	// The target is rebuilt, the checkpoint does not match it.
+	c3 := newCoordinator()
+	var res3 ConnectRes
+	if err := c3.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1, CoverTotal: 11}, &res3); err != nil {
+		t.Fatal(err)
+	}
+	if res3.Cover != nil || c3.coordinatorStats().Execs != 0 {
+		t.Fatalf("checkpoint of a different binary is restored")
+	}
+}
+
+func TestCoordinatorAuth(t *testing.T) {
+	_, cleanup := testWorkdir(t)
+	defer cleanup()
+	oldToken := *flagAuthToken
+	defer func() { *flagAuthToken = oldToken }()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	*flagAuthToken = "secret-token"
+	c := newCoordinator()
+	for _, token := range []string{"", "wrong-token", "secret-token-suffix"} {
+		if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1, Token: token}, &ConnectRes{}); err == nil {
+			t.Fatalf("coordinator accepted worker with token %q", token)
+		}
+	}
+	var res ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1, Token: "secret-token"}, &res); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every call is authenticated, not just the connect.
+	if err := c.NewInput(&NewInputArgs{ID: res.ID, Data: []byte("injected"), Token: "wrong-token"}, nil); err == nil {
+		t.Fatalf("coordinator accepted input with bad token")
+	}
+	if err := c.NewCrasher(&NewCrasherArgs{Data: []byte("injected"), Token: "wrong-token"}, nil); err == nil {
+		t.Fatalf("coordinator accepted crasher with bad token")
+	}
+	if err := c.Sync(&SyncArgs{ID: res.ID, Token: "wrong-token"}, &SyncRes{}); err == nil {
+		t.Fatalf("coordinator accepted sync with bad token")
+	}
+	if len(c.corpus.m) != 1 || len(c.crashers.m) != 0 {
+		t.Fatalf("coordinator state changed by unauthenticated calls")
+	}
+	if err := c.NewInput(&NewInputArgs{ID: res.ID, Data: []byte("input"), Token: "secret-token"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.corpus.m) != 2 {
+		t.Fatalf("coordinator did not accept authenticated input")
+	}
+
+	// Same over the network, the hub uses -authtoken.
+	oldWorker := *flagWorker
+	defer func() { *flagWorker = oldWorker }()
+	*flagAuthToken = "secret-token"
+	_, ln := serveCoordinator(t)
+	defer ln.Close()
+	hub := &Hub{fn: "Fuzz", funcs: 1}
+	*flagAuthToken = "wrong-token"
+	err := hub.connect(0)
+	if err == nil {
+		t.Fatalf("hub with bad token connected")
+	}
+	log.Printf("failed to connect: %v", err)
+	*flagAuthToken = "secret-token"
+	if err := hub.connect(0); err != nil {
+		t.Fatal(err)
+	}
+	hub.coordinator.Close()
+	if strings.Contains(logs.String(), "token-") || strings.Contains(logs.String(), "secret") {
+		t.Fatalf("token is leaked into logs:\n%s", logs.String())
+	}
+}
+
+func TestCoordinatorShard(t *testing.T) {
+	workdir, cleanup := testWorkdir(t)
+	defer cleanup()
+	old := *flagShard
+	defer func() { *flagShard = old }()
+
+	corpus := filepath.Join(workdir, "corpus")
+	if err := os.MkdirAll(corpus, 0770); err != nil {
+		t.Fatal(err)
+	}
+	const inputs = 100
+	for i := 0; i < inputs; i++ {
+		if err := ioutil.WriteFile(filepath.Join(corpus, fmt.Sprint(i)), []byte(fmt.Sprintf("input %v", i)), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Both shards load the same corpus dir.
+	var coords [2]*Coordinator
+	var res [2]ConnectRes
+	owner := make(map[string]int)
+	for i := range coords {
+		*flagShard = fmt.Sprintf("%v/2", i)
+		coords[i] = newCoordinator()
+		if err := coords[i].Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1, CoverTotal: 10}, &res[i]); err != nil {
+			t.Fatal(err)
+		}
+		if res[i].Shard != i || res[i].Shards != 2 {
+			t.Fatalf("shard %v: worker got shard %v/%v", i, res[i].Shard, res[i].Shards)
+		}
+		for _, inp := range res[i].Corpus {
+			if prev, ok := owner[string(inp.Data)]; ok {
+				t.Fatalf("input %q is in shards %v and %v", inp.Data, prev, i)
+			}
+			owner[string(inp.Data)] = i
+		}
+		if len(res[i].Corpus) == 0 || len(res[i].Corpus) == inputs {
+			t.Fatalf("shard %v got %v of %v inputs", i, len(res[i].Corpus), inputs)
+		}
+	}
+	if len(owner) != inputs {
+		t.Fatalf("shards have %v inputs, want %v", len(owner), inputs)
+	}
+
+	// New inputs found by shard 0 are routed to their owners.
+	for i := 0; i < 20; i++ {
+		data := []byte(fmt.Sprintf("new input %v", i))
+		if err := coords[0].NewInput(&NewInputArgs{ID: res[0].ID, Data: data}, nil); err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		if !inShard(hash(data), 0, 2) {
+			want = 1
+		}
+		owner[string(data)] = want
+	}
+	cover := make([]byte, CoverSize)
+	cover[3] = 1
+	if err := coords[0].Sync(&SyncArgs{ID: res[0].ID, CoverFullness: 1, Cover: cover}, &SyncRes{}); err != nil {
+		t.Fatal(err)
+	}
+	coords[0].syncShards()
+	coords[1].syncShards()
+	var sync [2]SyncRes
+	for i, c := range coords {
+		if err := c.Sync(&SyncArgs{ID: res[i].ID}, &sync[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := 0
+	for i, c := range coords {
+		for sig, a := range c.corpus.m {
+			if owner[string(a.data)] != i || !inShard(sig, i, 2) {
+				t.Errorf("input %q is in shard %v", a.data, i)
+			}
+			got++
+		}
+	}
+	if got != len(owner) {
+		t.Fatalf("shards have %v inputs, want %v", got, len(owner))
+	}
+	for _, inp := range sync[1].Inputs {
+		if owner[string(inp.Data)] != 1 {
+			t.Errorf("shard 1 sent input %q of shard 0 to worker", inp.Data)
+		}
+	}
+	if len(sync[1].Inputs) == 0 {
+		t.Errorf("shard 1 did not send routed inputs to worker")
+	}
+	// Coverage of shard 0 reaches workers of shard 1.
+	if sync[1].Cover == nil || sync[1].Cover[3] != 1 {
+		t.Errorf("coverage of shard 0 is not merged into shard 1")
+	}
+}
+
+func TestParseShard(t *testing.T) {
+	for _, test := range []struct {
+		s             string
+		shard, shards int
+		ok            bool
+	}{
+		{"", 0, 0, true},
+		{"0/1", 0, 1, true},
+		{"3/4", 3, 4, true},
+		{"4/4", 0, 0, false},
+		{"-1/4", 0, 0, false},
+		{"1/0", 0, 0, false},
+		{"1", 0, 0, false},
+		{"a/b", 0, 0, false},
+	} {
+		shard, shards, err := parseShard(test.s)
+		if shard != test.shard || shards != test.shards || (err == nil) != test.ok {
+			t.Errorf("parseShard(%q) = %v, %v, %v", test.s, shard, shards, err)
+		}
+	}
+}
+
+func TestCoordinatorCorpusDups(t *testing.T) {
+	workdir, cleanup := testWorkdir(t)
+	defer cleanup()
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	corpus := filepath.Join(workdir, "corpus")
+	if err := os.MkdirAll(filepath.Join(corpus, "imported"), 0770); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"a":              "foo",
+		"b":              "bar",
+		"imported/a":     "foo",
+		"imported/a.bak": "foo",
+		"imported/c":     "baz",
+	}
+	sig := hash([]byte("bar"))
+	files[hex.EncodeToString(sig[:])] = "bar" // the same input saved by go-fuzz
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(corpus, name), []byte(data), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := newCoordinator()
+	var res ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1}, &res); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, inp := range res.Corpus {
+		got = append(got, string(inp.Data))
+	}
+	sort.Strings(got)
+	if want := []string{"bar", "baz", "foo"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got corpus %q, want %q", got, want)
+	}
+	if !strings.Contains(logs.String(), "collapsed 3 duplicate inputs") {
+		t.Errorf("duplicates are not logged:\n%s", logs.String())
+	}
+	for name := range files {
+		if _, err := os.Stat(filepath.Join(corpus, name)); err != nil {
+			t.Errorf("duplicate file is removed: %v", err)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/cover"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+func BenchmarkCompareCoverBody(b *testing.B) {
+	base := make([]byte, CoverSize)
+	cur := make([]byte, CoverSize)
+
+	// Set 1 at both ends, so that it is easy regardless of which end compareCoverBody starts from.
+	cur[0] = 1
+	cur[CoverSize-1] = 1
+	b.Run("easy", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if !compareCoverBody(base, cur) {
+				b.Fatalf("cur should have increased coverage")
+			}
+		}
+	})
+	cur[0] = 0
+	cur[CoverSize-1] = 0
+
+	// Set 1 in the middle, so that it is hard regardless of which end compareCoverBody starts from.
+	cur[CoverSize/2] = 1
+	b.Run("hard", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if !compareCoverBody(base, cur) {
+				b.Fatalf("cur should have increased coverage")
+			}
+		}
+	})
+}
+
+func TestWriteCoverProfile(t *testing.T) {
+	blocks := map[int][]CoverBlock{
+		10: {{ID: 10, File: "/src/a.go", StartLine: 3, StartCol: 2, EndLine: 5, EndCol: 3, NumStmt: 2}},
+		20: {
+			{ID: 20, File: "/src/a.go", StartLine: 7, StartCol: 1, EndLine: 9, EndCol: 2, NumStmt: 1},
+			{ID: 20, File: "/src/b.go", StartLine: 1, StartCol: 1, EndLine: 2, EndCol: 5, NumStmt: 3},
+		},
+		30: {{ID: 30, File: "/src/c.go", StartLine: 4, StartCol: 10, EndLine: 4, EndCol: 20, NumStmt: 1}},
+	}
+	cov := make([]byte, CoverSize)
+	cov[10] = 5
+	cov[20] = 1
+
+	var buf bytes.Buffer
+	writeCoverProfile(&buf, blocks, cov)
+	if !bytes.HasPrefix(buf.Bytes(), []byte("mode: set\n")) {
+		t.Fatalf("bad profile header:\n%s", buf.Bytes())
+	}
+	dir, err := ioutil.TempDir("", "go-fuzz-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "coverprofile")
+	if err := ioutil.WriteFile(fname, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+	profiles, err := cover.ParseProfiles(fname)
+	if err != nil {
+		t.Fatalf("failed to parse profile: %v\n%s", err, buf.Bytes())
+	}
+	counts := make(map[string][]int)
+	for _, p := range profiles {
+		if p.Mode != "set" {
+			t.Errorf("%v: mode %q, want set", p.FileName, p.Mode)
+		}
+		for _, b := range p.Blocks {
+			counts[p.FileName] = append(counts[p.FileName], b.Count)
+		}
+	}
+	want := map[string][]int{
+		"/src/a.go": {1, 1}, // counters are not hit counts, only whether a block is hit is reported
+		"/src/b.go": {1},
+		"/src/c.go": {0}, // never hit, but still present
+	}
+	if len(counts) != len(want) {
+		t.Fatalf("got profiles for %v, want %v", counts, want)
+	}
+	for f, w := range want {
+		got := counts[f]
+		if len(got) != len(w) {
+			t.Fatalf("%v: got counts %v, want %v", f, got, w)
+		}
+		for i := range w {
+			if got[i] != w[i] {
+				t.Fatalf("%v: got counts %v, want %v", f, got, w)
+			}
+		}
+	}
+}
+
+func TestCoverReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-fuzz-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "a.go")
+	if err := ioutil.WriteFile(src, []byte("package a\n\nfunc f(x int) int {\n\tif x > 0 {\n\t\treturn 1\n\t}\n\treturn 0\n}\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	moved := filepath.Join(dir, "moved.go")
+	blocks := map[int][]CoverBlock{
+		1: {{ID: 1, File: src, StartLine: 3, StartCol: 19, EndLine: 4, EndCol: 11, NumStmt: 1}},
+		2: {{ID: 2, File: src, StartLine: 4, StartCol: 11, EndLine: 6, EndCol: 3, NumStmt: 1}},
+		3: {{ID: 3, File: src, StartLine: 7, StartCol: 2, EndLine: 7, EndCol: 10, NumStmt: 1}},
+		4: {
+			{ID: 4, File: moved, StartLine: 1, StartCol: 1, EndLine: 2, EndCol: 1, NumStmt: 1},
+			{ID: 4, File: moved, StartLine: 3, StartCol: 1, EndLine: 4, EndCol: 1, NumStmt: 1},
+		},
+	}
+	cov := make([]byte, CoverSize)
+	cov[1] = 1
+	cov[3] = 1
+	hits := make([]uint64, CoverSize)
+	hits[1] = 1000
+	hits[3] = 10
+	report := filepath.Join(dir, "report")
+	dumpCoverReport(report, blocks, cov, hits)
+
+	index, err := ioutil.ReadFile(filepath.Join(report, "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// moved.go has more uncovered blocks, so it goes first.
+	movedPos := bytes.Index(index, []byte(moved+": source unavailable"))
+	srcPos := bytes.Index(index, []byte(`<a href="file1.html">`+src+`</a>`))
+	if movedPos == -1 || srcPos == -1 || movedPos > srcPos {
+		t.Fatalf("bad index:\n%s", index)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(report, "file1.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<span class="heat4" data-block="1" title="1000 hits">{
+	if x &gt; 0 </span>`,
+		`<span class="uncov" data-block="2" title="0 hits">{
+		return 1
+	}</span>`,
+		`<span class="heat2" data-block="3" title="10 hits">return 0</span>`,
+		"package a\n\nfunc f(x int) int",
+	} {
+		if !bytes.Contains(page, []byte(want)) {
+			t.Errorf("report does not contain %q:\n%s", want, page)
+		}
+	}
+}
+
+func TestCoverBuckets(t *testing.T) {
+	bounds := []int{0, 1, 2, 3, 7, 15, 31, 127, 255}
+	for x := 0; x < 256; x++ {
+		want := 0
+		for _, b := range bounds {
+			if x <= b {
+				want = b
+				break
+			}
+		}
+		if got := roundUpCover(byte(x)); int(got) != want {
+			t.Errorf("roundUpCover(%v) = %v, want %v", x, got, want)
+		}
+	}
+}
+
+func TestCompareCoverBuckets(t *testing.T) {
+	maxCover := make([]byte, CoverSize)
+	cur := make([]byte, CoverSize)
+	const block = 1234
+	tests := []struct {
+		hits   byte
+		newCov bool
+	}{
+		{1, true},   // new block
+		{1, false},  // same bucket
+		{3, true},   // bucket 1 -> bucket 3
+		{2, false},  // lower bucket
+		{5, true},   // bucket 3 -> bucket 4-7
+		{7, false},  // same bucket 4-7
+		{4, false},  // same bucket 4-7
+		{8, true},   // bucket 4-7 -> bucket 8-15
+		{100, true}, // bucket 8-15 -> bucket 32-127
+		{127, false},
+		{128, true},
+		{255, false},
+	}
+	for _, test := range tests {
+		cur[block] = test.hits
+		if got := compareCover(maxCover, cur); got != test.newCov {
+			t.Fatalf("%v hits with max cover %v: got new coverage %v, want %v",
+				test.hits, maxCover[block], got, test.newCov)
+		}
+		if _, got := findNewCover(maxCover, cur); got != test.newCov {
+			t.Fatalf("%v hits with max cover %v: findNewCover returned %v, want %v",
+				test.hits, maxCover[block], got, test.newCov)
+		}
+		updateMaxCover(maxCover, cur)
+	}
+}
+
+func BenchmarkUpdateMaxCover(b *testing.B) {
+	base := make([]byte, CoverSize)
+	cur := make([]byte, CoverSize)
+	for i := range cur {
+		cur[i] = byte(i)
+	}
+	b.SetBytes(CoverSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		updateMaxCover(base, cur)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+// coverLog writes -coverlog: a CSV file with a row per coverage block
+// (block ID, file, line, seconds since start) in order of the first discovery.
+// Rows are buffered and flushed by coordinatorLoop, so the log survives a kill
+// except for the last few seconds.
+type coverLog struct {
+	f      *os.File
+	w      *csv.Writer
+	blocks map[int]CoverBlock // the first block with the ID, ID is coverage counter index
+}
+
+// openCoverLog creates coverage log file, block positions are read from metadata in bin.
+// With -resume rows are appended to the existing log, because blocks covered
+// by the checkpoint are not logged again.
+func openCoverLog(file, bin string) *coverLog {
+	meta, err := readBinMetaData(bin)
+	if err != nil {
+		log.Fatalf("failed to read metadata for -coverlog: %v", err)
+	}
+	mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if *flagResume {
+		mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(file, mode, 0660)
+	if err != nil {
+		log.Fatalf("failed to create -coverlog file: %v", err)
+	}
+	cl := &coverLog{
+		f:      f,
+		w:      csv.NewWriter(f),
+		blocks: make(map[int]CoverBlock),
+	}
+	for _, b := range meta.Blocks {
+		if _, ok := cl.blocks[b.ID]; !ok {
+			cl.blocks[b.ID] = b
+		}
+	}
+	if st, err := f.Stat(); err == nil && st.Size() == 0 {
+		cl.w.Write([]string{"block", "file", "line", "seconds"})
+	}
+	return cl
+}
+
+// readBinMetaData reads only metadata from test binary archive bin.
+func readBinMetaData(bin string) (MetaData, error) {
+	zipr, err := zip.OpenReader(bin)
+	if err != nil {
+		return MetaData{}, err
+	}
+	defer zipr.Close()
+	for _, zipf := range zipr.File {
+		if zipf.Name != "metadata" {
+			continue
+		}
+		r, err := zipf.Open()
+		if err != nil {
+			return MetaData{}, err
+		}
+		defer r.Close()
+		return readMetaData(r)
+	}
+	return MetaData{}, fmt.Errorf("no metadata in %v", bin)
+}
+
+// logCover logs blocks that are covered by cover, but not by base (can be nil).
+// Blocks discovered in the same sync get the same timestamp and are ordered by ID.
+func (cl *coverLog) logCover(base, cover []byte, since time.Duration) {
+	if base == nil {
+		base = make([]byte, len(cover))
+	}
+	secs := strconv.FormatFloat(since.Seconds(), 'f', 3, 64)
+	for _, id := range newBlocks(base, cover) {
+		b := cl.blocks[id]
+		cl.w.Write([]string{strconv.Itoa(id), b.File, strconv.Itoa(b.StartLine), secs})
+	}
+}
+
+func (cl *coverLog) flush() {
+	cl.w.Flush()
+	if err := cl.w.Error(); err != nil {
+		log.Printf("failed to write -coverlog file: %v", err)
+	}
+}
+
+func (cl *coverLog) close() {
+	cl.flush()
+	if err := cl.f.Close(); err != nil {
+		log.Printf("failed to write -coverlog file: %v", err)
+	}
+}
+
+// closeCoverLog flushes and closes -coverlog file on shutdown.
+func (c *Coordinator) closeCoverLog() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.coverLog == nil {
+		return
+	}
+	c.coverLog.close()
+	c.coverLog = nil
+}
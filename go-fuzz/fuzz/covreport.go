@@ -0,0 +1,237 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bufio"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+// heatLevels is the number of heatmap colors for covered blocks.
+const heatLevels = 5
+
+// reportFile is a source file in the HTML coverage report.
+type reportFile struct {
+	Name      string
+	Page      string // HTML page name in the report dir, empty if source is unavailable
+	Blocks    int
+	Uncovered int
+	Err       string // why the source is unavailable
+	Spans     []reportSpan
+}
+
+// reportSpan is a piece of source code, Block is -1 for code outside of coverage blocks.
+type reportSpan struct {
+	Text  string
+	Block int
+	Class string
+	Hits  uint64
+}
+
+// dumpCoverReport writes HTML coverage report into dir: index.html with all files
+// sorted by the number of uncovered blocks and a page per file with blocks colored
+// by estimated number of hits. Source files are read from paths in blocks,
+// files that are not available are listed in the index without a page.
+func dumpCoverReport(dir string, blocks map[int][]CoverBlock, cover []byte, hits []uint64) {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		log.Printf("failed to create coverage report dir: %v", err)
+		return
+	}
+	files := buildCoverReport(blocks, cover, hits)
+	for _, f := range files {
+		if f.Page == "" {
+			continue
+		}
+		writeReportPage(filepath.Join(dir, f.Page), coverReportFileTemplate, f)
+	}
+	writeReportPage(filepath.Join(dir, "index.html"), coverReportIndexTemplate, files)
+}
+
+func writeReportPage(fname string, t *template.Template, data interface{}) {
+	out, err := os.Create(fname)
+	if err != nil {
+		log.Printf("failed to create coverage report: %v", err)
+		return
+	}
+	defer out.Close()
+	w := bufio.NewWriter(out)
+	if err := t.Execute(w, data); err != nil {
+		log.Printf("failed to write coverage report: %v", err)
+		return
+	}
+	if err := w.Flush(); err != nil {
+		log.Printf("failed to write coverage report: %v", err)
+	}
+}
+
+// buildCoverReport groups blocks by file and splits sources into spans.
+// hits contains per coverage counter number of executions, it can be nil.
+func buildCoverReport(blocks map[int][]CoverBlock, cover []byte, hits []uint64) []*reportFile {
+	perFile := make(map[string][]CoverBlock)
+	var maxHits uint64
+	for id, bb := range blocks {
+		for _, b := range bb {
+			perFile[b.File] = append(perFile[b.File], b)
+		}
+		if hits != nil && cover[id] != 0 && hits[id] > maxHits {
+			maxHits = hits[id]
+		}
+	}
+	var files []*reportFile
+	for name, bb := range perFile {
+		f := &reportFile{Name: name, Blocks: len(bb)}
+		for _, b := range bb {
+			if cover[b.ID] == 0 {
+				f.Uncovered++
+			}
+		}
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			f.Err = "source unavailable (moved since build?)"
+		} else {
+			f.Spans = splitSource(src, bb, func(b CoverBlock) reportSpan {
+				s := reportSpan{Block: b.ID, Class: "uncov"}
+				if hits != nil {
+					s.Hits = hits[b.ID]
+				}
+				if cover[b.ID] != 0 {
+					s.Class = fmt.Sprintf("heat%v", heatLevel(s.Hits, maxHits))
+				}
+				return s
+			})
+		}
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].Uncovered != files[j].Uncovered {
+			return files[i].Uncovered > files[j].Uncovered
+		}
+		return files[i].Name < files[j].Name
+	})
+	for i, f := range files {
+		if f.Err == "" {
+			f.Page = fmt.Sprintf("file%v.html", i)
+		}
+	}
+	return files
+}
+
+// heatLevel maps hits to [0, heatLevels), each level down is 10x fewer hits than max.
+func heatLevel(hits, max uint64) int {
+	if hits == 0 {
+		return 0
+	}
+	l := heatLevels - 1 - int(math.Log10(float64(max)/float64(hits)))
+	if l < 0 {
+		l = 0
+	}
+	return l
+}
+
+// splitSource splits src into spans at block boundaries.
+// Nested blocks take precedence over enclosing ones.
+func splitSource(src []byte, blocks []CoverBlock, span func(CoverBlock) reportSpan) []reportSpan {
+	lineStart := []int{0, 0} // lines and columns are 1-based
+	for i, c := range src {
+		if c == '\n' {
+			lineStart = append(lineStart, i+1)
+		}
+	}
+	offset := func(line, col int) int {
+		if line >= len(lineStart) {
+			return len(src)
+		}
+		off := lineStart[line] + col - 1
+		if off < 0 {
+			off = 0
+		}
+		if off > len(src) {
+			off = len(src)
+		}
+		return off
+	}
+	sort.SliceStable(blocks, func(i, j int) bool {
+		bi, bj := blocks[i], blocks[j]
+		return bi.StartLine < bj.StartLine || bi.StartLine == bj.StartLine && bi.StartCol < bj.StartCol
+	})
+	owner := make([]int, len(src))
+	for i := range owner {
+		owner[i] = -1
+	}
+	for i, b := range blocks {
+		for off, end := offset(b.StartLine, b.StartCol), offset(b.EndLine, b.EndCol); off < end; off++ {
+			owner[off] = i
+		}
+	}
+	var spans []reportSpan
+	for start := 0; start < len(src); {
+		end := start + 1
+		for end < len(src) && owner[end] == owner[start] {
+			end++
+		}
+		s := reportSpan{Block: -1}
+		if owner[start] != -1 {
+			s = span(blocks[owner[start]])
+		}
+		s.Text = string(src[start:end])
+		spans = append(spans, s)
+		start = end
+	}
+	return spans
+}
+
+var coverReportIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>go-fuzz coverage</title>
+<style>
+body { font-family: sans-serif; }
+td { padding: 2px 12px; }
+.unavailable { color: #888; }
+</style>
+</head>
+<body>
+<table>
+<tr><th>File</th><th>Uncovered blocks</th><th>Blocks</th></tr>
+{{range .}}<tr>
+<td>{{if .Page}}<a href="{{.Page}}">{{.Name}}</a>{{else}}<span class="unavailable">{{.Name}}: {{.Err}}</span>{{end}}</td>
+<td>{{.Uncovered}}</td><td>{{.Blocks}}</td>
+</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var coverReportFileTemplate = template.Must(template.New("file").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: sans-serif; }
+pre { font-family: monospace; }
+.uncov { background: #f4a6a6; }
+.heat0 { background: #e0f5e0; }
+.heat1 { background: #b8e6b8; }
+.heat2 { background: #8fd68f; }
+.heat3 { background: #66c266; }
+.heat4 { background: #3faa3f; }
+</style>
+</head>
+<body>
+<p><a href="index.html">index</a> {{.Name}}: {{.Uncovered}} of {{.Blocks}} blocks uncovered</p>
+<pre>{{range .Spans}}{{if lt .Block 0}}{{.Text}}{{else}}<span class="{{.Class}}" data-block="{{.Block}}" title="{{.Hits}} hits">{{.Text}}</span>{{end}}{{end}}</pre>
+</body>
+</html>
+`))
@@ -0,0 +1,151 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+// loadDict reads AFL dictionary file. The file name can have @level suffix
+// (e.g. "http.dict@1"), then only entries with level <= level are loaded.
+func loadDict(fname string) ([][]byte, error) {
+	level := 0
+	if idx := strings.LastIndexByte(fname, '@'); idx != -1 {
+		if v, err := strconv.Atoi(fname[idx+1:]); err == nil {
+			fname, level = fname[:idx], v
+		}
+	}
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dictionary: %v", err)
+	}
+	tokens, err := parseDict(data, level)
+	if err != nil {
+		return nil, fmt.Errorf("%v:%v", fname, err)
+	}
+	return tokens, nil
+}
+
+// parseDict parses AFL dictionary. Each line is either empty, a # comment or an entry:
+//
+//	"value"
+//	name="value"
+//	name@level="value"
+//
+// Values can contain \\, \" and \xNN escapes, all other bytes must be printable ASCII.
+// Entries with level above the given one are skipped.
+func parseDict(data []byte, level int) ([][]byte, error) {
+	var tokens [][]byte
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; s.Scan(); line++ {
+		str := strings.TrimSpace(s.Text())
+		if str == "" || str[0] == '#' {
+			continue
+		}
+		i := 0
+		for i < len(str) && (isAlnum(str[i]) || str[i] == '_') {
+			i++
+		}
+		if i < len(str) && str[i] == '@' {
+			i++
+			start := i
+			for i < len(str) && str[i] >= '0' && str[i] <= '9' {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("%v: missing level after @", line)
+			}
+			lvl, err := strconv.Atoi(str[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("%v: bad level: %v", line, err)
+			}
+			if lvl > level {
+				continue
+			}
+		}
+		if i != 0 {
+			str = strings.TrimLeft(str[i:], " \t")
+			if str == "" || str[0] != '=' {
+				return nil, fmt.Errorf("%v: expected '=' after entry name", line)
+			}
+			str = strings.TrimLeft(str[1:], " \t")
+		}
+		tok, err := parseDictValue(str)
+		if err != nil {
+			return nil, fmt.Errorf("%v: %v", line, err)
+		}
+		tokens = append(tokens, tok)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
+
+var errDictEscape = errors.New(`bad escape sequence, expected \\, \" or \xNN`)
+
+// parseDictValue parses quoted value of a dictionary entry.
+func parseDictValue(str string) ([]byte, error) {
+	if len(str) < 2 || str[0] != '"' || str[len(str)-1] != '"' {
+		return nil, fmt.Errorf("value is not enclosed in quotes")
+	}
+	str = str[1 : len(str)-1]
+	var tok []byte
+	for i := 0; i < len(str); i++ {
+		c := str[i]
+		switch {
+		case c == '\\':
+			i++
+			if i < len(str) && (str[i] == '\\' || str[i] == '"') {
+				tok = append(tok, str[i])
+				continue
+			}
+			if i+2 >= len(str) || str[i] != 'x' {
+				return nil, errDictEscape
+			}
+			v, err := strconv.ParseUint(str[i+1:i+3], 16, 8)
+			if err != nil {
+				return nil, errDictEscape
+			}
+			tok = append(tok, byte(v))
+			i += 2
+		case c == '"':
+			return nil, fmt.Errorf("unescaped quote in value")
+		case c < 32 || c > 127:
+			return nil, fmt.Errorf("non-printable character 0x%02x in value, use \\xNN escape", c)
+		default:
+			tok = append(tok, c)
+		}
+	}
+	return tok, nil
+}
+
+// mergeLiterals adds dictionary tokens to literals as string literals, skipping duplicates.
+func mergeLiterals(lits []Literal, tokens [][]byte) []Literal {
+	seen := make(map[Literal]bool)
+	for _, lit := range lits {
+		seen[lit] = true
+	}
+	for _, tok := range tokens {
+		lit := Literal{Val: string(tok), IsStr: true}
+		if len(tok) == 0 || seen[lit] {
+			continue
+		}
+		seen[lit] = true
+		lits = append(lits, lit)
+	}
+	return lits
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,92 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+func TestParseDict(t *testing.T) {
+	dict := `# AFL dictionary for a made up format.
+
+# Header.
+header_png="\x89PNG\x0d\x0a\x1a\x0a"
+"IHDR"
+  kw_quote = "say \"hi\""
+kw_backslash="a\\b"
+kw_hex="\x00\xff\xAb"
+kw_l1@1="level1"
+kw_l2@2="level2"
+@3="level3"
+kw_del="~` + "\x7f" + `"
+`
+	want := []string{"\x89PNG\r\n\x1a\n", "IHDR", `say "hi"`, `a\b`, "\x00\xff\xab", "level1", "~\x7f"}
+	tokens, err := parseDict([]byte(dict), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %q, want %q", tokens, want)
+	}
+	for i := range want {
+		if string(tokens[i]) != want[i] {
+			t.Errorf("token %v: got %q, want %q", i, tokens[i], want[i])
+		}
+	}
+	if tokens, err := parseDict([]byte(dict), 3); err != nil || len(tokens) != len(want)+2 {
+		t.Errorf("level 3: got %q, %v", tokens, err)
+	}
+	if tokens, err := parseDict([]byte(dict), 0); err != nil || len(tokens) != len(want)-1 {
+		t.Errorf("level 0: got %q, %v", tokens, err)
+	}
+}
+
+func TestParseDictErrors(t *testing.T) {
+	tests := []struct {
+		dict string
+		line int
+	}{
+		{`foo`, 1},
+		{`foo=bar`, 1},
+		{`foo="bar`, 1},
+		{`foo="bar"baz"`, 1},
+		{`foo "bar"`, 1},
+		{`foo@="bar"`, 1},
+		{`foo-bar="baz"`, 1},
+		{"# comment\n\nfoo=\"\\y\"", 3},
+		{"a=\"ok\"\nb=\"\\x4\"", 2},
+		{"a=\"ok\"\nb=\"\\xZZ\"", 2},
+		{"a=\"ok\"\nb=\"\\\"", 2},
+		{"a=\"tab\there\"", 1},
+		{"a=\"\xc3\xa9\"", 1},
+	}
+	for _, test := range tests {
+		_, err := parseDict([]byte(test.dict), 0)
+		if err == nil {
+			t.Errorf("%q: no error", test.dict)
+			continue
+		}
+		if prefix := strconv.Itoa(test.line) + ": "; !strings.HasPrefix(err.Error(), prefix) {
+			t.Errorf("%q: error %q does not refer to line %v", test.dict, err, test.line)
+		}
+	}
+}
+
+func TestMergeLiterals(t *testing.T) {
+	lits := []Literal{{"foo", true}, {"\x01", false}}
+	lits = mergeLiterals(lits, [][]byte{[]byte("foo"), []byte("bar"), []byte("\x01"), []byte("bar"), nil})
+	want := []Literal{{"foo", true}, {"\x01", false}, {"bar", true}, {"\x01", true}}
+	if len(lits) != len(want) {
+		t.Fatalf("got %+v, want %+v", lits, want)
+	}
+	for i := range want {
+		if lits[i] != want[i] {
+			t.Fatalf("got %+v, want %+v", lits, want)
+		}
+	}
+}
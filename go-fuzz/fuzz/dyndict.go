@@ -0,0 +1,58 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"container/list"
+)
+
+// maxDynamicTokens limits size of the dynamic dictionary.
+const maxDynamicTokens = 256
+
+// dynamicDict is a dictionary of tokens observed at runtime: operands of sonar
+// comparisons that stay the same while the other operand changes (computed
+// checksums, derived magic values, etc). Once the dictionary is full, the least
+// recently used tokens are evicted. A token is used when it is added or when
+// a mutation with it gives new coverage, so tokens that never help age out.
+type dynamicDict struct {
+	version uint64     // incremented on every change
+	lru     *list.List // of string, the most recently used token is at front
+	m       map[string]*list.Element
+}
+
+func newDynamicDict() *dynamicDict {
+	return &dynamicDict{
+		lru: list.New(),
+		m:   make(map[string]*list.Element),
+	}
+}
+
+// add adds a new token, it does not touch tokens that are already present,
+// otherwise constantly observed but useless tokens would never age out.
+func (d *dynamicDict) add(tok []byte) {
+	if _, ok := d.m[string(tok)]; ok {
+		return
+	}
+	d.m[string(tok)] = d.lru.PushFront(string(tok))
+	if d.lru.Len() > maxDynamicTokens {
+		delete(d.m, d.lru.Remove(d.lru.Back()).(string))
+	}
+	d.version++
+}
+
+// use marks token as the most recently used.
+func (d *dynamicDict) use(tok []byte) {
+	if e, ok := d.m[string(tok)]; ok {
+		d.lru.MoveToFront(e) // the set of tokens does not change, so version stays the same
+	}
+}
+
+// tokens returns all tokens, the most recently used first.
+func (d *dynamicDict) tokens() [][]byte {
+	res := make([][]byte, 0, d.lru.Len())
+	for e := d.lru.Front(); e != nil; e = e.Next() {
+		res = append(res, []byte(e.Value.(string)))
+	}
+	return res
+}
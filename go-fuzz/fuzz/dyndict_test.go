@@ -0,0 +1,55 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDynamicDictEviction(t *testing.T) {
+	_, cleanup := testWorkdir(t)
+	defer cleanup()
+	c := newCoordinator()
+	var res ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1}, &res); err != nil {
+		t.Fatal(err)
+	}
+	sync := func(a *SyncArgs) [][]byte {
+		a.ID = res.ID
+		var r SyncRes
+		if err := c.Sync(a, &r); err != nil {
+			t.Fatal(err)
+		}
+		return r.Tokens
+	}
+	has := func(toks [][]byte, tok string) bool {
+		for _, v := range toks {
+			if string(v) == tok {
+				return true
+			}
+		}
+		return false
+	}
+
+	if toks := sync(&SyncArgs{Tokens: [][]byte{[]byte("useful"), []byte("useless")}}); len(toks) != 2 {
+		t.Fatalf("got tokens %q, want 2 tokens", toks)
+	}
+	if toks := sync(&SyncArgs{Tokens: [][]byte{[]byte("useless")}}); toks != nil {
+		t.Fatalf("got tokens %q, but the dictionary did not change", toks)
+	}
+	var toks [][]byte
+	for i := 0; i < maxDynamicTokens-1; i++ {
+		toks = sync(&SyncArgs{
+			Tokens:     [][]byte{[]byte(fmt.Sprintf("token%v", i))},
+			UsedTokens: [][]byte{[]byte("useful")},
+		})
+	}
+	if len(toks) != maxDynamicTokens {
+		t.Fatalf("got %v tokens, want %v", len(toks), maxDynamicTokens)
+	}
+	if !has(toks, "useful") || has(toks, "useless") || !has(toks, "token0") {
+		t.Fatalf("used token must be kept and the least recently used token must be evicted: %q", toks)
+	}
+}
@@ -1,6 +1,6 @@
 // Code generated by "stringer -type execType -trimprefix exec"; DO NOT EDIT.
 
-package main
+package fuzz
 
 import "strconv"
 
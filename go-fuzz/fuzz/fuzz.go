@@ -0,0 +1,217 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// Package fuzz implements go-fuzz coordinator and workers.
+// The go-fuzz command is a thin wrapper around Main,
+// Run allows to embed the fuzzer into other programs (e.g. test harnesses).
+package fuzz
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Config describes a fuzzing session started with Run.
+// Options that are not present in Config use values of the corresponding go-fuzz flags.
+type Config struct {
+	Workdir        string        // dir with persistent work data
+	Bin            string        // test binary built with go-fuzz-build, defaults to <pkg>-fuzz.zip in the current dir
+	Func           string        // function to fuzz, can be empty if the binary contains a single function
+	Corpus         string        // dir with corpus inputs, defaults to <Workdir>/corpus
+	Procs          int           // number of parallel workers, defaults to the number of CPUs
+	Affinity       bool          // pin test binaries of each worker to a separate CPU, see -affinity
+	Duration       time.Duration // stop after this time, 0 means no limit
+	Execs          uint64        // stop after this number of executions, 0 means no limit
+	MemLimit       uint64        // per-input heap growth limit in bytes, 0 means no limit
+	MaxInputSize   int           // max input size in bytes, 0 means the hint recorded in Bin or 1MB
+	MutatorWeights string        // weights of mutation strategies (including registered mutators), see -mutatorweights
+	Seed           int64         // seed of mutation RNGs, see -seed, 0 means a random seed
+	Resume         bool          // restore coordinator state from the checkpoint in Workdir
+	Baseline       string        // dir with baseline corpus, see -baseline
+	ExitOnCrash    bool          // stop after the first unique crasher is saved
+	CoverLog       string        // file for CSV log of the first discovery of coverage blocks, see -coverlog
+	Metrics        string        // HTTP listen address for metrics and the /procs control endpoint, see -metrics
+	SonarTrace     string        // file for sampled sonar comparisons, see -sonartrace
+	SonarTraceRate int           // trace every N-th execution with sonar, 0 means the default
+}
+
+// Result is the state of a fuzzing session at the time Run returns.
+type Result struct {
+	Execs    uint64   // number of executions of the fuzz function
+	Cover    int      // number of coverage blocks hit by the corpus
+	Crashers [][]byte // crashing inputs, including ones found by previous sessions with the same workdir
+	Tokens   [][]byte // dynamic dictionary: tokens observed in comparisons at runtime
+
+	NewCover [][]byte // inputs with coverage beyond Config.Baseline found by this session
+}
+
+// Run runs coordinator and workers in the current process until ctx is done
+// or one of the limits in cfg is reached. Returned error is non-nil if fuzzing
+// can't be started, e.g. because of a bad test binary, function or dictionary.
+// Run sets go-fuzz flags from cfg and restores them before returning,
+// so it must not be called concurrently.
+func Run(ctx context.Context, cfg Config) (Result, error) {
+	if cfg.Workdir == "" {
+		return Result{}, errors.New("workdir is not set")
+	}
+	bin := expandHomeDir(cfg.Bin)
+	if bin == "" {
+		if bin = defaultBin(); bin == "" {
+			return Result{}, errors.New("test binary is not set")
+		}
+	}
+	if _, err := os.Stat(bin); err != nil {
+		return Result{}, fmt.Errorf("failed to open bin file: %v", err)
+	}
+	if _, err := parseStrategyWeights(cfg.MutatorWeights); err != nil {
+		return Result{}, err
+	}
+	if cfg.Baseline != "" {
+		if _, err := os.Stat(cfg.Baseline); err != nil {
+			return Result{}, fmt.Errorf("failed to open baseline dir: %v", err)
+		}
+	}
+	if cfg.Affinity && !affinitySupported {
+		return Result{}, errors.New("affinity is supported only on Linux")
+	}
+	procs := cfg.Procs
+	if procs <= 0 {
+		procs = runtime.NumCPU()
+	}
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to listen: %v", err)
+	}
+	var metricsLn net.Listener
+	if cfg.Metrics != "" {
+		if metricsLn, err = net.Listen("tcp", cfg.Metrics); err != nil {
+			ln.Close()
+			return Result{}, fmt.Errorf("failed to listen on metrics address: %v", err)
+		}
+	}
+
+	defer restoreFlags(saveFlags())
+	*flagWorkdir = expandHomeDir(cfg.Workdir)
+	*flagBin = bin
+	*flagFunc = cfg.Func
+	*flagCorpus = expandHomeDir(cfg.Corpus)
+	*flagProcs = procs
+	*flagAffinity = cfg.Affinity
+	*flagMemLimit = cfg.MemLimit
+	*flagMaxInputSize = cfg.MaxInputSize
+	*flagMutatorWeights = cfg.MutatorWeights
+	*flagSeed = cfg.Seed
+	*flagResume = cfg.Resume
+	*flagBaseline = expandHomeDir(cfg.Baseline)
+	*flagExitOnCrash = cfg.ExitOnCrash
+	*flagCoverLog = expandHomeDir(cfg.CoverLog)
+	*flagSonarTrace = expandHomeDir(cfg.SonarTrace)
+	*flagSonarTraceRate = defaultSonarTraceRate
+	if cfg.SonarTraceRate > 0 {
+		*flagSonarTraceRate = cfg.SonarTraceRate
+	}
+	*flagCoordinator = ln.Addr().String()
+	*flagWorker = ln.Addr().String()
+	*flagHTTP = ""
+	atomic.StoreUint32(&shutdown, 0)
+	shutdownC = make(chan struct{})
+	stopC = make(chan struct{})
+	stopOnce = new(sync.Once)
+	shutdownCleanup = nil
+
+	c := startCoordinator(ln)
+	if metricsLn != nil {
+		srv := &http.Server{Handler: c.metricsHandler()}
+		go srv.Serve(metricsLn)
+		defer srv.Close()
+	}
+	hub, err := workerMain()
+	if err != nil {
+		atomic.StoreUint32(&shutdown, 1)
+		close(shutdownC)
+		ln.Close()
+		for _, f := range shutdownCleanup {
+			f()
+		}
+		return Result{}, err
+	}
+
+	var timeout <-chan time.Time
+	if cfg.Duration > 0 {
+		timer := time.NewTimer(cfg.Duration)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+loop:
+	for {
+		select {
+		case <-ctx.Done():
+			break loop
+		case <-timeout:
+			break loop
+		case <-stopC:
+			break loop
+		case <-ticker.C:
+			if cfg.Execs != 0 && c.coordinatorStats().Execs >= cfg.Execs {
+				break loop
+			}
+		}
+	}
+
+	// Workers exit on shutdown, after that nobody uses hub and coordinator.
+	atomic.StoreUint32(&shutdown, 1)
+	close(shutdownC)
+	hub.workers.Wait()
+	hub.stop()
+	ln.Close()
+	for _, f := range shutdownCleanup {
+		f()
+	}
+	return c.result(), nil
+}
+
+// saveFlags returns values of all go-fuzz flags.
+func saveFlags() map[string]string {
+	saved := make(map[string]string)
+	flags.VisitAll(func(f *flag.Flag) {
+		saved[f.Name] = f.Value.String()
+	})
+	return saved
+}
+
+// restoreFlags sets go-fuzz flags to values returned by saveFlags.
+func restoreFlags(saved map[string]string) {
+	flags.VisitAll(func(f *flag.Flag) {
+		f.Value.Set(saved[f.Name])
+	})
+}
+
+// result returns coordinator state for Run.
+func (c *Coordinator) result() Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	res := Result{
+		Execs:  c.statExecs,
+		Cover:  c.coverFullness,
+		Tokens: c.dict.tokens(),
+	}
+	res.NewCover = append(res.NewCover, c.newCoverInputs...)
+	if c.crashers != nil {
+		for _, a := range c.crashers.m {
+			res.Crashers = append(res.Crashers, a.data)
+		}
+	}
+	return res
+}
@@ -0,0 +1,1502 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dvyukov/go-fuzz/examples/structured"
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+)
+
+const testTarget = `package target
+
+func Fuzz(data []byte) int {
+	if string(data) == "crash" {
+		panic("crash")
+	}
+	return 0
+}
+`
+
+func TestRun(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, testTarget)
+	defer cleanup()
+
+	const execs = 20000
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir: filepath.Join(dir, "workdir"),
+		Bin:     bin,
+		Procs:   2,
+		Execs:   execs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Execs < execs {
+		t.Fatalf("fuzzing stopped after %v execs, want %v", res.Execs, execs)
+	}
+	if res.Cover == 0 {
+		t.Fatalf("no coverage")
+	}
+	if len(res.Crashers) != 1 || string(res.Crashers[0]) != "crash" {
+		t.Fatalf("got crashers %q, want [crash]", res.Crashers)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, testTarget)
+	defer cleanup()
+	badBin := filepath.Join(dir, "bad-fuzz.zip")
+	if err := ioutil.WriteFile(badBin, []byte("not a zip"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	badDict := filepath.Join(dir, "bad.dict")
+	if err := ioutil.WriteFile(badDict, []byte("token\n"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	oldDict := *flagDict
+	defer func() { *flagDict = oldDict }()
+
+	// Errors are returned to the caller instead of terminating the process,
+	// and Run does not leak its config into flags for the next Run.
+	tests := []struct {
+		cfg  Config
+		dict string
+		err  string
+	}{
+		{Config{Bin: badBin}, "", "failed to open bin file"},
+		{Config{Bin: bin, Func: "FuzzMissing"}, "", "function FuzzMissing not found"},
+		{Config{Bin: bin, MemLimit: 1 << 30}, badDict, "bad.dict:1"},
+	}
+	for i, test := range tests {
+		test.cfg.Workdir = filepath.Join(dir, fmt.Sprintf("workdir%v", i))
+		*flagDict = test.dict
+		_, err := Run(context.Background(), test.cfg)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("test %v: got error %v, want %q", i, err, test.err)
+		}
+		if *flagBin != "" || *flagFunc != "" || *flagMemLimit != 0 || *flagWorker != "" {
+			t.Errorf("test %v: Run did not restore flags: -bin=%q -func=%q -memlimit=%v -worker=%q", i, *flagBin, *flagFunc, *flagMemLimit, *flagWorker)
+		}
+	}
+}
+
+const memLimitTarget = `package target
+
+import "time"
+
+var sink [][]byte
+
+// Fuzz allocates 1MB per input byte and holds it for a while.
+func Fuzz(data []byte) int {
+	for range data {
+		sink = append(sink, make([]byte, 1<<20))
+	}
+	time.Sleep(20 * time.Millisecond)
+	sink = nil
+	return 0
+}
+`
+
+func TestMemLimit(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, memLimitTarget)
+	defer cleanup()
+
+	corpus := filepath.Join(dir, "corpus")
+	if err := os.MkdirAll(corpus, 0770); err != nil {
+		t.Fatal(err)
+	}
+	// Only the large input exceeds the limit.
+	for i, n := range []int{1, 4, 150} {
+		if err := ioutil.WriteFile(filepath.Join(corpus, fmt.Sprint(i)), make([]byte, n), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Minimization of crashers is slow for this target.
+	defer func(v time.Duration) { *flagMinimizeCrasher = v }(*flagMinimizeCrasher)
+	*flagMinimizeCrasher = time.Second
+	workdir := filepath.Join(dir, "workdir")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Corpus:   corpus,
+		Procs:    1,
+		Duration: 15 * time.Second,
+		MemLimit: 100 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Execs < 100 {
+		t.Fatalf("fuzzing stopped after %v execs", res.Execs)
+	}
+	if len(res.Crashers) == 0 {
+		t.Fatalf("no crashers")
+	}
+	for _, data := range res.Crashers {
+		// Inputs close to the limit can exceed it because of the runtime overhead.
+		if len(data) < 90 {
+			t.Errorf("input of size %v is reported as crasher", len(data))
+		}
+	}
+	oom, err := filepath.Glob(filepath.Join(workdir, "crashers", "*.oom"))
+	if err != nil || len(oom) != len(res.Crashers) {
+		t.Fatalf("got oom files %q for %v crashers: %v", oom, len(res.Crashers), err)
+	}
+	output, err := ioutil.ReadFile(strings.TrimSuffix(oom[0], ".oom") + ".output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), MemLimitMsg) || !strings.Contains(string(output), "target.Fuzz") {
+		t.Fatalf("bad crasher output:\n%s", output)
+	}
+}
+
+const memCapTarget = `package target
+
+var sink []byte
+
+// Fuzz allocates 16MB per input byte at once, the memory is never touched.
+func Fuzz(data []byte) int {
+	sink = make([]byte, len(data)<<24)
+	sink = nil
+	return 0
+}
+`
+
+// TestMemLimitAlloc checks that a single huge allocation is reported
+// even though it does not increase memory usage observed by polling.
+func TestMemLimitAlloc(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("address space is capped only on linux")
+	}
+	dir, bin, cleanup := buildTestTarget(t, memCapTarget)
+	defer cleanup()
+
+	corpus := filepath.Join(dir, "corpus")
+	if err := os.MkdirAll(corpus, 0770); err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range []int{1, 4, 1000} {
+		if err := ioutil.WriteFile(filepath.Join(corpus, fmt.Sprint(i)), make([]byte, n), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(v time.Duration) { *flagMinimizeCrasher = v }(*flagMinimizeCrasher)
+	*flagMinimizeCrasher = time.Second
+	workdir := filepath.Join(dir, "workdir")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Corpus:   corpus,
+		Procs:    1,
+		Duration: 10 * time.Second,
+		MemLimit: 100 << 20,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Crashers) == 0 {
+		t.Fatalf("no crashers")
+	}
+	for _, data := range res.Crashers {
+		if len(data) < 6 {
+			t.Errorf("input of size %v is reported as crasher", len(data))
+		}
+	}
+	oom, err := filepath.Glob(filepath.Join(workdir, "crashers", "*.oom"))
+	if err != nil || len(oom) != len(res.Crashers) {
+		t.Fatalf("got oom files %q for %v crashers: %v", oom, len(res.Crashers), err)
+	}
+	// The largest allocations must fail in the runtime, polling does not see them.
+	for _, f := range oom {
+		output, err := ioutil.ReadFile(strings.TrimSuffix(f, ".oom") + ".output")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(output), "fatal error: out of memory\n") {
+			return
+		}
+	}
+	t.Fatalf("no crashers are reported by the runtime")
+}
+
+const paddedCrashTarget = `package target
+
+import "bytes"
+
+func Fuzz(data []byte) int {
+	if bytes.Contains(data, []byte("boom!")) {
+		panic("boom")
+	}
+	return 0
+}
+`
+
+func TestCrasherMinimization(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, paddedCrashTarget)
+	defer cleanup()
+
+	workdir := filepath.Join(dir, "workdir")
+	corpus := filepath.Join(workdir, "corpus")
+	if err := os.MkdirAll(corpus, 0770); err != nil {
+		t.Fatal(err)
+	}
+	// The crash depends only on a short substring in the middle of 8KB of padding.
+	seed := append(bytes.Repeat([]byte{'x'}, 3000), "boom!"...)
+	seed = append(seed, bytes.Repeat([]byte{'y'}, 5187)...)
+	if err := ioutil.WriteFile(filepath.Join(corpus, "seed"), seed, 0660); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Procs:    1,
+		Duration: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Crashers) != 1 || !bytes.Equal(res.Crashers[0], seed) {
+		t.Fatalf("got %v crashers, want the original seed", len(res.Crashers))
+	}
+	crasher := filepath.Join(workdir, "crashers", fmt.Sprintf("%x", hash(seed)))
+	minimized, err := ioutil.ReadFile(crasher + ".min")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(minimized) != "boom!" {
+		t.Fatalf("crasher is minimized to %q, want %q", minimized, "boom!")
+	}
+	if quoted, err := ioutil.ReadFile(crasher + ".min.quoted"); err != nil || string(quoted) != "\t\"boom!\"\n" {
+		t.Fatalf("bad quoted minimized crasher %q: %v", quoted, err)
+	}
+}
+
+const maxInputSizeTarget = `package target
+
+//go-fuzz:maxinputsize 64
+
+func Fuzz(data []byte) int {
+	if len(data) > 64 {
+		panic("input is too large")
+	}
+	if len(data) > 0 && data[0] == 'a' {
+		return 1
+	}
+	return 0
+}
+`
+
+func TestMaxInputSize(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, maxInputSizeTarget)
+	defer cleanup()
+
+	workdir := filepath.Join(dir, "workdir")
+	corpus := filepath.Join(workdir, "corpus")
+	if err := os.MkdirAll(corpus, 0770); err != nil {
+		t.Fatal(err)
+	}
+	// The oversized seed is truncated on load.
+	if err := ioutil.WriteFile(filepath.Join(corpus, "seed"), bytes.Repeat([]byte{'a'}, 1000), 0660); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Procs:    2,
+		Duration: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Execs < 1000 {
+		t.Fatalf("fuzzing stopped after %v execs", res.Execs)
+	}
+	// Every input larger than the hint crashes the target.
+	if len(res.Crashers) != 0 {
+		t.Fatalf("got %v crashers, the largest is %v bytes", len(res.Crashers), len(res.Crashers[0]))
+	}
+}
+
+const crashTarget = `package target
+
+func Fuzz(data []byte) int {
+	// Every input except the empty seed is a unique crasher.
+	if len(data) == 0 {
+		return 0
+	}
+	panic("crash on " + string(data))
+}
+`
+
+func TestMaxCrashers(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, crashTarget)
+	defer cleanup()
+
+	defer func(v int) { *flagMaxCrashers = v }(*flagMaxCrashers)
+	*flagMaxCrashers = 5
+	// Candidates of crasher minimization are other unique crashers.
+	defer func(v time.Duration) { *flagMinimizeCrasher = v }(*flagMinimizeCrasher)
+	*flagMinimizeCrasher = 100 * time.Millisecond
+	workdir := filepath.Join(dir, "workdir")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Procs:    2,
+		Duration: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Crashers) <= *flagMaxCrashers {
+		t.Fatalf("got %v crashers, want more than %v", len(res.Crashers), *flagMaxCrashers)
+	}
+	for _, sub := range []string{"crashers", "suppressions"} {
+		files, err := filepath.Glob(filepath.Join(workdir, sub, "*.output"))
+		if sub == "suppressions" {
+			files, err = filepath.Glob(filepath.Join(workdir, sub, "*"))
+		}
+		if err != nil || len(files) != *flagMaxCrashers {
+			t.Fatalf("got %v files in %v dir, want %v: %v", len(files), sub, *flagMaxCrashers, err)
+		}
+	}
+
+	// With -exitoncrash fuzzing stops after the first crasher.
+	start := time.Now()
+	res, err = Run(ctx, Config{
+		Workdir:     filepath.Join(dir, "workdir1"),
+		Bin:         bin,
+		Procs:       2,
+		Duration:    time.Minute,
+		ExitOnCrash: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Crashers) == 0 || time.Since(start) > 30*time.Second {
+		t.Fatalf("got %v crashers in %v, want to stop on the first one", len(res.Crashers), time.Since(start))
+	}
+}
+
+const terminationTarget = `
+package target
+
+import (
+	"sync"
+	"syscall"
+)
+
+func Fuzz(data []byte) int {
+	switch string(data) {
+	case "panic":
+		panic("bad input")
+	case "fatal":
+		var mu sync.Mutex
+		mu.Unlock()
+	case "exit":
+		syscall.Exit(3)
+	case "signal":
+		syscall.Kill(syscall.Getpid(), syscall.SIGKILL)
+	}
+	return 0
+}
+`
+
+func TestTerminationClass(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, terminationTarget)
+	defer cleanup()
+
+	classes := []string{crashPanic, crashFatal, crashExit, crashSignal}
+	workdir := filepath.Join(dir, "workdir")
+	corpus := filepath.Join(workdir, "corpus")
+	if err := os.MkdirAll(corpus, 0770); err != nil {
+		t.Fatal(err)
+	}
+	for _, class := range classes {
+		if err := ioutil.WriteFile(filepath.Join(corpus, class), []byte(class), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Procs:    2,
+		Duration: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Crashers) != len(classes) {
+		t.Fatalf("got %v crashers, want %v", len(res.Crashers), len(classes))
+	}
+	// Every input is attributed the crash that it causes.
+	files, err := filepath.Glob(filepath.Join(workdir, "crashers", "*.class"))
+	if err != nil || len(files) != len(classes) {
+		t.Fatalf("got %v class files, want %v: %v", len(files), len(classes), err)
+	}
+	for _, file := range files {
+		crasher := strings.TrimSuffix(file, ".class")
+		data, err := ioutil.ReadFile(crasher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		class, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.SplitN(string(class), "\n", 2)[0]; got != string(data) {
+			output, _ := ioutil.ReadFile(crasher + ".output")
+			t.Errorf("input %q: got class %q, want %q\n%s", data, got, data, output)
+		}
+	}
+}
+
+const crashCoverTarget = `package target
+
+func Fuzz(data []byte) int {
+	if len(data) < 3 {
+		return 0
+	}
+	if data[0] == 'b' && data[1] == 'a' && data[2] == 'd' {
+		panic("bad input")
+	}
+	if data[0] == 'x' {
+		return 1
+	}
+	return 0
+}
+`
+
+func TestCrashCover(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, crashCoverTarget)
+	defer cleanup()
+
+	defer func(v bool) { *flagCrashCover = v }(*flagCrashCover)
+	*flagCrashCover = true
+	workdir := filepath.Join(dir, "workdir")
+	corpus := filepath.Join(workdir, "corpus")
+	if err := os.MkdirAll(corpus, 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(corpus, "bad"), []byte("bad"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Procs:    1,
+		Duration: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Crashers) != 1 {
+		t.Fatalf("got %v crashers, want 1", len(res.Crashers))
+	}
+	files, err := filepath.Glob(filepath.Join(workdir, "crashers", "*.cover"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got cover files %q, want 1: %v", files, err)
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Lines of the target that the crasher executed.
+	executed := make(map[int]bool)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			t.Fatalf("bad cover line %q", line)
+		}
+		pos := fields[1]
+		if !strings.HasPrefix(filepath.Base(pos), "target.go:") {
+			continue
+		}
+		var startLine, startCol, endLine, endCol int
+		if _, err := fmt.Sscanf(pos[strings.LastIndexByte(pos, ':')+1:], "%d.%d,%d.%d", &startLine, &startCol, &endLine, &endCol); err != nil {
+			t.Fatalf("bad cover line %q: %v", line, err)
+		}
+		for l := startLine; l <= endLine; l++ {
+			executed[l] = true
+		}
+	}
+	// The blocks on the path to the panic are listed, the blocks after it and the early return are not.
+	for _, l := range []int{4, 7, 8} {
+		if !executed[l] {
+			t.Errorf("line %v is not listed as executed:\n%s", l, data)
+		}
+	}
+	for _, l := range []int{5, 11, 13} {
+		if executed[l] {
+			t.Errorf("line %v is listed as executed:\n%s", l, data)
+		}
+	}
+}
+
+const leakTarget = `package target
+
+func Fuzz(data []byte) int {
+	go func() {
+		select {}
+	}()
+	return 0
+}
+`
+
+func TestLeakCheck(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, leakTarget)
+	defer cleanup()
+
+	defer func(v bool) { *flagLeakCheck = v }(*flagLeakCheck)
+	*flagLeakCheck = true
+	workdir := filepath.Join(dir, "workdir")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Procs:    1,
+		Duration: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Crashers) != 0 {
+		t.Fatalf("leaks are reported as crashers: %q", res.Crashers)
+	}
+	markers, err := filepath.Glob(filepath.Join(workdir, "leaks", "*.leak"))
+	if err != nil || len(markers) != 1 {
+		t.Fatalf("got leak files %q, want 1: %v", markers, err)
+	}
+	marker, err := ioutil.ReadFile(markers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(marker) != "input leaks goroutines\n" {
+		t.Fatalf("bad leak marker: %q", marker)
+	}
+	output, err := ioutil.ReadFile(strings.TrimSuffix(markers[0], ".leak") + ".output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(output), "every execution of the input leaks goroutines") {
+		t.Fatalf("bad leak report:\n%s", output)
+	}
+}
+
+const baselineTarget = `package target
+
+func Fuzz(data []byte) int {
+	if len(data) == 0 {
+		return 0
+	}
+	if data[0] == 'A' {
+		return 1
+	}
+	if data[0] == 'B' {
+		return 2
+	}
+	return 0
+}
+`
+
+func TestBaseline(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, baselineTarget)
+	defer cleanup()
+
+	// The baseline covers everything except the 'B' branch.
+	baseline := filepath.Join(dir, "baseline")
+	if err := os.MkdirAll(baseline, 0770); err != nil {
+		t.Fatal(err)
+	}
+	for i, seed := range []string{"", "A", "x"} {
+		if err := ioutil.WriteFile(filepath.Join(baseline, fmt.Sprint(i)), []byte(seed), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	workdir := filepath.Join(dir, "workdir")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Procs:    2,
+		Duration: 10 * time.Second,
+		Baseline: baseline,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.NewCover) != 1 || len(res.NewCover[0]) == 0 || res.NewCover[0][0] != 'B' {
+		t.Fatalf("got inputs with new coverage %q, want one starting with B", res.NewCover)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(workdir, "newcover"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("got %v files in newcover dir, want 1: %v", len(files), err)
+	}
+	// Baseline inputs are not copied into corpus dir.
+	corpus, err := ioutil.ReadDir(filepath.Join(workdir, "corpus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range corpus {
+		if data, _ := ioutil.ReadFile(filepath.Join(workdir, "corpus", f.Name())); string(data) == "A" {
+			t.Fatalf("baseline input is copied into corpus dir")
+		}
+	}
+}
+
+const coverLogTarget = `package target
+
+import "syscall"
+
+// Fuzz unlocks the blocks at lines 8 and 11 once the test creates the files.
+// Package syscall is used, because it's not instrumented.
+func Fuzz(data []byte) int {
+	if len(data) >= 2 && exists(%[2]q) {
+		return 2
+	}
+	if len(data) >= 1 && exists(%[1]q) {
+		return 1
+	}
+	return 0
+}
+
+func exists(file string) bool {
+	fd, err := syscall.Open(file, syscall.O_RDONLY, 0)
+	if err != nil {
+		return false
+	}
+	syscall.Close(fd)
+	return true
+}
+`
+
+func TestCoverLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-fuzz-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	unlock := []string{filepath.Join(dir, "unlock1"), filepath.Join(dir, "unlock2")}
+	dir1, bin, cleanup := buildTestTarget(t, fmt.Sprintf(coverLogTarget, unlock[0], unlock[1]))
+	defer cleanup()
+
+	// Unlock the blocks at different times, so that they are reported in different syncs.
+	for i, file := range unlock {
+		file := file
+		timer := time.AfterFunc(time.Duration(i+1)*2*syncPeriod, func() {
+			ioutil.WriteFile(file, nil, 0660)
+		})
+		defer timer.Stop()
+	}
+	coverLog := filepath.Join(dir1, "coverlog.csv")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	_, err = Run(ctx, Config{
+		Workdir:  filepath.Join(dir1, "workdir"),
+		Bin:      bin,
+		Procs:    2,
+		Duration: 6 * syncPeriod,
+		CoverLog: coverLog,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(coverLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) < 2 || strings.Join(rows[0], ",") != "block,file,line,seconds" {
+		t.Fatalf("bad cover log header:\n%q", rows)
+	}
+	// Blocks are logged once, in order of discovery. Blocks of the function
+	// are discovered right away, the unlocked ones (the last ones logged
+	// at lines 11 and 8) are discovered later, in order of unlocking.
+	blocks := make(map[string]bool)
+	first, prev, last := -1.0, 0.0, make(map[int]float64)
+	for _, row := range rows[1:] {
+		if len(row) != 4 {
+			t.Fatalf("bad cover log row %q", row)
+		}
+		if blocks[row[0]] {
+			t.Fatalf("block %v is logged twice:\n%q", row[0], rows)
+		}
+		blocks[row[0]] = true
+		line, err1 := strconv.Atoi(row[2])
+		secs, err2 := strconv.ParseFloat(row[3], 64)
+		if err1 != nil || err2 != nil {
+			t.Fatalf("bad cover log row %q", row)
+		}
+		if secs < prev {
+			t.Fatalf("cover log is not ordered by time:\n%q", rows)
+		}
+		if first < 0 {
+			first = secs
+		}
+		prev = secs
+		if strings.HasSuffix(row[1], "target.go") {
+			last[line] = secs
+		}
+	}
+	if !(first < last[11] && last[11] < last[8]) {
+		t.Fatalf("unlocked blocks are not logged in order of unlocking:\n%q", rows)
+	}
+}
+
+const seedTarget = `package target
+
+import "syscall"
+
+var fd = -1
+
+// Fuzz appends every input to the file as 2-byte little-endian length and data.
+// Package syscall is used, because it's not instrumented.
+func Fuzz(data []byte) int {
+	if fd == -1 {
+		var err error
+		fd, err = syscall.Open(%q, syscall.O_WRONLY|syscall.O_CREAT|syscall.O_APPEND, 0660)
+		if err != nil {
+			panic(err)
+		}
+	}
+	syscall.Write(fd, append([]byte{byte(len(data)), byte(len(data) >> 8)}, data...))
+	return 0
+}
+`
+
+func TestSeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-fuzz-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	inputs := filepath.Join(dir, "inputs")
+	dir1, bin, cleanup := buildTestTarget(t, fmt.Sprintf(seedTarget, inputs))
+	defer cleanup()
+
+	run := func(i int) [][]byte {
+		os.Remove(inputs)
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		defer cancel()
+		_, err := Run(ctx, Config{
+			Workdir: filepath.Join(dir1, fmt.Sprintf("workdir%v", i)),
+			Bin:     bin,
+			Procs:   1,
+			Execs:   5000,
+			Seed:    42,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadFile(inputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res [][]byte
+		for len(data) >= 2 {
+			n := int(data[0]) | int(data[1])<<8
+			if len(data) < 2+n {
+				break
+			}
+			res = append(res, data[2:2+n])
+			data = data[2+n:]
+		}
+		return res
+	}
+	inputs1, inputs2 := run(1), run(2)
+	// Runs stop at a slightly different number of executions.
+	n := len(inputs1)
+	if n > len(inputs2) {
+		n = len(inputs2)
+	}
+	if n < 1000 {
+		t.Fatalf("too few executions: %v and %v", len(inputs1), len(inputs2))
+	}
+	distinct := make(map[string]bool)
+	for i := 0; i < n; i++ {
+		if !bytes.Equal(inputs1[i], inputs2[i]) {
+			t.Fatalf("input #%v differs: %q vs %q", i, inputs1[i], inputs2[i])
+		}
+		distinct[string(inputs1[i])] = true
+	}
+	if len(distinct) < n/10 {
+		t.Fatalf("only %v distinct inputs out of %v", len(distinct), n)
+	}
+}
+
+const outputTarget = `package target
+
+// Fuzz returns input length modulo 8 as output signature.
+// It's branchless, so all inputs have the same coverage.
+func Fuzz(data []byte) []byte {
+	return []byte{byte(len(data) & 7)}
+}
+`
+
+func TestOutputSignature(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, outputTarget)
+	defer cleanup()
+
+	workdir := filepath.Join(dir, "workdir")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	_, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Procs:    2,
+		Duration: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only inputs with novel outputs are kept, and they are minimized
+	// to the shortest inputs with the same output.
+	corpus := newPersistentSet(filepath.Join(workdir, "corpus"))
+	lens := make(map[int]bool)
+	for _, a := range corpus.m {
+		lens[len(a.data)] = true
+	}
+	if len(corpus.m) != 8 || len(lens) != 8 {
+		t.Fatalf("got %v corpus inputs with %v distinct lengths, want 8", len(corpus.m), len(lens))
+	}
+	for n := 0; n < 8; n++ {
+		if !lens[n] {
+			t.Fatalf("no corpus input of length %v: %v", n, lens)
+		}
+	}
+}
+
+const scaleTarget = `package target
+
+import "syscall"
+
+// Executions sleep instead of using CPU, so throughput grows
+// with the number of workers even on a single CPU.
+func Fuzz(data []byte) int {
+	syscall.Nanosleep(&syscall.Timespec{Nsec: 2e6}, nil)
+	if len(data) > 1 && data[0] == 'a' && data[1] == 'b' {
+		return 1
+	}
+	return 0
+}
+`
+
+func TestScaleWorkers(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, scaleTarget)
+	defer cleanup()
+
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	getMetrics := func() metrics {
+		resp, err := http.Get("http://" + addr + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var m metrics
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	waitWorkers := func(n uint64) {
+		for deadline := time.Now().Add(time.Minute); getMetrics().Workers != n; {
+			if time.Now().After(deadline) {
+				t.Fatalf("workers did not scale to %v", n)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		// Let stats of the new workers reach the coordinator.
+		time.Sleep(2 * syncPeriod)
+	}
+	execRate := func() (float64, metrics) {
+		m0 := getMetrics()
+		time.Sleep(3 * syncPeriod)
+		m1 := getMetrics()
+		return float64(m1.Execs-m0.Execs) / (m1.Uptime - m0.Uptime), m1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	done := make(chan Result)
+	go func() {
+		res, err := Run(ctx, Config{
+			Workdir: filepath.Join(dir, "workdir"),
+			Bin:     bin,
+			Procs:   2,
+			Metrics: addr,
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		done <- res
+	}()
+	for i := 0; ; i++ {
+		if _, err := http.Get("http://" + addr + "/"); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("metrics server did not start")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	waitWorkers(2)
+	rate2, m2 := execRate()
+	resp, err := http.PostForm("http://"+addr+"/procs", url.Values{"n": {"4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /procs failed: %v", resp.Status)
+	}
+	waitWorkers(4)
+	rate4, m4 := execRate()
+	t.Logf("execs/sec: %.0f with 2 workers, %.0f with 4 workers", rate2, rate4)
+	if rate4 < rate2*1.3 {
+		t.Fatalf("throughput did not increase: %.0f execs/sec with 2 workers, %.0f with 4 workers", rate2, rate4)
+	}
+	if m4.Cover < m2.Cover || m4.Corpus < m2.Corpus {
+		t.Fatalf("coverage is lost after scaling: cover %v -> %v, corpus %v -> %v", m2.Cover, m4.Cover, m2.Corpus, m4.Corpus)
+	}
+	cancel()
+	if res := <-done; res.Cover < int(m2.Cover) {
+		t.Fatalf("coverage is lost after scaling: cover %v -> %v", m2.Cover, res.Cover)
+	}
+}
+
+// TestHelperProcess is not a real test: it runs go-fuzz in a subprocess started
+// with GOFUZZ_TEST_HELPER_PROCESS=1 by tests that need separate coordinator
+// and worker processes. go-fuzz arguments follow "--".
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GOFUZZ_TEST_HELPER_PROCESS") != "1" {
+		return
+	}
+	args := os.Args
+	for len(args) != 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	os.Args = append([]string{"go-fuzz"}, args[1:]...)
+	Main()
+}
+
+// restartTarget returns a target with a long chain of checks of the input bytes,
+// every check adds a coverage block, and executions are slow, so coverage grows
+// for longer than the test runs.
+func restartTarget() string {
+	src := "package target\n\nimport \"syscall\"\n\nfunc Fuzz(data []byte) int {\n"
+	src += "\tsyscall.Nanosleep(&syscall.Timespec{Nsec: 1e6}, nil)\n"
+	for i := 0; i < 200; i++ {
+		src += fmt.Sprintf("\tif len(data) <= %v || data[%v] != %v {\n\t\treturn %v\n\t}\n", i, i, 'a'+i%26, i)
+	}
+	return src + "\treturn 1000\n}\n"
+}
+
+func TestWorkerRestart(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, restartTarget())
+	defer cleanup()
+
+	freeAddr := func() string {
+		ln, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		return ln.Addr().String()
+	}
+	addr, metricsAddr := freeAddr(), freeAddr()
+	var outputs []*bytes.Buffer
+	start := func(args ...string) *exec.Cmd {
+		cmd := exec.Command(os.Args[0], append([]string{"-test.run=^TestHelperProcess$", "--"}, args...)...)
+		cmd.Env = append(os.Environ(), "GOFUZZ_TEST_HELPER_PROCESS=1")
+		output := new(bytes.Buffer)
+		outputs = append(outputs, output)
+		cmd.Stdout = output
+		cmd.Stderr = output
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+		return cmd
+	}
+	stop := func(cmd *exec.Cmd) {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	coordinator := start("-coordinator="+addr, "-metrics="+metricsAddr, "-workdir="+filepath.Join(dir, "workdir"))
+	startWorker := func() *exec.Cmd {
+		return start("-worker="+addr, "-bin="+bin, "-procs=1", "-workdir="+filepath.Join(dir, "worker"))
+	}
+	worker := startWorker()
+	defer func() {
+		stop(worker)
+		stop(coordinator)
+		if t.Failed() {
+			for _, output := range outputs {
+				t.Logf("go-fuzz output:\n%s", output.Bytes())
+			}
+		}
+	}()
+	getMetrics := func() (m metrics) {
+		resp, err := http.Get("http://" + metricsAddr + "/")
+		if err != nil {
+			return metrics{}
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&m)
+		return m
+	}
+	waitCover := func(cover uint64) metrics {
+		for deadline := time.Now().Add(time.Minute); ; {
+			if m := getMetrics(); m.Cover > cover {
+				return m
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("coverage did not grow over %v", cover)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+
+	first := waitCover(10)
+	// Kill the worker mid-run, the coordinator keeps its corpus and coverage.
+	stop(worker)
+	time.Sleep(2 * syncPeriod)
+	killed := getMetrics()
+	if killed.Cover < first.Cover {
+		t.Fatalf("coverage dropped from %v to %v after the worker was killed", first.Cover, killed.Cover)
+	}
+	// A restarted worker registers again and fuzzing continues from the coordinator's corpus.
+	worker = startWorker()
+	restarted := waitCover(killed.Cover)
+	t.Logf("cover %v before kill, %v after restart", killed.Cover, restarted.Cover)
+}
+
+const interestingTarget = `package target
+
+import "bytes"
+
+// Fuzz returns 1 for inputs containing 0xaa.
+// The check is branchless, so all inputs have the same coverage.
+func Fuzz(data []byte) int {
+	return bytes.IndexByte(data, 0xaa)>>63 + 1
+}
+`
+
+func TestInterestingInputs(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, interestingTarget)
+	defer cleanup()
+
+	workdir := filepath.Join(dir, "workdir")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Procs:    2,
+		Duration: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Execs < 1000 {
+		t.Fatalf("fuzzing stopped after %v execs", res.Execs)
+	}
+	files, err := ioutil.ReadDir(filepath.Join(workdir, "corpus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var marked, other [][]byte
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".meta") {
+			continue // provenance
+		}
+		data, err := ioutil.ReadFile(filepath.Join(workdir, "corpus", f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.IndexByte(data, 0xaa) != -1 {
+			marked = append(marked, data)
+		} else {
+			other = append(other, data)
+		}
+	}
+	// Inputs that don't give new coverage are dropped, unless Fuzz returned 1 for them.
+	if len(marked) != 1 || len(other) != 1 {
+		t.Fatalf("got inputs %q with marker and %q without marker, want one of each", marked, other)
+	}
+}
+
+const multiFuncTarget = `package target
+
+func FuzzHeader(data []byte) int {
+	if len(data) >= 4 && string(data[:4]) == "HDR:" {
+		return 1
+	}
+	return 0
+}
+
+func FuzzBody(data []byte) int {
+	if len(data) != 0 && data[len(data)-1] == ';' {
+		return 1
+	}
+	return 0
+}
+`
+
+// TestMultipleFuncs checks that functions of a multi-function binary
+// fuzzed with the same workdir get independent corpus and coverage.
+func TestMultipleFuncs(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, multiFuncTarget)
+	defer cleanup()
+
+	workdir := filepath.Join(dir, "workdir")
+	fns := []string{"FuzzHeader", "FuzzBody"}
+	corpus := make(map[string]map[string]bool)
+	cover := make(map[string]map[int]bool)
+	for _, fn := range fns {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		res, err := Run(ctx, Config{
+			Workdir:  workdir,
+			Bin:      bin,
+			Func:     fn,
+			Procs:    1,
+			Duration: 5 * time.Second,
+		})
+		cancel()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Cover == 0 {
+			t.Fatalf("%v: no coverage", fn)
+		}
+		files, err := ioutil.ReadDir(filepath.Join(workdir, fn, "corpus"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		corpus[fn] = make(map[string]bool)
+		for _, f := range files {
+			if strings.HasSuffix(f.Name(), ".meta") {
+				continue // provenance
+			}
+			data, err := ioutil.ReadFile(filepath.Join(workdir, fn, "corpus", f.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(data) != 0 {
+				// The empty input seeds corpus of every function.
+				corpus[fn][string(data)] = true
+			}
+		}
+		data, err := ioutil.ReadFile(filepath.Join(workdir, fn, "checkpoint"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cp checkpoint
+		if err := json.Unmarshal(data, &cp); err != nil {
+			t.Fatal(err)
+		}
+		cover[fn] = make(map[int]bool)
+		for i, v := range cp.Cover {
+			if v != 0 {
+				cover[fn][i] = true
+			}
+		}
+		if len(corpus[fn]) == 0 || len(cover[fn]) == 0 {
+			t.Fatalf("%v: got %v corpus inputs and %v covered blocks", fn, len(corpus[fn]), len(cover[fn]))
+		}
+	}
+	for data := range corpus[fns[0]] {
+		if corpus[fns[1]][data] {
+			t.Errorf("input %q is in corpus of both functions", data)
+		}
+	}
+	for i := range cover[fns[0]] {
+		if cover[fns[1]][i] {
+			t.Errorf("block %v is covered by both functions", i)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(workdir, "corpus")); err == nil {
+		t.Errorf("multi-function binary uses shared corpus dir")
+	}
+}
+
+func TestStructuredInput(t *testing.T) {
+	src, err := ioutil.ReadFile(filepath.Join("..", "..", "examples", "structured", "structured.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, bin, cleanup := buildTestTarget(t, string(src))
+	defer cleanup()
+
+	workdir := filepath.Join(dir, "workdir")
+	corpus := filepath.Join(workdir, "corpus")
+	if err := os.MkdirAll(corpus, 0770); err != nil {
+		t.Fatal(err)
+	}
+	// Record{OpSum, "answer", [42]} with overlong name length and trailing garbage.
+	seed := []byte("\x00\x86\x00answer\x01\x54garbage")
+	want := structured.Encode(&structured.Record{Op: structured.OpSum, Name: "answer", Values: []int64{42}})
+	if err := ioutil.WriteFile(filepath.Join(corpus, "seed"), seed, 0660); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  workdir,
+		Bin:      bin,
+		Procs:    2,
+		Duration: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Crashers) != 0 {
+		t.Fatalf("got crashers %q", res.Crashers)
+	}
+	files, err := ioutil.ReadDir(corpus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, f := range files {
+		if f.Name() == "seed" || strings.HasSuffix(f.Name(), ".meta") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(corpus, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, ok := structured.Decode(data)
+		if !ok {
+			t.Errorf("corpus input %q does not decode", data)
+			continue
+		}
+		if enc := structured.Encode(v); !bytes.Equal(enc, data) {
+			t.Errorf("corpus input %q is not canonical, want %q", data, enc)
+		}
+		found = found || bytes.Equal(data, want)
+	}
+	if !found {
+		t.Errorf("canonical seed %q is not in corpus", want)
+	}
+}
+
+// buildTestTarget builds a test binary for src with go-fuzz-build.
+// It returns the temp dir with the binary and a function that removes the dir.
+func buildTestTarget(t *testing.T, src string) (string, string, func()) {
+	if testing.Short() {
+		t.Skip("skipping in short mode")
+	}
+	if _, err := exec.LookPath("go-fuzz-build"); err != nil {
+		t.Skip("go-fuzz-build is not in PATH")
+	}
+	dir, err := ioutil.TempDir("", "go-fuzz-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+
+	gopath := filepath.Join(dir, "gopath")
+	pkg := filepath.Join(gopath, "src", "target")
+	if err := os.MkdirAll(pkg, 0770); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pkg, "target.go"), []byte(src), 0660); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "target-fuzz.zip")
+	cmd := exec.Command("go-fuzz-build", "-o", bin, "target")
+	cmd.Dir = pkg
+	cmd.Env = append(os.Environ(), "GO111MODULE=off",
+		"GOPATH="+gopath+string(filepath.ListSeparator)+build.Default.GOPATH)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		cleanup()
+		t.Fatalf("go-fuzz-build failed: %v\n%s", err, out)
+	}
+	return dir, bin, cleanup
+}
+
+const dynamicDictTarget = `package target
+
+var magic []byte
+
+func init() {
+	// FNV-1a hash of a string, not known to go-fuzz-build.
+	h := uint64(14695981039346656037)
+	for _, c := range []byte("go-fuzz") {
+		h = (h ^ uint64(c)) * 1099511628211
+	}
+	for i := 0; i < 8; i++ {
+		magic = append(magic, byte(h>>uint(i*8)))
+	}
+}
+
+func Fuzz(data []byte) int {
+	if len(data) >= 8 && string(data[:8]) == string(magic) {
+		return 1
+	}
+	return 0
+}
+`
+
+func TestDynamicDictionary(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, dynamicDictTarget)
+	defer cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	res, err := Run(ctx, Config{
+		Workdir:  filepath.Join(dir, "workdir"),
+		Bin:      bin,
+		Procs:    2,
+		Duration: 10 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := uint64(14695981039346656037)
+	for _, c := range []byte("go-fuzz") {
+		h = (h ^ uint64(c)) * 1099511628211
+	}
+	magic := make([]byte, 8)
+	binary.LittleEndian.PutUint64(magic, h)
+	for _, tok := range res.Tokens {
+		if bytes.Equal(tok, magic) {
+			return
+		}
+	}
+	t.Fatalf("magic %x is not in dynamic dictionary %x", magic, res.Tokens)
+}
+
+const sonarTraceTarget = `package target
+
+import "strings"
+
+func Fuzz(data []byte) int {
+	if string(data) == "sonartrace" {
+		return 1
+	}
+	if strings.EqualFold(string(data), "sonarfold") {
+		return 1
+	}
+	if len(data) >= 4 && uint32(data[0])|uint32(data[1])<<8|uint32(data[2])<<16|uint32(data[3])<<24 == 0xdeadbeef {
+		return 1
+	}
+	return 0
+}
+`
+
+func TestSonarTrace(t *testing.T) {
+	dir, bin, cleanup := buildTestTarget(t, sonarTraceTarget)
+	defer cleanup()
+
+	// The input is equal to the EqualFold operand only under case folding.
+	corpus := filepath.Join(dir, "corpus")
+	if err := os.MkdirAll(corpus, 0770); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(corpus, "fold"), []byte("SonarFold"), 0660); err != nil {
+		t.Fatal(err)
+	}
+	trace := filepath.Join(dir, "sonartrace")
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	_, err := Run(ctx, Config{
+		Workdir:        filepath.Join(dir, "workdir"),
+		Bin:            bin,
+		Corpus:         corpus,
+		Procs:          2,
+		Duration:       10 * time.Second,
+		SonarTrace:     trace,
+		SonarTraceRate: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(trace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	recs, err := readSonarTrace(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var str, fold, num bool
+	for _, r := range recs {
+		if r.Flags&SonarString != 0 {
+			if string(r.Val[1]) == "sonartrace" && r.Flags&SonarOpMask == SonarEQL && r.Flags&SonarConst2 != 0 {
+				str = true
+				if r.Result != (string(r.Val[0]) == "sonartrace") {
+					t.Fatalf("bad result of %q == %q: %v", r.Val[0], r.Val[1], r.Result)
+				}
+			}
+			// Operands equal under case folding are reported as equal.
+			if string(r.Val[1]) == "sonarfold" && r.Result {
+				fold = true
+				if string(r.Val[0]) != "sonarfold" {
+					t.Fatalf("bad result of EqualFold(%q, %q): %v", r.Val[0], r.Val[1], r.Result)
+				}
+			}
+		} else if bytes.HasPrefix(r.Val[1], []byte{0xef, 0xbe, 0xad, 0xde}) && r.Flags&SonarConst2 != 0 && len(r.Val[0]) >= 4 {
+			num = true
+			if r.Result != bytes.Equal(r.Val[0][:4], r.Val[1][:4]) {
+				t.Fatalf("bad result of %x == %x: %v", r.Val[0], r.Val[1], r.Result)
+			}
+		}
+	}
+	if !str || !fold || !num {
+		t.Fatalf("trace of %v records misses comparisons with the string (%v), the folded string (%v) or the number (%v)",
+			len(recs), str, fold, num)
+	}
+}
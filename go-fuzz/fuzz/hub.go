@@ -0,0 +1,881 @@
+// Copyright 2015 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"fmt"
+	"log"
+	"net/rpc"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dvyukov/go-fuzz/go-fuzz/versifier"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+const (
+	syncPeriod             = 3 * time.Second
+	rescorePeriod          = 10 * syncPeriod
+	syncDeadline           = 100 * syncPeriod
+	connectionPollInterval = 100 * time.Millisecond
+	maxReconnectBackoff    = 30 * time.Second
+
+	minScore = 1.0
+	maxScore = 1000.0
+	defScore = 10.0
+)
+
+// Hub contains data shared between all workers in the process (e.g. corpus).
+// This reduces memory consumption for highly parallel workers.
+// Hub also handles communication with the coordinator.
+type Hub struct {
+	id          int
+	epoch       uint64      // epoch of the coordinator that assigned id
+	coordinator *rpc.Client // nil while the hub reconnects to the coordinator
+	fn          string      // fuzz function name
+	funcs       int         // number of fuzz functions in the test binary
+	coverTotal  int         // number of distinct coverage counters in the test binary
+	maxInput    int         // max input size, see inputSizeLimit
+
+	sonarTraceRate int // trace every N-th execution with sonar for -sonartrace, 0 if disabled
+	shard, shards  int // -shard of the coordinator, shards is 0 without -shard
+
+	ro atomic.Value // *ROData
+
+	maxCoverMu  sync.Mutex
+	maxCover    atomic.Value // []byte
+	maxResCover atomic.Value // []byte, max cover of inputs for which Fuzz returned 1
+
+	output    bool // fn is a differential fuzz function, see outputSig
+	outputsMu sync.Mutex
+	outputs   map[uint64]struct{} // output signatures of all executions
+
+	initialTriage uint32
+	initialInputs int  // number of inputs received on connect
+	initialDone   bool // initial triage is finished and logged
+
+	corpusCoverSize int
+	coverChanged    bool // corpus coverage changed since the last sync
+	corpusSigs      map[Sig]struct{}
+	corpusStale     bool
+	lastRescore     time.Time
+	triageQueue     []CoordinatorInput
+
+	// Per coverage counter number of statements and number of fuzzing executions
+	// that hit it (sampled by workers), used to boost inputs hitting rare code.
+	// blockHitsMu protects writes to blockHits from concurrent reads by -covreport.
+	blockStmts  []int
+	blockHitsMu sync.Mutex
+	blockHits   []uint64
+
+	triageC     chan CoordinatorInput
+	newInputC   chan Input
+	newCrasherC chan NewCrasherArgs
+	syncC       chan Stats
+	stopC       chan struct{}
+	reconnectC  chan connectResult // result of the background reconnect, nil if not reconnecting
+
+	reconnectTimeout time.Duration // -reconnecttimeout
+
+	// Inputs and crashers that can't be sent to the coordinator while the hub reconnects.
+	// New corpus inputs are not here, after reconnect the hub sends all inputs that
+	// the coordinator does not have.
+	pendingInputs   []NewInputArgs
+	pendingCrashers []NewCrasherArgs
+
+	workers sync.WaitGroup // worker goroutines using the hub
+	arch    *binArchive    // test binary archive, used to start workers
+
+	procsMu sync.Mutex
+	procs   int       // number of workers, changed at runtime by scale
+	running []*Worker // running workers, the index is the worker index
+
+	stats         Stats
+	corpusOrigins [execCount]uint64
+}
+
+type ROData struct {
+	corpus         []Input
+	corpusCover    []byte
+	corpusResCover []byte              // max cover of corpus inputs for which Fuzz returned 1
+	corpusOutputs  map[uint64]struct{} // output signatures of corpus inputs of a differential fuzz function
+	badInputs      map[Sig]struct{}
+	suppressions   map[Sig]struct{}
+	strLits        [][]byte // string literals in testee
+	intLits        [][]byte // int literals in testee
+	dynLits        [][]byte // tokens from the coordinator's dynamic dictionary
+	maxInput       int      // max input size, 0 means MaxInputSize
+	bigEndian      bool     // the test binary is built for a big-endian architecture
+	coverBlocks    map[int][]CoverBlock
+	sonarSites     []SonarSite
+	verse          *versifier.Verse
+}
+
+type Stats struct {
+	execs     uint64
+	restarts  uint64
+	blockHits []uint32 // per coverage counter hits in sampled fuzzing executions
+
+	tokens     [][]byte // new dynamic dictionary tokens
+	usedTokens [][]byte // dynamic dictionary tokens that gave new coverage
+	sonarTrace []SonarRecord
+}
+
+func newHub(metadata MetaData, fn string) (*Hub, error) {
+	procs := *flagProcs
+	hub := &Hub{
+		fn:          fn,
+		funcs:       len(metadata.Funcs),
+		corpusSigs:  make(map[Sig]struct{}),
+		triageC:     make(chan CoordinatorInput, procs),
+		newInputC:   make(chan Input, procs),
+		newCrasherC: make(chan NewCrasherArgs, procs),
+		syncC:       make(chan Stats, procs),
+		stopC:       make(chan struct{}),
+		outputs:     make(map[uint64]struct{}),
+		procs:       procs,
+
+		reconnectTimeout: *flagReconnectTimeout,
+	}
+	for _, fn1 := range metadata.OutputFuncs {
+		hub.output = hub.output || fn1 == fn
+	}
+
+	coverBlocks := make(map[int][]CoverBlock)
+	for _, b := range metadata.Blocks {
+		coverBlocks[b.ID] = append(coverBlocks[b.ID], b)
+	}
+	hub.coverTotal = len(coverBlocks)
+	hub.maxInput = inputSizeLimit(metadata.MaxInputSize)
+	hub.blockStmts = make([]int, CoverSize)
+	hub.blockHits = make([]uint64, CoverSize)
+	for _, b := range metadata.Blocks {
+		hub.blockStmts[b.ID] += b.NumStmt
+	}
+
+	hub.maxCover.Store(make([]byte, CoverSize))
+	hub.maxResCover.Store(make([]byte, CoverSize))
+
+	sonarSites := make([]SonarSite, len(metadata.Sonar))
+	for i, b := range metadata.Sonar {
+		if i != b.ID {
+			return nil, fmt.Errorf("corrupted sonar metadata")
+		}
+		sonarSites[i].id = b.ID
+		sonarSites[i].loc = fmt.Sprintf("%v:%v.%v,%v.%v", b.File, b.StartLine, b.StartCol, b.EndLine, b.EndCol)
+		sonarSites[i].float = b.NumStmt&SonarFloat != 0
+		sonarSites[i].width = b.NumStmt >> SonarWidthShift
+	}
+	ro := &ROData{
+		corpusCover:    make([]byte, CoverSize),
+		corpusResCover: make([]byte, CoverSize),
+		corpusOutputs:  make(map[uint64]struct{}),
+		badInputs:      make(map[Sig]struct{}),
+		suppressions:   make(map[Sig]struct{}),
+		coverBlocks:    coverBlocks,
+		sonarSites:     sonarSites,
+		maxInput:       hub.maxInput,
+		bigEndian:      metadata.BigEndian,
+	}
+	// Prepare list of string and integer literals.
+	for _, lit := range metadata.Literals {
+		if lit.IsStr {
+			ro.strLits = append(ro.strLits, []byte(lit.Val))
+		} else {
+			ro.intLits = append(ro.intLits, []byte(lit.Val))
+		}
+	}
+	hub.ro.Store(ro)
+
+	if err := hub.connect(*flagConnectionTimeout); err != nil {
+		return nil, fmt.Errorf("failed to connect to coordinator: %v", err)
+	}
+	go hub.loop()
+
+	return hub, nil
+}
+
+// connectResult is the result of a background reconnect to the coordinator.
+type connectResult struct {
+	c   *rpc.Client
+	res *ConnectRes
+	err error
+}
+
+// connect registers the hub with the coordinator,
+// dialing the coordinator is retried for up to timeout.
+func (hub *Hub) connect(timeout time.Duration) error {
+	c, res, err := dialCoordinator(hub.connectArgs(), timeout)
+	if err != nil {
+		return err
+	}
+	return hub.connected(c, res)
+}
+
+func (hub *Hub) connectArgs() *ConnectArgs {
+	return &ConnectArgs{Procs: hub.numProcs(), Func: hub.fn, Funcs: hub.funcs, CoverTotal: hub.coverTotal, MaxInputSize: hub.maxInput, PrevID: hub.id, PrevEpoch: hub.epoch, Token: *flagAuthToken}
+}
+
+// dialCoordinator connects to the coordinator and registers a worker with args,
+// dialing the coordinator is retried for up to timeout.
+func dialCoordinator(args *ConnectArgs, timeout time.Duration) (*rpc.Client, *ConnectRes, error) {
+	var c *rpc.Client
+	var err error
+
+	t := time.Now()
+	for {
+		c, err = rpc.Dial("tcp", *flagWorker)
+		if err == nil || time.Since(t) > timeout {
+			break
+		}
+		time.Sleep(connectionPollInterval)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	res := new(ConnectRes)
+	if err := c.Call("Coordinator.Connect", args, res); err != nil {
+		c.Close()
+		return nil, nil, err
+	}
+	return c, res, nil
+}
+
+// connected starts using connection c to the coordinator that registered the hub with res.
+func (hub *Hub) connected(c *rpc.Client, res *ConnectRes) error {
+	reconnect := hub.id != 0
+	hub.coordinator = c
+	hub.id = res.ID
+	hub.epoch = res.Epoch
+	if res.Cover != nil {
+		// Coverage restored by -resume, inputs that don't extend it are not new.
+		hub.updateMaxCover(res.Cover)
+	}
+	if !reconnect {
+		hub.sonarTraceRate = res.SonarTraceRate
+		hub.shard, hub.shards = res.Shard, res.Shards
+		hub.initialTriage = uint32(len(res.Corpus))
+		hub.initialInputs = len(res.Corpus)
+		hub.triageQueue = res.Corpus
+		return nil
+	}
+	// We already have most of the inputs, triage only the new ones.
+	// The coordinator could also have been restarted and lost some inputs,
+	// so send it inputs it does not have.
+	known := make(map[Sig]struct{})
+	for _, inp := range res.Corpus {
+		sig := hash(inp.Data)
+		known[sig] = struct{}{}
+		if _, ok := hub.corpusSigs[sig]; !ok {
+			hub.triageQueue = append(hub.triageQueue, inp)
+		}
+	}
+	for _, inp := range hub.ro.Load().(*ROData).corpus {
+		if _, ok := known[hash(inp.data)]; ok {
+			continue
+		}
+		if err := c.Call("Coordinator.NewInput", NewInputArgs{hub.id, inp.data, uint64(inp.depth), inp.prov, *flagAuthToken}, nil); err != nil {
+			return err
+		}
+	}
+	for len(hub.pendingInputs) > 0 {
+		args := hub.pendingInputs[0]
+		args.ID = hub.id
+		if err := c.Call("Coordinator.NewInput", args, nil); err != nil {
+			return err
+		}
+		hub.pendingInputs = hub.pendingInputs[1:]
+	}
+	for len(hub.pendingCrashers) > 0 {
+		if err := c.Call("Coordinator.NewCrasher", hub.pendingCrashers[0], nil); err != nil {
+			return err
+		}
+		hub.pendingCrashers = hub.pendingCrashers[1:]
+	}
+	hub.pendingInputs, hub.pendingCrashers = nil, nil
+	return nil
+}
+
+// reconnect closes the broken connection to the coordinator and starts
+// reconnecting in background, meanwhile the hub keeps serving workers.
+// Connection attempts are retried with exponential backoff for up to
+// -reconnecttimeout, so that workers resume fuzzing instead of dying.
+func (hub *Hub) reconnect() {
+	if hub.coordinator == nil {
+		return // already reconnecting
+	}
+	hub.coordinator.Close()
+	hub.coordinator = nil
+	reconnectC := make(chan connectResult, 1)
+	hub.reconnectC = reconnectC
+	args := hub.connectArgs()
+	timeout := hub.reconnectTimeout
+	go func() {
+		t := time.Now()
+		backoff := connectionPollInterval
+		for {
+			c, res, err := dialCoordinator(args, 0)
+			if err == nil {
+				reconnectC <- connectResult{c: c, res: res}
+				return
+			}
+			if timeout != 0 && time.Since(t) > timeout {
+				reconnectC <- connectResult{err: err}
+				return
+			}
+			log.Printf("failed to connect to coordinator: %v, retrying in %v", err, backoff)
+			select {
+			case <-time.After(backoff):
+			case <-hub.stopC:
+				return
+			}
+			if backoff *= 2; backoff > maxReconnectBackoff {
+				backoff = maxReconnectBackoff
+			}
+		}
+	}()
+}
+
+// reconnected handles the result of the background reconnect.
+func (hub *Hub) reconnected(r connectResult) {
+	hub.reconnectC = nil
+	if r.err != nil {
+		log.Printf("failed to reconnect to coordinator for %v: %v, giving up", hub.reconnectTimeout, r.err)
+		requestStop(1)
+		return
+	}
+	if err := hub.connected(r.c, r.res); err != nil {
+		log.Printf("failed to send inputs to coordinator: %v, reconnecting to coordinator", err)
+		hub.reconnect()
+		return
+	}
+	log.Printf("reconnected to coordinator")
+}
+
+// sync sends stats to the coordinator and receives new inputs from it.
+func (hub *Hub) sync() {
+	args := &SyncArgs{
+		ID:            hub.id,
+		Execs:         hub.stats.execs,
+		Restarts:      hub.stats.restarts,
+		CoverFullness: hub.corpusCoverSize,
+		Tokens:        hub.stats.tokens,
+		UsedTokens:    hub.stats.usedTokens,
+		SonarTrace:    hub.stats.sonarTrace,
+		Procs:         hub.numProcs(),
+		Token:         *flagAuthToken,
+	}
+	if hub.coordinator == nil {
+		// Reconnecting, stats are kept and sent after reconnect.
+		return
+	}
+	if hub.coverChanged {
+		args.Cover = hub.ro.Load().(*ROData).corpusCover
+	}
+	var res SyncRes
+	if err := hub.coordinator.Call("Coordinator.Sync", args, &res); err != nil {
+		// Stats are kept and sent with the next sync.
+		log.Printf("sync call failed: %v, reconnecting to coordinator", err)
+		hub.reconnect()
+		return
+	}
+	hub.stats.execs = 0
+	hub.stats.restarts = 0
+	hub.coverChanged = false
+	hub.stats.tokens = nil
+	hub.stats.usedTokens = nil
+	hub.stats.sonarTrace = nil
+	if len(res.Inputs) > 0 {
+		hub.triageQueue = append(hub.triageQueue, res.Inputs...)
+	}
+	if res.Tokens != nil {
+		ro := hub.ro.Load().(*ROData)
+		ro1 := new(ROData)
+		*ro1 = *ro
+		ro1.dynLits = res.Tokens
+		hub.ro.Store(ro1)
+	}
+	if res.Cover != nil {
+		// Coverage of other shards, inputs that don't extend it are not new.
+		hub.updateMaxCover(res.Cover)
+	}
+	if res.Procs != 0 && res.Procs != args.Procs {
+		log.Printf("hub: scaling from %v to %v workers", args.Procs, res.Procs)
+		hub.scale(res.Procs)
+	}
+}
+
+func (hub *Hub) loop() {
+	// Local buffer helps to avoid deadlocks on chan overflows.
+	var triageC chan CoordinatorInput
+	var triageInput CoordinatorInput
+
+	ticker := time.NewTicker(syncPeriod)
+	defer ticker.Stop()
+	syncTicker := ticker.C
+	for {
+		if len(hub.triageQueue) > 0 && triageC == nil {
+			n := len(hub.triageQueue) - 1
+			triageInput = hub.triageQueue[n]
+			hub.triageQueue[n] = CoordinatorInput{}
+			hub.triageQueue = hub.triageQueue[:n]
+			triageC = hub.triageC
+		}
+
+		select {
+		case <-syncTicker:
+			// Sync with the coordinator.
+			if *flagV >= 1 {
+				ro := hub.ro.Load().(*ROData)
+				log.Printf("hub: corpus=%v bootstrap=%v fuzz=%v minimize=%v versifier=%v smash=%v sonar=%v",
+					len(ro.corpus), hub.corpusOrigins[execBootstrap]+hub.corpusOrigins[execCorpus],
+					hub.corpusOrigins[execFuzz]+hub.corpusOrigins[execSonar],
+					hub.corpusOrigins[execMinimizeInput]+hub.corpusOrigins[execMinimizeCrasher],
+					hub.corpusOrigins[execVersifier], hub.corpusOrigins[execSmash],
+					hub.corpusOrigins[execSonarHint])
+			}
+			hub.sync()
+			if !hub.initialDone && atomic.LoadUint32(&hub.initialTriage) == 0 && len(hub.newInputC) == 0 {
+				// Workers decrement initialTriage after sending the input to newInputC,
+				// so all initial inputs are processed.
+				hub.initialDone = true
+				hub.logInitialTriage()
+			}
+			// Block hit frequencies change all the time, so rescore periodically even if corpus did not change.
+			ro := hub.ro.Load().(*ROData)
+			if hub.corpusStale || len(ro.corpus) != 0 && time.Since(hub.lastRescore) > rescorePeriod {
+				hub.updateScores()
+				hub.corpusStale = false
+			}
+
+		case triageC <- triageInput:
+			// Send new input to workers for triage.
+			if len(hub.triageQueue) > 0 {
+				n := len(hub.triageQueue) - 1
+				triageInput = hub.triageQueue[n]
+				hub.triageQueue[n] = CoordinatorInput{}
+				hub.triageQueue = hub.triageQueue[:n]
+			} else {
+				triageC = nil
+				triageInput = CoordinatorInput{}
+			}
+
+		case s := <-hub.syncC:
+			// Sync from a worker.
+			hub.stats.execs += s.execs
+			hub.stats.restarts += s.restarts
+			hub.blockHitsMu.Lock()
+			for i, v := range s.blockHits {
+				hub.blockHits[i] += uint64(v)
+			}
+			hub.blockHitsMu.Unlock()
+			hub.stats.tokens = append(hub.stats.tokens, s.tokens...)
+			hub.stats.usedTokens = append(hub.stats.usedTokens, s.usedTokens...)
+			if n := maxHubSonarTrace - len(hub.stats.sonarTrace); n > 0 {
+				if n > len(s.sonarTrace) {
+					n = len(s.sonarTrace)
+				}
+				hub.stats.sonarTrace = append(hub.stats.sonarTrace, s.sonarTrace[:n]...)
+			}
+
+		case input := <-hub.newInputC:
+			// New interesting input from workers.
+			ro := hub.ro.Load().(*ROData)
+			if !compareCover(ro.corpusCover, input.cover) && (input.res <= 0 || !compareCover(ro.corpusResCover, input.cover)) && !ro.newOutput(&input) {
+				break
+			}
+			sig := hash(input.data)
+			if _, ok := hub.corpusSigs[sig]; ok {
+				break
+			}
+
+			// Passed deduplication, taking it.
+			if *flagV >= 2 {
+				log.Printf("hub received new input [%v]%v mine=%v", len(input.data), hash(input.data), input.mine)
+			}
+			hub.corpusSigs[sig] = struct{}{}
+			input.sig = sig
+			if !inShard(sig, hub.shard, hub.shards) {
+				// Input of another shard: the coordinator routes it to the owner,
+				// here only its coverage is kept, so that it is not rediscovered.
+				hub.updateMaxCover(input.cover)
+				ro1 := new(ROData)
+				*ro1 = *ro
+				ro1.corpusCover = makeCopy(ro.corpusCover)
+				hub.corpusCoverSize = updateMaxCover(ro1.corpusCover, input.cover)
+				hub.coverChanged = true
+				hub.ro.Store(ro1)
+				if input.mine {
+					args := NewInputArgs{hub.id, input.data, uint64(input.depth), input.prov, *flagAuthToken}
+					if hub.coordinator == nil {
+						hub.pendingInputs = append(hub.pendingInputs, args)
+					} else if err := hub.coordinator.Call("Coordinator.NewInput", args, nil); err != nil {
+						log.Printf("new input call failed: %v, reconnecting to coordinator", err)
+						hub.pendingInputs = append(hub.pendingInputs, args)
+						hub.reconnect()
+					}
+				}
+				break
+			}
+			ro1 := new(ROData)
+			*ro1 = *ro
+			// Assign it the default score, but mark corpus for score recalculation.
+			hub.corpusStale = true
+			scoreSum := 0
+			if len(ro1.corpus) > 0 {
+				scoreSum = ro1.corpus[len(ro1.corpus)-1].runningScoreSum
+			}
+			input.score = defScore
+			input.runningScoreSum = scoreSum + defScore
+			ro1.corpus = append(ro1.corpus, input)
+			hub.updateMaxCover(input.cover)
+			ro1.corpusCover = makeCopy(ro.corpusCover)
+			hub.corpusCoverSize = updateMaxCover(ro1.corpusCover, input.cover)
+			hub.coverChanged = true
+			if input.res > 0 {
+				hub.updateMaxResCover(input.cover)
+				ro1.corpusResCover = makeCopy(ro.corpusResCover)
+				updateMaxCover(ro1.corpusResCover, input.cover)
+			}
+			if input.outputOK {
+				hub.updateOutputs(input.output)
+				ro1.corpusOutputs = make(map[uint64]struct{})
+				for k, v := range ro.corpusOutputs {
+					ro1.corpusOutputs[k] = v
+				}
+				ro1.corpusOutputs[input.output] = struct{}{}
+			}
+			if input.res > 0 || input.typ == execBootstrap {
+				ro1.verse = versifier.BuildVerse(ro.verse, input.data)
+			}
+			hub.ro.Store(ro1)
+			hub.corpusOrigins[input.typ]++
+
+			if input.mine && hub.coordinator != nil {
+				// On reconnect the input is resent along with all other inputs
+				// that the coordinator does not have.
+				if err := hub.coordinator.Call("Coordinator.NewInput", NewInputArgs{hub.id, input.data, uint64(input.depth), input.prov, *flagAuthToken}, nil); err != nil {
+					log.Printf("new input call failed: %v, reconnecting to coordinator", err)
+					hub.reconnect()
+				}
+			}
+
+			if *flagDumpCover {
+				dumpCover(filepath.Join(funcWorkdir(hub.fn, hub.funcs), "coverprofile"), ro.coverBlocks, ro.corpusCover)
+			}
+
+		case crash := <-hub.newCrasherC:
+			// New crasher from workers. Woohoo!
+			if crash.Hanging || !*flagDup {
+				ro := hub.ro.Load().(*ROData)
+				ro1 := new(ROData)
+				*ro1 = *ro
+				if crash.Hanging {
+					ro1.badInputs = make(map[Sig]struct{})
+					for k, v := range ro.badInputs {
+						ro1.badInputs[k] = v
+					}
+					ro1.badInputs[hash(crash.Data)] = struct{}{}
+				}
+				if !*flagDup {
+					ro1.suppressions = make(map[Sig]struct{})
+					for k, v := range ro.suppressions {
+						ro1.suppressions[k] = v
+					}
+					ro1.suppressions[hash(crash.Suppression)] = struct{}{}
+				}
+				hub.ro.Store(ro1)
+			}
+			crash.Token = *flagAuthToken
+			if hub.coordinator == nil {
+				hub.pendingCrashers = append(hub.pendingCrashers, crash)
+			} else if err := hub.coordinator.Call("Coordinator.NewCrasher", crash, nil); err != nil {
+				log.Printf("new crasher call failed: %v, reconnecting to coordinator", err)
+				hub.pendingCrashers = append(hub.pendingCrashers, crash)
+				hub.reconnect()
+			}
+
+		case r := <-hub.reconnectC:
+			hub.reconnected(r)
+
+		case <-hub.stopC:
+			if hub.coordinator != nil {
+				hub.coordinator.Close()
+			}
+			return
+		}
+	}
+}
+
+// logInitialTriage logs how many of the initial corpus inputs were skipped:
+// crashing inputs and inputs that don't give new coverage are not added to corpus.
+func (hub *Hub) logInitialTriage() {
+	kept := int(hub.corpusOrigins[execCorpus])
+	if kept > hub.initialInputs {
+		kept = hub.initialInputs // inputs triaged meanwhile for other worker processes
+	}
+	if skipped := hub.initialInputs - kept; skipped != 0 {
+		log.Printf("hub: initial corpus: %v inputs, %v skipped because they crash or give no new coverage", hub.initialInputs, skipped)
+	}
+}
+
+// inputSizeLimit returns max input size: -maxinputsize if set,
+// otherwise the hint recorded by go-fuzz-build, otherwise MaxInputSize.
+func inputSizeLimit(hint int) int {
+	n := *flagMaxInputSize
+	if n == 0 {
+		n = hint
+	}
+	if n <= 0 || n > MaxInputSize {
+		n = MaxInputSize
+	}
+	return n
+}
+
+// maxInputSize returns max size of inputs passed to the test binary.
+func (ro *ROData) maxInputSize() int {
+	if ro.maxInput == 0 {
+		return MaxInputSize
+	}
+	return ro.maxInput
+}
+
+// stop terminates hub loop, it must be called after all workers have exited.
+// estimatedBlockHits returns estimated per coverage counter number of fuzzing executions.
+func (hub *Hub) estimatedBlockHits() []uint64 {
+	hub.blockHitsMu.Lock()
+	defer hub.blockHitsMu.Unlock()
+	hits := make([]uint64, len(hub.blockHits))
+	for i, v := range hub.blockHits {
+		hits[i] = v * blockHitsSample
+	}
+	return hits
+}
+
+func (hub *Hub) stop() {
+	close(hub.stopC)
+}
+
+// Preliminary cover update to prevent new input thundering herd.
+// This function is synchronous to reduce latency.
+func (hub *Hub) updateMaxCover(cover []byte) bool {
+	return hub.updateMax(&hub.maxCover, cover)
+}
+
+// updateMaxResCover is updateMaxCover for inputs for which Fuzz returned 1.
+func (hub *Hub) updateMaxResCover(cover []byte) bool {
+	return hub.updateMax(&hub.maxResCover, cover)
+}
+
+func (hub *Hub) updateMax(max *atomic.Value, cover []byte) bool {
+	oldMaxCover := max.Load().([]byte)
+	if !compareCover(oldMaxCover, cover) {
+		return false
+	}
+	hub.maxCoverMu.Lock()
+	defer hub.maxCoverMu.Unlock()
+	oldMaxCover = max.Load().([]byte)
+	if !compareCover(oldMaxCover, cover) {
+		return false
+	}
+	maxCover := makeCopy(oldMaxCover)
+	updateMaxCover(maxCover, cover)
+	max.Store(maxCover)
+	return true
+}
+
+func (hub *Hub) updateScores() {
+	ro := hub.ro.Load().(*ROData)
+	ro1 := new(ROData)
+	*ro1 = *ro
+	corpus := make([]Input, len(ro.corpus))
+	copy(corpus, ro.corpus)
+	ro1.corpus = corpus
+
+	var sumExecTime, sumCoverSize uint64
+	for _, inp := range corpus {
+		sumExecTime += inp.execTime
+		sumCoverSize += uint64(inp.coverSize)
+	}
+	n := uint64(len(corpus))
+	avgExecTime := sumExecTime / n
+	avgCoverSize := sumCoverSize / n
+	rarities := make([]float64, len(corpus))
+	sumRarity := 0.0
+	for i, inp := range corpus {
+		rarities[i] = hub.rarity(inp.cover)
+		sumRarity += rarities[i]
+	}
+	avgRarity := sumRarity / float64(n)
+
+	// Phase 1: calculate score for each input independently.
+	for i, inp := range corpus {
+		score := defScore
+
+		// Execution time multiplier 0.1-3x.
+		// Fuzzing faster inputs increases efficiency.
+		execTime := float64(inp.execTime) / float64(avgExecTime)
+		if execTime > 10 {
+			score /= 10
+		} else if execTime > 4 {
+			score /= 4
+		} else if execTime > 2 {
+			score /= 2
+		} else if execTime < 0.25 {
+			score *= 3
+		} else if execTime < 0.33 {
+			score *= 2
+		} else if execTime < 0.5 {
+			score *= 1.5
+		}
+
+		// Coverage size multiplier 0.25-3x.
+		// Inputs with larger coverage are more interesting.
+		coverSize := float64(inp.coverSize) / float64(avgCoverSize)
+		if coverSize > 3 {
+			score *= 3
+		} else if coverSize > 2 {
+			score *= 2
+		} else if coverSize > 1.5 {
+			score *= 1.5
+		} else if coverSize < 0.3 {
+			score /= 4
+		} else if coverSize < 0.5 {
+			score /= 2
+		} else if coverSize < 0.75 {
+			score /= 1.5
+		}
+
+		// Rarity multiplier 0.5-4x.
+		// Inputs hitting rarely executed code with lots of statements
+		// have higher chances of exploring it further.
+		if avgRarity != 0 {
+			rarity := rarities[i] / avgRarity
+			if rarity > 4 {
+				score *= 4
+			} else if rarity > 2 {
+				score *= 3
+			} else if rarity > 1.5 {
+				score *= 2
+			} else if rarity < 0.5 {
+				score /= 2
+			}
+		}
+
+		// Input depth multiplier 1-5x.
+		// Deeper inputs have higher chances of digging deeper into code.
+		if inp.depth < 10 {
+			// no boost for you
+		} else if inp.depth < 20 {
+			score *= 2
+		} else if inp.depth < 40 {
+			score *= 3
+		} else if inp.depth < 80 {
+			score *= 4
+		} else {
+			score *= 5
+		}
+
+		// User boost (Fuzz function return value) multiplier 1-2x.
+		// We don't know what it is, but user said so.
+		if inp.res > 0 {
+			// Assuming this is a correct input (e.g. deserialized successfully).
+			score *= 2
+		}
+
+		if score < minScore {
+			score = minScore
+		} else if score > maxScore {
+			score = maxScore
+		}
+		corpus[i].score = int(score)
+	}
+
+	// Phase 2: Choose a minimal set of (favored) inputs that give full coverage,
+	// and a minimal set of inputs the user marked as interesting (Fuzz returned 1)
+	// that give full coverage of such inputs.
+	// Non-favored inputs receive minimal score.
+	for idx := range corpus {
+		corpus[idx].favored = false
+	}
+	chooseFavored(corpus, ro.corpusCover, func(inp *Input) bool { return true })
+	chooseFavored(corpus, ro.corpusResCover, func(inp *Input) bool { return inp.res > 0 })
+	scoreSum := 0
+	for i, inp := range corpus {
+		if !inp.favored {
+			inp.score = minScore
+		}
+		scoreSum += inp.score
+		corpus[i].runningScoreSum = scoreSum
+	}
+
+	hub.ro.Store(ro1)
+	hub.lastRescore = time.Now()
+}
+
+// chooseFavored marks as favored a minimal set of inputs selected by filter
+// that together give maxCover, inputs with higher score are preferred.
+func chooseFavored(corpus []Input, maxCover []byte, filter func(inp *Input) bool) {
+	type Candidate struct {
+		index  int
+		score  int
+		chosen bool
+	}
+	candidates := make([]Candidate, CoverSize)
+	for idx, inp := range corpus {
+		if !filter(&inp) {
+			continue
+		}
+		for i, c := range inp.cover {
+			if c == 0 {
+				continue
+			}
+			c = roundUpCover(c)
+			if c != maxCover[i] {
+				continue
+			}
+			if c > maxCover[i] {
+				log.Fatalf("bad")
+			}
+			if candidates[i].score < inp.score {
+				candidates[i].index = idx
+				candidates[i].score = inp.score
+			}
+		}
+	}
+	for ci, cand := range candidates {
+		if cand.score == 0 {
+			continue
+		}
+		inp := &corpus[cand.index]
+		inp.favored = true
+		for i := ci + 1; i < CoverSize; i++ {
+			c := inp.cover[i]
+			if c == 0 {
+				continue
+			}
+			c = roundUpCover(c)
+			if c != maxCover[i] {
+				continue
+			}
+			candidates[i].score = 0
+		}
+	}
+}
+
+// rarity returns sum of statements in blocks hit by cover,
+// each block is weighted inversely to its global hit frequency.
+func (hub *Hub) rarity(cover []byte) float64 {
+	r := 0.0
+	for i, c := range cover {
+		if c != 0 {
+			r += float64(hub.blockStmts[i]) / float64(hub.blockHits[i]+1)
+		}
+	}
+	return r
+}
@@ -0,0 +1,256 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+// serveCoordinator starts a new coordinator and points -worker to it.
+func serveCoordinator(t *testing.T) (*Coordinator, net.Listener) {
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newCoordinator()
+	s := rpc.NewServer()
+	s.Register(c)
+	go s.Accept(ln)
+	*flagWorker = ln.Addr().String()
+	return c, ln
+}
+
+func TestHubReconnect(t *testing.T) {
+	_, cleanup := testWorkdir(t)
+	defer cleanup()
+	oldWorker := *flagWorker
+	defer func() { *flagWorker = oldWorker }()
+
+	c, ln := serveCoordinator(t)
+	defer ln.Close()
+	hub := &Hub{
+		fn:         "Fuzz",
+		funcs:      1,
+		corpusSigs: make(map[Sig]struct{}),
+		stopC:      make(chan struct{}),
+	}
+	hub.ro.Store(&ROData{})
+	if err := hub.connect(0); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { hub.coordinator.Close() }()
+	hub.corpusCoverSize = 10
+	hub.sync()
+	if stats := c.coordinatorStats(); stats.Cover != 10 {
+		t.Fatalf("got cover %v, want 10", stats.Cover)
+	}
+
+	// Connection drops, the worker must re-register and keep syncing.
+	hub.coordinator.Close()
+	hub.stats.execs = 100
+	hub.corpusCoverSize = 20
+	hub.sync()
+	hub.reconnected(<-hub.reconnectC)
+	hub.sync()
+	stats := c.coordinatorStats()
+	if stats.Cover != 20 || stats.Execs != 100 {
+		t.Fatalf("got cover %v and execs %v after reconnect, want 20 and 100", stats.Cover, stats.Execs)
+	}
+	if len(c.workers) != 1 {
+		t.Fatalf("coordinator has %v workers after reconnect, want 1", len(c.workers))
+	}
+
+	// Coordinator restarts with an empty workdir and gets inputs back from the worker.
+	_, cleanup2 := testWorkdir(t)
+	defer cleanup2()
+	hub.ro.Store(&ROData{corpus: []Input{{data: []byte("input")}}})
+	c2, ln2 := serveCoordinator(t)
+	defer ln2.Close()
+	hub.coordinator.Close()
+	hub.sync()
+	hub.reconnected(<-hub.reconnectC)
+	hub.sync()
+	c2.mu.Lock()
+	defer c2.mu.Unlock()
+	if _, ok := c2.corpus.m[hash([]byte("input"))]; !ok {
+		t.Fatalf("worker did not send its corpus to the restarted coordinator")
+	}
+	if c2.coverFullness != 20 {
+		t.Fatalf("got cover %v on the restarted coordinator, want 20", c2.coverFullness)
+	}
+}
+
+func TestHubDisconnected(t *testing.T) {
+	_, cleanup := testWorkdir(t)
+	defer cleanup()
+	oldWorker, oldProcs := *flagWorker, *flagProcs
+	defer func() { *flagWorker, *flagProcs = oldWorker, oldProcs }()
+	*flagProcs = 1
+
+	// The coordinator goes away along with all its connections.
+	c := newCoordinator()
+	s := rpc.NewServer()
+	s.Register(c)
+	var mu sync.Mutex
+	var conns []net.Conn
+	serve := func(ln net.Listener) {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go s.ServeConn(conn)
+		}
+	}
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go serve(ln)
+	*flagWorker = ln.Addr().String()
+	hub, err := newHub(MetaData{Funcs: []string{"Fuzz"}}, "Fuzz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hub.stop()
+	ln.Close()
+	mu.Lock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	mu.Unlock()
+
+	// While the hub reconnects, workers must not block on it.
+	crash := NewCrasherArgs{Data: []byte("crash"), Error: []byte("panic"), Suppression: []byte("panic")}
+	deadline := time.Now().Add(2 * syncPeriod)
+	for time.Now().Before(deadline) {
+		select {
+		case hub.syncC <- Stats{execs: 1}:
+		case <-time.After(syncPeriod):
+			t.Fatalf("hub does not receive worker stats while disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case hub.newCrasherC <- crash:
+	case <-time.After(syncPeriod):
+		t.Fatalf("hub does not receive crashers while disconnected")
+	}
+
+	// The coordinator comes back, the hub sends it the crasher found meanwhile.
+	ln, err = net.Listen("tcp", *flagWorker)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go serve(ln)
+	for deadline := time.Now().Add(time.Minute); ; {
+		c.mu.Lock()
+		n := len(c.crashers.m)
+		c.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("crasher was not sent to the coordinator after reconnect")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestHubReconnectTimeout(t *testing.T) {
+	_, cleanup := testWorkdir(t)
+	defer cleanup()
+	oldWorker := *flagWorker
+	defer func() { *flagWorker = oldWorker }()
+	defer func() { stopC, stopOnce, exitStatus = make(chan struct{}), new(sync.Once), 0 }()
+
+	// The coordinator is gone for good, the hub gives up after -reconnecttimeout.
+	_, ln := serveCoordinator(t)
+	hub := &Hub{
+		fn:               "Fuzz",
+		funcs:            1,
+		corpusSigs:       make(map[Sig]struct{}),
+		stopC:            make(chan struct{}),
+		reconnectTimeout: 100 * time.Millisecond,
+	}
+	hub.ro.Store(&ROData{})
+	if err := hub.connect(0); err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	hub.coordinator.Close()
+	hub.sync()
+	hub.reconnected(<-hub.reconnectC)
+	select {
+	case <-stopC:
+	default:
+		t.Fatalf("hub did not give up reconnecting")
+	}
+	if exitStatus != 1 {
+		t.Fatalf("got exit status %v, want 1", exitStatus)
+	}
+}
+
+func TestRarityScheduling(t *testing.T) {
+	// Inputs 0..n-1 cover shared block 0 and own common block i+1,
+	// input n additionally covers block rare that is almost never hit by fuzzing.
+	const n, rare = 20, 100
+	hub := &Hub{
+		blockStmts: make([]int, CoverSize),
+		blockHits:  make([]uint64, CoverSize),
+	}
+	ro := &ROData{corpusCover: make([]byte, CoverSize)}
+	for i := 0; i <= n; i++ {
+		cover := make([]byte, CoverSize)
+		cover[0] = 1
+		if i < n {
+			cover[i+1] = 1
+		} else {
+			cover[rare] = 1
+		}
+		updateMaxCover(ro.corpusCover, cover)
+		ro.corpus = append(ro.corpus, Input{data: []byte{byte(i)}, cover: cover, coverSize: 2, execTime: 1})
+	}
+	for i := 0; i <= n; i++ {
+		hub.blockStmts[i] = 1
+		hub.blockHits[i] = 1e4
+	}
+	hub.blockStmts[rare] = 10
+	hub.ro.Store(ro)
+
+	chosen := func() float64 {
+		hub.updateScores()
+		ro := hub.ro.Load().(*ROData)
+		m := newMutator()
+		const iters = 1e5
+		cnt := 0
+		for i := 0; i < iters; i++ {
+			if m.chooseInput(ro).data[0] == n {
+				cnt++
+			}
+		}
+		// Ratio to the uniform share.
+		return float64(cnt) / iters * (n + 1)
+	}
+	if r := chosen(); r < 3 {
+		t.Fatalf("rare input is chosen %.2fx of the uniform share, want at least 3x", r)
+	}
+	// Once the block becomes common, the input loses its boost.
+	hub.blockHits[rare] = 1e4
+	hub.blockStmts[rare] = 1
+	if r := chosen(); r < 0.5 || r > 1.5 {
+		t.Fatalf("common input is chosen %.2fx of the uniform share, want about 1x", r)
+	}
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"fmt"
+	"log"
+)
+
+// With -leakcheck a worker samples the number of goroutines and open file descriptors
+// in the test binary every leakCheckPeriod fuzz executions. Samples taken soon after
+// the test binary start are ignored, because lazy initialization and caches grow
+// during warm-up. If the counts keep growing afterwards, the worker restarts
+// the test binary and re-executes the recent inputs one by one to find the input
+// that leaks, the input is saved into leaks dir.
+const (
+	leakCheckPeriod = 100 // fuzz executions between samples
+	leakWarmup      = 3   // samples after the test binary start that are not checked
+	leakTrend       = 3   // number of the last samples that must not decrease
+	leakThreshold   = 100 // growth over the first checked sample that is reported as leak
+	leakRepro       = 10  // executions of a recent input to check whether it leaks
+)
+
+type leakStats struct {
+	goroutines int
+	fds        int
+}
+
+// leakChecker is the per-worker state of -leakcheck.
+type leakChecker struct {
+	testee  *Testee // test binary process the samples were taken from
+	execs   int
+	samples []leakStats
+	recent  [][]byte // inputs executed since the previous sample
+}
+
+// leakKinds describes resources that are checked for leaks.
+var leakKinds = []struct {
+	name  string
+	count func(st leakStats) int
+}{
+	{"goroutines", func(st leakStats) int { return st.goroutines }},
+	{"file descriptors", func(st leakStats) int { return st.fds }},
+}
+
+// checkLeaks is called after every fuzz execution of data with -leakcheck.
+func (w *Worker) checkLeaks(data []byte) {
+	lc := &w.leaks
+	bin := w.coverBin
+	if lc.testee != bin.testee {
+		*lc = leakChecker{testee: bin.testee}
+	}
+	lc.recent = append(lc.recent, makeCopy(data))
+	lc.execs++
+	if lc.execs%leakCheckPeriod != 0 {
+		return
+	}
+	recent := lc.recent
+	lc.recent = nil
+	st, ok := bin.leakStats()
+	if !ok || lc.testee != bin.testee {
+		return
+	}
+	lc.samples = append(lc.samples, st)
+	kind, from, to := lc.leak()
+	if kind < 0 {
+		return
+	}
+	name := leakKinds[kind].name
+	supp := []byte("leak of " + name)
+	// The leaked resources would eventually kill the test binary,
+	// so it is restarted even if this leak is already reported.
+	bin.restart()
+	ro := w.hub.ro.Load().(*ROData)
+	if _, ok := ro.suppressions[hash(supp)]; ok {
+		if *flagV >= 1 {
+			log.Printf("worker %v: test binary leaks %v (%v -> %v), restarting", w.id, name, from, to)
+		}
+		return
+	}
+	data, reproduced := w.findLeakingInput(kind, recent)
+	report := fmt.Sprintf("test binary leaks %v: %v -> %v in %v executions\n", name, from, to, lc.execs-leakWarmup*leakCheckPeriod)
+	if reproduced {
+		report += fmt.Sprintf("every execution of the input leaks %v\n", name)
+	} else {
+		report += fmt.Sprintf("failed to reproduce the leak with a single input, saving the last of %v recent inputs\n", len(recent))
+	}
+	log.Printf("worker %v: test binary leaks %v (%v -> %v), saving input %x into leaks dir (reproduced: %v)",
+		w.id, name, from, to, hash(data), reproduced)
+	w.hub.newCrasherC <- NewCrasherArgs{
+		Data:        data,
+		Error:       []byte(report),
+		Suppression: supp,
+		Leak:        name,
+	}
+}
+
+// leak returns index in leakKinds of the resource that grows in the samples
+// and its counts in the first checked and the last samples, or -1 if nothing leaks.
+func (lc *leakChecker) leak() (kind, from, to int) {
+	if len(lc.samples) < leakWarmup+leakTrend {
+		return -1, 0, 0
+	}
+	base := lc.samples[leakWarmup-1]
+	trend := lc.samples[len(lc.samples)-leakTrend:]
+next:
+	for i, k := range leakKinds {
+		for j := 1; j < len(trend); j++ {
+			if k.count(trend[j]) < k.count(trend[j-1]) {
+				continue next
+			}
+		}
+		from, to = k.count(base), k.count(trend[len(trend)-1])
+		if to-from >= leakThreshold && to > k.count(trend[0]) {
+			return i, from, to
+		}
+	}
+	return -1, 0, 0
+}
+
+// findLeakingInput re-executes recent inputs in a fresh test binary and returns
+// the most recent one that leaks resource kind on every execution.
+// If there is no such input, it returns the last input.
+func (w *Worker) findLeakingInput(kind int, recent [][]byte) (data []byte, reproduced bool) {
+	bin := w.coverBin
+	count := leakKinds[kind].count
+	for i := len(recent) - 1; i >= 0; i-- {
+		before, ok := bin.leakStats()
+		if !ok {
+			continue
+		}
+		crashed := false
+		for j := 0; j < leakRepro && !crashed; j++ {
+			_, _, _, _, _, crashed, _ = bin.test(recent[i])
+		}
+		if crashed {
+			continue
+		}
+		after, ok := bin.leakStats()
+		if ok && count(after)-count(before) >= leakRepro {
+			bin.restart()
+			return recent[i], true
+		}
+	}
+	bin.restart()
+	return recent[len(recent)-1], false
+}
@@ -0,0 +1,81 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"time"
+)
+
+// initLogging sets up log output to w according to -logformat.
+// In json mode every log line is a JSON object with time and event fields,
+// free-form messages are logged as "log" events with msg field.
+func initLogging(w io.Writer) {
+	if *flagLogFormat != "json" {
+		return
+	}
+	log.SetFlags(0)
+	log.SetOutput(jsonLogWriter{w})
+}
+
+type jsonLogWriter struct {
+	w io.Writer
+}
+
+func (w jsonLogWriter) Write(p []byte) (int, error) {
+	if bytes.HasPrefix(p, []byte("{")) {
+		return w.w.Write(p) // already an event
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"time":  time.Now().Format(time.RFC3339),
+		"event": "log",
+		"msg":   string(bytes.TrimRight(p, "\n")),
+	})
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.w.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// logEvent logs event with the given fields in json mode, it does nothing in text mode.
+func logEvent(event string, fields map[string]interface{}) {
+	if *flagLogFormat != "json" {
+		return
+	}
+	fields["time"] = time.Now().Format(time.RFC3339)
+	fields["event"] = event
+	data, err := json.Marshal(fields)
+	if err != nil {
+		log.Printf("failed to marshal %v event: %v", event, err)
+		return
+	}
+	log.Print(string(data))
+}
+
+// logStats logs periodic status update.
+func logStats(stats coordinatorStats) {
+	if *flagLogFormat != "json" {
+		log.Println(stats.String())
+		return
+	}
+	logEvent("status", map[string]interface{}{
+		"workers":        stats.Workers,
+		"corpus":         stats.Corpus,
+		"last_new_input": stats.LastNewInputTime.Format(time.RFC3339),
+		"crashers":       stats.Crashers,
+		"restarts_denom": stats.RestartsDenom,
+		"execs":          stats.Execs,
+		"execs_per_sec":  stats.ExecsPerSec(),
+		"cover":          stats.Cover,
+		"cover_total":    stats.CoverTotal,
+		"start_time":     stats.StartTime.Format(time.RFC3339),
+		"uptime_sec":     time.Since(stats.StartTime).Seconds(),
+	})
+}
@@ -0,0 +1,83 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLogging(t *testing.T) {
+	_, cleanup := testWorkdir(t)
+	defer cleanup()
+	oldFormat := *flagLogFormat
+	*flagLogFormat = "json"
+	var buf bytes.Buffer
+	initLogging(&buf)
+	defer func() {
+		*flagLogFormat = oldFormat
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	c := newCoordinator()
+	var res ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1, CoverTotal: 50}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Sync(&SyncArgs{ID: res.ID, Execs: 100, Restarts: 1, CoverFullness: 7}, &SyncRes{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NewCrasher(&NewCrasherArgs{Data: []byte("crash"), Error: []byte("panic: boom"), Suppression: []byte("boom")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	c.broadcastStats()
+	log.Printf("free-form message")
+
+	events := make(map[string]map[string]interface{})
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var ev map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("bad log line %q: %v", line, err)
+		}
+		if _, err := time.Parse(time.RFC3339, ev["time"].(string)); err != nil {
+			t.Fatalf("bad time in %q: %v", line, err)
+		}
+		events[ev["event"].(string)] = ev
+	}
+	status := events["status"]
+	if status == nil {
+		t.Fatalf("no status event in:\n%s", buf.Bytes())
+	}
+	for _, f := range []string{"workers", "corpus", "crashers", "execs", "execs_per_sec", "cover", "cover_total", "uptime_sec", "restarts_denom"} {
+		if _, ok := status[f]; !ok {
+			t.Errorf("status event misses %q", f)
+		}
+	}
+	if status["execs"] != 100.0 || status["crashers"] != 1.0 || status["cover"] != 7.0 {
+		t.Errorf("bad status event: %v", status)
+	}
+	if ev := events["cover"]; ev == nil || ev["cover"] != 7.0 || ev["cover_total"] != 50.0 {
+		t.Errorf("bad cover event: %v", ev)
+	}
+	if ev := events["restart"]; ev == nil || ev["restarts"] != 1.0 {
+		t.Errorf("bad restart event: %v", ev)
+	}
+	crasher := events["crasher"]
+	if crasher == nil || crasher["dedup_key"] == "" {
+		t.Fatalf("bad crasher event: %v", crasher)
+	}
+	if data, err := ioutil.ReadFile(crasher["file"].(string)); err != nil || string(data) != "crash" {
+		t.Errorf("crasher file %v: %q, %v", crasher["file"], data, err)
+	}
+	if ev := events["log"]; ev == nil || ev["msg"] != "free-form message" {
+		t.Errorf("bad log event: %v", ev)
+	}
+}
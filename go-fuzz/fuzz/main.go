@@ -0,0 +1,272 @@
+// Copyright 2015 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"flag"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/tools/go/packages"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+)
+
+//go:generate go build github.com/dvyukov/go-fuzz/go-fuzz/vendor/github.com/elazarl/go-bindata-assetfs/go-bindata-assetfs
+//go:generate ./go-bindata-assetfs -pkg fuzz assets/...
+//go:generate goimports -w bindata_assetfs.go
+//go:generate rm go-bindata-assetfs
+
+var (
+	flags = flag.NewFlagSet("go-fuzz", flag.ExitOnError)
+
+	flagWorkdir           = flags.String("workdir", ".", "dir with persistent work data")
+	flagProcs             = flags.Int("procs", runtime.NumCPU(), "parallelism level (can be changed at runtime with the /procs control endpoint on -metrics address)")
+	flagAffinity          = flags.Bool("affinity", false, "pin test binary processes of each worker to a separate CPU (Linux only)")
+	flagTimeout           = flags.Int("timeout", 10, "test timeout, in seconds")
+	flagHangTimeout       = flags.Duration("hangtimeout", 0, "per-input time limit, inputs exceeding it are saved into hangs dir instead of crashers (overrides -timeout)")
+	flagMemLimit          = flags.Uint64("memlimit", 0, "per-input heap growth limit in bytes, inputs exceeding it are saved as crashers (0 means no limit)")
+	flagLeakCheck         = flags.Bool("leakcheck", false, "detect inputs that leak goroutines or file descriptors in the test binary, they are saved into leaks dir")
+	flagMaxInputSize      = flags.Int("maxinputsize", 0, "max input size in bytes, larger corpus inputs are truncated (default is the hint recorded in the test binary, or 1MB)")
+	flagMinimize          = flags.Duration("minimize", 1*time.Minute, "time limit for minimization of new corpus inputs")
+	flagMinimizeCrasher   = flags.Duration("minimizecrasher", 1*time.Minute, "time limit for minimization of a single crasher, the minimized input is saved into <crasher>.min (0 disables minimization)")
+	flagMinimizeCorpus    = flags.String("minimizecorpus", "", "replay corpus, write a minimal subset of inputs with the same coverage into the given dir and exit")
+	flagDumpCorpusMeta    = flags.Bool("dumpcorpusmeta", false, "print provenance of corpus inputs (origin, parents, mutations, new coverage) and exit")
+	flagCoordinator       = flags.String("coordinator", "", "coordinator mode (value is coordinator address)")
+	flagWorker            = flags.String("worker", "", "worker mode (value is coordinator address)")
+	flagAuthToken         = flags.String("authtoken", "", "shared secret that workers must present to coordinator (default $GOFUZZ_AUTHTOKEN)")
+	flagResume            = flags.Bool("resume", false, "restore coverage, dynamic dictionary and stats from the checkpoint in workdir (saved every minute and on shutdown)")
+	flagConnectionTimeout = flags.Duration("connectiontimeout", 1*time.Minute, "time limit for worker to try to connect coordinator")
+	flagReconnectTimeout  = flags.Duration("reconnecttimeout", 10*time.Minute, "time limit for worker to reconnect to coordinator after losing connection, then the worker exits (0 means no limit)")
+	flagCorpus            = flags.String("corpus", "", "dir with corpus inputs (default <workdir>/corpus)")
+	flagShard             = flags.String("shard", "", "i/n: fuzz only corpus inputs whose hash modulo n is i, coordinators of all n shards share -corpus dir (coordinator mode only)")
+	flagBin               = flags.String("bin", "", "test binary built with go-fuzz-build")
+	flagFunc              = flags.String("func", "", "function to fuzz")
+	flagRun               = flags.String("run", "", "run the fuzz function once on the given input file without fuzzing, print the result and exit (exit status is 1 if the input crashes)")
+	flagSeed              = flags.Int64("seed", 0, "seed of mutation RNGs, worker i uses seed+i, so that runs with the same corpus, binary and -procs mutate the same way (0 means a random seed)")
+	flagMutatorWeights    = flags.String("mutatorweights", "", "comma-separated name=weight list of mutation strategy weights (built-in, e.g. splice, and registered with RegisterMutator), unlisted strategies have weight 1")
+	flagDict              = flags.String("dict", "", "AFL dictionary file with additional tokens for mutation (use file@level to include entries up to level)")
+	flagBaseline          = flags.String("baseline", "", "dir with baseline corpus: it seeds coverage, inputs with coverage beyond it are saved into newcover dir and go-fuzz exits with status 1")
+	flagNativeCorpus      = flags.String("nativecorpus", "testdata/fuzz", "dir with Go native fuzzing seed corpus, inputs are read from <dir>/<func>")
+	flagDumpCover         = flags.Bool("dumpcover", false, "dump coverage profile into workdir")
+	flagCoverProfile      = flags.String("coverprofile", "", "write accumulated coverage profile to file on shutdown (for use with 'go tool cover')")
+	flagCovReport         = flags.String("covreport", "", "write HTML coverage report with per-block hit count heatmap into dir on shutdown")
+	flagCoverLog          = flags.String("coverlog", "", "write CSV log of the first discovery of every coverage block (block ID, file, line, seconds since start) to file (coordinator mode only, requires -bin)")
+	flagSonarTrace        = flags.String("sonartrace", "", "write a sample of sonar comparisons (site ID, operands and result) as length-delimited records to file (coordinator mode only)")
+	flagSonarTraceRate    = flags.Int("sonartracerate", defaultSonarTraceRate, "with -sonartrace, trace comparisons of every N-th execution with sonar")
+	flagDup               = flags.Bool("dup", false, "collect duplicate crashers")
+	flagMaxCrashers       = flags.Int("maxcrashers", 1000, "max number of saved crashers (and hangs and leaks), further unique crashers are only counted (0 means no limit)")
+	flagExitOnCrash       = flags.Bool("exitoncrash", false, "exit with status 1 after the first unique crasher is saved")
+	flagDedup             = flags.String("dedup", "output", "crasher deduplication mode: output (crash message and function names) or stack (normalized top stack frames)")
+	flagCrashCover        = flags.Bool("crashcover", false, "save coverage blocks that a crasher executed up to the crash into <crasher>.cover")
+	flagTestOutput        = flags.Bool("testoutput", false, "print test binary output to stdout (for debugging only)")
+	flagCoverCounters     = flags.Bool("covercounters", true, "use coverage hit counters")
+	flagSonar             = flags.Bool("sonar", true, "use sonar hints")
+	flagV                 = flags.Int("v", 0, "verbosity level")
+	flagLogFormat         = flags.String("logformat", "text", "log format: text or json (one JSON object per line)")
+	flagHTTP              = flags.String("http", "", "HTTP server listen address (coordinator mode only)")
+	flagMetrics           = flags.String("metrics", "", "HTTP server listen address for JSON and Prometheus metrics and the /procs control endpoint (coordinator mode only)")
+
+	shutdown        uint32
+	shutdownC       = make(chan struct{})
+	shutdownCleanup []func()
+	exitStatus      int                   // exit status after shutdown
+	stopC           = make(chan struct{}) // closed to request shutdown (e.g. by -exitoncrash), see requestStop
+	stopOnce        = new(sync.Once)      // closes stopC
+)
+
+// requestStop requests shutdown of the process with the given exit status.
+// It can be called more than once, only the first call has effect.
+func requestStop(status int) {
+	stopOnce.Do(func() {
+		exitStatus = status
+		close(stopC)
+	})
+}
+
+// Main is the entry point of the go-fuzz command, it parses command line flags
+// and runs coordinator and/or worker until interrupted.
+func Main() {
+	flags.Parse(os.Args[1:])
+	if *flagCoordinator != "" && *flagWorker != "" {
+		log.Fatalf("both -coordinator and -worker are specified")
+	}
+	if *flagHTTP != "" && *flagWorker != "" {
+		log.Fatalf("both -http and -worker are specified")
+	}
+	if *flagMetrics != "" && *flagWorker != "" {
+		log.Fatalf("both -metrics and -worker are specified")
+	}
+	if *flagCoverLog != "" && *flagWorker != "" {
+		log.Fatalf("both -coverlog and -worker are specified")
+	}
+	if *flagSonarTrace != "" && *flagWorker != "" {
+		log.Fatalf("both -sonartrace and -worker are specified")
+	}
+	if *flagShard != "" && *flagWorker != "" {
+		log.Fatalf("both -shard and -worker are specified")
+	}
+	if _, _, err := parseShard(*flagShard); err != nil {
+		log.Fatalf("%v", err)
+	}
+	if *flagSonarTraceRate <= 0 {
+		log.Fatalf("bad -sonartracerate value %v, want a positive number", *flagSonarTraceRate)
+	}
+	if *flagAffinity && !affinitySupported {
+		log.Fatalf("-affinity is supported only on Linux")
+	}
+	if *flagDedup != "output" && *flagDedup != "stack" {
+		log.Fatalf("bad -dedup value %q, want output or stack", *flagDedup)
+	}
+	if *flagMaxInputSize < 0 || *flagMaxInputSize > MaxInputSize {
+		log.Fatalf("bad -maxinputsize value %v, want a value in [1, %v] (or 0 for the default)", *flagMaxInputSize, MaxInputSize)
+	}
+	if _, err := parseStrategyWeights(*flagMutatorWeights); err != nil {
+		log.Fatalf("bad -mutatorweights: %v", err)
+	}
+	if *flagBaseline != "" {
+		if _, err := os.Stat(*flagBaseline); err != nil {
+			log.Fatalf("bad -baseline: %v", err)
+		}
+	}
+	if *flagMaxCrashers < 0 {
+		log.Fatalf("bad -maxcrashers value %v, want a non-negative number", *flagMaxCrashers)
+	}
+	if *flagLogFormat != "text" && *flagLogFormat != "json" {
+		log.Fatalf("bad -logformat value %q, want text or json", *flagLogFormat)
+	}
+	initLogging(os.Stderr)
+	if *flagRun != "" && (*flagCoordinator != "" || *flagWorker != "") {
+		log.Fatalf("-run can't be used with -coordinator or -worker")
+	}
+	if *flagMinimizeCorpus != "" && (*flagRun != "" || *flagCoordinator != "" || *flagWorker != "") {
+		log.Fatalf("-minimizecorpus can't be used with -run, -coordinator or -worker")
+	}
+	if *flagDumpCorpusMeta && (*flagRun != "" || *flagMinimizeCorpus != "" || *flagCoordinator != "" || *flagWorker != "") {
+		log.Fatalf("-dumpcorpusmeta can't be used with -run, -minimizecorpus, -coordinator or -worker")
+	}
+
+	go func() {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, syscall.SIGINT)
+		select {
+		case <-c:
+		case <-stopC:
+		}
+		atomic.StoreUint32(&shutdown, 1)
+		close(shutdownC)
+		log.Printf("shutting down...")
+		time.Sleep(2 * time.Second)
+		for _, f := range shutdownCleanup {
+			f()
+		}
+		os.Exit(exitStatus)
+	}()
+
+	runtime.GOMAXPROCS(min(*flagProcs, runtime.NumCPU()))
+	debug.SetGCPercent(50) // most memory is in large binary blobs
+	lowerProcessPrio()
+
+	*flagWorkdir = expandHomeDir(*flagWorkdir)
+	*flagBin = expandHomeDir(*flagBin)
+	if *flagAuthToken == "" {
+		// Environment is preferred, because command line is visible to other users.
+		*flagAuthToken = os.Getenv("GOFUZZ_AUTHTOKEN")
+	}
+
+	if *flagRun != "" {
+		requireBin()
+		os.Exit(replayMain())
+	}
+	if *flagMinimizeCorpus != "" {
+		requireBin()
+		minimizeCorpusMain()
+		os.Exit(0)
+	}
+	if *flagDumpCorpusMeta {
+		dumpCorpusMetaMain()
+		os.Exit(0)
+	}
+
+	if *flagCoordinator != "" || *flagWorker == "" {
+		if *flagWorkdir == "" {
+			log.Fatalf("-workdir is not set")
+		}
+		if *flagCoverLog != "" {
+			// Coordinator needs block positions from the test binary.
+			requireBin()
+		}
+		if *flagCoordinator == "" {
+			*flagCoordinator = "localhost:0"
+		}
+		ln, err := net.Listen("tcp", *flagCoordinator)
+		if err != nil {
+			log.Fatalf("failed to listen: %v", err)
+		}
+		if *flagCoordinator == "localhost:0" && *flagWorker == "" {
+			*flagWorker = ln.Addr().String()
+		}
+		coordinatorListen(startCoordinator(ln))
+	}
+
+	if *flagWorker != "" {
+		requireBin()
+		go func() {
+			if _, err := workerMain(); err != nil {
+				log.Fatalf("%v", err)
+			}
+		}()
+	}
+
+	select {}
+}
+
+// requireBin sets -bin to the default binary if it's not set.
+func requireBin() {
+	if *flagBin == "" {
+		// Try the default. Best effort only.
+		if *flagBin = defaultBin(); *flagBin == "" {
+			log.Fatalf("-bin is not set")
+		}
+	}
+}
+
+// defaultBin returns <pkg>-fuzz.zip for the package in the current dir
+// if such file exists, or an empty string otherwise.
+func defaultBin() string {
+	cfg := new(packages.Config)
+	// Note that we do not set GO111MODULE here in order to respect any GO111MODULE
+	// setting by the user as we are finding dependencies. See modules support
+	// comments in go-fuzz-build/main.go for more details.
+	cfg.Env = os.Environ()
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil || len(pkgs) != 1 {
+		return ""
+	}
+	bin := pkgs[0].Name + "-fuzz.zip"
+	if _, err := os.Stat(bin); err != nil {
+		return ""
+	}
+	return bin
+}
+
+// expandHomeDir expands the tilde sign and replaces it
+// with current users home directory and returns it.
+func expandHomeDir(path string) string {
+	if len(path) > 2 && path[:2] == "~/" {
+		usr, _ := user.Current()
+		path = filepath.Join(usr.HomeDir, path[2:])
+	}
+	return path
+}
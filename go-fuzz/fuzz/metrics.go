@@ -0,0 +1,72 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// metrics is a snapshot of fuzzing progress served on -metrics address.
+type metrics struct {
+	Execs       uint64  `json:"execs"`
+	ExecsPerSec float64 `json:"execs_per_sec"`
+	Corpus      uint64  `json:"corpus"`
+	Cover       uint64  `json:"cover"`
+	CoverTotal  uint64  `json:"cover_total"`
+	Crashers    uint64  `json:"crashers"`
+	Workers     uint64  `json:"workers"`
+	Uptime      float64 `json:"uptime_sec"`
+}
+
+func (c *Coordinator) metrics() metrics {
+	stats := c.coordinatorStats()
+	return metrics{
+		Execs:       stats.Execs,
+		ExecsPerSec: stats.ExecsPerSec(),
+		Corpus:      stats.Corpus,
+		Cover:       stats.Cover,
+		CoverTotal:  stats.CoverTotal,
+		Crashers:    stats.Crashers,
+		Workers:     stats.Workers,
+		Uptime:      time.Since(stats.StartTime).Seconds(),
+	}
+}
+
+// metricsHandler serves metrics as JSON on / and in Prometheus text format on /metrics,
+// and the control endpoint on /procs.
+func (c *Coordinator) metricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.metrics())
+	})
+	mux.HandleFunc("/procs", c.procsHandler)
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		m := c.metrics()
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, v := range []struct {
+			name, typ, help string
+			val             interface{}
+		}{
+			{"gofuzz_execs_total", "counter", "Total number of test executions.", m.Execs},
+			{"gofuzz_execs_per_second", "gauge", "Average number of test executions per second.", m.ExecsPerSec},
+			{"gofuzz_corpus_inputs", "gauge", "Number of inputs in corpus.", m.Corpus},
+			{"gofuzz_cover_blocks", "gauge", "Number of covered coverage counters.", m.Cover},
+			{"gofuzz_cover_blocks_max", "gauge", "Number of distinct coverage counters in the test binary.", m.CoverTotal},
+			{"gofuzz_crashers", "gauge", "Number of discovered crashers.", m.Crashers},
+			{"gofuzz_workers", "gauge", "Number of connected worker processes.", m.Workers},
+			{"gofuzz_uptime_seconds", "gauge", "Coordinator uptime in seconds.", m.Uptime},
+		} {
+			fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n%v %v\n", v.name, v.help, v.name, v.typ, v.name, v.val)
+		}
+	})
+	return mux
+}
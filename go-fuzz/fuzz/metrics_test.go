@@ -0,0 +1,142 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	_, cleanup := testWorkdir(t)
+	defer cleanup()
+
+	c := newCoordinator()
+	var res ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 2, Func: "Fuzz", Funcs: 1, CoverTotal: 100}, &res); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(c.metricsHandler())
+	defer srv.Close()
+
+	get := func(path string) []byte {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%v: got status %v", path, resp.Status)
+		}
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	fields := []string{"execs", "execs_per_sec", "corpus", "cover", "cover_total", "crashers", "workers", "uptime_sec"}
+	var prev map[string]float64
+	for i := 1; i <= 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+		if err := c.Sync(&SyncArgs{ID: res.ID, Execs: 1000, CoverFullness: 10 * i}, &SyncRes{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.NewInput(&NewInputArgs{ID: res.ID, Data: []byte{byte(i)}}, nil); err != nil {
+			t.Fatal(err)
+		}
+		var m map[string]float64
+		if err := json.Unmarshal(get("/"), &m); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range fields {
+			if _, ok := m[f]; !ok {
+				t.Fatalf("metrics miss %q: %v", f, m)
+			}
+		}
+		if m["execs"] != float64(1000*i) || m["cover"] != float64(10*i) || m["cover_total"] != 100 || m["workers"] != 2 {
+			t.Fatalf("bad metrics: %v", m)
+		}
+		for _, f := range []string{"execs", "corpus", "cover", "crashers", "uptime_sec"} {
+			if prev != nil && m[f] < prev[f] {
+				t.Fatalf("%v decreased from %v to %v", f, prev[f], m[f])
+			}
+		}
+		prev = m
+	}
+
+	prom := string(get("/metrics"))
+	for _, want := range []string{"\ngofuzz_execs_total 3000\n", "\ngofuzz_cover_blocks 30\n", "# TYPE gofuzz_execs_total counter\n"} {
+		if !strings.Contains(prom, want) {
+			t.Errorf("prometheus metrics miss %q:\n%v", want, prom)
+		}
+	}
+}
+
+func TestProcsEndpoint(t *testing.T) {
+	_, cleanup := testWorkdir(t)
+	defer cleanup()
+
+	c := newCoordinator()
+	c.token = "secret-token"
+	var res ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 2, Func: "Fuzz", Funcs: 1, Token: c.token}, &res); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(c.metricsHandler())
+	defer srv.Close()
+
+	post := func(n, token string) int {
+		req, err := http.NewRequest("POST", srv.URL+"/procs", strings.NewReader("n="+n))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	sync := func(procs int) int {
+		var r SyncRes
+		if err := c.Sync(&SyncArgs{ID: res.ID, Procs: procs, Token: c.token}, &r); err != nil {
+			t.Fatal(err)
+		}
+		return r.Procs
+	}
+
+	if got := sync(2); got != 0 {
+		t.Fatalf("got procs %v before scaling, want 0", got)
+	}
+	if got := post("4", "wrong-token"); got != http.StatusUnauthorized {
+		t.Fatalf("POST with bad token: got status %v", got)
+	}
+	for _, n := range []string{"0", "-1", "x"} {
+		if got := post(n, c.token); got != http.StatusBadRequest {
+			t.Fatalf("POST n=%v: got status %v", n, got)
+		}
+	}
+	if got := post("4", c.token); got != http.StatusOK {
+		t.Fatalf("POST n=4: got status %v", got)
+	}
+	if got := sync(2); got != 4 {
+		t.Fatalf("got procs %v, want 4", got)
+	}
+	if got := sync(4); got != 0 {
+		t.Fatalf("got procs %v after scaling, want 0", got)
+	}
+	if m := c.metrics(); m.Workers != 4 {
+		t.Fatalf("got %v workers, want 4", m.Workers)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+)
+
+// minInput is a corpus input replayed for corpus minimization.
+type minInput struct {
+	size    int
+	blocks  []int // covered entries of the coverage table
+	crashed bool
+}
+
+// minimizeCorpusMain replays corpus with the coverage binary and writes
+// a minimal subset of inputs with the same total coverage into -minimizecorpus dir.
+func minimizeCorpusMain() {
+	arch, err := openBinArchive()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer arch.cleanup()
+
+	dir := corpusDir(funcWorkdir(arch.fn, len(arch.metadata.Funcs)))
+	if filepath.Clean(dir) == filepath.Clean(*flagMinimizeCorpus) {
+		log.Fatalf("-minimizecorpus dir must differ from corpus dir %v", dir)
+	}
+	corpus := newPersistentSet(dir)
+	sigs := make([]Sig, 0, len(corpus.m))
+	for sig := range corpus.m {
+		sigs = append(sigs, sig)
+	}
+	sort.Slice(sigs, func(i, j int) bool {
+		return bytes.Compare(sigs[i][:], sigs[j][:]) < 0
+	})
+
+	var stats Stats
+	bin := newTestBinary(arch.coverBin, func() {}, &stats, arch.fnidx)
+	defer bin.close()
+	inputs := make([]minInput, len(sigs))
+	for i, sig := range sigs {
+		data := corpus.m[sig].data
+		if len(data) > MaxInputSize {
+			data = data[:MaxInputSize]
+		}
+		_, _, cover, _, _, crashed, _ := bin.test(data)
+		inputs[i].size = len(data)
+		inputs[i].crashed = crashed
+		if crashed {
+			continue
+		}
+		for idx, v := range cover {
+			if v != 0 {
+				inputs[i].blocks = append(inputs[i].blocks, idx)
+			}
+		}
+	}
+
+	keep := minimizeCorpus(inputs)
+	out := newPersistentSet(*flagMinimizeCorpus)
+	crashers := 0
+	for _, i := range keep {
+		out.add(corpus.m[sigs[i]])
+		if inputs[i].crashed {
+			crashers++
+		}
+	}
+	fmt.Printf("kept %v inputs (%v crashing), dropped %v of %v\n",
+		len(keep), crashers, len(inputs)-len(keep), len(inputs))
+}
+
+// minimizeCorpus returns sorted indices of a minimal subset of inputs that covers
+// all blocks covered by non-crashing inputs, crashing inputs are always kept.
+// The subset is selected greedily: on every step we take the input that covers
+// the most not yet covered blocks, ties are broken in favor of smaller inputs.
+func minimizeCorpus(inputs []minInput) []int {
+	var keep []int
+	h := &minHeap{inputs: inputs}
+	for i := range inputs {
+		if inputs[i].crashed {
+			keep = append(keep, i)
+			continue
+		}
+		if len(inputs[i].blocks) != 0 {
+			h.items = append(h.items, minItem{i, len(inputs[i].blocks)})
+		}
+	}
+	heap.Init(h)
+	covered := make([]bool, CoverSize)
+	for h.Len() != 0 {
+		// Gains only decrease as more blocks are covered, so stale gain
+		// of the top item needs to be recalculated only for this item.
+		it := heap.Pop(h).(minItem)
+		it.gain = 0
+		for _, b := range inputs[it.idx].blocks {
+			if !covered[b] {
+				it.gain++
+			}
+		}
+		if it.gain == 0 {
+			continue
+		}
+		if h.Len() != 0 && h.less(h.items[0], it) {
+			heap.Push(h, it)
+			continue
+		}
+		for _, b := range inputs[it.idx].blocks {
+			covered[b] = true
+		}
+		keep = append(keep, it.idx)
+	}
+	sort.Ints(keep)
+	return keep
+}
+
+type minItem struct {
+	idx  int
+	gain int // number of not yet covered blocks (can be stale)
+}
+
+// minHeap orders inputs by gain, then by size, then by index.
+type minHeap struct {
+	inputs []minInput
+	items  []minItem
+}
+
+func (h *minHeap) less(a, b minItem) bool {
+	if a.gain != b.gain {
+		return a.gain > b.gain
+	}
+	if sa, sb := h.inputs[a.idx].size, h.inputs[b.idx].size; sa != sb {
+		return sa < sb
+	}
+	return a.idx < b.idx
+}
+
+func (h *minHeap) Len() int           { return len(h.items) }
+func (h *minHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *minHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *minHeap) Push(x interface{}) { h.items = append(h.items, x.(minItem)) }
+
+func (h *minHeap) Pop() interface{} {
+	it := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return it
+}
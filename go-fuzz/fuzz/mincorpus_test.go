@@ -0,0 +1,56 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMinimizeCorpus(t *testing.T) {
+	inputs := []minInput{
+		{size: 10, blocks: []int{1, 2, 3}},
+		{size: 1, blocks: []int{1, 2}},
+		{size: 5, blocks: []int{3, 4}},
+		{size: 1, blocks: []int{4}},
+		{size: 5, blocks: []int{1, 2, 3}}, // same as 0, but smaller
+		{size: 100, crashed: true},
+		{size: 0},
+		{size: 3, blocks: []int{2, 4}},
+	}
+	keep := minimizeCorpus(inputs)
+	if want := []int{3, 4, 5}; !reflect.DeepEqual(keep, want) {
+		t.Fatalf("kept %v, want %v", keep, want)
+	}
+}
+
+func TestMinimizeCorpusCoverage(t *testing.T) {
+	// Overlapping sliding windows of blocks: every block is covered by several inputs.
+	var inputs []minInput
+	all := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		inp := minInput{size: 100 - i}
+		for b := i; b < i+i%7+3; b++ {
+			inp.blocks = append(inp.blocks, b)
+			all[b] = true
+		}
+		inputs = append(inputs, inp)
+	}
+	keep := minimizeCorpus(inputs)
+	covered := make(map[int]bool)
+	for _, i := range keep {
+		for _, b := range inputs[i].blocks {
+			covered[b] = true
+		}
+	}
+	if !reflect.DeepEqual(covered, all) {
+		t.Fatalf("minimized corpus covers %v blocks, want %v", len(covered), len(all))
+	}
+	if len(keep) >= len(inputs)/2 {
+		t.Fatalf("kept %v of %v inputs", len(keep), len(inputs))
+	}
+	if keep2 := minimizeCorpus(inputs); !reflect.DeepEqual(keep, keep2) {
+		t.Fatalf("minimization is not deterministic: %v vs %v", keep, keep2)
+	}
+}
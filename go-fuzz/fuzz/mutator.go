@@ -1,10 +1,12 @@
 // Copyright 2015 go-fuzz project authors. All rights reserved.
 // Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
 
-package main
+package fuzz
 
 import (
+	"bytes"
 	"encoding/binary"
+	"math/rand"
 	"sort"
 	"strconv"
 
@@ -12,48 +14,133 @@ import (
 	"github.com/dvyukov/go-fuzz/go-fuzz/internal/pcg"
 )
 
-type Mutator struct {
-	r *pcg.Rand
+type mutator struct {
+	r       *pcg.Rand
+	rng     *rand.Rand // passed to custom mutators
+	weights []int      // cumulative weights of mutation strategies, see parseStrategyWeights
+	dynLits [][]byte   // dynamic dictionary tokens used by the last mutate call
+	parents []Sig      // corpus inputs the last mutated input is derived from
+	ops     []byte     // mutations applied since the last setParent call, see mutationNames
 }
 
-func newMutator() *Mutator {
-	return &Mutator{r: pcg.New()}
+func newMutator() *mutator {
+	return newMutatorRand(pcg.New())
 }
 
-func (m *Mutator) rand(n int) int {
+// newSeededMutator returns mutator that makes the same sequence of choices for the same seed.
+func newSeededMutator(seed uint64) *mutator {
+	return newMutatorRand(pcg.NewSeeded(seed))
+}
+
+func newMutatorRand(r *pcg.Rand) *mutator {
+	m := &mutator{r: r, weights: strategyWeights()}
+	m.rng = rand.New(rand.NewSource(int64(m.r.Uint32())<<32 | int64(m.r.Uint32())))
+	return m
+}
+
+func (m *mutator) rand(n int) int {
 	return m.r.Intn(n)
 }
 
 // randbig generates a number in [0, 2³⁰).
-func (m *Mutator) randbig() int64 {
+func (m *mutator) randbig() int64 {
 	return int64(m.r.Uint32() >> 2)
 }
 
-func (m *Mutator) randByteOrder() binary.ByteOrder {
+func (m *mutator) randByteOrder() binary.ByteOrder {
 	if m.r.Bool() {
 		return binary.LittleEndian
 	}
 	return binary.BigEndian
 }
 
-func (m *Mutator) generate(ro *ROData) ([]byte, int) {
+func (m *mutator) generate(ro *ROData) ([]byte, int) {
+	input := m.chooseInput(ro)
+	data := input.data
+	m.setParent(input.sig)
+	return m.mutate(data, ro), input.depth + 1
+}
+
+// setParent starts recording provenance of inputs mutated from corpus input parent.
+func (m *mutator) setParent(parent Sig) {
+	m.parents = append(m.parents[:0], parent)
+	m.ops = m.ops[:0]
+}
+
+// chooseInput chooses a random corpus input according to input scores.
+func (m *mutator) chooseInput(ro *ROData) *Input {
 	corpus := ro.corpus
 	scoreSum := corpus[len(corpus)-1].runningScoreSum
 	weightedIdx := m.rand(scoreSum)
 	idx := sort.Search(len(corpus), func(i int) bool {
 		return corpus[i].runningScoreSum > weightedIdx
 	})
-	input := &corpus[idx]
-	return m.mutate(input.data, ro), input.depth + 1
+	return &corpus[idx]
+}
+
+// chooseLiteral returns a random static literal or dynamic dictionary token, or nil if there are none.
+func (m *mutator) chooseLiteral(ro *ROData) []byte {
+	static := len(ro.intLits) != 0 || len(ro.strLits) != 0
+	if len(ro.dynLits) != 0 && (!static || m.rand(3) == 0) {
+		// Dynamic tokens are raw operand values: strings or little-endian ints.
+		lit := ro.dynLits[m.rand(len(ro.dynLits))]
+		m.dynLits = append(m.dynLits, lit)
+		if m.rand(3) == 0 {
+			lit = reverse(lit)
+		}
+		return lit
+	}
+	if !static {
+		return nil
+	}
+	if len(ro.intLits) == 0 || len(ro.strLits) != 0 && m.r.Bool() {
+		return []byte(ro.strLits[m.rand(len(ro.strLits))])
+	}
+	lit := ro.intLits[m.rand(len(ro.intLits))]
+	if m.rand(3) == 0 {
+		lit = reverse(lit)
+	}
+	return lit
 }
 
-func (m *Mutator) mutate(data []byte, ro *ROData) []byte {
+// splice grafts tail of other onto head of data (AFL-style splicing).
+// The split point is chosen between the first and the last differing bytes,
+// so that the result differs from both inputs. Returns nil if the inputs
+// are too similar for splicing to make sense.
+func (m *mutator) splice(data, other []byte) []byte {
+	first, last := -1, -1
+	for i := 0; i < len(data) && i < len(other); i++ {
+		if data[i] != other[i] {
+			if first == -1 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first == last {
+		return nil
+	}
+	// Result takes data[first] from data and other[last] from other.
+	split := first + 1 + m.rand(last-first)
+	res := make([]byte, 0, len(other))
+	res = append(res, data[:split]...)
+	res = append(res, other[split:]...)
+	if len(res) > MaxInputSize {
+		res = res[:MaxInputSize]
+	}
+	return res
+}
+
+func (m *mutator) mutate(data []byte, ro *ROData) []byte {
 	corpus := ro.corpus
+	maxSize := ro.maxInputSize()
 	res := make([]byte, len(data))
 	copy(res, data)
+	m.dynLits = m.dynLits[:0]
 	nm := 1 + m.r.Exp2()
 	for iter := 0; iter < nm; iter++ {
-		switch m.rand(20) {
+		op := m.chooseStrategy()
+		switch op {
 		case 0:
 			// Remove a range of bytes.
 			if len(res) <= 1 {
@@ -67,7 +154,11 @@ func (m *Mutator) mutate(data []byte, ro *ROData) []byte {
 		case 1:
 			// Insert a range of random bytes.
 			pos := m.rand(len(res) + 1)
-			n := m.chooseLen(10)
+			n := min(m.chooseLen(10), maxSize-len(res))
+			if n <= 0 {
+				iter--
+				continue
+			}
 			for i := 0; i < n; i++ {
 				res = append(res, 0)
 			}
@@ -86,7 +177,11 @@ func (m *Mutator) mutate(data []byte, ro *ROData) []byte {
 			for dst == src {
 				dst = m.rand(len(res))
 			}
-			n := m.chooseLen(len(res) - src)
+			n := min(m.chooseLen(len(res)-src), maxSize-len(res))
+			if n <= 0 {
+				iter--
+				continue
+			}
 			tmp := make([]byte, n)
 			copy(tmp, res[src:])
 			for i := 0; i < n; i++ {
@@ -301,7 +396,8 @@ func (m *Mutator) mutate(data []byte, ro *ROData) []byte {
 				iter--
 				continue
 			}
-			other := corpus[m.rand(len(corpus))].data
+			otherInp := &corpus[m.rand(len(corpus))]
+			other := otherInp.data
 			if len(other) < 4 || &res[0] == &other[0] {
 				iter--
 				continue
@@ -322,20 +418,27 @@ func (m *Mutator) mutate(data []byte, ro *ROData) []byte {
 				continue
 			}
 			copy(res[idx0:idx0+m.rand(diff-2)+1], other[idx0:])
+			m.parents = append(m.parents, otherInp.sig)
 		case 17:
 			// Insert a part of another input.
 			if len(res) < 4 || len(corpus) < 2 {
 				iter--
 				continue
 			}
-			other := corpus[m.rand(len(corpus))].data
+			otherInp := &corpus[m.rand(len(corpus))]
+			other := otherInp.data
 			if len(other) < 4 || &res[0] == &other[0] {
 				iter--
 				continue
 			}
 			pos0 := m.rand(len(res) + 1)
 			pos1 := m.rand(len(other) - 2)
-			n := m.chooseLen(len(other)-pos1-2) + 2
+			n := min(m.chooseLen(len(other)-pos1-2)+2, maxSize-len(res))
+			if n <= 0 {
+				iter--
+				continue
+			}
+			m.parents = append(m.parents, otherInp.sig)
 			for i := 0; i < n; i++ {
 				res = append(res, 0)
 			}
@@ -346,19 +449,11 @@ func (m *Mutator) mutate(data []byte, ro *ROData) []byte {
 		case 18:
 			// Insert a literal.
 			// TODO: encode int literals in big-endian, base-128, etc.
-			if len(ro.intLits) == 0 && len(ro.strLits) == 0 {
+			lit := m.chooseLiteral(ro)
+			if lit == nil || len(res)+len(lit) > maxSize {
 				iter--
 				continue
 			}
-			var lit []byte
-			if len(ro.strLits) != 0 && m.r.Bool() {
-				lit = []byte(ro.strLits[m.rand(len(ro.strLits))])
-			} else {
-				lit = ro.intLits[m.rand(len(ro.intLits))]
-				if m.rand(3) == 0 {
-					lit = reverse(lit)
-				}
-			}
 			pos := m.rand(len(res) + 1)
 			for i := 0; i < len(lit); i++ {
 				res = append(res, 0)
@@ -367,36 +462,53 @@ func (m *Mutator) mutate(data []byte, ro *ROData) []byte {
 			copy(res[pos:], lit)
 		case 19:
 			// Replace with literal.
-			if len(ro.intLits) == 0 && len(ro.strLits) == 0 {
+			lit := m.chooseLiteral(ro)
+			if lit == nil {
 				iter--
 				continue
 			}
-			var lit []byte
-			if len(ro.strLits) != 0 && m.r.Bool() {
-				lit = []byte(ro.strLits[m.rand(len(ro.strLits))])
-			} else {
-				lit = ro.intLits[m.rand(len(ro.intLits))]
-				if m.rand(3) == 0 {
-					lit = reverse(lit)
-				}
-			}
 			if len(lit) >= len(res) {
 				iter--
 				continue
 			}
 			pos := m.rand(len(res) - len(lit))
 			copy(res[pos:], lit)
+		case 20:
+			// Splice with another corpus input. Splicing with itself or with
+			// a too similar input is skipped, but not retried: the corpus
+			// may have no suitable input.
+			if len(corpus) < 2 {
+				continue
+			}
+			other := m.chooseInput(ro)
+			if bytes.Equal(other.data, data) {
+				continue
+			}
+			tmp := m.splice(res, other.data)
+			if tmp == nil {
+				continue
+			}
+			res = tmp
+			m.parents = append(m.parents, other.sig)
+		default:
+			// Custom mutator registered with RegisterMutator.
+			tmp := customMutators[op-len(mutationNames)].m.Mutate(res, m.rng)
+			if tmp == nil {
+				continue
+			}
+			res = tmp
 		}
+		m.ops = append(m.ops, byte(op))
 	}
-	if len(res) > MaxInputSize {
-		res = res[:MaxInputSize]
+	if len(res) > maxSize {
+		res = res[:maxSize]
 	}
 	return res
 }
 
 // chooseLen chooses length of range mutation.
 // It gives preference to shorter ranges.
-func (m *Mutator) chooseLen(n int) int {
+func (m *mutator) chooseLen(n int) int {
 	switch x := m.rand(100); {
 	case x < 90:
 		return m.rand(min(8, n)) + 1
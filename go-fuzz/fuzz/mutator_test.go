@@ -0,0 +1,200 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+)
+
+func TestSplice(t *testing.T) {
+	m := newMutator()
+	for _, test := range [][2]string{
+		{"", ""},
+		{"abc", "abc"},
+		{"abc", "abcdef"},
+		{"abcdef", "abXdef"}, // single differing byte
+	} {
+		if res := m.splice([]byte(test[0]), []byte(test[1])); res != nil {
+			t.Errorf("splice(%q, %q) = %q, want nil", test[0], test[1], res)
+		}
+	}
+	data, other := []byte("0123456789"), []byte("abcdefghijklmnop")
+	for i := 0; i < 1000; i++ {
+		res := m.splice(data, other)
+		if len(res) != len(other) || bytes.Equal(res, data) || bytes.Equal(res, other) ||
+			res[0] != data[0] || res[len(res)-1] != other[len(other)-1] {
+			t.Fatalf("bad splice of %q and %q: %q", data, other, res)
+		}
+	}
+	big := bytes.Repeat([]byte{'a'}, MaxInputSize+10)
+	if res := m.splice([]byte("bb"), big); len(res) != MaxInputSize {
+		t.Fatalf("spliced input is %v bytes, max is %v", len(res), MaxInputSize)
+	}
+}
+
+func TestSpliceReachesBlock(t *testing.T) {
+	// Neither seed (nor mutations of a single seed, realistically) reaches
+	// the block, it requires the header of the first seed and the body of the second.
+	seeds := []string{
+		"HDR:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa",
+		"xxxxbbbbbbbbbbbbbbbbbbbbbbbbbbbb:END",
+	}
+	target := func(data []byte) bool {
+		return bytes.HasPrefix(data, []byte("HDR:")) && bytes.HasSuffix(data, []byte(":END"))
+	}
+	ro := &ROData{}
+	for i, seed := range seeds {
+		ro.corpus = append(ro.corpus, Input{data: []byte(seed), runningScoreSum: (i + 1) * defScore})
+	}
+	m := newMutator()
+	for i := 0; i < 100000; i++ {
+		if data, _ := m.generate(ro); target(data) {
+			return
+		}
+	}
+	t.Fatalf("splicing did not reach the block")
+}
+
+func TestMutateMaxInputSize(t *testing.T) {
+	const maxSize = 16
+	ro := &ROData{
+		maxInput: maxSize,
+		strLits:  [][]byte{[]byte("literal")},
+		intLits:  [][]byte{{1, 2, 3, 4}},
+	}
+	for i, seed := range []string{"0123456789", "0123456789abcdefghijklmnopqrstuvwxyz"} {
+		ro.corpus = append(ro.corpus, Input{data: []byte(seed), runningScoreSum: (i + 1) * defScore})
+	}
+	m := newMutator()
+	grown := false
+	for i := 0; i < 100000; i++ {
+		data, _ := m.generate(ro)
+		if len(data) > maxSize {
+			t.Fatalf("generated input of size %v, max is %v: %q", len(data), maxSize, data)
+		}
+		grown = grown || len(data) == maxSize
+	}
+	if !grown {
+		t.Fatalf("inputs never grew up to the max size")
+	}
+}
+
+func TestMutateStringLiteralsOnly(t *testing.T) {
+	// go-fuzz-build drops int literals that duplicate string literals,
+	// so a dictionary can have only strings.
+	ro := &ROData{
+		strLits: [][]byte{[]byte("literal")},
+		corpus:  []Input{{data: []byte("0123456789"), runningScoreSum: defScore}},
+	}
+	m := newMutator()
+	used := false
+	for i := 0; i < 10000; i++ {
+		data, _ := m.generate(ro)
+		used = used || bytes.Contains(data, []byte("literal"))
+	}
+	if !used {
+		t.Fatalf("string literal is never used")
+	}
+}
+
+func TestSpliceWeight(t *testing.T) {
+	defer func(old string) { *flagMutatorWeights = old }(*flagMutatorWeights)
+
+	ro := &ROData{}
+	for i, seed := range []string{"0123456789", "abcdefghijklmnop"} {
+		ro.corpus = append(ro.corpus, Input{data: []byte(seed), sig: hash([]byte(seed)), runningScoreSum: (i + 1) * defScore})
+	}
+	// spliced returns the number of spliced inputs out of 1000
+	// and the number of inputs with other mutations.
+	spliced := func() (n, other int) {
+		m := newMutator()
+		for i := 0; i < 1000; i++ {
+			m.generate(ro)
+			if bytes.IndexByte(m.ops, mutSplice) != -1 {
+				n++
+			}
+			for _, op := range m.ops {
+				if op != mutSplice {
+					other++
+					break
+				}
+			}
+		}
+		return n, other
+	}
+	*flagMutatorWeights = "splice=0"
+	if n, _ := spliced(); n != 0 {
+		t.Fatalf("%v out of 1000 inputs are spliced with splice=0", n)
+	}
+	var weights []string
+	for _, name := range mutationNames[:mutSplice] {
+		weights = append(weights, name+"=0")
+	}
+	*flagMutatorWeights = strings.Join(weights, ",") + ",splice=3"
+	if n, other := spliced(); n == 0 || other != 0 {
+		t.Fatalf("with 0 weights of other mutations %v out of 1000 inputs are spliced, %v have other mutations", n, other)
+	}
+	// Splicing is skipped, but generate does not hang if no input can be spliced.
+	ro.corpus = ro.corpus[:1]
+	if n, _ := spliced(); n != 0 {
+		t.Fatalf("%v out of 1000 inputs are spliced with a single corpus input", n)
+	}
+}
+
+type markerMutator struct{}
+
+func (markerMutator) Mutate(input []byte, rng *rand.Rand) []byte {
+	return append(input, "MARKER"...)
+}
+
+func TestCustomMutator(t *testing.T) {
+	defer func(old []namedMutator) { customMutators = old }(customMutators)
+	defer func(old string) { *flagMutatorWeights = old }(*flagMutatorWeights)
+	RegisterMutator("marker", markerMutator{})
+
+	ro := &ROData{corpus: []Input{{data: []byte("0123456789"), runningScoreSum: defScore}}}
+	generate := func() (marked int) {
+		m := newMutator()
+		for i := 0; i < 1000; i++ {
+			data, _ := m.generate(ro)
+			if !bytes.Contains(data, []byte("MARKER")) {
+				continue
+			}
+			marked++
+			found := false
+			for _, op := range m.ops {
+				found = found || mutationName(op) == "marker"
+			}
+			if !found {
+				t.Fatalf("marked input %q has mutations %v", data, m.ops)
+			}
+		}
+		return marked
+	}
+	// The custom mutator is one of the weighted strategies.
+	*flagMutatorWeights = ""
+	if marked := generate(); marked == 0 || marked == 1000 {
+		t.Fatalf("%v out of 1000 inputs are marked with default weights", marked)
+	}
+	// Only the custom mutator is chosen if weights of all built-in mutations are 0.
+	var weights []string
+	for _, name := range mutationNames {
+		weights = append(weights, name+"=0")
+	}
+	*flagMutatorWeights = strings.Join(weights, ",")
+	if marked := generate(); marked != 1000 {
+		t.Fatalf("%v out of 1000 inputs are marked with 0 weights of built-in mutations", marked)
+	}
+
+	for _, spec := range []string{"marker", "marker=-1", "nosuchmutator=1", strings.Join(weights, ",") + ",marker=0"} {
+		if _, err := parseStrategyWeights(spec); err == nil {
+			t.Errorf("bad weights %q are accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// nativeCorpusHeader is the first line of seed corpus files
+// written by Go native fuzzing (testing.F).
+const nativeCorpusHeader = "go test fuzz v1"
+
+// readNativeCorpus reads seed inputs stored by Go native fuzzing in dir
+// (usually testdata/fuzz/FuzzXxx). Only files with a single []byte value
+// can be used as go-fuzz inputs, other files are skipped with a warning.
+func readNativeCorpus(dir string) [][]byte {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("failed to read native corpus dir: %v", err)
+		}
+		return nil
+	}
+	var corpus [][]byte
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		fname := filepath.Join(dir, f.Name())
+		data, err := ioutil.ReadFile(fname)
+		if err != nil {
+			log.Printf("failed to read native corpus file: %v", err)
+			continue
+		}
+		input, err := parseNativeCorpus(data)
+		if err != nil {
+			log.Printf("skipping native corpus file %v: %v", fname, err)
+			continue
+		}
+		corpus = append(corpus, input)
+	}
+	return corpus
+}
+
+// parseNativeCorpus parses contents of a native corpus file and returns its []byte value.
+// The format is the "go test fuzz v1" header followed by one Go expression
+// per line for each argument of the fuzz function, e.g. []byte("\x00foo").
+func parseNativeCorpus(data []byte) ([]byte, error) {
+	lines := bytes.Split(data, []byte("\n"))
+	if string(bytes.TrimSpace(lines[0])) != nativeCorpusHeader {
+		return nil, fmt.Errorf("missing %q header", nativeCorpusHeader)
+	}
+	var values [][]byte
+	for _, line := range lines[1:] {
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+		values = append(values, line)
+	}
+	if len(values) != 1 {
+		return nil, fmt.Errorf("got %v values, only a single []byte value is supported", len(values))
+	}
+	return parseNativeBytes(string(values[0]))
+}
+
+// parseNativeBytes parses []byte("...") expression.
+func parseNativeBytes(line string) ([]byte, error) {
+	expr, err := parser.ParseExpr(line)
+	if err != nil {
+		return nil, fmt.Errorf("malformed value %q: %v", line, err)
+	}
+	call, ok := expr.(*ast.CallExpr)
+	if !ok || len(call.Args) != 1 {
+		return nil, fmt.Errorf("malformed value %q: expected type conversion", line)
+	}
+	typ, ok := call.Fun.(*ast.ArrayType)
+	if !ok || typ.Len != nil {
+		return nil, fmt.Errorf("unsupported value %q: only []byte is supported", line)
+	}
+	if elem, ok := typ.Elt.(*ast.Ident); !ok || elem.Name != "byte" && elem.Name != "uint8" {
+		return nil, fmt.Errorf("unsupported value %q: only []byte is supported", line)
+	}
+	lit, ok := call.Args[0].(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return nil, fmt.Errorf("malformed value %q: expected string literal", line)
+	}
+	s, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return nil, fmt.Errorf("malformed value %q: %v", line, err)
+	}
+	return []byte(s), nil
+}
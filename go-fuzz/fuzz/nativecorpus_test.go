@@ -0,0 +1,113 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseNativeCorpus(t *testing.T) {
+	tests := []struct {
+		data string
+		want string
+		err  bool
+	}{
+		{"go test fuzz v1\n[]byte(\"\")\n", "", false},
+		{"go test fuzz v1\n[]byte(\"abc\")\n", "abc", false},
+		{"go test fuzz v1\n[]byte(\"abc\")", "abc", false},
+		{"go test fuzz v1\r\n[]byte(\"abc\")\r\n", "abc", false},
+		{"go test fuzz v1\n\n  []byte(\"abc\")  \n\n", "abc", false},
+		{"go test fuzz v1\n[]uint8(\"abc\")\n", "abc", false},
+		{"go test fuzz v1\n[]byte(`a\\b\"c`)\n", "a\\b\"c", false},
+		// Escapes produced by the standard library with %q.
+		{"go test fuzz v1\n[]byte(\"\\x00\\x01\\xff\")\n", "\x00\x01\xff", false},
+		{"go test fuzz v1\n[]byte(\"\\a\\b\\f\\n\\r\\t\\v\")\n", "\a\b\f\n\r\t\v", false},
+		{"go test fuzz v1\n[]byte(\"\\\\\\\"'\")\n", "\\\"'", false},
+		{"go test fuzz v1\n[]byte(\"привет\")\n", "привет", false},
+		// Escapes that are not produced by %q, but are valid Go.
+		{"go test fuzz v1\n[]byte(\"\\u00e9\\U0001F600\")\n", "\u00e9\U0001F600", false},
+		{"go test fuzz v1\n[]byte(\"\\000\\177\\377\")\n", "\000\177\377", false},
+		{"go test fuzz v1\n[]byte(\"\\'\")\n", "", true},
+		{"go test fuzz v1\n[]byte(\"\\x0\")\n", "", true},
+		{"go test fuzz v1\n[]byte(\"abc)\n", "", true},
+		{"go test fuzz v1\n[]byte('a')\n", "", true},
+		{"go test fuzz v1\n[]byte(x)\n", "", true},
+		{"go test fuzz v1\n[]byte(\"a\", \"b\")\n", "", true},
+		{"go test fuzz v1\n[4]byte(\"abcd\")\n", "", true},
+		{"go test fuzz v1\n[]int(\"abc\")\n", "", true},
+		{"go test fuzz v1\nstring(\"abc\")\n", "", true},
+		{"go test fuzz v1\nint(1)\n", "", true},
+		{"go test fuzz v1\n[]byte(\"a\")\nstring(\"b\")\n", "", true},
+		{"go test fuzz v1\n", "", true},
+		{"go test fuzz v2\n[]byte(\"abc\")\n", "", true},
+		{"[]byte(\"abc\")\n", "", true},
+		{"", "", true},
+	}
+	for _, test := range tests {
+		got, err := parseNativeCorpus([]byte(test.data))
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: parsed as %q, want error", test.data, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", test.data, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%q: got %q, want %q", test.data, got, test.want)
+		}
+	}
+}
+
+func TestParseNativeCorpusRoundTrip(t *testing.T) {
+	// The standard library marshals []byte values with %q.
+	var data []byte
+	for i := 0; i < 256; i++ {
+		data = append(data, byte(i))
+	}
+	data = append(data, "日本語\u2028\ufeff"...)
+	for i := 0; i <= len(data); i++ {
+		file := fmt.Sprintf("go test fuzz v1\n[]byte(%q)\n", data[i:])
+		got, err := parseNativeCorpus([]byte(file))
+		if err != nil {
+			t.Fatalf("%q: %v", file, err)
+		}
+		if !bytes.Equal(got, data[i:]) {
+			t.Fatalf("%q: got %q, want %q", file, got, data[i:])
+		}
+	}
+}
+
+func TestReadNativeCorpus(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go-fuzz-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"seed1": "go test fuzz v1\n[]byte(\"foo\")\n",
+		"seed2": "go test fuzz v1\n[]byte(\"bar\")\nint(1)\n",
+		"seed3": "garbage",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0660); err != nil {
+			t.Fatal(err)
+		}
+	}
+	corpus := readNativeCorpus(dir)
+	if len(corpus) != 1 || string(corpus[0]) != "foo" {
+		t.Fatalf("got corpus %q, want [foo]", corpus)
+	}
+	if corpus := readNativeCorpus(filepath.Join(dir, "nonexistent")); len(corpus) != 0 {
+		t.Fatalf("got corpus %q for nonexistent dir", corpus)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+)
+
+// Differential fuzz functions of the form func([]byte) []byte return an output
+// signature (e.g. a digest of results of two implementations). The test binary
+// hashes it, and inputs that produce a new hash are kept in corpus even without
+// new coverage, the same way as inputs for which Fuzz returns 1.
+
+// outputSig returns hash of the output of the last execution of a differential
+// fuzz function, ok is false if it is not known (e.g. the test binary was restarted).
+func (bin *TestBinary) outputSig() (sig uint64, ok bool) {
+	valid, sig, _, _, _, crashed, _ := bin.run(OutputFunc, nil)
+	if crashed || valid != 1 {
+		return 0, false
+	}
+	return sig, true
+}
+
+// updateOutputs records output signature of an execution,
+// it returns whether the signature is new.
+func (hub *Hub) updateOutputs(sig uint64) bool {
+	hub.outputsMu.Lock()
+	defer hub.outputsMu.Unlock()
+	if _, ok := hub.outputs[sig]; ok {
+		return false
+	}
+	hub.outputs[sig] = struct{}{}
+	return true
+}
+
+// noteNewOutput queues data for triage if the last execution of it in bin
+// produced a new output signature, it returns whether data was queued.
+func (w *Worker) noteNewOutput(bin *TestBinary, data []byte, res, depth int, typ execType) bool {
+	if !w.hub.output || res < 0 {
+		return false
+	}
+	sig, ok := bin.outputSig()
+	if !ok || !w.hub.updateOutputs(sig) {
+		return false
+	}
+	w.triageQueue = append(w.triageQueue, CoordinatorInput{makeCopy(data), uint64(depth), typ, false, false, w.provenance(typ)})
+	return true
+}
+
+// newOutput reports whether output signature of inp is not produced by any corpus input.
+func (ro *ROData) newOutput(inp *Input) bool {
+	if !inp.outputOK {
+		return false
+	}
+	_, ok := ro.corpusOutputs[inp.output]
+	return !ok
+}
@@ -1,7 +1,7 @@
 // Copyright 2015 go-fuzz project authors. All rights reserved.
 // Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
 
-package main
+package fuzz
 
 import (
 	"crypto/sha1"
@@ -16,8 +16,10 @@ import (
 
 // PersistentSet is a set of binary blobs with a persistent mirror on disk.
 type PersistentSet struct {
-	dir string
-	m   map[Sig]Artifact
+	dir  string
+	m    map[Sig]Artifact
+	keep func(sig Sig) bool // if set, only matching files are read in (see -shard)
+	dups int                // number of files read in that duplicate contents of other files
 }
 
 type Artifact struct {
@@ -33,9 +35,16 @@ func hash(data []byte) Sig {
 }
 
 func newPersistentSet(dir string) *PersistentSet {
+	return newFilteredSet(dir, nil)
+}
+
+// newFilteredSet creates a set that contains only files in dir for which keep returns true,
+// other files stay in dir.
+func newFilteredSet(dir string, keep func(sig Sig) bool) *PersistentSet {
 	ps := &PersistentSet{
-		dir: dir,
-		m:   make(map[Sig]Artifact),
+		dir:  dir,
+		m:    make(map[Sig]Artifact),
+		keep: keep,
 	}
 	os.MkdirAll(dir, 0770)
 	ps.readInDir(dir)
@@ -49,6 +58,9 @@ func (ps *PersistentSet) readInDir(dir string) {
 			return nil
 		}
 		if info.IsDir() {
+			if path != dir && info.Name() == shardStateDir {
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		data, err := ioutil.ReadFile(path)
@@ -57,14 +69,19 @@ func (ps *PersistentSet) readInDir(dir string) {
 			return nil
 		}
 		sig := hash(data)
-		if _, ok := ps.m[sig]; ok {
-			return nil
-		}
 		name := info.Name()
 		const hexLen = 2 * sha1.Size
 		if len(name) > hexLen+1 && isHexString(name[:hexLen]) && name[hexLen] == '.' {
 			return nil // description file
 		}
+		if ps.keep != nil && !ps.keep(sig) {
+			return nil
+		}
+		if _, ok := ps.m[sig]; ok {
+			// Byte-identical inputs are loaded once, the files are left intact.
+			ps.dups++
+			return nil
+		}
 		var meta uint64
 		if len(name) > hexLen+1 && isHexString(name[:hexLen]) && name[hexLen] == '-' {
 			meta, _ = strconv.ParseUint(name[2*sha1.Size+1:], 10, 64)
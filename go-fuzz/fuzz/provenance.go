@@ -0,0 +1,201 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Provenance describes where a corpus input came from.
+// It is stored as JSON in <sig>.meta file next to the input in the corpus dir.
+type Provenance struct {
+	Origin    string   // seed, mutation, splice, sonar, smash, versifier or minimize
+	Parents   []Sig    // corpus inputs the input was derived from, the mutated one first
+	Mutations []string // mutations that produced the input, in order of application
+	NewBlocks []int    // coverage counters that the input covered first
+}
+
+// Origins of corpus inputs.
+const (
+	originSeed      = "seed"
+	originMutation  = "mutation"
+	originSplice    = "splice"
+	originSonar     = "sonar"
+	originSmash     = "smash"
+	originVersifier = "versifier"
+	originMinimize  = "minimize"
+)
+
+// mutationNames are names of mutations in provenance indexed by the mutate switch case,
+// the last one is splicing of 2 inputs. Custom mutators follow it, see mutationName.
+var mutationNames = [...]string{
+	"remove-range", "insert-random", "duplicate-range", "copy-range",
+	"bit-flip", "random-byte", "swap-bytes", "arith8", "arith16", "arith32", "arith64",
+	"interesting8", "interesting16", "interesting32", "digit", "number",
+	"splice-range", "insert-range", "insert-literal", "replace-literal",
+	"splice",
+}
+
+const mutSplice = byte(len(mutationNames) - 1)
+
+// MarshalText encodes sig as hex in provenance files.
+func (sig Sig) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(sig[:])), nil
+}
+
+// UnmarshalText decodes hex-encoded sig.
+func (sig *Sig) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != len(sig) {
+		return fmt.Errorf("bad input hash %q", text)
+	}
+	_, err := hex.Decode(sig[:], text)
+	return err
+}
+
+// provenance returns provenance of a new input of type typ
+// derived from w.parents with w.ops mutations.
+func (w *Worker) provenance(typ execType) *Provenance {
+	p := new(Provenance)
+	for _, sig := range w.parents {
+		dup := false
+		for _, sig1 := range p.Parents {
+			dup = dup || sig1 == sig
+		}
+		if !dup {
+			p.Parents = append(p.Parents, sig)
+		}
+	}
+	for _, op := range w.ops {
+		p.Mutations = append(p.Mutations, mutationName(op))
+	}
+	switch typ {
+	case execBootstrap, execCorpus:
+		p.Origin = originSeed
+	case execMinimizeInput:
+		p.Origin = originMinimize
+	case execVersifier:
+		p.Origin = originVersifier
+	case execSmash:
+		p.Origin = originSmash
+	case execSonarHint:
+		p.Origin = originSonar
+	default:
+		p.Origin = originMutation
+		if bytes.IndexByte(w.ops, mutSplice) != -1 {
+			p.Origin = originSplice
+		}
+	}
+	return p
+}
+
+// newBlocks returns coverage counters that are covered by cover, but not by base.
+func newBlocks(base, cover []byte) []int {
+	var res []int
+	for i, c := range cover {
+		if c != 0 && base[i] == 0 {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// readProvenance reads provenance of corpus input sig from dir, it returns nil if there is none.
+func readProvenance(dir string, sig Sig) *Provenance {
+	data, err := ioutil.ReadFile(filepath.Join(dir, hex.EncodeToString(sig[:])+".meta"))
+	if err != nil {
+		return nil
+	}
+	p := new(Provenance)
+	if err := json.Unmarshal(data, p); err != nil {
+		return nil
+	}
+	return p
+}
+
+// dumpCorpusMetaMain prints provenance of all corpus inputs,
+// parents are printed before inputs derived from them.
+func dumpCorpusMetaMain() {
+	workdir := *flagWorkdir
+	if *flagFunc != "" {
+		// Each function of a multi-function binary has own workdir.
+		if _, err := os.Stat(filepath.Join(workdir, *flagFunc)); err == nil {
+			workdir = filepath.Join(workdir, *flagFunc)
+		}
+	}
+	dir := corpusDir(workdir)
+	corpus := newPersistentSet(dir)
+	provs := make(map[Sig]*Provenance)
+	for sig := range corpus.m {
+		provs[sig] = readProvenance(dir, sig)
+	}
+	// Generation of an input is 1 + max generation of its parents that are in corpus.
+	gens := make(map[Sig]int)
+	var gen func(sig Sig) int
+	gen = func(sig Sig) int {
+		if g, ok := gens[sig]; ok {
+			return g
+		}
+		gens[sig] = 0 // breaks cycles
+		g := 0
+		if p := provs[sig]; p != nil {
+			for _, parent := range p.Parents {
+				if _, ok := corpus.m[parent]; ok && parent != sig {
+					if pg := gen(parent) + 1; g < pg {
+						g = pg
+					}
+				}
+			}
+		}
+		gens[sig] = g
+		return g
+	}
+	sigs := make([]Sig, 0, len(corpus.m))
+	for sig := range corpus.m {
+		gen(sig)
+		sigs = append(sigs, sig)
+	}
+	sort.Slice(sigs, func(i, j int) bool {
+		if gens[sigs[i]] != gens[sigs[j]] {
+			return gens[sigs[i]] < gens[sigs[j]]
+		}
+		return bytes.Compare(sigs[i][:], sigs[j][:]) < 0
+	})
+	for _, sig := range sigs {
+		fmt.Println(formatProvenance(sig, provs[sig], corpus.m[sig].user))
+	}
+}
+
+// formatProvenance formats provenance p of input sig as a single line.
+func formatProvenance(sig Sig, p *Provenance, user bool) string {
+	s := hex.EncodeToString(sig[:])
+	if p == nil {
+		if user {
+			return s + " " + originSeed
+		}
+		return s + " unknown"
+	}
+	s += " " + p.Origin
+	if len(p.Parents) != 0 {
+		var parents []string
+		for _, parent := range p.Parents {
+			parents = append(parents, hex.EncodeToString(parent[:]))
+		}
+		s += " parents=" + strings.Join(parents, ",")
+	}
+	if len(p.Mutations) != 0 {
+		s += " mutations=" + strings.Join(p.Mutations, ",")
+	}
+	if len(p.NewBlocks) != 0 {
+		s += fmt.Sprintf(" newblocks=%v", len(p.NewBlocks))
+	}
+	return s
+}
@@ -0,0 +1,69 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSpliceProvenance(t *testing.T) {
+	workdir, cleanup := testWorkdir(t)
+	defer cleanup()
+
+	seeds := []string{
+		"0123456789abcdef",
+		"ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	}
+	ro := &ROData{}
+	for i, seed := range seeds {
+		ro.corpus = append(ro.corpus, Input{data: []byte(seed), sig: hash([]byte(seed)), runningScoreSum: (i + 1) * defScore})
+	}
+	w := &Worker{mutator: newMutator()}
+	var data []byte
+	for i := 0; ; i++ {
+		if i == 100000 {
+			t.Fatalf("generate did not splice inputs")
+		}
+		data, _ = w.mutator.generate(ro)
+		if bytes.IndexByte(w.mutator.ops, mutSplice) != -1 {
+			break
+		}
+	}
+	w.parents, w.ops = w.mutator.parents, w.mutator.ops
+	prov := w.provenance(execFuzz)
+	if prov.Origin != originSplice {
+		t.Fatalf("spliced input has origin %q, want %q", prov.Origin, originSplice)
+	}
+
+	c := newCoordinator()
+	var res ConnectRes
+	if err := c.Connect(&ConnectArgs{Procs: 1, Func: "Fuzz", Funcs: 1}, &res); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.NewInput(&NewInputArgs{ID: res.ID, Data: data, Prov: prov}, nil); err != nil {
+		t.Fatal(err)
+	}
+	got := readProvenance(corpusDir(workdir), hash(data))
+	if got == nil {
+		t.Fatalf("no provenance for new input")
+	}
+	if !reflect.DeepEqual(got, prov) {
+		t.Fatalf("read provenance %+v, want %+v", got, prov)
+	}
+	for _, seed := range seeds {
+		found := false
+		for _, parent := range got.Parents {
+			found = found || parent == hash([]byte(seed))
+		}
+		if !found {
+			t.Errorf("parent %q is not recorded in provenance %+v", seed, got)
+		}
+	}
+	if line := formatProvenance(hash(data), got, false); !strings.Contains(line, " splice parents=") {
+		t.Errorf("bad provenance line: %v", line)
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+// replayMain runs the fuzz function once on -run input without any mutations
+// and returns process exit status: 1 if the input crashes the function, 0 otherwise.
+// The input is executed by the coverage binary, so -coverprofile can be used
+// to get coverage of this single input.
+func replayMain() int {
+	data, err := ioutil.ReadFile(*flagRun)
+	if err != nil {
+		log.Fatalf("failed to read input: %v", err)
+	}
+	if len(data) > MaxInputSize {
+		log.Fatalf("input is too large: %v bytes, max %v", len(data), MaxInputSize)
+	}
+	arch, err := openBinArchive()
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	defer arch.cleanup()
+
+	var stats Stats
+	bin := newTestBinary(arch.coverBin, func() {}, &stats, arch.fnidx)
+	defer bin.close()
+	res, _, _, _, output, crashed, _ := bin.test(data)
+	if *flagCoverProfile != "" {
+		coverBlocks := make(map[int][]CoverBlock)
+		for _, b := range arch.metadata.Blocks {
+			coverBlocks[b.ID] = append(coverBlocks[b.ID], b)
+		}
+		dumpCoverProfile(*flagCoverProfile, coverBlocks, makeCopy(bin.coverRegion))
+	}
+	if crashed {
+		if len(output) != 0 && output[len(output)-1] != '\n' {
+			output = append(output, '\n')
+		}
+		os.Stderr.Write(output)
+		fmt.Printf("%v crashed\n", arch.fn)
+		return 1
+	}
+	fmt.Printf("%v returned %v\n", arch.fn, res)
+	return 0
+}
@@ -0,0 +1,124 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// The number of workers of a worker process (-procs) can be changed at runtime
+// with POST /procs?n=N on -metrics address. The coordinator passes the new value
+// to worker processes on the next sync, and hubs start or stop workers.
+// Corpus and coverage are shared by all workers of a hub, so they are preserved.
+
+// scale starts or stops workers, so that n workers are running.
+// Stopped workers finish the input being tested and their local queues first.
+func (hub *Hub) scale(n int) {
+	hub.procsMu.Lock()
+	defer hub.procsMu.Unlock()
+
+	for len(hub.running) < n {
+		hub.running = append(hub.running, hub.startWorker(len(hub.running)))
+	}
+	for len(hub.running) > n {
+		last := len(hub.running) - 1
+		atomic.StoreUint32(&hub.running[last].stop, 1)
+		hub.running[last] = nil
+		hub.running = hub.running[:last]
+	}
+	hub.procs = n
+	if procs := min(n, runtime.NumCPU()); runtime.GOMAXPROCS(0) < procs {
+		runtime.GOMAXPROCS(procs)
+	}
+}
+
+// numProcs returns the number of workers that the hub runs.
+func (hub *Hub) numProcs() int {
+	hub.procsMu.Lock()
+	defer hub.procsMu.Unlock()
+	return hub.procs
+}
+
+// startWorker starts worker with index i, the index selects its seed with -seed
+// and its CPU with -affinity.
+func (hub *Hub) startWorker(i int) *Worker {
+	arch := hub.arch
+	w := &Worker{
+		id:           i,
+		hub:          hub,
+		mutator:      newMutator(),
+		canonicalize: arch.metadata.Canonicalize,
+	}
+	if *flagSeed != 0 {
+		w.mutator = newSeededMutator(uint64(*flagSeed) + uint64(i))
+	}
+	w.coverBin = newTestBinary(arch.coverBin, w.periodicCheck, &w.stats, arch.fnidx)
+	w.sonarBin = newTestBinary(arch.sonarBin, w.periodicCheck, &w.stats, arch.fnidx)
+	w.coverBin.strayCrash = w.noteStrayCrash
+	w.sonarBin.strayCrash = w.noteStrayCrash
+	if *flagAffinity {
+		w.coverBin.cpu = i
+		w.sonarBin.cpu = i
+	}
+	hub.workers.Add(1)
+	go func() {
+		defer hub.workers.Done()
+		w.loop()
+	}()
+	return w
+}
+
+// stopped returns true if the worker is stopped by scale
+// and it has processed all queued inputs.
+func (w *Worker) stopped() bool {
+	return atomic.LoadUint32(&w.stop) != 0 && len(w.crasherQueue) == 0 && len(w.triageQueue) == 0
+}
+
+// procsHandler serves /procs on -metrics address. GET returns the requested number
+// of workers per worker process (0 if it was not changed) and the current total
+// number of workers, POST with n=N sets -procs of all worker processes.
+// If -authtoken is set, POST requests must present it as a bearer token.
+func (c *Coordinator) procsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if err := c.checkToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		n, err := strconv.Atoi(r.FormValue("n"))
+		if err != nil || n <= 0 {
+			http.Error(w, "bad n value, want a positive number of workers", http.StatusBadRequest)
+			return
+		}
+		c.setProcs(n)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	c.mu.Lock()
+	procs := c.procs
+	c.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Procs   int    `json:"procs"`
+		Workers uint64 `json:"workers"`
+	}{procs, c.coordinatorStats().Workers})
+}
+
+// setProcs requests n workers in every worker process.
+func (c *Coordinator) setProcs(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.procs != n {
+		log.Printf("scaling worker processes to %v workers", n)
+	}
+	c.procs = n
+}
@@ -0,0 +1,221 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+)
+
+// With -shard=i/n several coordinators share a corpus dir (e.g. on a network file system),
+// but every coordinator loads and fuzzes only inputs of its shard: inputs whose hash
+// modulo n is i. The assignment depends only on the input, so new inputs are spread
+// evenly over the shards as the corpus grows and all coordinators agree on the owner.
+// New inputs of other shards found by workers of a coordinator are written into
+// the corpus dir, but not fuzzed, the owner picks them up when it rescans the dir.
+// Coordinators also exchange max coverage through files in <corpus>/.shards,
+// so that workers of a shard don't rediscover coverage of other shards.
+
+// shardPeriod is how often a coordinator rescans the corpus dir and exchanges coverage with other shards.
+const shardPeriod = 10 * time.Second
+
+// shardStateDir is a subdir of the corpus dir with coverage of the shards, it is not a part of the corpus.
+const shardStateDir = ".shards"
+
+// parseShard parses -shard value i/n, n is 0 if s is empty.
+func parseShard(s string) (shard, shards int, err error) {
+	if s == "" {
+		return 0, 0, nil
+	}
+	i := strings.IndexByte(s, '/')
+	if i == -1 {
+		return 0, 0, fmt.Errorf("bad -shard value %q, want i/n", s)
+	}
+	shard, err1 := strconv.Atoi(s[:i])
+	shards, err2 := strconv.Atoi(s[i+1:])
+	if err1 != nil || err2 != nil || shards <= 0 || shard < 0 || shard >= shards {
+		return 0, 0, fmt.Errorf("bad -shard value %q, want i/n with 0 <= i < n", s)
+	}
+	return shard, shards, nil
+}
+
+// inShard reports whether input with hash sig belongs to shard of shards (0 means no sharding).
+func inShard(sig Sig, shard, shards int) bool {
+	return shards == 0 || binary.BigEndian.Uint64(sig[:8])%uint64(shards) == uint64(shard)
+}
+
+// ownsInput reports whether input with hash sig belongs to the coordinator's shard.
+func (c *Coordinator) ownsInput(sig Sig) bool {
+	return inShard(sig, c.shard, c.shards)
+}
+
+// listCorpusDir marks files that are already in corpus dir as seen by rescanCorpus.
+func (c *Coordinator) listCorpusDir() {
+	c.shardSeen = make(map[string]bool)
+	files, err := ioutil.ReadDir(c.corpus.dir)
+	if err != nil {
+		log.Printf("failed to read corpus dir: %v", err)
+		return
+	}
+	for _, f := range files {
+		c.shardSeen[f.Name()] = true
+	}
+}
+
+// routeInput writes new input of another shard into the shared corpus dir,
+// where the owning coordinator picks it up. The input is not added to corpus.
+func (c *Coordinator) routeInput(a *NewInputArgs) {
+	sig := hash(a.Data)
+	fname := persistentFilename(c.corpus.dir, Artifact{a.Data, a.Prio, false}, sig)
+	if c.shardSeen[filepath.Base(fname)] {
+		return
+	}
+	c.shardSeen[filepath.Base(fname)] = true
+	if a.Prov != nil {
+		if data, err := json.Marshal(a.Prov); err == nil {
+			c.corpus.addDescription(a.Data, data, "meta")
+		}
+	}
+	if err := ioutil.WriteFile(fname, a.Data, 0660); err != nil {
+		log.Printf("failed to write file: %v", err)
+	}
+}
+
+// rescanCorpus adds inputs of the coordinator's shard that other coordinators
+// wrote into the corpus dir, the inputs are sent to all workers.
+func (c *Coordinator) rescanCorpus() {
+	files, err := ioutil.ReadDir(c.corpus.dir)
+	if err != nil {
+		log.Printf("failed to read corpus dir: %v", err)
+		return
+	}
+	const hexLen = 2 * len(Sig{})
+	added := 0
+	for _, f := range files {
+		name := f.Name()
+		if c.shardSeen[name] || f.IsDir() {
+			continue
+		}
+		var nameSig Sig
+		generated := len(name) >= hexLen && isHexString(name[:hexLen])
+		if generated {
+			if len(name) > hexLen && name[hexLen] == '.' {
+				c.shardSeen[name] = true
+				continue // description file
+			}
+			hex.Decode(nameSig[:], []byte(name[:hexLen]))
+			if !c.ownsInput(nameSig) {
+				c.shardSeen[name] = true
+				continue
+			}
+		}
+		data, err := ioutil.ReadFile(filepath.Join(c.corpus.dir, name))
+		if err != nil {
+			log.Printf("error during file read: %v", err)
+			continue
+		}
+		sig := hash(data)
+		if generated && sig != nameSig {
+			continue // the file is still being written, retry on the next rescan
+		}
+		c.shardSeen[name] = true
+		if _, ok := c.corpus.m[sig]; ok || !c.ownsInput(sig) {
+			continue
+		}
+		var meta uint64
+		if generated && len(name) > hexLen+1 && name[hexLen] == '-' {
+			meta, _ = strconv.ParseUint(name[hexLen+1:], 10, 64)
+		}
+		c.corpus.m[sig] = Artifact{data, meta, !generated}
+		for _, w := range c.workers {
+			w.pending = append(w.pending, CoordinatorInput{data, meta, execCorpus, generated, generated, nil})
+		}
+		added++
+	}
+	if added != 0 {
+		c.lastInput = time.Now()
+		log.Printf("shard: added %v inputs from corpus dir", added)
+	}
+}
+
+// shardCover is coverage of a shard saved into <corpus>/.shards/cover-<i>.
+type shardCover struct {
+	CoverTotal int    // number of distinct coverage counters in the test binary
+	Cover      []byte // max coverage of the shard, including coverage from other shards
+}
+
+// exchangeCover saves max coverage of the coordinator for other shards
+// and merges max coverage of other shards into it.
+func (c *Coordinator) exchangeCover() {
+	dir := filepath.Join(c.corpus.dir, shardStateDir)
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		log.Printf("failed to create shard dir: %v", err)
+		return
+	}
+	own := fmt.Sprintf("cover-%v", c.shard)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Printf("failed to read shard dir: %v", err)
+		return
+	}
+	for _, f := range files {
+		if f.Name() == own || !strings.HasPrefix(f.Name(), "cover-") || strings.HasSuffix(f.Name(), ".tmp") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			continue
+		}
+		var sc shardCover
+		if json.Unmarshal(data, &sc) != nil || sc.CoverTotal != c.coverTotal || len(sc.Cover) != CoverSize {
+			continue // a different test binary or a partially written file
+		}
+		if c.cover == nil {
+			c.cover = make([]byte, CoverSize)
+		}
+		if compareCover(c.cover, sc.Cover) {
+			updateMaxCover(c.cover, sc.Cover)
+			c.coverVersion++
+		}
+	}
+	if c.cover == nil {
+		return
+	}
+	data, err := json.Marshal(&shardCover{c.coverTotal, c.cover})
+	if err != nil {
+		return
+	}
+	fname := filepath.Join(dir, own)
+	if err := ioutil.WriteFile(fname+".tmp", data, 0660); err != nil {
+		log.Printf("failed to write shard cover: %v", err)
+		return
+	}
+	if err := os.Rename(fname+".tmp", fname); err != nil {
+		log.Printf("failed to write shard cover: %v", err)
+	}
+}
+
+// syncShards rescans corpus dir and exchanges coverage with other shards,
+// it is a no-op without -shard or until the first worker connects.
+func (c *Coordinator) syncShards() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.shards == 0 || c.corpus == nil {
+		return
+	}
+	c.rescanCorpus()
+	c.exchangeCover()
+}
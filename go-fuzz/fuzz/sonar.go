@@ -1,13 +1,14 @@
 // Copyright 2015 go-fuzz project authors. All rights reserved.
 // Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
 
-package main
+package fuzz
 
 import (
 	"bytes"
 	"encoding/binary"
 	"encoding/hex"
 	"log"
+	"math"
 	"path/filepath"
 	"strconv"
 	"sync"
@@ -17,8 +18,10 @@ import (
 )
 
 type SonarSite struct {
-	id  int    // unique site id (const)
-	loc string // file:line.pos,line.pos (const)
+	id    int    // unique site id (const)
+	loc   string // file:line.pos,line.pos (const)
+	float bool   // float comparison, operands are IEEE-754 values (const)
+	width int    // static width of integer operands in bytes, 0 if unknown (const)
 	sync.Mutex
 	dynamic    bool   // both operands are not constant
 	takenFuzz  [2]int // number of times condition evaluated to false/true during fuzzing
@@ -30,6 +33,8 @@ type SonarSample struct {
 	site  *SonarSite
 	flags byte
 	val   [2][]byte
+	exact [2][]byte // operands of the site width, nil if the width is unknown
+	raw   [2][]byte // untrimmed operands, see -sonartrace
 }
 
 func (w *Worker) parseSonarData(sonar []byte) (res []SonarSample) {
@@ -48,9 +53,20 @@ func (w *Worker) parseSonarData(sonar []byte) (res []SonarSample) {
 		v1 := makeCopy(sonar[:n1])
 		v2 := makeCopy(sonar[n1 : n1+n2])
 		sonar = sonar[n1+n2:]
+		site := &ro.sonarSites[id]
+		if ro.bigEndian && flags&SonarString == 0 {
+			// Numbers are in the byte order of the target, samples hold them little-endian.
+			v1, v2 = reverse(v1), reverse(v2)
+		}
+		var exact [2][]byte
+		if flags&SonarString == 0 && site.width != 0 && len(v1) >= site.width && len(v2) >= site.width {
+			// Const operands are passed as int, trim them to the width of the other operand.
+			exact = [2][]byte{v1[:site.width], v2[:site.width]}
+		}
+		raw := [2][]byte{v1, v2}
 
 		// Trim trailing 0x00 and 0xff bytes (we don't know exact size of operands).
-		if flags&SonarString == 0 {
+		if flags&SonarString == 0 && !site.float {
 			for len(v1) > 0 || len(v2) > 0 {
 				i := len(v1) - 1
 				if len(v2) > len(v1) {
@@ -76,16 +92,29 @@ func (w *Worker) parseSonarData(sonar []byte) (res []SonarSample) {
 			}
 		}
 
-		res = append(res, SonarSample{&ro.sonarSites[id], flags, [2][]byte{v1, v2}})
+		res = append(res, SonarSample{site, flags, [2][]byte{v1, v2}, exact, raw})
 	}
 	return res
 }
 
+// byteOrders returns functions that convert little-endian numbers of sonar samples
+// into the byte order of the target and into the opposite byte order.
+func (ro *ROData) byteOrders() (native, swapped func([]byte) []byte) {
+	same := func(v []byte) []byte { return v }
+	if ro.bigEndian {
+		return reverse, same
+	}
+	return same, reverse
+}
+
 func (w *Worker) processSonarData(data, sonar []byte, depth int, smash bool) {
 	ro := w.hub.ro.Load().(*ROData)
+	// Hints are tried in the byte order of the target first.
+	native, swapped := ro.byteOrders()
 	updated := false
 	checked := make(map[string]struct{})
 	samples := w.parseSonarData(sonar)
+	w.traceSonar(samples)
 	for _, sam := range samples {
 		// TODO: extract literal corpus from sonar instead of from source.
 		// This should give smaller, better corpus which does not contain literals from dead code.
@@ -100,10 +129,16 @@ func (w *Worker) processSonarData(data, sonar []byte, depth int, smash bool) {
 		res := sam.evaluate()
 		// Ignore sites that has at least one const operand and
 		// are already taken both ways enough times.
-		upd, skip := site.update(sam, smash, res)
+		upd, skip, tok := site.update(sam, smash, res)
 		if upd {
 			updated = true
 		}
+		if tok != nil {
+			if flags&SonarString == 0 {
+				tok = native(tok)
+			}
+			w.noteToken(tok)
+		}
 		if skip {
 			continue
 		}
@@ -157,10 +192,10 @@ func (w *Worker) processSonarData(data, sonar []byte, depth int, smash bool) {
 				}
 			}
 		}
-		check1 := func(v1, v2 []byte) {
-			check(data, v1, v2)
+		check1 := func(v1, v2, exact1, exact2 []byte) {
 			// TODO: for strings check upper/lower case.
 			if flags&SonarString != 0 {
+				check(data, v1, v2)
 				if bytes.Equal(v1, bytes.ToLower(v1)) && bytes.Equal(v2, bytes.ToLower(v2)) {
 					if lower := bytes.ToLower(data); len(lower) == len(data) {
 						check(lower, v1, v2)
@@ -171,12 +206,25 @@ func (w *Worker) processSonarData(data, sonar []byte, depth int, smash bool) {
 						check(upper, v1, v2)
 					}
 				}
+			} else if site.float {
+				// Try IEEE-754 representation in both byte orders and decimal text.
+				// Closest neighbours of v2 take care of less and greater comparison operators.
+				for _, vv2 := range floatNeighbours(v2) {
+					check(data, native(v1), native(vv2))
+					check(data, swapped(v1), swapped(vv2))
+					if s1, ok := formatFloat(v1); ok {
+						s2, _ := formatFloat(vv2)
+						check(data, []byte(s1), []byte(s2))
+					}
+				}
 			} else {
 				// Try several common wire encodings of the values:
-				// network format (big endian), hex, base-128.
+				// the opposite byte order (e.g. network format), hex, base-128.
 				// TODO: try more encodings if it proves to be useful:
 				// base-64, quoted-printable, xml-escaping, hex+increment/decrement.
 
+				check(data, native(v1), native(v2))
+
 				if len(v1) == 1 && len(v2) == 1 && unicode.IsLower(rune(v1[0])) && unicode.IsLower(rune(v2[0])) {
 					if lower := bytes.ToLower(data); len(lower) == len(data) {
 						check(lower, v1, v2)
@@ -190,16 +238,23 @@ func (w *Worker) processSonarData(data, sonar []byte, depth int, smash bool) {
 
 				// Increment and decrement take care of less and greater comparison operators
 				// as well as of off-by-one bugs.
-				check(data, v1, increment(v2))
-				check(data, v1, decrement(v2))
+				check(data, native(v1), native(increment(v2)))
+				check(data, native(v1), native(decrement(v2)))
 
-				// Also try big-endian increments/decrements.
+				// Also try increments/decrements in the opposite byte order.
 				if len(v1) > 1 {
-					check(data, reverse(v1), reverse(v2))
-					check(data, reverse(v1), reverse(increment(v2)))
-					check(data, reverse(v1), reverse(decrement(v2)))
-					check(data, v1, reverse(increment(reverse(v2))))
-					check(data, v1, reverse(decrement(reverse(v2))))
+					check(data, swapped(v1), swapped(v2))
+					check(data, swapped(v1), swapped(increment(v2)))
+					check(data, swapped(v1), swapped(decrement(v2)))
+					check(data, native(v1), swapped(increment(reverse(v2))))
+					check(data, native(v1), swapped(decrement(reverse(v2))))
+				}
+
+				// Trimmed values lose high 0x00/0xff bytes (e.g. 0x0012 becomes 0x12),
+				// so also try values of the exact operand width in both byte orders.
+				if exact1 != nil {
+					check(data, native(exact1), native(exact2))
+					check(data, swapped(exact1), swapped(exact2))
 				}
 
 				// Base-128.
@@ -235,22 +290,25 @@ func (w *Worker) processSonarData(data, sonar []byte, depth int, smash bool) {
 			check(data, []byte(hex.EncodeToString(v1)), []byte(hex.EncodeToString(v2)))
 		}
 		if flags&SonarConst1 == 0 {
-			check1(v1, v2)
+			check1(v1, v2, sam.exact[0], sam.exact[1])
 		}
 		if flags&SonarConst2 == 0 {
-			check1(v2, v1)
+			check1(v2, v1, sam.exact[1], sam.exact[0])
 		}
 	}
 	if updated && *flagDumpCover {
 		dumpMu.Lock()
 		defer dumpMu.Unlock()
-		dumpSonar(filepath.Join(*flagWorkdir, "sonarprofile"), ro.sonarSites)
+		dumpSonar(filepath.Join(funcWorkdir(w.hub.fn, w.hub.funcs), "sonarprofile"), ro.sonarSites)
 	}
 }
 
 var dumpMu sync.Mutex
 
-func (site *SonarSite) update(sam SonarSample, smash, resb bool) (updated, skip bool) {
+// update updates site statistics with a new sample. It returns whether the site
+// is taken a new way, whether the sample is not interesting, and a token for
+// the dynamic dictionary if one operand of the site is constant at runtime.
+func (site *SonarSite) update(sam SonarSample, smash, resb bool) (updated, skip bool, tok []byte) {
 	res := 0
 	if resb {
 		res = 1
@@ -271,6 +329,19 @@ func (site *SonarSite) update(sam SonarSample, smash, resb bool) (updated, skip
 			site.val[1] = nil
 			site.dynamic = true
 		}
+		// One operand is the same while the other changes, e.g. a computed checksum or magic.
+		// Such values are not known statically, so they are added to the dynamic dictionary.
+		for i := 0; i < 2 && !site.dynamic; i++ {
+			if bytes.Equal(site.val[i], sam.val[i]) && !bytes.Equal(site.val[1-i], sam.val[1-i]) {
+				tok = sam.val[i]
+				if sam.exact[i] != nil {
+					tok = sam.exact[i]
+				}
+			}
+		}
+		if len(tok) < 2 {
+			tok = nil // single bytes are covered by byte mutations anyway
+		}
 	}
 	if site.takenTotal[res] == 0 {
 		updated = true
@@ -316,6 +387,29 @@ func (sam *SonarSample) evaluate() bool {
 			panic("bad")
 		}
 	}
+	if sam.site.float {
+		f1, _, ok1 := decodeFloat(v1)
+		f2, _, ok2 := decodeFloat(v2)
+		if !ok1 || !ok2 {
+			return false
+		}
+		switch sam.flags & SonarOpMask {
+		case SonarEQL:
+			return f1 == f2
+		case SonarNEQ:
+			return f1 != f2
+		case SonarLSS:
+			return f1 < f2
+		case SonarGTR:
+			return f1 > f2
+		case SonarLEQ:
+			return f1 <= f2
+		case SonarGEQ:
+			return f1 >= f2
+		default:
+			panic("bad")
+		}
+	}
 	if len(v1) == 0 || len(v2) == 0 || len(v1) > 8 || len(v2) > 8 || len(v1) != len(v2) {
 		return false
 	}
@@ -377,6 +471,49 @@ func (sam *SonarSample) evaluate() bool {
 	}
 }
 
+// decodeFloat decodes float sonar operand (float32 or float64 in little-endian).
+func decodeFloat(v []byte) (f float64, bits int, ok bool) {
+	switch len(v) {
+	case 4:
+		return float64(math.Float32frombits(binary.LittleEndian.Uint32(v))), 32, true
+	case 8:
+		return math.Float64frombits(binary.LittleEndian.Uint64(v)), 64, true
+	}
+	return 0, 0, false
+}
+
+// floatNeighbours returns float sonar operand v along with the closest floats below and above it.
+func floatNeighbours(v []byte) [][]byte {
+	f, bits, ok := decodeFloat(v)
+	if !ok {
+		return nil
+	}
+	res := [][]byte{v}
+	if bits == 32 {
+		for _, inf := range []float64{math.Inf(-1), math.Inf(1)} {
+			b := make([]byte, 4)
+			binary.LittleEndian.PutUint32(b, math.Float32bits(math.Nextafter32(float32(f), float32(inf))))
+			res = append(res, b)
+		}
+	} else {
+		for _, inf := range []float64{math.Inf(-1), math.Inf(1)} {
+			b := make([]byte, 8)
+			binary.LittleEndian.PutUint64(b, math.Float64bits(math.Nextafter(f, inf)))
+			res = append(res, b)
+		}
+	}
+	return res
+}
+
+// formatFloat returns the shortest decimal text that represents float sonar operand v.
+func formatFloat(v []byte) (string, bool) {
+	f, bits, ok := decodeFloat(v)
+	if !ok {
+		return "", false
+	}
+	return strconv.FormatFloat(f, 'g', -1, bits), true
+}
+
 func dumpSonarData(site *SonarSite, flags byte, v1, v2 []byte) {
 	// Debug output.
 	op := ""
@@ -404,6 +541,9 @@ func dumpSonarData(site *SonarSite, flags byte, v1, v2 []byte) {
 	if flags&SonarString != 0 {
 		isstr = "(string)"
 	}
+	if site.float {
+		isstr = "(float)"
+	}
 	const1 := ""
 	if flags&SonarConst1 != 0 {
 		const1 = "c"
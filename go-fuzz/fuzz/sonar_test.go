@@ -0,0 +1,123 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+)
+
+func TestSonarFloat(t *testing.T) {
+	f64 := func(f float64) []byte {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, math.Float64bits(f))
+		return b
+	}
+	f32 := func(f float32) []byte {
+		b := make([]byte, 4)
+		binary.LittleEndian.PutUint32(b, math.Float32bits(f))
+		return b
+	}
+	site := &SonarSite{float: true}
+	tests := []struct {
+		v1, v2 []byte
+		op     byte
+		res    bool
+	}{
+		{f64(3), f64(3.14159), SonarGTR, false},
+		{f64(3.5), f64(3.14159), SonarGTR, true},
+		{f64(-1), f64(0), SonarLSS, true},
+		{f64(-1), f64(0), SonarGEQ, false},
+		{f32(2.5), f32(2.5), SonarEQL, true},
+		{f32(2.5), f32(-2.5), SonarNEQ, true},
+		{f32(-2.5), f32(-2), SonarLEQ, true},
+	}
+	for _, test := range tests {
+		sam := &SonarSample{site: site, flags: test.op, val: [2][]byte{test.v1, test.v2}}
+		if res := sam.evaluate(); res != test.res {
+			t.Errorf("%x %v %x: got %v, want %v", test.v1, test.op, test.v2, res, test.res)
+		}
+	}
+
+	// Neighbours of the threshold can be used to satisfy any comparison.
+	n := floatNeighbours(f64(3.14159))
+	if len(n) != 3 {
+		t.Fatalf("got %v neighbours, want 3", len(n))
+	}
+	lo, _ := formatFloat(n[1])
+	hi, _ := formatFloat(n[2])
+	if s, _ := formatFloat(n[0]); s != "3.14159" || lo >= s || hi <= s {
+		t.Errorf("bad neighbours: %v %v %v", lo, s, hi)
+	}
+	if s, _ := formatFloat(f32(0.1)); s != "0.1" {
+		t.Errorf("float32 is formatted as %v", s)
+	}
+}
+
+func TestSonarWidth(t *testing.T) {
+	// Sample for a uint16 field compared with const 0x12: the field is 0,
+	// the const is passed as 8-byte int.
+	sonar := []byte{SonarEQL | SonarConst2, 0, 0, 0, 2, 8, 0x00, 0x00, 0x12, 0, 0, 0, 0, 0, 0, 0}
+	hub := &Hub{}
+	hub.ro.Store(&ROData{sonarSites: []SonarSite{{width: 2}}})
+	w := &Worker{hub: hub}
+	samples := w.parseSonarData(sonar)
+	if len(samples) != 1 {
+		t.Fatalf("got %v samples, want 1", len(samples))
+	}
+	sam := samples[0]
+	if !bytes.Equal(sam.exact[0], []byte{0x00, 0x00}) || !bytes.Equal(sam.exact[1], []byte{0x12, 0x00}) {
+		t.Fatalf("got exact operands %x %x, want 0000 1200", sam.exact[0], sam.exact[1])
+	}
+	// Trimmed operands are 1 byte, they don't match the field in the input.
+	if len(sam.val[0]) != 1 || len(sam.val[1]) != 1 {
+		t.Fatalf("got trimmed operands %x %x", sam.val[0], sam.val[1])
+	}
+	if sam.evaluate() {
+		t.Fatalf("0 == 0x12 evaluated to true")
+	}
+
+	// Sites without width info don't get exact operands.
+	hub.ro.Store(&ROData{sonarSites: []SonarSite{{}}})
+	if sam := w.parseSonarData(sonar)[0]; sam.exact[0] != nil || sam.exact[1] != nil {
+		t.Fatalf("got exact operands %x %x for unknown width", sam.exact[0], sam.exact[1])
+	}
+}
+
+func TestSonarByteOrder(t *testing.T) {
+	// Sample for a uint32 field compared with another uint32 value, operands are in the byte order of the target.
+	sonar := []byte{SonarLSS, 0, 0, 0, 4, 4, 0x01, 0x00, 0x00, 0x02, 0x02, 0x00, 0x00, 0x01}
+	hub := &Hub{}
+	w := &Worker{hub: hub}
+	for _, test := range []struct {
+		bigEndian bool
+		less      bool   // result of the comparison
+		inc       []byte // incremented second operand in the byte order of the target
+	}{
+		{false, false, []byte{0x03, 0x00, 0x00, 0x01}},
+		{true, true, []byte{0x02, 0x00, 0x00, 0x02}},
+	} {
+		ro := &ROData{sonarSites: []SonarSite{{width: 4}}, bigEndian: test.bigEndian}
+		hub.ro.Store(ro)
+		sam := w.parseSonarData(sonar)[0]
+		if res := sam.evaluate(); res != test.less {
+			t.Errorf("bigEndian=%v: comparison evaluated to %v, want %v", test.bigEndian, res, test.less)
+		}
+		// Hints are tried in the byte order of the target first, the opposite byte order is the fallback.
+		native, swapped := ro.byteOrders()
+		if got := native(sam.val[0]); !bytes.Equal(got, sonar[6:10]) {
+			t.Errorf("bigEndian=%v: hint operand is %x, want %x", test.bigEndian, got, sonar[6:10])
+		}
+		if got := native(increment(sam.val[1])); !bytes.Equal(got, test.inc) {
+			t.Errorf("bigEndian=%v: incremented hint is %x, want %x", test.bigEndian, got, test.inc)
+		}
+		if got := swapped(sam.val[0]); !bytes.Equal(got, reverse(sonar[6:10])) {
+			t.Errorf("bigEndian=%v: fallback hint operand is %x, want %x", test.bigEndian, got, reverse(sonar[6:10]))
+		}
+	}
+}
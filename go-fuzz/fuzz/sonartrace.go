@@ -0,0 +1,161 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"os"
+)
+
+// -sonartrace writes a sample of sonar comparisons into a file for external tools.
+// Workers trace all comparisons of every -sonartracerate-th execution with sonar
+// and send the records with sync, the coordinator writes them.
+// The file is a sequence of records, a record is uvarint length of the rest
+// of the record followed by:
+//
+//	uvarint sonar site ID (index of the site in MetaData.Sonar)
+//	byte    flags (SonarEQL...SonarGEQ and SonarLength, SonarSigned, SonarString, SonarConst1, SonarConst2)
+//	byte    comparison result, 0 or 1
+//	uvarint length of the first operand followed by the operand
+//	uvarint length of the second operand followed by the operand
+//
+// Numeric operands are little-endian, const operands are not trimmed to the operand width.
+const (
+	defaultSonarTraceRate = 10
+	maxSonarTrace         = 1000  // records sent by a worker to the hub with a single sync
+	maxHubSonarTrace      = 10000 // records sent by a hub to the coordinator with a single sync, the rest is dropped
+)
+
+// SonarRecord is a sonar comparison traced for -sonartrace.
+type SonarRecord struct {
+	Site   int
+	Flags  byte
+	Result bool
+	Val    [2][]byte
+}
+
+// traceSonar records samples of a sonar execution for -sonartrace, if it is sampled.
+func (w *Worker) traceSonar(samples []SonarSample) {
+	rate := w.hub.sonarTraceRate
+	if rate == 0 {
+		return
+	}
+	w.sonarExecs++
+	if w.sonarExecs%rate != 0 {
+		return
+	}
+	for _, sam := range samples {
+		if len(w.stats.sonarTrace) >= maxSonarTrace {
+			break
+		}
+		w.stats.sonarTrace = append(w.stats.sonarTrace, SonarRecord{sam.site.id, sam.flags, sam.evaluate(), sam.raw})
+	}
+}
+
+type sonarTrace struct {
+	f   *os.File
+	w   *bufio.Writer
+	buf []byte
+}
+
+// openSonarTrace creates -sonartrace file, with -resume records are appended to it.
+func openSonarTrace(file string) *sonarTrace {
+	mode := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if *flagResume {
+		mode = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(file, mode, 0660)
+	if err != nil {
+		log.Fatalf("failed to create -sonartrace file: %v", err)
+	}
+	return &sonarTrace{f: f, w: bufio.NewWriter(f)}
+}
+
+func (st *sonarTrace) write(recs []SonarRecord) {
+	for _, r := range recs {
+		buf := appendUvarint(st.buf[:0], uint64(r.Site))
+		res := byte(0)
+		if r.Result {
+			res = 1
+		}
+		buf = append(buf, r.Flags, res)
+		for _, v := range r.Val {
+			buf = appendUvarint(buf, uint64(len(v)))
+			buf = append(buf, v...)
+		}
+		st.w.Write(appendUvarint(nil, uint64(len(buf))))
+		st.w.Write(buf)
+		st.buf = buf
+	}
+}
+
+func (st *sonarTrace) flush() {
+	if err := st.w.Flush(); err != nil {
+		log.Printf("failed to write -sonartrace file: %v", err)
+	}
+}
+
+func (st *sonarTrace) close() {
+	st.flush()
+	if err := st.f.Close(); err != nil {
+		log.Printf("failed to write -sonartrace file: %v", err)
+	}
+}
+
+// closeSonarTrace flushes and closes -sonartrace file on shutdown.
+func (c *Coordinator) closeSonarTrace() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.sonarTrace == nil {
+		return
+	}
+	c.sonarTrace.close()
+	c.sonarTrace = nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(buf, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+var errBadSonarTrace = errors.New("corrupted sonar trace")
+
+// readSonarTrace reads records written by -sonartrace.
+func readSonarTrace(r io.Reader) ([]SonarRecord, error) {
+	br := bufio.NewReader(r)
+	var recs []SonarRecord
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return recs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(br, buf); err != nil {
+			return nil, err
+		}
+		site, k := binary.Uvarint(buf)
+		if k <= 0 || len(buf) < k+2 {
+			return nil, errBadSonarTrace
+		}
+		rec := SonarRecord{Site: int(site), Flags: buf[k], Result: buf[k+1] != 0}
+		buf = buf[k+2:]
+		for i := range rec.Val {
+			n, k := binary.Uvarint(buf)
+			if k <= 0 || uint64(len(buf)-k) < n {
+				return nil, errBadSonarTrace
+			}
+			rec.Val[i] = buf[k : k+int(n)]
+			buf = buf[k+int(n):]
+		}
+		recs = append(recs, rec)
+	}
+}
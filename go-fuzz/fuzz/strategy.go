@@ -0,0 +1,125 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Mutator is a custom mutation strategy, e.g. one that knows the input format.
+// Registered mutators are chosen alongside the built-in mutations of go-fuzz.
+// Mutate may modify input in place, the result is truncated to the max input size.
+// Mutate returns nil if it can't mutate input. It is called concurrently by all workers.
+type Mutator interface {
+	Mutate(input []byte, rng *rand.Rand) []byte
+}
+
+type namedMutator struct {
+	name string
+	m    Mutator
+}
+
+// customMutators are mutators registered with RegisterMutator, in order of registration.
+var customMutators []namedMutator
+
+// RegisterMutator registers mutation strategy m under name,
+// the name is used in -mutatorweights and in provenance of corpus inputs.
+// It must be called before Main or Run, and panics if the name is already taken.
+func RegisterMutator(name string, m Mutator) {
+	if name == "" || strings.ContainsAny(name, "=,") {
+		panic(fmt.Sprintf("bad mutator name %q", name))
+	}
+	for _, name1 := range strategyNames() {
+		if name1 == name {
+			panic(fmt.Sprintf("mutator %v is already registered", name))
+		}
+	}
+	if len(mutationNames)+len(customMutators) > 255 {
+		panic("too many mutators")
+	}
+	customMutators = append(customMutators, namedMutator{name, m})
+}
+
+// strategyNames returns names of mutation strategies chosen by mutate:
+// built-in mutations (including splice) followed by custom mutators.
+// Index of a strategy is its mutation op recorded in provenance.
+func strategyNames() []string {
+	names := append([]string{}, mutationNames[:]...)
+	for _, cm := range customMutators {
+		names = append(names, cm.name)
+	}
+	return names
+}
+
+// mutationName returns name of mutation op.
+func mutationName(op byte) string {
+	if int(op) < len(mutationNames) {
+		return mutationNames[op]
+	}
+	return customMutators[int(op)-len(mutationNames)].name
+}
+
+// parseStrategyWeights parses -mutatorweights flag value of the form name=weight,...
+// and returns cumulative weights of strategies in strategyNames order.
+// Strategies that are not listed have weight 1.
+func parseStrategyWeights(spec string) ([]int, error) {
+	names := strategyNames()
+	weights := make([]int, len(names))
+	for i := range weights {
+		weights[i] = 1
+	}
+	for _, kv := range strings.Split(spec, ",") {
+		if kv == "" {
+			continue
+		}
+		eq := strings.IndexByte(kv, '=')
+		if eq == -1 {
+			return nil, fmt.Errorf("bad mutator weight %q, want name=weight", kv)
+		}
+		w, err := strconv.Atoi(kv[eq+1:])
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("bad mutator weight %q, want name=weight", kv)
+		}
+		idx := -1
+		for i, name := range names {
+			if name == kv[:eq] {
+				idx = i
+			}
+		}
+		if idx == -1 {
+			return nil, fmt.Errorf("unknown mutator %q, want one of %v", kv[:eq], strings.Join(names, ", "))
+		}
+		weights[idx] = w
+	}
+	total := 0
+	for i, w := range weights {
+		total += w
+		weights[i] = total
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("all mutator weights are 0")
+	}
+	return weights, nil
+}
+
+func strategyWeights() []int {
+	weights, err := parseStrategyWeights(*flagMutatorWeights)
+	if err != nil {
+		log.Fatalf("bad -mutatorweights: %v", err)
+	}
+	return weights
+}
+
+// chooseStrategy returns a random mutation op according to strategy weights.
+func (m *mutator) chooseStrategy() int {
+	x := m.rand(m.weights[len(m.weights)-1])
+	return sort.Search(len(m.weights), func(i int) bool {
+		return m.weights[i] > x
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2015 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+// +build darwin linux freebsd dragonfly openbsd netbsd
+
+package fuzz
+
+import (
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+func lowerProcessPrio() {
+	syscall.Setpriority(syscall.PRIO_PROCESS, 0, 19)
+}
+
+type Mapping struct {
+	f *os.File
+}
+
+func createMapping(name string, size int) (*Mapping, []byte) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		log.Fatalf("failed to open comm file: %v", err)
+	}
+	mem, err := syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		log.Fatalf("failed to mmap comm file: %v", err)
+	}
+	return &Mapping{f}, mem
+}
+
+func (m *Mapping) destroy() {
+	m.f.Close()
+}
+
+// setupCommMapping passes comm to the test binary as fd 3.
+func setupCommMapping(cmd *exec.Cmd, comm *Mapping) {
+	cmd.ExtraFiles = append(cmd.ExtraFiles, comm.f)
+}
+
+// pipeConn passes requests and replies over a pair of pipes,
+// the test binary gets them as fds 4 and 5.
+type pipeConn struct {
+	rIn, wIn   *os.File
+	rOut, wOut *os.File
+}
+
+func newTesteeConn() (testeeConn, error) {
+	rIn, wIn, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	rOut, wOut, err := os.Pipe()
+	if err != nil {
+		rIn.Close()
+		wIn.Close()
+		return nil, err
+	}
+	return &pipeConn{rIn: rIn, wIn: wIn, rOut: rOut, wOut: wOut}, nil
+}
+
+func (c *pipeConn) setup(cmd *exec.Cmd) {
+	cmd.ExtraFiles = append(cmd.ExtraFiles, c.rOut)
+	cmd.ExtraFiles = append(cmd.ExtraFiles, c.wIn)
+}
+
+func (c *pipeConn) connect(p *os.Process) (io.ReadCloser, io.WriteCloser) {
+	c.rOut.Close()
+	c.wIn.Close()
+	return c.rIn, c.wOut
+}
+
+func (c *pipeConn) close() {
+	c.rIn.Close()
+	c.wIn.Close()
+	c.rOut.Close()
+	c.wOut.Close()
+}
+
+// abortTestee asks the test binary to crash with goroutine stacks.
+func abortTestee(p *os.Process) {
+	p.Signal(syscall.SIGABRT)
+}
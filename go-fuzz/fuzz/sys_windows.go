@@ -0,0 +1,143 @@
+// Copyright 2015 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/exec"
+	"reflect"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+func lowerProcessPrio() {
+	const BELOW_NORMAL_PRIORITY_CLASS = 0x4000
+	kernel32 := syscall.NewLazyDLL("kernel32.dll")
+	p, _ := syscall.GetCurrentProcess()
+	kernel32.NewProc("SetPriorityClass").Call(uintptr(p), BELOW_NORMAL_PRIORITY_CLASS)
+}
+
+// Mapping is backed by the comm file, so the test binary can map the same
+// memory by file name. Handles are not inherited by child processes
+// (os/exec limits inheritance to stdio), so they can't be passed directly.
+type Mapping struct {
+	f       *os.File
+	mapping syscall.Handle
+	addr    uintptr
+}
+
+func createMapping(name string, size int) (*Mapping, []byte) {
+	f, err := os.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		log.Fatalf("failed to open comm file: %v", err)
+	}
+	mapping, err := syscall.CreateFileMapping(syscall.Handle(f.Fd()), nil, syscall.PAGE_READWRITE, 0, uint32(size), nil)
+	if err != nil {
+		log.Fatalf("failed to create file mapping: %v", err)
+	}
+	const FILE_MAP_ALL_ACCESS = 0xF001F
+	addr, err := syscall.MapViewOfFile(mapping, FILE_MAP_ALL_ACCESS, 0, 0, uintptr(size))
+	if err != nil {
+		log.Fatalf("failed to mmap comm file: %v", err)
+	}
+	hdr := reflect.SliceHeader{addr, size, size}
+	mem := *(*[]byte)(unsafe.Pointer(&hdr))
+	mem[0] = 1 // test access
+	return &Mapping{f, mapping, addr}, mem
+}
+
+func (m *Mapping) destroy() {
+	syscall.UnmapViewOfFile(m.addr)
+	syscall.CloseHandle(m.mapping)
+	m.f.Close()
+}
+
+func setupCommMapping(cmd *exec.Cmd, comm *Mapping) {
+	cmd.Env = append(cmd.Env, fmt.Sprintf("GO_FUZZ_COMM_FILE=%v", comm.f.Name()))
+}
+
+// testeeConnectTimeout is how long the test binary has to connect after start.
+const testeeConnectTimeout = time.Minute
+
+// tcpConn passes requests and replies over a loopback TCP connection.
+// The test binary connects to GO_FUZZ_COMM_PORT and sends GO_FUZZ_COMM_TOKEN first,
+// so that a stray connection from another process is not mistaken for the testee.
+type tcpConn struct {
+	ln    *net.TCPListener
+	token uint64
+}
+
+func newTesteeConn() (testeeConn, error) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, err
+	}
+	return &tcpConn{ln: ln, token: uint64(rand.Int63())}, nil
+}
+
+func (c *tcpConn) setup(cmd *exec.Cmd) {
+	cmd.Env = append(cmd.Env, fmt.Sprintf("GO_FUZZ_COMM_PORT=%v", c.ln.Addr().(*net.TCPAddr).Port))
+	cmd.Env = append(cmd.Env, fmt.Sprintf("GO_FUZZ_COMM_TOKEN=%v", c.token))
+}
+
+func (c *tcpConn) connect(p *os.Process) (io.ReadCloser, io.WriteCloser) {
+	defer c.ln.Close()
+	deadline := time.Now().Add(testeeConnectTimeout)
+	for time.Now().Before(deadline) && processAlive(p) {
+		c.ln.SetDeadline(time.Now().Add(100 * time.Millisecond))
+		conn, err := c.ln.AcceptTCP()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			log.Printf("failed to accept test binary connection: %v", err)
+			break
+		}
+		var token [8]byte
+		conn.SetReadDeadline(time.Now().Add(testeeConnectTimeout))
+		if _, err := io.ReadFull(conn, token[:]); err != nil || binary.LittleEndian.Uint64(token[:]) != c.token {
+			conn.Close()
+			continue
+		}
+		conn.SetReadDeadline(time.Time{})
+		return conn, conn
+	}
+	// The test binary died (or hanged) before connecting,
+	// reads from deadConn fail, so it is handled as a crash.
+	return deadConn{}, deadConn{}
+}
+
+func (c *tcpConn) close() {
+	c.ln.Close()
+}
+
+func processAlive(p *os.Process) bool {
+	const SYNCHRONIZE = 0x00100000
+	h, err := syscall.OpenProcess(SYNCHRONIZE, false, uint32(p.Pid))
+	if err != nil {
+		return false
+	}
+	defer syscall.CloseHandle(h)
+	ev, _ := syscall.WaitForSingleObject(h, 0)
+	return ev != syscall.WAIT_OBJECT_0
+}
+
+// deadConn is a connection to a test binary that failed to connect.
+type deadConn struct{}
+
+func (deadConn) Read(buf []byte) (int, error)  { return 0, io.EOF }
+func (deadConn) Write(buf []byte) (int, error) { return len(buf), nil }
+func (deadConn) Close() error                  { return nil }
+
+// abortTestee is a no-op, there is no way to make the test binary dump goroutines
+// on windows, so a hanged binary is killed without stacks.
+func abortTestee(p *os.Process) {
+}
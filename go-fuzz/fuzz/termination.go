@@ -0,0 +1,65 @@
+// Copyright 2019 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+// Classes of test binary termination, a crasher's class is saved into <crasher>.class.
+const (
+	crashPanic  = "panic"  // unrecovered panic
+	crashFatal  = "fatal"  // runtime fatal error (e.g. concurrent map writes), it can't be recovered
+	crashExit   = "exit"   // the test binary exited without a crash message (e.g. os.Exit or log.Fatal)
+	crashSignal = "signal" // unhandled signal, or the test binary was killed by a signal
+)
+
+// crashClass classifies termination of the test binary by its output,
+// the last line of the output is the wait status (see Testee.shutdown).
+func crashClass(out []byte) string {
+	last := ""
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		// The first crash message decides: a runtime fatal error
+		// that follows a panic is caused by the panic.
+		switch {
+		case strings.HasPrefix(line, "panic: "):
+			return crashPanic
+		case strings.HasPrefix(line, "fatal error: "):
+			return crashFatal
+		case strings.HasPrefix(line, "SIG") && strings.Contains(line, ": "):
+			return crashSignal
+		}
+		last = line
+	}
+	if strings.HasPrefix(last, "signal: ") {
+		return crashSignal
+	}
+	return crashExit
+}
+
+// logPrefix matches timestamps that the log package prepends to messages.
+var logPrefix = regexp.MustCompile(`^[0-9]{4}/[0-9]{2}/[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)? `)
+
+// exitSuppression returns deduplication key of a crash without a crash message:
+// the wait status and, for exits, the last line of output (e.g. log.Fatal message)
+// without the log timestamp. The rest of the output often depends on the input.
+func exitSuppression(out []byte) []byte {
+	lines := strings.Split(string(out), "\n")
+	status := lines[len(lines)-1]
+	supp := []byte(status + "\n")
+	if strings.HasPrefix(status, "signal: ") {
+		return supp
+	}
+	for i := len(lines) - 2; i >= 0; i-- {
+		if line := logPrefix.ReplaceAllString(lines[i], ""); strings.TrimSpace(line) != "" {
+			return append(supp, line+"\n"...)
+		}
+	}
+	return supp
+}
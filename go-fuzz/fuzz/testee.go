@@ -1,7 +1,7 @@
 // Copyright 2015 go-fuzz project authors. All rights reserved.
 // Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
 
-package main
+package fuzz
 
 import (
 	"encoding/binary"
@@ -12,7 +12,6 @@ import (
 	"os"
 	"os/exec"
 	"sync/atomic"
-	"syscall"
 	"time"
 	"unsafe"
 
@@ -26,8 +25,8 @@ type Testee struct {
 	inputRegion []byte
 	sonarRegion []byte
 	cmd         *exec.Cmd
-	inPipe      *os.File
-	outPipe     *os.File
+	inPipe      io.ReadCloser
+	outPipe     io.WriteCloser
 	stdoutPipe  *os.File
 	writebuf    [9]byte  // reusable write buffer
 	resbuf      [24]byte // reusable results buffer
@@ -36,7 +35,21 @@ type Testee struct {
 	outputC     chan []byte
 	downC       chan bool
 	down        bool
-	fnidx       uint8
+	execState   *uint32 // exec state in the comm mapping, see ExecStateOffset
+}
+
+// testeeConn is the OS-specific channel that carries requests to a test binary
+// and replies back (pipes on posix, loopback TCP on windows).
+// Coverage, input and sonar data are passed in the shared comm mapping.
+type testeeConn interface {
+	// setup passes the test binary ends of the channel to cmd before it is started.
+	setup(cmd *exec.Cmd)
+	// connect returns go-fuzz ends of the channel after p is started.
+	// If p fails to connect, reads from the returned reader fail,
+	// so the test binary is treated as crashed.
+	connect(p *os.Process) (io.ReadCloser, io.WriteCloser)
+	// close releases the channel if the test binary failed to start.
+	close()
 }
 
 // TestBinary handles communication with and restring of testee subprocesses.
@@ -49,6 +62,14 @@ type TestBinary struct {
 	coverRegion []byte
 	inputRegion []byte
 	sonarRegion []byte
+	execState   *uint32
+
+	// lastInput is the last input executed by the current testee (if hasLast is set).
+	// If the testee crashes after the execution (e.g. in a goroutine started by the input),
+	// strayCrash is called with it, otherwise the crash is attributed to the next input.
+	lastInput  []byte
+	hasLast    bool
+	strayCrash func(data, cover, output []byte)
 
 	testee       *Testee
 	testeeBuffer []byte // reusable buffer for collecting testee output
@@ -56,6 +77,7 @@ type TestBinary struct {
 	stats *Stats
 
 	fnidx uint8
+	cpu   int // index of the CPU the test binary is pinned to with -affinity, or -1
 }
 
 func init() {
@@ -74,9 +96,9 @@ func newTestBinary(fileName string, periodicCheck func(), stats *Stats, fnidx ui
 	if err != nil {
 		log.Fatalf("failed to create comm file: %v", err)
 	}
-	comm.Truncate(CoverSize + MaxInputSize + SonarRegionSize)
+	comm.Truncate(CommSize)
 	comm.Close()
-	mapping, mem := createMapping(comm.Name(), CoverSize+MaxInputSize+SonarRegionSize)
+	mapping, mem := createMapping(comm.Name(), CommSize)
 	return &TestBinary{
 		fileName:      fileName,
 		commFile:      comm.Name(),
@@ -84,9 +106,11 @@ func newTestBinary(fileName string, periodicCheck func(), stats *Stats, fnidx ui
 		periodicCheck: periodicCheck,
 		coverRegion:   mem[:CoverSize],
 		inputRegion:   mem[CoverSize : CoverSize+SonarRegionSize],
-		sonarRegion:   mem[CoverSize+SonarRegionSize:],
+		sonarRegion:   mem[CoverSize+SonarRegionSize : ExecStateOffset],
+		execState:     (*uint32)(unsafe.Pointer(&mem[ExecStateOffset])),
 		stats:         stats,
 		fnidx:         fnidx,
+		cpu:           -1,
 		testeeBuffer:  make([]byte, testeeBufferSize),
 	}
 }
@@ -101,6 +125,44 @@ func (bin *TestBinary) close() {
 }
 
 func (bin *TestBinary) test(data []byte) (res int, ns uint64, cover, sonar, output []byte, crashed, hanged bool) {
+	return bin.run(bin.fnidx, data)
+}
+
+// canonicalize returns canonical encoding of data produced by the target Encode function,
+// or nil if the target fails to decode or encode data.
+func (bin *TestBinary) canonicalize(data []byte) []byte {
+	res, _, _, _, output, crashed, _ := bin.run(CanonicalizeFunc, data)
+	if crashed {
+		if *flagV >= 1 {
+			log.Printf("test binary crashed while canonicalizing input:\n%s", output)
+		}
+		return nil
+	}
+	if res < 0 || res > len(bin.inputRegion) {
+		return nil
+	}
+	return makeCopy(bin.inputRegion[:res])
+}
+
+// leakStats returns the number of goroutines and open file descriptors in the test binary,
+// ok is false if the test binary crashed.
+func (bin *TestBinary) leakStats() (st leakStats, ok bool) {
+	goroutines, fds, _, _, _, crashed, _ := bin.run(LeakCheckFunc, nil)
+	if crashed {
+		return leakStats{}, false
+	}
+	return leakStats{goroutines, int(fds)}, true
+}
+
+// restart kills the test binary, a new one is started by the next execution.
+func (bin *TestBinary) restart() {
+	if bin.testee != nil {
+		bin.testee.shutdown()
+		bin.testee = nil
+	}
+}
+
+func (bin *TestBinary) run(fnidx uint8, data []byte) (res int, ns uint64, cover, sonar, output []byte, crashed, hanged bool) {
 	if len(data) > MaxInputSize {
 		panic("input is too large")
 	}
@@ -112,37 +174,63 @@ func (bin *TestBinary) test(data []byte) (res int, ns uint64, cover, sonar, outp
 		bin.stats.execs++
 		if bin.testee == nil {
 			bin.stats.restarts++
-			bin.testee = newTestee(bin.fileName, bin.comm, bin.coverRegion, bin.inputRegion, bin.sonarRegion, bin.fnidx, bin.testeeBuffer)
+			bin.testee = newTestee(bin.fileName, bin.comm, bin.coverRegion, bin.inputRegion, bin.sonarRegion, bin.execState, bin.testeeBuffer, bin.cpu)
+			bin.hasLast = false
 		}
 		var retry bool
-		res, ns, cover, sonar, crashed, hanged, retry = bin.testee.test(data)
+		res, ns, cover, sonar, crashed, hanged, retry = bin.testee.test(fnidx, data)
 		if retry {
 			bin.testee.shutdown()
 			bin.testee = nil
 			continue
 		}
 		if crashed {
+			stray := !hanged && atomic.LoadUint32(bin.execState) == ExecIdle
 			output = bin.testee.shutdown()
+			bin.testee = nil
+			if *flagCrashCover {
+				// The test binary is dead, but coverage counters of the crashing
+				// execution are still in the comm mapping until the next one starts.
+				cover = makeCopy(bin.coverRegion)
+			}
+			if stray && bin.hasLast && bin.strayCrash != nil {
+				// The test binary did not execute data, the previous input crashed it.
+				bin.strayCrash(bin.lastInput, cover, output)
+				continue
+			}
 			if hanged {
 				hdr := fmt.Sprintf("program hanged (timeout %v seconds)\n\n", *flagTimeout)
+				if *flagHangTimeout != 0 {
+					hdr = fmt.Sprintf("program hanged (hang timeout %v)\n\n", *flagHangTimeout)
+				}
 				output = append([]byte(hdr), output...)
 			}
-			bin.testee = nil
 			return
 		}
+		if fnidx != LeakCheckFunc && fnidx != OutputFunc {
+			// These requests don't execute user code.
+			bin.lastInput = append(bin.lastInput[:0], data...)
+			bin.hasLast = true
+		}
 		return
 	}
 }
 
-func newTestee(bin string, comm *Mapping, coverRegion, inputRegion, sonarRegion []byte, fnidx uint8, buffer []byte) *Testee {
-retry:
-	rIn, wIn, err := os.Pipe()
-	if err != nil {
-		log.Fatalf("failed to pipe: %v", err)
+// testTimeout returns time limit for a single test execution.
+func testTimeout() time.Duration {
+	if *flagHangTimeout != 0 {
+		return *flagHangTimeout
 	}
-	rOut, wOut, err := os.Pipe()
+	return time.Duration(*flagTimeout) * time.Second
+}
+
+func newTestee(bin string, comm *Mapping, coverRegion, inputRegion, sonarRegion []byte, execState *uint32, buffer []byte, cpu int) *Testee {
+	// Crashes before the test binary stores the exec state are attributed to the first input.
+	atomic.StoreUint32(execState, 0)
+retry:
+	conn, err := newTesteeConn()
 	if err != nil {
-		log.Fatalf("failed to pipe: %v", err)
+		log.Fatalf("failed to create testee channel: %v", err)
 	}
 	rStdout, wStdout, err := os.Pipe()
 	if err != nil {
@@ -159,22 +247,22 @@ retry:
 	}
 	cmd.Env = append([]string{}, os.Environ()...)
 	cmd.Env = append(cmd.Env, "GOTRACEBACK=1")
-	setupCommMapping(cmd, comm, rOut, wIn)
-	if err = cmd.Start(); err != nil {
+	if *flagMemLimit != 0 {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%v=%v", MemLimitEnv, *flagMemLimit))
+	}
+	setupCommMapping(cmd, comm)
+	conn.setup(cmd)
+	if err = startPinned(cmd, cpu); err != nil {
 		// This can be a transient failure like "cannot allocate memory" or "text file is busy".
 		log.Printf("failed to start test binary: %v", err)
-		rIn.Close()
-		wIn.Close()
-		rOut.Close()
-		wOut.Close()
+		conn.close()
 		rStdout.Close()
 		wStdout.Close()
 		time.Sleep(time.Second)
 		goto retry
 	}
-	rOut.Close()
-	wIn.Close()
 	wStdout.Close()
+	rIn, wOut := conn.connect(cmd.Process)
 	t := &Testee{
 		coverRegion: coverRegion,
 		inputRegion: inputRegion,
@@ -182,10 +270,10 @@ retry:
 		cmd:         cmd,
 		inPipe:      rIn,
 		outPipe:     wOut,
+		execState:   execState,
 		stdoutPipe:  rStdout,
 		outputC:     make(chan []byte),
 		downC:       make(chan bool),
-		fnidx:       fnidx,
 	}
 	// Stdout reader goroutine.
 	go func() {
@@ -222,7 +310,7 @@ retry:
 	}()
 	// Hang watcher goroutine.
 	go func() {
-		timeout := time.Duration(*flagTimeout) * time.Second
+		timeout := testTimeout()
 		ticker := time.NewTicker(timeout / 2)
 		for {
 			select {
@@ -230,9 +318,9 @@ retry:
 				start := atomic.LoadInt64(&t.startTime)
 				if start != 0 && time.Now().UnixNano()-start > int64(timeout) {
 					atomic.StoreInt64(&t.startTime, -1)
-					t.cmd.Process.Signal(syscall.SIGABRT)
+					abortTestee(t.cmd.Process)
 					time.Sleep(time.Second)
-					t.cmd.Process.Signal(syscall.SIGKILL)
+					t.cmd.Process.Kill()
 					ticker.Stop()
 					return
 				}
@@ -248,14 +336,14 @@ retry:
 		select {
 		case <-t.downC:
 		case <-shutdownC:
-			t.cmd.Process.Signal(syscall.SIGKILL)
+			t.cmd.Process.Kill()
 		}
 	}()
 	return t
 }
 
-// test passes data for testing.
-func (t *Testee) test(data []byte) (res int, ns uint64, cover, sonar []byte, crashed, hanged, retry bool) {
+// test passes data for testing by function fnidx.
+func (t *Testee) test(fnidx uint8, data []byte) (res int, ns uint64, cover, sonar []byte, crashed, hanged, retry bool) {
 	if t.down {
 		log.Fatalf("cannot test: testee is already shutdown")
 	}
@@ -264,19 +352,24 @@ func (t *Testee) test(data []byte) (res int, ns uint64, cover, sonar []byte, cra
 	// so we recreate it periodically.
 	t.execs++
 	if t.execs > 10000 {
-		t.cmd.Process.Signal(syscall.SIGKILL)
+		t.cmd.Process.Kill()
 		retry = true
 		return
 	}
 
 	copy(t.inputRegion[:], data)
 	atomic.StoreInt64(&t.startTime, time.Now().UnixNano())
-	t.writebuf[0] = t.fnidx
+	t.writebuf[0] = fnidx
 	binary.LittleEndian.PutUint64(t.writebuf[1:], uint64(len(data)))
 	if _, err := t.outPipe.Write(t.writebuf[:]); err != nil {
 		if *flagV >= 1 {
 			log.Printf("write to testee failed: %v", err)
 		}
+		if atomic.LoadUint32(t.execState) == ExecIdle {
+			// The test binary died after it replied to the previous request.
+			crashed = true
+			return
+		}
 		retry = true
 		return
 	}
@@ -315,8 +408,14 @@ func (t *Testee) shutdown() (output []byte) {
 	t.cmd.Process.Kill() // it is probably already dead, but kill it again to be sure
 	close(t.downC)       // wakeup stdout reader
 	out := <-t.outputC
+	// The wait status is the last line of the output, see crashClass.
+	if len(out) != 0 && out[len(out)-1] != '\n' {
+		out = append(out, '\n')
+	}
 	if err := t.cmd.Wait(); err != nil {
 		out = append(out, err.Error()...)
+	} else {
+		out = append(out, t.cmd.ProcessState.String()...)
 	}
 	t.inPipe.Close()
 	t.outPipe.Close()
@@ -0,0 +1,1128 @@
+// Copyright 2015 go-fuzz project authors. All rights reserved.
+// Use of this source code is governed by Apache 2 LICENSE that can be found in the LICENSE file.
+
+package fuzz
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/bits"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	. "github.com/dvyukov/go-fuzz/go-fuzz-defs"
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+type execType byte
+
+//go:generate stringer -type execType -trimprefix exec
+const (
+	execBootstrap execType = iota
+	execCorpus
+	execMinimizeInput
+	execMinimizeCrasher
+	execTriageInput
+	execFuzz
+	execVersifier
+	execSmash
+	execSonar
+	execSonarHint
+	execTotal
+	execCount
+)
+
+// Worker manages one testee.
+type Worker struct {
+	id      int
+	hub     *Hub
+	mutator *mutator
+
+	coverBin     *TestBinary
+	sonarBin     *TestBinary
+	canonicalize bool   // the target can re-encode inputs, see CanonicalizeFunc
+	stop         uint32 // set by Hub.scale to stop the worker
+
+	triageQueue  []CoordinatorInput
+	crasherQueue []NewCrasherArgs
+
+	lastSync time.Time
+	stats    Stats
+	execs    [execCount]uint64
+	tokens   map[string]struct{} // dynamic dictionary tokens sent to hub
+	leaks    leakChecker         // state of -leakcheck
+
+	sonarExecs int // executions with sonar, see traceSonar
+
+	// Corpus inputs that the inputs being tested are derived from and mutations applied to them,
+	// they are turned into Provenance of new inputs.
+	parents []Sig
+	ops     []byte
+}
+
+type Input struct {
+	mine            bool
+	data            []byte
+	sig             Sig
+	prov            *Provenance // set for new inputs found by this process
+	cover           []byte
+	coverSize       int
+	res             int
+	depth           int
+	typ             execType
+	execTime        uint64
+	output          uint64 // output signature of a differential fuzz function
+	outputOK        bool   // output is known
+	favored         bool
+	score           int
+	runningScoreSum int
+}
+
+// binArchive is test binary archive built by go-fuzz-build and unpacked into temp files.
+type binArchive struct {
+	coverBin string
+	sonarBin string
+	metadata MetaData
+	fn       string // function to fuzz
+	fnidx    uint8
+}
+
+// openBinArchive unpacks -bin archive and selects function to fuzz.
+func openBinArchive() (*binArchive, error) {
+	zipr, err := zip.OpenReader(*flagBin)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bin file: %v", err)
+	}
+	defer zipr.Close()
+	a := new(binArchive)
+	if err := a.unpack(zipr); err != nil {
+		a.cleanup()
+		return nil, err
+	}
+	if err := a.selectFunc(*flagFunc); err != nil {
+		a.cleanup()
+		return nil, err
+	}
+	return a, nil
+}
+
+// unpack extracts test binaries into temp files and decodes metadata.
+func (a *binArchive) unpack(zipr *zip.ReadCloser) error {
+	for _, zipf := range zipr.File {
+		r, err := zipf.Open()
+		if err != nil {
+			return fmt.Errorf("failed to unzip file from input archive: %v", err)
+		}
+		err = a.unpackFile(zipf.Name, r)
+		r.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if a.coverBin == "" || a.sonarBin == "" || len(a.metadata.Blocks) == 0 || len(a.metadata.Funcs) == 0 {
+		return fmt.Errorf("bad input archive: missing file")
+	}
+	return nil
+}
+
+func (a *binArchive) unpackFile(name string, r io.Reader) error {
+	if name == "metadata" {
+		var err error
+		if a.metadata, err = readMetaData(r); err != nil {
+			return fmt.Errorf("failed to decode metadata: %v", err)
+		}
+		return nil
+	}
+	if name != "cover.exe" && name != "sonar.exe" {
+		return fmt.Errorf("unknown file '%v' in input archive", name)
+	}
+	f, err := ioutil.TempFile("", "go-fuzz")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	f, err = os.OpenFile(f.Name()+".exe", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0700)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %v", err)
+	}
+	if name == "cover.exe" {
+		a.coverBin = f.Name()
+	} else {
+		a.sonarBin = f.Name()
+	}
+	_, err = io.Copy(f, r)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("failed to uzip bin file: %v", err)
+	}
+	return nil
+}
+
+// selectFunc selects function to fuzz, fnname can be empty if the binary
+// has a default function or a single function.
+func (a *binArchive) selectFunc(fnname string) error {
+	metadata := a.metadata
+	if fnname == "" {
+		fnname = metadata.DefaultFunc
+	}
+	if fnname == "" && len(metadata.Funcs) == 1 {
+		fnname = metadata.Funcs[0]
+	}
+	if fnname == "" {
+		return fmt.Errorf("-func flag not provided, but multiple fuzz functions available: %v", strings.Join(metadata.Funcs, ", "))
+	}
+	fnidx := -1
+	for i, n := range metadata.Funcs {
+		if n == fnname {
+			fnidx = i
+			break
+		}
+	}
+	if fnidx == -1 {
+		return fmt.Errorf("function %v not found, available functions are: %v", fnname, strings.Join(metadata.Funcs, ", "))
+	}
+	if int(uint8(fnidx)) != fnidx {
+		return fmt.Errorf("internal consistency error, please file an issue: too many fuzz functions: %v", metadata.Funcs)
+	}
+	a.fn = fnname
+	a.fnidx = uint8(fnidx)
+	return nil
+}
+
+// cleanup removes unpacked binaries.
+func (a *binArchive) cleanup() {
+	if a.coverBin != "" {
+		os.Remove(a.coverBin)
+	}
+	if a.sonarBin != "" {
+		os.Remove(a.sonarBin)
+	}
+}
+
+// workerMain starts fuzzing workers, they run until shutdown.
+func workerMain() (*Hub, error) {
+	arch, err := openBinArchive()
+	if err != nil {
+		return nil, err
+	}
+	metadata := arch.metadata
+	if *flagDict != "" {
+		tokens, err := loadDict(*flagDict)
+		if err != nil {
+			arch.cleanup()
+			return nil, err
+		}
+		metadata.Literals = mergeLiterals(metadata.Literals, tokens)
+	}
+
+	hub, err := newHub(metadata, arch.fn)
+	if err != nil {
+		arch.cleanup()
+		return nil, err
+	}
+	shutdownCleanup = append(shutdownCleanup, arch.cleanup)
+	if *flagCoverProfile != "" {
+		shutdownCleanup = append(shutdownCleanup, func() {
+			ro := hub.ro.Load().(*ROData)
+			dumpCoverProfile(*flagCoverProfile, ro.coverBlocks, ro.corpusCover)
+		})
+	}
+	if *flagCovReport != "" {
+		shutdownCleanup = append(shutdownCleanup, func() {
+			ro := hub.ro.Load().(*ROData)
+			dumpCoverReport(*flagCovReport, ro.coverBlocks, ro.corpusCover, hub.estimatedBlockHits())
+		})
+	}
+	hub.arch = arch
+	hub.scale(*flagProcs)
+	return hub, nil
+}
+
+// readMetaData decodes metadata produced by go-fuzz-build.
+// Metadata without a version (produced before versioning was introduced)
+// is treated as version 0.
+func readMetaData(r io.Reader) (MetaData, error) {
+	var metadata MetaData
+	if err := json.NewDecoder(r).Decode(&metadata); err != nil {
+		return MetaData{}, err
+	}
+	if metadata.Version > MetaDataVersion {
+		return MetaData{}, fmt.Errorf("metadata version %v produced by a newer go-fuzz-build, this go-fuzz supports up to version %v; please update go-fuzz",
+			metadata.Version, MetaDataVersion)
+	}
+	return metadata, nil
+}
+
+func (w *Worker) loop() {
+	iter, fuzzSonarIter, versifierSonarIter := 0, 0, 0
+	for atomic.LoadUint32(&shutdown) == 0 {
+		if w.stopped() {
+			// Send stats of the last executions, the hub runs until shutdown.
+			w.hub.syncC <- w.stats
+			break
+		}
+		if len(w.crasherQueue) > 0 {
+			n := len(w.crasherQueue) - 1
+			crash := w.crasherQueue[n]
+			w.crasherQueue[n] = NewCrasherArgs{}
+			w.crasherQueue = w.crasherQueue[:n]
+			if *flagV >= 2 {
+				log.Printf("worker %v processes crasher [%v]%v", w.id, len(crash.Data), hash(crash.Data))
+			}
+			w.processCrasher(crash)
+			continue
+		}
+
+		select {
+		case input := <-w.hub.triageC:
+			if *flagV >= 2 {
+				log.Printf("worker %v triages coordinator input [%v]%v minimized=%v smashed=%v", w.id, len(input.Data), hash(input.Data), input.Minimized, input.Smashed)
+			}
+			w.triageInput(input)
+			for {
+				x := atomic.LoadUint32(&w.hub.initialTriage)
+				if x == 0 || atomic.CompareAndSwapUint32(&w.hub.initialTriage, x, x-1) {
+					break
+				}
+			}
+			continue
+		default:
+		}
+
+		if atomic.LoadUint32(&w.hub.initialTriage) != 0 {
+			// Other workers are still triaging initial inputs.
+			// Wait until they finish, otherwise we can generate
+			// as if new interesting inputs that are not actually new
+			// and thus unnecessary inflate corpus on every run.
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		if len(w.triageQueue) > 0 {
+			n := len(w.triageQueue) - 1
+			input := w.triageQueue[n]
+			w.triageQueue[n] = CoordinatorInput{}
+			w.triageQueue = w.triageQueue[:n]
+			if *flagV >= 2 {
+				log.Printf("worker %v triages local input [%v]%v minimized=%v smashed=%v", w.id, len(input.Data), hash(input.Data), input.Minimized, input.Smashed)
+			}
+			w.triageInput(input)
+			continue
+		}
+
+		ro := w.hub.ro.Load().(*ROData)
+		if len(ro.corpus) == 0 {
+			// Some other worker triages corpus inputs.
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+
+		// 9 out of 10 iterations are random fuzzing.
+		iter++
+		if iter%10 != 0 || ro.verse == nil {
+			data, depth := w.mutator.generate(ro)
+			w.parents, w.ops = w.mutator.parents, w.mutator.ops
+			// Every 1000-th iteration goes to sonar.
+			fuzzSonarIter++
+			if *flagSonar && fuzzSonarIter%1000 == 0 {
+				// TODO: ensure that generated hint inputs does not actually take 99% of time.
+				sonar := w.testInputSonar(data, depth)
+				w.processSonarData(data, sonar, depth, false)
+			} else {
+				// Plain old blind fuzzing.
+				w.testInput(data, depth, execFuzz)
+			}
+		} else {
+			// 1 out of 10 iterations goes to versifier.
+			data := ro.verse.Rhyme()
+			w.parents, w.ops = nil, nil
+			maxSize := ro.maxInputSize() - 5*SonarMaxLen // need some gap for sonar replacements
+			if maxSize <= 0 {
+				maxSize = ro.maxInputSize()
+			}
+			if len(data) > maxSize {
+				data = data[:maxSize]
+			}
+			// Every 100-th versifier input goes to sonar.
+			versifierSonarIter++
+			if *flagSonar && versifierSonarIter%100 == 0 {
+				sonar := w.testInputSonar(data, 0)
+				w.processSonarData(data, sonar, 0, false)
+			} else {
+				w.testInput(data, 0, execVersifier)
+			}
+		}
+	}
+	w.shutdown()
+}
+
+// triageInput processes every new input.
+// It calculates per-input metrics like execution time, coverage mask,
+// and minimizes the input to the minimal input with the same coverage.
+func (w *Worker) triageInput(input CoordinatorInput) {
+	if maxSize := w.hub.ro.Load().(*ROData).maxInputSize(); len(input.Data) > maxSize {
+		input.Data = input.Data[:maxSize]
+	}
+	inp := Input{
+		data:  input.Data,
+		depth: int(input.Prio),
+		typ:   input.Type,
+	}
+	if !w.measureInput(&inp) {
+		return
+	}
+	if !input.Minimized {
+		inp.mine = true
+		inp.prov = input.Prov
+		if inp.prov == nil {
+			// Inputs from the coordinator that need minimization are user seeds.
+			inp.prov = &Provenance{Origin: originSeed, Parents: []Sig{hash(input.Data)}}
+		}
+		// Inputs found during minimization are siblings of the minimized input.
+		w.parents, w.ops = inp.prov.Parents, nil
+		ro := w.hub.ro.Load().(*ROData)
+		// When minimizing new inputs we don't pursue exactly the same coverage,
+		// instead we pursue just the "novelty" in coverage.
+		// Here we use corpusCover, because maxCover already includes the input coverage.
+		newCover, ok := findNewCover(ro.corpusCover, inp.cover)
+		if !ok && inp.res > 0 {
+			// The user said the input is interesting, keep it
+			// if it is new among such inputs.
+			newCover, ok = findNewCover(ro.corpusResCover, inp.cover)
+		}
+		newOutput := false
+		if !ok && ro.newOutput(&inp) {
+			// Differential fuzz function produced a new output signature, keep it
+			// and minimize it to the minimal input with the same signature.
+			newOutput, ok = true, true
+		}
+		if !ok {
+			return // covered by somebody else
+		}
+		inp.data = w.minimizeInput(inp.data, false, func(candidate, cover, output []byte, res int, crashed, hanged bool) bool {
+			if crashed {
+				w.noteCrasher(candidate, cover, output, hanged)
+				return false
+			}
+			if newOutput {
+				if sig, ok := w.coverBin.outputSig(); !ok || sig != inp.output {
+					w.noteNewOutput(w.coverBin, candidate, res, inp.depth+1, execMinimizeInput)
+					return false
+				}
+				return true
+			}
+			if inp.res != res || worseCover(newCover, cover) {
+				w.noteNewInput(candidate, cover, res, inp.depth+1, execMinimizeInput)
+				return false
+			}
+			return true
+		})
+		// Structured inputs are stored in the canonical encoding.
+		// It can lose coverage of the decoder itself (e.g. of a non-minimal
+		// varint), so the metrics are recalculated for the canonical input.
+		if w.canonicalize {
+			if data := w.coverBin.canonicalize(inp.data); data != nil && !bytes.Equal(data, inp.data) && len(data) <= ro.maxInputSize() {
+				canon := Input{
+					mine:  true,
+					data:  data,
+					prov:  inp.prov,
+					depth: inp.depth,
+					typ:   inp.typ,
+				}
+				if !w.measureInput(&canon) {
+					return
+				}
+				if _, ok := findNewCover(ro.corpusCover, canon.cover); !ok && (canon.res <= 0 || !compareCover(ro.corpusResCover, canon.cover)) && !ro.newOutput(&canon) {
+					return
+				}
+				inp = canon
+			}
+		}
+		inp.prov.NewBlocks = newBlocks(ro.corpusCover, inp.cover)
+	} else if !input.Smashed {
+		w.smash(inp.data, inp.depth)
+	}
+	inp.coverSize = 0
+	for _, v := range inp.cover {
+		if v != 0 {
+			inp.coverSize++
+		}
+	}
+	w.hub.newInputC <- inp
+}
+
+// measureInput calculates min exec time, max coverage and max result of 3 runs of inp.data.
+// It returns false if the input crashes.
+func (w *Worker) measureInput(inp *Input) bool {
+	inp.execTime = 1 << 60
+	for i := 0; i < 3; i++ {
+		w.execs[execTriageInput]++
+		res, ns, cover, _, output, crashed, hanged := w.coverBin.test(inp.data)
+		if crashed {
+			// Inputs in corpus should not crash.
+			w.noteCrasher(inp.data, cover, output, hanged)
+			return false
+		}
+		if inp.cover == nil {
+			inp.cover = make([]byte, CoverSize)
+			copy(inp.cover, cover)
+		} else {
+			for i, v := range cover {
+				x := inp.cover[i]
+				if v > x {
+					inp.cover[i] = v
+				}
+			}
+		}
+		if inp.res < res {
+			inp.res = res
+		}
+		if inp.execTime > ns {
+			inp.execTime = ns
+		}
+	}
+	if w.hub.output {
+		inp.output, inp.outputOK = w.coverBin.outputSig()
+	}
+	return true
+}
+
+// processCrasher minimizes new crashers and sends them to the hub.
+// Minimization runs in the worker, so a candidate that hangs only kills the test binary.
+// A candidate is accepted if it crashes with the same suppression
+// (with -dedup=stack it is the normalized crash stack).
+func (w *Worker) processCrasher(crash NewCrasherArgs) {
+	if *flagCrashCover && crash.Cover == nil && !crash.Hanging && !crash.Stray {
+		// The crash was found by the sonar binary, it does not count coverage.
+		w.execs[execMinimizeCrasher]++
+		_, _, cover, _, output, crashed, hanged := w.coverBin.test(crash.Data)
+		if crashed && !hanged && bytes.Equal(crash.Suppression, extractSuppression(output)) {
+			ro := w.hub.ro.Load().(*ROData)
+			crash.Cover = crashCoverage(ro.coverBlocks, cover)
+		}
+	}
+	// Hanging inputs can take very long time to minimize.
+	if !crash.Hanging && !crash.Stray && *flagMinimizeCrasher != 0 {
+		minimized := w.minimizeInput(crash.Data, true, func(candidate, cover, output []byte, res int, crashed, hanged bool) bool {
+			if !crashed {
+				return false
+			}
+			supp := extractSuppression(output)
+			if hanged || !bytes.Equal(crash.Suppression, supp) {
+				w.noteCrasher(candidate, cover, output, hanged)
+				return false
+			}
+			return true
+		})
+		if !bytes.Equal(minimized, crash.Data) {
+			crash.Minimized = minimized
+		}
+	}
+	w.hub.newCrasherC <- crash
+}
+
+// minimizeInput applies series of minimizing transformations to data
+// and asks pred whether the input is equivalent to the original one or not.
+func (w *Worker) minimizeInput(data []byte, canonicalize bool, pred func(candidate, cover, output []byte, result int, crashed, hanged bool) bool) []byte {
+	res := make([]byte, len(data))
+	copy(res, data)
+	start := time.Now()
+	stat := &w.execs[execMinimizeInput]
+	limit := *flagMinimize
+	if canonicalize {
+		stat = &w.execs[execMinimizeCrasher]
+		limit = *flagMinimizeCrasher
+	}
+
+	// First, try to cut tail.
+	for n := 1024; n != 0; n /= 2 {
+		for len(res) > n {
+			if time.Since(start) > limit {
+				return res
+			}
+			candidate := res[:len(res)-n]
+			*stat++
+			result, _, cover, _, output, crashed, hanged := w.coverBin.test(candidate)
+			if !pred(candidate, cover, output, result, crashed, hanged) {
+				break
+			}
+			res = candidate
+		}
+	}
+
+	// Then, try to remove byte ranges of decreasing size,
+	// this quickly strips padding in front of and between the interesting bytes.
+	tmp := make([]byte, len(res))
+	for n := len(res) / 2; n > 1; n /= 2 {
+		for i := 0; i+n <= len(res); {
+			if time.Since(start) > limit {
+				return res
+			}
+			candidate := tmp[:len(res)-n]
+			copy(candidate[:i], res[:i])
+			copy(candidate[i:], res[i+n:])
+			*stat++
+			result, _, cover, _, output, crashed, hanged := w.coverBin.test(candidate)
+			if !pred(candidate, cover, output, result, crashed, hanged) {
+				i += n
+				continue
+			}
+			res = makeCopy(candidate)
+		}
+	}
+
+	// Then, try to remove each individual byte.
+	for i := 0; i < len(res); i++ {
+		if time.Since(start) > limit {
+			return res
+		}
+		candidate := tmp[:len(res)-1]
+		copy(candidate[:i], res[:i])
+		copy(candidate[i:], res[i+1:])
+		*stat++
+		result, _, cover, _, output, crashed, hanged := w.coverBin.test(candidate)
+		if !pred(candidate, cover, output, result, crashed, hanged) {
+			continue
+		}
+		res = makeCopy(candidate)
+		i--
+	}
+
+	// Then, try to remove each possible subset of bytes.
+	for i := 0; i < len(res)-1; i++ {
+		copy(tmp, res[:i])
+		for j := len(res); j > i+1; j-- {
+			if time.Since(start) > limit {
+				return res
+			}
+			candidate := tmp[:len(res)-j+i]
+			copy(candidate[i:], res[j:])
+			*stat++
+			result, _, cover, _, output, crashed, hanged := w.coverBin.test(candidate)
+			if !pred(candidate, cover, output, result, crashed, hanged) {
+				continue
+			}
+			res = makeCopy(candidate)
+			j = len(res)
+		}
+	}
+
+	// Then, try to replace byte ranges of decreasing size with '0',
+	// and finally each individual byte.
+	if canonicalize {
+		for n := len(res) / 2; n > 1; n /= 2 {
+			for i := 0; i+n <= len(res); i += n {
+				if bytes.Count(res[i:i+n], []byte{'0'}) == n {
+					continue
+				}
+				if time.Since(start) > limit {
+					return res
+				}
+				candidate := tmp[:len(res)]
+				copy(candidate, res)
+				for j := i; j < i+n; j++ {
+					candidate[j] = '0'
+				}
+				*stat++
+				result, _, cover, _, output, crashed, hanged := w.coverBin.test(candidate)
+				if !pred(candidate, cover, output, result, crashed, hanged) {
+					continue
+				}
+				res = makeCopy(candidate)
+			}
+		}
+		for i := 0; i < len(res); i++ {
+			if res[i] == '0' {
+				continue
+			}
+			if time.Since(start) > limit {
+				return res
+			}
+			candidate := tmp[:len(res)]
+			copy(candidate, res)
+			candidate[i] = '0'
+			*stat++
+			result, _, cover, _, output, crashed, hanged := w.coverBin.test(candidate)
+			if !pred(candidate, cover, output, result, crashed, hanged) {
+				continue
+			}
+			res = makeCopy(candidate)
+		}
+	}
+
+	return res
+}
+
+// smash gives some minimal attention to every new input.
+func (w *Worker) smash(data []byte, depth int) {
+	ro := w.hub.ro.Load().(*ROData)
+	sig := hash(data)
+	w.parents, w.ops = []Sig{sig}, nil
+
+	// Pass it through sonar.
+	if *flagSonar {
+		sonar := w.testInputSonar(data, depth)
+		w.processSonarData(data, sonar, depth, true)
+	}
+
+	// Flip each bit one-by-one.
+	for i := 0; i < len(data)*8; i++ {
+		data[i/8] ^= 1 << uint(i%8)
+		w.testInput(data, depth, execSmash)
+		data[i/8] ^= 1 << uint(i%8)
+	}
+
+	// Two walking bits.
+	for i := 0; i < len(data)*8-1; i++ {
+		data[i/8] ^= 1 << uint(i%8)
+		data[(i+1)/8] ^= 1 << uint((i+1)%8)
+		w.testInput(data, depth, execSmash)
+		data[i/8] ^= 1 << uint(i%8)
+		data[(i+1)/8] ^= 1 << uint((i+1)%8)
+	}
+
+	// Four walking bits.
+	for i := 0; i < len(data)*8-3; i++ {
+		data[i/8] ^= 1 << uint(i%8)
+		data[(i+1)/8] ^= 1 << uint((i+1)%8)
+		data[(i+2)/8] ^= 1 << uint((i+2)%8)
+		data[(i+3)/8] ^= 1 << uint((i+3)%8)
+		w.testInput(data, depth, execSmash)
+		data[i/8] ^= 1 << uint(i%8)
+		data[(i+1)/8] ^= 1 << uint((i+1)%8)
+		data[(i+2)/8] ^= 1 << uint((i+2)%8)
+		data[(i+3)/8] ^= 1 << uint((i+3)%8)
+	}
+
+	// Byte flip.
+	for i := 0; i < len(data); i++ {
+		data[i] ^= 0xff
+		w.testInput(data, depth, execSmash)
+		data[i] ^= 0xff
+	}
+
+	// Two walking bytes.
+	for i := 0; i < len(data)-1; i++ {
+		data[i] ^= 0xff
+		data[i+1] ^= 0xff
+		w.testInput(data, depth, execSmash)
+		data[i] ^= 0xff
+		data[i+1] ^= 0xff
+	}
+
+	// Four walking bytes.
+	for i := 0; i < len(data)-3; i++ {
+		data[i] ^= 0xff
+		data[i+1] ^= 0xff
+		data[i+2] ^= 0xff
+		data[i+3] ^= 0xff
+		w.testInput(data, depth, execSmash)
+		data[i] ^= 0xff
+		data[i+1] ^= 0xff
+		data[i+2] ^= 0xff
+		data[i+3] ^= 0xff
+	}
+
+	// Increment/decrement every byte.
+	for i := 0; i < len(data); i++ {
+		for j := uint8(1); j <= 4; j++ {
+			data[i] += j
+			w.testInput(data, depth, execSmash)
+			data[i] -= j
+			data[i] -= j
+			w.testInput(data, depth, execSmash)
+			data[i] += j
+		}
+	}
+
+	// Set bytes to interesting values.
+	for i := 0; i < len(data); i++ {
+		v := data[i]
+		for _, x := range interesting8 {
+			data[i] = uint8(x)
+			w.testInput(data, depth, execSmash)
+		}
+		data[i] = v
+	}
+
+	// Set words to interesting values.
+	for i := 0; i < len(data)-1; i++ {
+		p := (*int16)(unsafe.Pointer(&data[i]))
+		v := *p
+		for _, x := range interesting16 {
+			*p = x
+			w.testInput(data, depth, execSmash)
+			if x != 0 && x != -1 {
+				*p = int16(bits.ReverseBytes16(uint16(x)))
+				w.testInput(data, depth, execSmash)
+			}
+		}
+		*p = v
+	}
+
+	// Set double-words to interesting values.
+	for i := 0; i < len(data)-3; i++ {
+		p := (*int32)(unsafe.Pointer(&data[i]))
+		v := *p
+		for _, x := range interesting32 {
+			*p = x
+			w.testInput(data, depth, execSmash)
+			if x != 0 && x != -1 {
+				*p = int32(bits.ReverseBytes32(uint32(x)))
+				w.testInput(data, depth, execSmash)
+			}
+		}
+		*p = v
+	}
+
+	// Trim after every byte.
+	for i := 1; i < len(data); i++ {
+		tmp := data[:i]
+		w.testInput(tmp, depth, execSmash)
+	}
+
+	// Insert a byte after every byte.
+	maxSize := ro.maxInputSize()
+	tmp := make([]byte, len(data)+1)
+	if len(tmp) > maxSize {
+		tmp = tmp[:maxSize]
+	}
+	for i := 0; i <= len(data) && i < maxSize-1; i++ {
+		copy(tmp, data[:i])
+		copy(tmp[i+1:], data[i:])
+		tmp[i] = 0
+		w.testInput(tmp, depth, execSmash)
+		tmp[i] = 'a'
+		w.testInput(tmp, depth, execSmash)
+	}
+
+	// Do a bunch of random mutations so that this input catches up with the rest.
+	for i := 0; i < 1e4; i++ {
+		w.mutator.setParent(sig)
+		tmp := w.mutator.mutate(data, ro)
+		w.parents, w.ops = w.mutator.parents, w.mutator.ops
+		w.testInput(tmp, depth+1, execFuzz)
+	}
+}
+
+func (w *Worker) testInput(data []byte, depth int, typ execType) {
+	w.testInputImpl(w.coverBin, data, depth, typ)
+}
+
+func (w *Worker) testInputSonar(data []byte, depth int) (sonar []byte) {
+	return w.testInputImpl(w.sonarBin, data, depth, execSonar)
+}
+
+func (w *Worker) testInputImpl(bin *TestBinary, data []byte, depth int, typ execType) (sonar []byte) {
+	ro := w.hub.ro.Load().(*ROData)
+	if len(data) > ro.maxInputSize() {
+		// Sonar replacements and smashing can grow inputs over the limit.
+		data = data[:ro.maxInputSize()]
+	}
+	if len(ro.badInputs) > 0 {
+		if _, ok := ro.badInputs[hash(data)]; ok {
+			return nil // no, thanks
+		}
+	}
+	w.execs[typ]++
+	res, _, cover, sonar, output, crashed, hanged := bin.test(data)
+	if crashed {
+		w.noteCrasher(data, cover, output, hanged)
+		return nil
+	}
+	if typ == execFuzz && w.execs[typ]%blockHitsSample == 0 {
+		w.noteBlockHits(cover)
+	}
+	if (w.noteNewInput(data, cover, res, depth, typ) || w.noteNewOutput(bin, data, res, depth, typ)) && typ == execFuzz {
+		w.stats.usedTokens = append(w.stats.usedTokens, w.mutator.dynLits...)
+	}
+	// Leak check executes other inputs, so it goes after everything that needs the last execution.
+	if *flagLeakCheck && bin == w.coverBin {
+		w.checkLeaks(data)
+	}
+	return sonar
+}
+
+// noteToken queues a new token for the dynamic dictionary.
+func (w *Worker) noteToken(tok []byte) {
+	if _, ok := w.tokens[string(tok)]; ok {
+		return
+	}
+	if w.tokens == nil || len(w.tokens) > 4*maxDynamicTokens {
+		w.tokens = make(map[string]struct{})
+	}
+	w.tokens[string(tok)] = struct{}{}
+	w.stats.tokens = append(w.stats.tokens, makeCopy(tok))
+}
+
+// blockHitsSample is the sampling rate of fuzzing executions used to estimate block hit frequencies,
+// checking every execution would double cover processing time.
+const blockHitsSample = 16
+
+func (w *Worker) noteBlockHits(cover []byte) {
+	if w.stats.blockHits == nil {
+		w.stats.blockHits = make([]uint32, CoverSize)
+	}
+	for i, c := range cover {
+		if c != 0 {
+			w.stats.blockHits[i]++
+		}
+	}
+}
+
+// noteNewInput queues data for triage if it gives new coverage, it returns whether data was queued.
+func (w *Worker) noteNewInput(data, cover []byte, res, depth int, typ execType) bool {
+	if res < 0 {
+		// User said to not add this input to corpus.
+		return false
+	}
+	// User said the input is interesting (e.g. parsed successfully),
+	// it is kept if it is new among such inputs even without new coverage.
+	newCover := w.hub.updateMaxCover(cover)
+	newRes := res > 0 && w.hub.updateMaxResCover(cover)
+	if !newCover && !newRes {
+		return false
+	}
+	w.triageQueue = append(w.triageQueue, CoordinatorInput{makeCopy(data), uint64(depth), typ, false, false, w.provenance(typ)})
+	return true
+}
+
+func (w *Worker) noteCrasher(data, cover, output []byte, hanged bool) {
+	if crash, ok := w.newCrasher(data, cover, output, hanged); ok {
+		w.crasherQueue = append(w.crasherQueue, crash)
+	}
+}
+
+// noteStrayCrash queues a crash of the test binary that happened after data was executed.
+// A single execution does not reproduce such crash, so it is not minimized.
+func (w *Worker) noteStrayCrash(data, cover, output []byte) {
+	if crash, ok := w.newCrasher(data, cover, output, false); ok {
+		crash.Stray = true
+		w.crasherQueue = append(w.crasherQueue, crash)
+	}
+}
+
+// newCrasher describes crash of the test binary on data, ok is false if the crash is suppressed.
+// With -crashcover cover holds coverage counters of the crashing execution.
+func (w *Worker) newCrasher(data, cover, output []byte, hanged bool) (crash NewCrasherArgs, ok bool) {
+	ro := w.hub.ro.Load().(*ROData)
+	supp := extractSuppression(output)
+	if _, ok := ro.suppressions[hash(supp)]; ok {
+		return NewCrasherArgs{}, false
+	}
+	var hangTimeout time.Duration
+	if hanged {
+		hangTimeout = *flagHangTimeout
+	}
+	var memLimit uint64
+	if bytes.Contains(output, []byte(MemLimitMsg)) ||
+		*flagMemLimit != 0 && bytes.Contains(output, []byte("fatal error: out of memory\n")) {
+		// The latter is printed by the runtime when an allocation
+		// hits the address space cap set along with the limit.
+		memLimit = *flagMemLimit
+	}
+	return NewCrasherArgs{
+		Data:        makeCopy(data),
+		Error:       output,
+		Suppression: supp,
+		Class:       crashClass(output),
+		Cover:       crashCoverage(ro.coverBlocks, cover),
+		Hanging:     hanged,
+		HangTimeout: hangTimeout,
+		MemLimit:    memLimit,
+	}, true
+}
+
+func (w *Worker) periodicCheck() {
+	if atomic.LoadUint32(&shutdown) != 0 {
+		w.shutdown()
+		runtime.Goexit()
+	}
+	if time.Since(w.lastSync) < syncPeriod {
+		return
+	}
+	w.execs[execTotal] += w.stats.execs
+	w.lastSync = time.Now()
+	w.hub.syncC <- w.stats
+	w.stats.execs = 0
+	w.stats.restarts = 0
+	w.stats.blockHits = nil
+	w.stats.tokens = nil
+	w.stats.usedTokens = nil
+	w.stats.sonarTrace = nil
+	if *flagV >= 2 {
+		log.Printf("worker %v: triageq=%v execs=%v mininp=%v mincrash=%v triage=%v fuzz=%v versifier=%v smash=%v sonar=%v hint=%v",
+			w.id, len(w.triageQueue),
+			w.execs[execTotal], w.execs[execMinimizeInput], w.execs[execMinimizeCrasher],
+			w.execs[execTriageInput], w.execs[execFuzz], w.execs[execVersifier], w.execs[execSmash],
+			w.execs[execSonar], w.execs[execSonarHint])
+	}
+}
+
+// shutdown cleanups after worker, it is not guaranteed to be called.
+func (w *Worker) shutdown() {
+	w.coverBin.close()
+	w.sonarBin.close()
+}
+
+func extractSuppression(out []byte) []byte {
+	if *flagDedup == "stack" {
+		// A panic and a runtime fatal error at the same site are different bugs.
+		if stack := extractStack(out); len(stack) != 0 {
+			return append([]byte(crashClass(out)+"\n"), stack...)
+		}
+	}
+	var supp []byte
+	seenPanic := false
+	collect := false
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		if !seenPanic && (strings.HasPrefix(line, "panic: ") ||
+			strings.HasPrefix(line, "fatal error: ") ||
+			strings.HasPrefix(line, "SIG") && strings.Index(line, ": ") != 0) {
+			// Start of a crash message.
+			seenPanic = true
+			supp = append(supp, line...)
+			supp = append(supp, '\n')
+			if line == "SIGABRT: abort" || line == "signal: killed" {
+				return supp // timeout stacks are flaky
+			}
+		}
+		if collect && line == "runtime stack:" {
+			// Skip runtime stack.
+			// Unless it is a runtime bug, user stack is more descriptive.
+			collect = false
+		}
+		if collect && len(line) > 0 && (line[0] >= 'a' && line[0] <= 'z' ||
+			line[0] >= 'A' && line[0] <= 'Z') {
+			// Function name line.
+			idx := strings.LastIndex(line, "(")
+			if idx != -1 {
+				supp = append(supp, line[:idx]...)
+				supp = append(supp, '\n')
+			}
+		}
+		if collect && line == "" {
+			// End of first goroutine stack.
+			break
+		}
+		if seenPanic && !collect && line == "" {
+			// Start of first goroutine stack.
+			collect = true
+		}
+	}
+	if len(supp) == 0 {
+		supp = exitSuppression(out)
+	}
+	return supp
+}
+
+// dedupStackFrames is the number of top stack frames used for -dedup=stack.
+const dedupStackFrames = 10
+
+// extractStack returns normalized top frames of the first goroutine stack in crash output.
+// Panic message, argument values, goroutine numbers, pc offsets and file dirs
+// are stripped, because they vary for different inputs that trigger the same bug.
+func extractStack(out []byte) []byte {
+	var supp []byte
+	seenPanic := false
+	collect := false
+	frames := 0
+	inFrame := false
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() && frames < dedupStackFrames {
+		line := s.Text()
+		if !seenPanic && (strings.HasPrefix(line, "panic: ") ||
+			strings.HasPrefix(line, "fatal error: ") ||
+			strings.HasPrefix(line, "SIG") && strings.Index(line, ": ") != 0) {
+			if line == "SIGABRT: abort" || line == "signal: killed" {
+				return nil // timeout stacks are flaky
+			}
+			seenPanic = true
+			continue
+		}
+		if collect && line == "runtime stack:" {
+			collect = false
+		}
+		if collect && line == "" {
+			break
+		}
+		if seenPanic && !collect && line == "" {
+			collect = true
+			continue
+		}
+		if !collect {
+			continue
+		}
+		if inFrame && strings.HasPrefix(line, "\t") {
+			// File line, e.g. "\t/tmp/go-fuzz-build123/src/foo/foo.go:12 +0x1d".
+			file := strings.TrimSpace(line)
+			if idx := strings.LastIndex(file, " +0x"); idx != -1 {
+				file = file[:idx]
+			}
+			file = file[strings.LastIndexAny(file, "/\\")+1:]
+			supp = append(supp, '\t')
+			supp = append(supp, file...)
+			supp = append(supp, '\n')
+			frames++
+			inFrame = false
+			continue
+		}
+		inFrame = false
+		if len(line) > 0 && (line[0] >= 'a' && line[0] <= 'z' ||
+			line[0] >= 'A' && line[0] <= 'Z') && !strings.HasPrefix(line, "created by ") {
+			// Function name line.
+			idx := strings.LastIndex(line, "(")
+			if idx != -1 {
+				supp = append(supp, line[:idx]...)
+				supp = append(supp, '\n')
+				inFrame = true
+			}
+		}
+	}
+	return supp
+}
+
+func reverse(data []byte) []byte {
+	tmp := make([]byte, len(data))
+	for i, v := range data {
+		tmp[len(data)-i-1] = v
+	}
+	return tmp
+}
+
+func increment(data []byte) []byte {
+	tmp := make([]byte, len(data))
+	copy(tmp, data)
+	for i, v := range data {
+		tmp[i] = v + 1
+		if v != 0xff {
+			break
+		}
+	}
+	return tmp
+}
+
+func decrement(data []byte) []byte {
+	tmp := make([]byte, len(data))
+	copy(tmp, data)
+	for i, v := range data {
+		tmp[i] = v - 1
+		if v != 0 {
+			break
+		}
+	}
+	return tmp
+}
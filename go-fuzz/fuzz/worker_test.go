@@ -0,0 +1,223 @@
+package fuzz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	. "github.com/dvyukov/go-fuzz/internal/go-fuzz-types"
+)
+
+func TestIncrementDecrement(t *testing.T) {
+	b := make([]byte, 2)
+	for i := 0; i < math.MaxUint16; i++ {
+		u := uint16(i)
+		binary.LittleEndian.PutUint16(b, u)
+
+		b1 := increment(b)
+		u1 := binary.LittleEndian.Uint16(b1)
+		if u+1 != u1 {
+			t.Fatalf("increment(%d) = %d, want %d", u, u1, u+1)
+		}
+
+		b1 = decrement(b)
+		u1 = binary.LittleEndian.Uint16(b1)
+		if u-1 != u1 {
+			t.Fatalf("decrement(%d) = %d, want %d", u, u1, u-1)
+		}
+	}
+}
+
+func TestReadMetaDataVersion(t *testing.T) {
+	// Metadata produced before versioning was introduced.
+	type oldMetaData struct {
+		Literals    []Literal
+		Blocks      []CoverBlock
+		Sonar       []CoverBlock
+		Funcs       []string
+		DefaultFunc string
+	}
+	old := oldMetaData{
+		Blocks:      []CoverBlock{{ID: 1, File: "a.go", StartLine: 1, EndLine: 2, NumStmt: 1}},
+		Funcs:       []string{"Fuzz"},
+		DefaultFunc: "Fuzz",
+	}
+	data, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := readMetaData(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to read version 0 metadata: %v", err)
+	}
+	if meta.Version != 0 || len(meta.Blocks) != 1 || meta.Blocks[0].File != "a.go" || meta.DefaultFunc != "Fuzz" {
+		t.Fatalf("bad version 0 metadata: %+v", meta)
+	}
+
+	// Current metadata.
+	data, err = json.Marshal(MetaData{Version: MetaDataVersion, Funcs: []string{"Fuzz"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta, err = readMetaData(bytes.NewReader(data)); err != nil || meta.Version != MetaDataVersion {
+		t.Fatalf("failed to read current metadata: %+v, %v", meta, err)
+	}
+
+	// Metadata from the future.
+	data, err = json.Marshal(MetaData{Version: MetaDataVersion + 1, Funcs: []string{"Fuzz"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readMetaData(bytes.NewReader(data))
+	want := fmt.Sprintf("metadata version %v produced by a newer go-fuzz-build", MetaDataVersion+1)
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("got error %v, want %q", err, want)
+	}
+
+	// Older go-fuzz would silently misinterpret metadata with these fields
+	// (SonarFloat is a flag in Sonar NumStmt), so they must come with
+	// a version that it refuses.
+	for field, version := range map[string]int{
+		"BigEndian":    2,
+		"OutputFuncs":  3,
+		"Canonicalize": 3,
+		"SonarFloat":   3,
+	} {
+		if MetaDataVersion < version {
+			t.Errorf("%v requires metadata version %v, but MetaDataVersion is %v", field, version, MetaDataVersion)
+		}
+	}
+}
+
+func TestExtractStack(t *testing.T) {
+	// Two crashes at the same site with different inputs.
+	out1 := []byte(`panic: runtime error: index out of range [5] with length 3
+
+goroutine 1 [running]:
+example.com/foo.parse(0xc000012345, 0x3, 0x3, 0x5)
+	/tmp/go-fuzz-build123/src/example.com/foo/foo.go:12 +0x1d
+example.com/foo.Fuzz(0xc000012345, 0x3, 0x3, 0x3)
+	/tmp/go-fuzz-build123/src/example.com/foo/fuzz.go:7 +0x65
+go-fuzz-dep.Main(0xc000076f48, 0x1, 0x1)
+	/tmp/go-fuzz-build123/src/go-fuzz-dep/main.go:36 +0x1ad
+main.main()
+	/tmp/go-fuzz-build123/src/example.com/foo/go.fuzz.main/main.go:15 +0x52
+exit status 2`)
+	out2 := []byte(`panic: runtime error: index out of range [42] with length 17
+
+goroutine 7 [running]:
+example.com/foo.parse({0xc0000a0000, 0x11, 0x11}, 0x2a)
+	/tmp/go-fuzz-build456/src/example.com/foo/foo.go:12 +0x2f
+example.com/foo.Fuzz({0xc0000a0000, 0x11, 0x11})
+	/tmp/go-fuzz-build456/src/example.com/foo/fuzz.go:7 +0x85
+go-fuzz-dep.Main({0xc000076f48, 0x1, 0x1})
+	/tmp/go-fuzz-build456/src/go-fuzz-dep/main.go:36 +0x1ad
+main.main()
+	/tmp/go-fuzz-build456/src/example.com/foo/go.fuzz.main/main.go:15 +0x52
+created by main.init in goroutine 1
+	/tmp/go-fuzz-build456/src/example.com/foo/go.fuzz.main/main.go:3 +0x10
+exit status 2`)
+	// Same function, but another line.
+	out3 := []byte(`panic: runtime error: index out of range [5] with length 3
+
+goroutine 1 [running]:
+example.com/foo.parse(...)
+	/tmp/go-fuzz-build123/src/example.com/foo/foo.go:14
+example.com/foo.Fuzz(0xc000012345, 0x3, 0x3, 0x3)
+	/tmp/go-fuzz-build123/src/example.com/foo/fuzz.go:7 +0x65
+go-fuzz-dep.Main(0xc000076f48, 0x1, 0x1)
+	/tmp/go-fuzz-build123/src/go-fuzz-dep/main.go:36 +0x1ad
+main.main()
+	/tmp/go-fuzz-build123/src/example.com/foo/go.fuzz.main/main.go:15 +0x52
+exit status 2`)
+
+	want := "example.com/foo.parse\n\tfoo.go:12\n" +
+		"example.com/foo.Fuzz\n\tfuzz.go:7\n" +
+		"go-fuzz-dep.Main\n\tmain.go:36\n" +
+		"main.main\n\tmain.go:15\n"
+	if got := string(extractStack(out1)); got != want {
+		t.Errorf("got stack:\n%s\nwant:\n%s", got, want)
+	}
+	if got := string(extractStack(out2)); got != want {
+		t.Errorf("got stack:\n%s\nwant:\n%s", got, want)
+	}
+	if bytes.Equal(extractStack(out1), extractStack(out3)) {
+		t.Errorf("crashes on different lines have the same stack")
+	}
+	if stack := extractStack([]byte("SIGABRT: abort\nPC=0x40c84e m=0 sigcode=0\n\ngoroutine 1 [running]:\nfoo()\n\tfoo.go:1\n")); stack != nil {
+		t.Errorf("got stack for a hang: %q", stack)
+	}
+
+	old := *flagDedup
+	defer func() { *flagDedup = old }()
+	*flagDedup = "output"
+	if bytes.Equal(extractSuppression(out1), extractSuppression(out2)) {
+		t.Errorf("-dedup=output: crashes with different messages have the same suppression")
+	}
+	*flagDedup = "stack"
+	if !bytes.Equal(extractSuppression(out1), extractSuppression(out2)) {
+		t.Errorf("-dedup=stack: crashes at the same site have different suppressions")
+	}
+	fatal := bytes.Replace(out1, []byte("panic: runtime error"), []byte("fatal error: runtime error"), 1)
+	if bytes.Equal(extractSuppression(out1), extractSuppression(fatal)) {
+		t.Errorf("-dedup=stack: a panic and a fatal error at the same site have the same suppression")
+	}
+}
+
+func TestExtractStackFrames(t *testing.T) {
+	// Deep recursion is cut to the top frames.
+	out := "panic: boom\n\ngoroutine 1 [running]:\n"
+	for i := 0; i < 2*dedupStackFrames; i++ {
+		out += fmt.Sprintf("foo.f%v(0x%x)\n\t/src/foo.go:%v +0x10\n", i, i, i)
+	}
+	stack := extractStack([]byte(out))
+	if n := strings.Count(string(stack), "\n\t"); n != dedupStackFrames {
+		t.Fatalf("got %v frames, want %v:\n%s", n, dedupStackFrames, stack)
+	}
+}
+
+func TestCrashClass(t *testing.T) {
+	tests := []struct {
+		out  string
+		want string
+	}{
+		{"panic: foo\n\ngoroutine 1 [running]:\nfoo()\n\tfoo.go:1 +0x1\nexit status 2", crashPanic},
+		// A runtime fatal error caused by a panic is still a panic.
+		{"panic: foo\nfatal error: panic while printing panic value\nexit status 2", crashPanic},
+		{"fatal error: concurrent map writes\n\ngoroutine 5 [running]:\nfoo()\n\tfoo.go:1 +0x1\nexit status 2", crashFatal},
+		{"fatal error: all goroutines are asleep - deadlock!\nexit status 2", crashFatal},
+		{"SIGSEGV: segmentation violation\nPC=0x40c84e m=0 sigcode=1\nexit status 2", crashSignal},
+		{"signal: killed", crashSignal},
+		{"2019/01/02 03:04:05 bad input\nexit status 1", crashExit},
+		{"exit status 3", crashExit},
+		// Messages printed by the target don't count in the middle of a line.
+		{"got panic: foo\nexit status 1", crashExit},
+	}
+	for _, test := range tests {
+		if got := crashClass([]byte(test.out)); got != test.want {
+			t.Errorf("crashClass(%q) = %v, want %v", test.out, got, test.want)
+		}
+	}
+}
+
+func TestExitSuppression(t *testing.T) {
+	// log.Fatal with different timestamps and preceding output.
+	out1 := []byte("parsing 123\n2019/01/02 03:04:05 bad input\nexit status 1")
+	out2 := []byte("parsing 4567\n2019/01/02 03:04:06 bad input\n\nexit status 1")
+	if !bytes.Equal(exitSuppression(out1), exitSuppression(out2)) {
+		t.Errorf("the same exit has different suppressions:\n%s\n%s", exitSuppression(out1), exitSuppression(out2))
+	}
+	if bytes.Equal(exitSuppression(out1), exitSuppression([]byte("bad input\nexit status 3"))) {
+		t.Errorf("exits with different statuses have the same suppression")
+	}
+	if bytes.Equal(exitSuppression(out1), exitSuppression([]byte("2019/01/02 03:04:05 unexpected EOF\nexit status 1"))) {
+		t.Errorf("exits with different messages have the same suppression")
+	}
+	if got := string(exitSuppression([]byte("some output\nsignal: killed"))); got != "signal: killed\n" {
+		t.Errorf("got suppression %q for a kill", got)
+	}
+}
@@ -4,8 +4,8 @@
 // Package pcg implements a 32 bit PRNG with a 64 bit period: pcg xsh rr 64 32.
 // See https://www.pcg-random.org/ for more information.
 // This implementation is geared specifically towards go-fuzz's needs:
-// Simple creation and use, no reproducibility, no concurrency safety,
-// just the methods go-fuzz needs, optimized for speed.
+// Simple creation and use, reproducibility only with an explicit seed,
+// no concurrency safety, just the methods go-fuzz needs, optimized for speed.
 package pcg
 
 import (
@@ -42,6 +42,16 @@ func New() *Rand {
 	return r
 }
 
+// NewSeeded returns a Rand that generates the same sequence for the same seed.
+func NewSeeded(seed uint64) *Rand {
+	r := new(Rand)
+	r.inc = (seed << 1) | 1
+	r.step()
+	r.state += seed
+	r.step()
+	return r
+}
+
 func (r *Rand) step() {
 	r.state *= multiplier
 	r.state += r.inc
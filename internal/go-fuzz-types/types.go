@@ -19,10 +19,38 @@ type Literal struct {
 	IsStr bool
 }
 
+// MetaDataVersion is the current version of the MetaData format.
+// It must be incremented whenever MetaData changes in a way
+// that older versions of go-fuzz can't handle, including fields that
+// change how other data must be interpreted.
+// Metadata produced before versioning was introduced has version 0.
+//
+// Versions:
+//
+//	1: Version field.
+//	2: BigEndian, sonar operands can be big-endian.
+//	3: OutputFuncs, differential functions return []byte instead of int;
+//	   Canonicalize, the test binary re-encodes inputs of Decode/Encode targets;
+//	   SonarFloat flag in Sonar NumStmt, operands are IEEE-754 bits.
+const MetaDataVersion = 3
+
 type MetaData struct {
+	Version     int // format version, see MetaDataVersion
 	Literals    []Literal
 	Blocks      []CoverBlock
 	Sonar       []CoverBlock
 	Funcs       []string // fuzz function names; must have length > 0
 	DefaultFunc string   // default function to fuzz
+	// Canonicalize is set if the target provides Decode and Encode,
+	// then the test binary re-encodes inputs for CanonicalizeFunc requests.
+	Canonicalize bool
+	// MaxInputSize is max meaningful input size advertised by the target
+	// with a //go-fuzz:maxinputsize directive, 0 if there is none.
+	MaxInputSize int
+	// BigEndian is set if the test binary is built for a big-endian architecture,
+	// then integer and float operands in sonar data are big-endian.
+	BigEndian bool
+	// OutputFuncs are differential fuzz functions of the form func([]byte) []byte,
+	// the test binary replies with hash of their result for OutputFunc requests.
+	OutputFuncs []string
 }
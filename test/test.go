@@ -98,6 +98,10 @@ func Fuzz(data []byte) int {
 			// new coverage
 		}
 	}
+	// Test for sonar hints from bytes.Equal with a magic constant.
+	if len(data) >= 8 && bytes.Equal(data[4:8], []byte("PK\x03\x04")) {
+		// new coverage
+	}
 	if len(data) == 7 && data[7:8][0] == 'a' {
 		// The above should cause OOB.
 	}